@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
+)
+
+// runConfigCommand implements the "config" subcommand and returns the process exit code
+func runConfigCommand(args []string) int {
+	switch {
+	case len(args) > 0 && args[0] == "migrate":
+		return runConfigMigrate(args[1:])
+	case len(args) == 1 && args[0] == "schema":
+		return runConfigSchema()
+	}
+	fmt.Fprintln(os.Stderr, "usage: cloudfauxnt config migrate [-config config.yaml] [-dry-run]")
+	fmt.Fprintln(os.Stderr, "       cloudfauxnt config schema")
+	return 2
+}
+
+// runConfigSchema prints the JSON Schema for configuration files
+func runConfigSchema() int {
+	schema, err := cloudfauxnt.ConfigSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate schema: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(append(schema, '\n'))
+	return 0
+}
+
+// runConfigMigrate rewrites a configuration file in the current config_version, keeping a backup
+// of the original
+func runConfigMigrate(args []string) int {
+	flags := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	dryRun := flags.Bool("dry-run", false, "Print the migrated configuration instead of rewriting the file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config file: %v\n", err)
+		return 1
+	}
+	migrated, version, err := cloudfauxnt.MigrateConfig(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate configuration: %v\n", err)
+		return 1
+	}
+
+	if *dryRun {
+		os.Stdout.Write(migrated)
+		return 0
+	}
+	if version == cloudfauxnt.CurrentConfigVersion {
+		fmt.Printf("%s is already at config_version %d\n", *configPath, version)
+		return 0
+	}
+
+	info, err := os.Stat(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config file: %v\n", err)
+		return 1
+	}
+	backup := *configPath + ".bak"
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write backup: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(*configPath, migrated, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write migrated configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Migrated %s from config_version %d to %d (original saved as %s)\n", *configPath, version, cloudfauxnt.CurrentConfigVersion, backup)
+	return 0
+}
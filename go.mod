@@ -1,6 +1,6 @@
-module cloudfauxnt
+module github.com/tonyellard/cloudfauxnt
 
-go 1.23
+go 1.24
 
 require (
 	github.com/go-chi/chi/v5 v5.0.11
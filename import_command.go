@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
+)
+
+// runImportCommand implements the "import" subcommand and returns the process exit code
+func runImportCommand(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	distributionID := flags.String("distribution-id", "", "Read the distribution from the CloudFront API (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	file := flags.String("file", "", "Read a saved `aws cloudfront get-distribution-config` JSON file instead (- for stdin)")
+	terraform := flags.String("terraform", "", "Read an aws_cloudfront_distribution from a .tf or .tf.json file, or `terraform show -json` output (- for stdin)")
+	cloudFormation := flags.String("cloudformation", "", "Read an AWS::CloudFront::Distribution from a CloudFormation template (- for stdin)")
+	resource := flags.String("resource", "", "Terraform resource name or CloudFormation logical ID, when there are several distributions")
+	output := flags.String("output", "", "Write the configuration to this file instead of stdout")
+	endpoint := flags.String("endpoint", "", "CloudFront API endpoint (default: https://cloudfront.amazonaws.com)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	sources := 0
+	for _, source := range []string{*distributionID, *file, *terraform, *cloudFormation} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		fmt.Fprintln(os.Stderr, "usage: cloudfauxnt import (-distribution-id ID | -file distribution-config.json | -terraform main.tf | -cloudformation template.yaml) [-resource name] [-output config.yaml]")
+		return 2
+	}
+
+	var data []byte
+	var keyGroupKeys func(string) ([]string, error)
+	var err error
+	switch path := *file + *terraform + *cloudFormation; {
+	case path == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case path != "":
+		data, err = os.ReadFile(path)
+	default:
+		api := &cloudfauxnt.CloudFrontAPI{
+			Endpoint:        *endpoint,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if api.AccessKeyID == "" || api.SecretAccessKey == "" {
+			fmt.Fprintln(os.Stderr, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to read from the CloudFront API")
+			return 1
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		data, err = api.GetDistributionConfig(ctx, *distributionID)
+		keyGroupKeys = func(keyGroupID string) ([]string, error) {
+			return api.KeyGroupPublicKeys(ctx, keyGroupID)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read distribution: %v\n", err)
+		return 1
+	}
+
+	var config []byte
+	switch {
+	case *terraform != "":
+		config, err = cloudfauxnt.ImportTerraform(data, *resource)
+	case *cloudFormation != "":
+		config, err = cloudfauxnt.ImportCloudFormationTemplate(data, *resource)
+	default:
+		config, err = cloudfauxnt.ImportDistributionConfig(data, keyGroupKeys)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to import distribution: %v\n", err)
+		return 1
+	}
+	if *output == "" {
+		os.Stdout.Write(config)
+		return 0
+	}
+	if err := os.WriteFile(*output, config, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write configuration: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote %s; run `cloudfauxnt validate --config %s` after pointing origins at local stand-ins\n", *output, *output)
+	return 0
+}
@@ -0,0 +1,370 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// scaffoldTemplate is an example architecture `cloudfauxnt init` can write out
+type scaffoldTemplate struct {
+	description string
+	// config is the config.yaml body; {{key_pair_id}} is replaced with the generated key's ID
+	config string
+	// origin maps paths under origin/ to the sample content nginx serves
+	origin map[string]string
+}
+
+// scaffoldTemplates are the architectures `cloudfauxnt init --template` knows
+var scaffoldTemplates = map[string]scaffoldTemplate{
+	"spa": {
+		description: "single-page application with cached hashed assets and a short-lived index.html",
+		config: `# Scaffolded by ` + "`cloudfauxnt init --template spa`" + `: a single-page application whose
+# fingerprinted assets are cached for a day and whose shell is revalidated every minute
+config_version: 2
+
+server:
+  port: 9001
+  host: "0.0.0.0"
+  default_root_object: "index.html"
+
+# Every behavior states whether it is public or needs a signed URL or cookie
+default_access: deny
+
+origins:
+  - name: site
+    url: http://origin:80  # The nginx service in docker-compose.yml
+
+behaviors:
+  - name: assets
+    target_origin: site
+    path_patterns: ["/assets/*"]
+    public: true
+    cache:
+      default_ttl_seconds: 86400
+  - name: app
+    target_origin: site
+    path_patterns: ["/*"]
+    public: true
+    response_headers_policy: app-security
+    cache:
+      default_ttl_seconds: 60
+      stale_while_revalidate_seconds: 30
+
+response_headers_policies:
+  - name: app-security
+    security_headers:
+      frame_options: {value: DENY, override: true}
+      referrer_policy: {value: strict-origin-when-cross-origin}
+      content_type_options: {}
+
+# Nothing is signed yet: set require_signature: true on a behavior to protect it
+signing:
+  enabled: true
+  key_pair_id: "{{key_pair_id}}"
+  public_key_path: keys/public.pem
+  private_key_path: keys/private.pem  # Lets cloudfauxnt smoke test signed URLs (never used by the server)
+`,
+		origin: map[string]string{
+			"index.html": `<!doctype html>
+<html>
+  <head>
+    <title>CloudFauxnt SPA</title>
+    <script src="/assets/app.js" defer></script>
+  </head>
+  <body>
+    <div id="app">Loading...</div>
+  </body>
+</html>
+`,
+			"assets/app.js": `document.getElementById("app").textContent = "Served through CloudFauxnt";
+`,
+		},
+	},
+	"media": {
+		description: "media library with public posters and signed, cached video",
+		config: `# Scaffolded by ` + "`cloudfauxnt init --template media`" + `: a media library whose posters are
+# public and whose videos need a CloudFront signed URL or cookie
+config_version: 2
+
+server:
+  port: 9001
+  host: "0.0.0.0"
+  default_root_object: "index.html"
+
+# Every behavior states whether it is public or needs a signed URL or cookie
+default_access: deny
+
+origins:
+  - name: media
+    url: http://origin:80  # The nginx service in docker-compose.yml
+
+behaviors:
+  - name: posters
+    target_origin: media
+    path_patterns: ["/posters/*"]
+    public: true
+    cache:
+      default_ttl_seconds: 3600
+  - name: videos
+    target_origin: media
+    path_patterns: ["/videos/*"]
+    require_signature: true
+    allowed_methods: [GET, HEAD, OPTIONS]
+    cache:
+      default_ttl_seconds: 86400
+      stale_if_error_seconds: 300
+  - name: site
+    target_origin: media
+    path_patterns: ["/*"]
+    public: true
+
+# Players on other origins fetch playlists and segments with Range requests
+cors:
+  enabled: true
+  allowed_origins: ["*"]
+  allowed_methods: [GET, HEAD, OPTIONS]
+  allowed_headers: [Range]
+  max_age: 3600
+
+signing:
+  enabled: true
+  key_pair_id: "{{key_pair_id}}"
+  public_key_path: keys/public.pem
+  private_key_path: keys/private.pem  # Lets cloudfauxnt smoke test signed URLs (never used by the server)
+`,
+		origin: map[string]string{
+			"index.html": `<!doctype html>
+<html>
+  <head><title>CloudFauxnt media</title></head>
+  <body>
+    <img src="/posters/sample.svg" alt="Sample poster">
+    <p>/videos/sample.m3u8 needs a CloudFront signed URL or cookie made with keys/private.pem</p>
+  </body>
+</html>
+`,
+			"posters/sample.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="320" height="180">
+  <rect width="320" height="180" fill="#232f3e"/>
+  <text x="160" y="95" fill="#ff9900" font-family="sans-serif" font-size="20" text-anchor="middle">CloudFauxnt</text>
+</svg>
+`,
+			"videos/sample.m3u8": `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-ENDLIST
+`,
+		},
+	},
+	"api": {
+		description: "API with uncached public endpoints and signed export downloads",
+		config: `# Scaffolded by ` + "`cloudfauxnt init --template api`" + `: an API whose responses are never
+# cached and whose exports are downloaded through signed URLs
+config_version: 2
+
+server:
+  port: 9001
+  host: "0.0.0.0"
+
+# Every behavior states whether it is public or needs a signed URL or cookie
+default_access: deny
+
+origins:
+  - name: api
+    url: http://origin:80  # The nginx service in docker-compose.yml
+
+behaviors:
+  - name: api
+    target_origin: api
+    path_patterns: ["/api/*"]
+    public: true
+  - name: exports
+    target_origin: api
+    path_patterns: ["/exports/*"]
+    require_signature: true
+    allowed_methods: [GET, HEAD]
+
+cors:
+  enabled: true
+  allowed_origins: ["http://localhost:3000"]  # Your front end's dev server
+  allowed_methods: [GET, HEAD, OPTIONS, PUT, POST, PATCH, DELETE]
+  allowed_headers: [Content-Type, Authorization]
+  max_age: 3600
+
+rate_limit:
+  enabled: true
+  requests_per_second: 20
+  burst: 40
+
+signing:
+  enabled: true
+  key_pair_id: "{{key_pair_id}}"
+  public_key_path: keys/public.pem
+  private_key_path: keys/private.pem  # Lets cloudfauxnt smoke test signed URLs (never used by the server)
+`,
+		origin: map[string]string{
+			"api/status.json":        `{"status": "ok"}` + "\n",
+			"api/users.json":         `[{"id": 1, "name": "Ada"}, {"id": 2, "name": "Grace"}]` + "\n",
+			"exports/users-2024.csv": "id,name\n1,Ada\n2,Grace\n",
+		},
+	},
+}
+
+// scaffoldCompose runs CloudFauxnt in front of an nginx sample origin serving ./origin
+const scaffoldCompose = `services:
+  cloudfauxnt:
+    # Build the image from a CloudFauxnt checkout with: docker build -t cloudfauxnt .
+    image: ${CLOUDFAUXNT_IMAGE:-cloudfauxnt}
+    ports:
+      - "9001:9001"
+    volumes:
+      - ./config.yaml:/app/config.yaml:ro
+      - ./keys:/app/keys:ro
+    depends_on:
+      - origin
+
+  # Sample origin: replace it with your own service, or point origins[0].url elsewhere
+  origin:
+    image: nginx:alpine
+    volumes:
+      - ./origin:/usr/share/nginx/html:ro
+`
+
+// scaffoldSmoke runs `cloudfauxnt smoke` with a local binary if there is one, or in the container
+const scaffoldSmoke = `#!/bin/sh
+# Checks the scaffolded stack end to end. Start it first with: docker compose up -d
+set -e
+cd "$(dirname "$0")"
+if command -v cloudfauxnt >/dev/null 2>&1; then
+  exec cloudfauxnt smoke -config config.yaml -target "${TARGET:-http://localhost:9001}"
+fi
+exec docker compose exec cloudfauxnt ./cloudfauxnt smoke -config /app/config.yaml -target http://localhost:9001
+`
+
+// runInitCommand implements the "init" subcommand and returns the process exit code
+func runInitCommand(args []string) int {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	templateName := flags.String("template", "", "Architecture to scaffold: "+strings.Join(scaffoldTemplateNames(), ", "))
+	dir := flags.String("dir", ".", "Directory to write the scaffold into")
+	force := flags.Bool("force", false, "Overwrite existing files")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: cloudfauxnt init --template <name> [--dir path] [--force]")
+		fmt.Fprintln(flags.Output(), "\nTemplates:")
+		for _, name := range scaffoldTemplateNames() {
+			fmt.Fprintf(flags.Output(), "  %-6s %s\n", name, scaffoldTemplates[name].description)
+		}
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	template, ok := scaffoldTemplates[*templateName]
+	if !ok || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	files, err := scaffoldFiles(template)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to generate signing key: %v\n", err)
+		return 1
+	}
+	if !*force {
+		var existing []string
+		for _, name := range sortedKeys(files) {
+			if _, err := os.Stat(filepath.Join(*dir, name)); err == nil {
+				existing = append(existing, name)
+			}
+		}
+		if len(existing) > 0 {
+			fmt.Fprintf(os.Stderr, "refusing to overwrite %s in %s (use --force)\n", strings.Join(existing, ", "), *dir)
+			return 1
+		}
+	}
+
+	for _, name := range sortedKeys(files) {
+		mode := os.FileMode(0o644)
+		switch name {
+		case "keys/private.pem":
+			mode = 0o600
+		case "smoke.sh":
+			mode = 0o755
+		}
+		path := filepath.Join(*dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s: %v\n", filepath.Dir(path), err)
+			return 1
+		}
+		if err := os.WriteFile(path, []byte(files[name]), mode); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", path, err)
+			return 1
+		}
+		// WriteFile keeps the mode of a file it overwrites
+		if err := os.Chmod(path, mode); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+
+	fmt.Printf("\nScaffolded the %s template. Next:\n", *templateName)
+	fmt.Printf("  cd %s\n", *dir)
+	fmt.Println("  docker compose up -d")
+	fmt.Println("  ./smoke.sh")
+	return 0
+}
+
+// scaffoldFiles renders every file of a template, keyed by slash-separated path, with a freshly
+// generated signing key pair
+func scaffoldFiles(template scaffoldTemplate) (map[string]string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	// Derived from the key, so the ID changes whenever the key does
+	fingerprint := sha256.Sum256(publicDER)
+	keyPairID := "APKA" + strings.ToUpper(hex.EncodeToString(fingerprint[:8]))
+
+	files := map[string]string{
+		"config.yaml":        strings.ReplaceAll(template.config, "{{key_pair_id}}", keyPairID),
+		"docker-compose.yml": scaffoldCompose,
+		"smoke.sh":           scaffoldSmoke,
+		"keys/private.pem":   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"keys/public.pem":    string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+	}
+	for name, content := range template.origin {
+		files["origin/"+name] = content
+	}
+	return files, nil
+}
+
+// scaffoldTemplateNames returns the template names in order
+func scaffoldTemplateNames() []string {
+	return sortedKeys(scaffoldTemplates)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
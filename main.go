@@ -3,60 +3,125 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
 )
 
 func main() {
+	// Dispatch subcommands before parsing server flags
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		os.Exit(runSignCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "smoke" {
+		os.Exit(runSmokeCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidateCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		os.Exit(runInitCommand(os.Args[2:]))
+	}
+
 	// Parse command-line flags
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration (secrets redacted) and exit")
+	seed := flag.Uint64("seed", 0, "Seed for request IDs, canary assignment, fault injection, and log sampling (default: random, logged at startup)")
 	flag.Parse()
 
+	// Make randomized features reproducible
+	if *seed != 0 {
+		cloudfauxnt.SetSeed(*seed)
+	}
+
 	// Load configuration
-	log.Printf("Loading configuration from %s", *configPath)
-	config, err := LoadConfig(*configPath)
+	slog.Info("Loading configuration", "path", *configPath)
+	config, err := cloudfauxnt.LoadConfig(*configPath)
+	var problems cloudfauxnt.ConfigErrors
+	if errors.As(err, &problems) {
+		for _, problem := range problems {
+			slog.Error("Configuration problem", "path", problem.Path, "error", problem.Err)
+		}
+		fatal("Failed to load configuration", "problems", len(problems))
+	}
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", "error", err)
+	}
+
+	// Switch to the configured log format and level
+	slog.SetDefault(cloudfauxnt.NewLogger(config.Logging))
+
+	// Dump the effective configuration if requested
+	if *printConfig || config.Server.DumpConfig {
+		effective, err := config.EffectiveYAML()
+		if err != nil {
+			fatal("Failed to render effective configuration", "error", err)
+		}
+		if *printConfig {
+			os.Stdout.Write(effective)
+			return
+		}
+		slog.Info("Effective configuration", "config", string(effective))
 	}
 
-	log.Printf("CloudFauxnt starting with %d origin(s)", len(config.Origins))
+	slog.Info("CloudFauxnt starting", "origins", len(config.Origins), "seed", cloudfauxnt.Seed())
 	for _, origin := range config.Origins {
-		log.Printf("  - %s: %s (patterns: %v)", origin.Name, origin.URL, origin.PathPatterns)
+		slog.Info("Origin configured", "name", origin.Name, "url", origin.URL, "patterns", origin.PathPatterns)
+	}
+	for _, distribution := range config.Distributions {
+		slog.Info("Distribution configured", "name", distribution.Name, "aliases", distribution.Aliases,
+			"origins", len(distribution.Origins), "signing", distribution.Signing != nil && distribution.Signing.Enabled)
 	}
 
-	// Initialize signature validator if signing is enabled
-	var validator *SignatureValidator
 	if config.Signing.Enabled {
-		clockSkew := config.Signing.TokenOptions.ClockSkewSeconds
-		if clockSkew == 0 {
-			clockSkew = 30 // Default 30 seconds clock skew
-		}
-		validator = NewSignatureValidator(config.Signing.PublicKey, config.Signing.KeyPairID, clockSkew)
-		log.Printf("CloudFront signature validation enabled (Key Pair ID: %s, Clock Skew: %d seconds)",
-			config.Signing.KeyPairID, clockSkew)
+		slog.Info("CloudFront signature validation enabled",
+			"key_pair_id", config.Signing.KeyPairID, "clock_skew_seconds", config.Signing.TokenOptions.ClockSkewSeconds)
 	} else {
-		log.Println("CloudFront signature validation disabled")
+		slog.Info("CloudFront signature validation disabled")
 	}
 
-	// Setup router
-	router := SetupRouter(config, validator)
-
-	// Configure HTTP server
-	addr := fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port)
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      router,
-		ReadTimeout:  time.Duration(config.Server.TimeoutSeconds) * time.Second,
-		WriteTimeout: time.Duration(config.Server.TimeoutSeconds) * time.Second,
-		IdleTimeout:  120 * time.Second,
+	server, err := cloudfauxnt.NewServer(config)
+	if err != nil {
+		fatal("Failed to create server", "error", err)
 	}
+	server.Reloader().WatchSignals()
 
 	// Start server
-	log.Printf("CloudFauxnt listening on %s", addr)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	if err := server.Start(); err != nil {
+		fatal("Server failed", "error", err)
+	}
+
+	// Run until interrupted, then drain in-flight requests and flush logs
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-server.Errors():
+		fatal("Server failed", "error", err)
+	case <-stop:
 	}
+	slog.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Server.TimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown failed", "error", err)
+	}
+}
+
+// fatal logs an error and exits, replacing log.Fatalf
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogFields are the CloudFront standard log fields, in order
+var accessLogFields = []string{
+	"date", "time", "x-edge-location", "sc-bytes", "c-ip", "cs-method", "cs(Host)", "cs-uri-stem",
+	"sc-status", "cs(Referer)", "cs(User-Agent)", "cs-uri-query", "cs(Cookie)", "x-edge-result-type",
+	"x-edge-request-id", "x-host-header", "cs-protocol", "cs-bytes", "time-taken", "x-forwarded-for",
+	"ssl-protocol", "ssl-cipher", "x-edge-response-result-type", "cs-protocol-version", "fle-status",
+	"fle-encrypted-fields", "c-port", "time-to-first-byte", "x-edge-detailed-result-type",
+	"sc-content-type", "sc-content-len", "sc-range-start", "sc-range-end",
+}
+
+// AccessLogger writes CloudFront standard (W3C) access logs to a file or an hourly rotated directory
+type AccessLogger struct {
+	config  AccessLogConfig
+	fields  []string
+	storage Storage // Optional: rotated files are shipped here, as CloudFront delivers logs to S3
+
+	mu        sync.Mutex
+	file      *os.File
+	fileHour  string
+	shipping  sync.WaitGroup
+	shipments subsystemState // Outcome of the latest upload to storage
+}
+
+// NewAccessLogger opens the configured access log destination
+func NewAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	al := &AccessLogger{config: config, fields: accessLogFields}
+	// Extra fields are appended after the standard fields so existing parsers still read the first 33 columns
+	if config.IncludeRules {
+		al.fields = append(append([]string{}, al.fields...), "x-cloudfauxnt-rules")
+	}
+	if config.IncludeTraceID {
+		al.fields = append(append([]string{}, al.fields...), "x-cloudfauxnt-trace-id")
+	}
+	if config.IncludeBodyStats {
+		al.fields = append(append([]string{}, al.fields...), "x-cloudfauxnt-body-bytes", "x-cloudfauxnt-time-to-last-byte")
+	}
+	if config.IncludeBodySHA256 {
+		al.fields = append(append([]string{}, al.fields...), "x-cloudfauxnt-body-sha256")
+	}
+	if config.IncludeSignature {
+		al.fields = append(append([]string{}, al.fields...),
+			"x-cloudfauxnt-key-pair-id", "x-cloudfauxnt-signature-expires", "x-cloudfauxnt-signature-status")
+	}
+	if config.Directory != "" {
+		if err := os.MkdirAll(config.Directory, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create access log directory: %w", err)
+		}
+		return al, nil
+	}
+
+	file, err := al.open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	al.file = file
+	return al, nil
+}
+
+// open opens a log file for appending, writing the W3C header if the file is new
+func (al *AccessLogger) open(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		fmt.Fprintf(file, "#Version: 1.0\n#Fields: %s\n", strings.Join(al.fields, " "))
+	}
+	return file, nil
+}
+
+// writer returns the file for the given time, rotating hourly in directory mode
+func (al *AccessLogger) writer(now time.Time) (*os.File, error) {
+	if al.config.Directory == "" {
+		return al.file, nil
+	}
+	hour := now.Format("2006-01-02-15")
+	if al.file != nil && al.fileHour == hour {
+		return al.file, nil
+	}
+	if al.file != nil {
+		al.file.Close()
+		if al.storage != nil {
+			al.shipping.Add(1)
+			go func(name string) {
+				defer al.shipping.Done()
+				al.ship(name)
+			}(al.file.Name())
+		}
+	}
+	// Mirrors CloudFront's <prefix>.YYYY-MM-DD-HH.<unique-id> object naming
+	name := fmt.Sprintf("%s.%s.log", al.config.FilePrefix, hour)
+	file, err := al.open(filepath.Join(al.config.Directory, name))
+	if err != nil {
+		al.file = nil
+		return nil, err
+	}
+	al.file = file
+	al.fileHour = hour
+	return file, nil
+}
+
+// logRequest appends a log line for a completed request
+func (al *AccessLogger) logRequest(entry *requestLogEntry) {
+	fields := make([]string, len(al.fields))
+	for i, name := range al.fields {
+		if name == "cs(Cookie)" && !al.config.IncludeCookies {
+			fields[i] = "-"
+			continue
+		}
+		fields[i] = logEscape(entry.field(name))
+	}
+	line := strings.Join(fields, "\t") + "\n"
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	file, err := al.writer(entry.start)
+	if err != nil {
+		return
+	}
+	file.WriteString(line)
+}
+
+// ship uploads a finished log file to storage under access-logs/
+func (al *AccessLogger) ship(name string) {
+	data, err := os.ReadFile(name)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err = al.storage.Put(ctx, storageKey("access-logs", filepath.Base(name)), data)
+	}
+	al.shipments.report(err)
+	if err != nil {
+		slog.Warn("Access log shipping failed", "file", name, "error", err)
+	}
+}
+
+// Close closes the current log file, shipping it and waiting for earlier shipments if storage is set
+func (al *AccessLogger) Close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	defer al.shipping.Wait()
+	if al.file == nil {
+		return nil
+	}
+	err := al.file.Close()
+	if al.storage != nil && err == nil {
+		al.ship(al.file.Name())
+	}
+	al.file = nil
+	return err
+}
@@ -0,0 +1,376 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// maxAdminBodyBytes bounds admin request bodies
+const maxAdminBodyBytes = 1 << 20
+
+// AdminAPI exposes runtime configuration endpoints on a separate listener
+type AdminAPI struct {
+	reloader *ConfigReloader
+	bypass   *BypassTokens
+	learner  *trafficLearner // nil unless learning mode is enabled
+	captures *requestCapture // nil when requests aren't captured
+	caches   func() []*responseCache
+	usage    *usageTracker
+	// certificates checks the certificates of the https origins currently in use
+	certificates func(ctx context.Context) []originCertificateCheck
+}
+
+// NewAdminRouter creates the router for the admin REST API. caches returns the response caches
+// currently in use, which change when the configuration is reloaded; reports collects CSP violation
+// reports; certificates checks the certificates of the origins currently in use.
+func NewAdminRouter(reloader *ConfigReloader, bypass *BypassTokens, learner *trafficLearner, captures *requestCapture, caches func() []*responseCache, usage *usageTracker, reports *cspReports, certificates func(ctx context.Context) []originCertificateCheck) chi.Router {
+	api := &AdminAPI{reloader: reloader, bypass: bypass, learner: learner, captures: captures, caches: caches, usage: usage, certificates: certificates}
+	r := chi.NewRouter()
+	r.Get("/config", api.getConfig)
+	r.Get("/origins", api.listOrigins)
+	r.Get("/origins/certificates", api.checkOriginCertificates)
+	r.Post("/origins", api.addOrigin)
+	r.Delete("/origins/{name}", api.removeOrigin)
+	r.Get("/cache", api.listCacheObjects)
+	r.Get("/cache/object", api.getCacheObject)
+	r.Delete("/cache", api.purgeCacheObjects)
+	r.Post("/cache/flush", api.flushCaches)
+	r.Get("/usage", api.getUsage)
+	r.Put("/signing/key", api.rotateSigningKey)
+	r.Post("/bypass-tokens", api.mintBypassToken)
+	r.Handle(cspReportPath, reports)
+	r.Get(signatureDebugPath, api.debugSignature)
+	r.Post(signatureDebugPath, api.debugSignature)
+	if learner != nil {
+		r.Get("/learned", api.getLearnedRoutes)
+		r.Get("/learned/config", api.getLearnedConfig)
+		r.Delete("/learned", api.resetLearnedRoutes)
+	}
+	if captures != nil {
+		r.Get("/requests", api.listRequests)
+		r.Get("/requests/{id}", api.getRequest)
+		r.Get("/dashboard", serveDashboard)
+	}
+	return r
+}
+
+// getConfig returns the effective configuration as JSON, with secrets redacted
+func (api *AdminAPI) getConfig(w http.ResponseWriter, r *http.Request) {
+	effective, err := api.reloader.Config().EffectiveYAML()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Round-trip through a generic value so JSON keys match the YAML field names
+	var doc map[string]any
+	if err := yaml.Unmarshal(effective, &doc); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, doc)
+}
+
+// listOrigins returns the configured origins (CloudFauxnt's equivalent of cache behaviors), with
+// custom header values redacted
+func (api *AdminAPI) listOrigins(w http.ResponseWriter, r *http.Request) {
+	writeAdminYAMLAsJSON(w, http.StatusOK, redactOrigins(api.reloader.Config().Origins))
+}
+
+// checkOriginCertificates connects to every https origin and reports the certificate it presents
+func (api *AdminAPI) checkOriginCertificates(w http.ResponseWriter, r *http.Request) {
+	timeout := time.Duration(api.reloader.Config().Server.ReadinessTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	checks := api.certificates(ctx)
+	if checks == nil {
+		checks = []originCertificateCheck{}
+	}
+	writeAdminJSON(w, http.StatusOK, struct {
+		Origins []originCertificateCheck `json:"origins"`
+	}{checks})
+}
+
+// addOrigin adds an origin from a JSON body using the same field names as the YAML configuration
+func (api *AdminAPI) addOrigin(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	var origin Origin
+	decoder := yaml.NewDecoder(bytes.NewReader(body))
+	decoder.KnownFields(api.reloader.Config().strict())
+	if err := decoder.Decode(&origin); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid origin: %w", err))
+		return
+	}
+
+	err = api.reloader.Update(func(config *Config) error {
+		config.Origins = append(config.Origins, origin)
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	audit("Origin added via admin API", "name", origin.Name, "url", origin.URL, "patterns", origin.PathPatterns)
+	writeAdminYAMLAsJSON(w, http.StatusCreated, origin)
+}
+
+// removeOrigin removes the named origin
+func (api *AdminAPI) removeOrigin(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	found := false
+	err := api.reloader.Update(func(config *Config) error {
+		for i := range config.Origins {
+			if config.Origins[i].Name == name {
+				config.Origins = append(config.Origins[:i], config.Origins[i+1:]...)
+				found = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !found {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("no origin named %q", name))
+		return
+	}
+	audit("Origin removed via admin API", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// flushCaches discards in-memory caches by rebuilding the request handler, and empties the disk
+// cache the rebuilt handler keeps
+func (api *AdminAPI) flushCaches(w http.ResponseWriter, r *http.Request) {
+	api.reloader.Rebuild()
+	for _, cache := range api.caches() {
+		cache.flush()
+	}
+	flushOriginShields()
+	audit("Caches flushed via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listCacheObjects returns the cached objects, most recently used first, optionally only those
+// whose keys match the pattern parameter
+func (api *AdminAPI) listCacheObjects(w http.ResponseWriter, r *http.Request) {
+	objects := []cacheObject{}
+	var bytes int64
+	for _, cache := range api.caches() {
+		for _, object := range cache.objects(r.URL.Query().Get("pattern")) {
+			objects = append(objects, object)
+			bytes += object.Size
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{"objects": objects, "count": len(objects), "bytes": bytes})
+}
+
+// getCacheObject returns the object cached under the key parameter, with its headers
+func (api *AdminAPI) getCacheObject(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	for _, cache := range api.caches() {
+		if object, ok := cache.object(key); ok {
+			writeAdminJSON(w, http.StatusOK, object)
+			return
+		}
+	}
+	writeAdminError(w, http.StatusNotFound, fmt.Errorf("no object is cached under %q", key))
+}
+
+// purgeCacheObjects discards the object cached under the key parameter, or those whose keys match
+// the pattern parameter
+func (api *AdminAPI) purgeCacheObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key, pattern := query.Get("key"), query.Get("pattern")
+	if (key == "") == (pattern == "") {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("exactly one of key or pattern is required; POST /cache/flush empties the cache"))
+		return
+	}
+	purged := 0
+	for _, cache := range api.caches() {
+		if key != "" {
+			if _, ok := cache.object(key); ok {
+				cache.remove(key)
+				purged++
+			}
+			continue
+		}
+		purged += cache.purge(pattern)
+	}
+	audit("Cached objects purged via admin API", "key", key, "pattern", pattern, "purged", purged)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// getUsage reports the requests and bytes each distribution served today, against its quota
+func (api *AdminAPI) getUsage(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, api.usage.report(api.reloader.Config()))
+}
+
+// getLearnedRoutes returns the routes learning mode has recorded, busiest first
+func (api *AdminAPI) getLearnedRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, dropped := api.learner.snapshot()
+	writeAdminJSON(w, http.StatusOK, map[string]any{"routes": routes, "dropped_requests": dropped})
+}
+
+// getLearnedConfig returns the configuration suggested by the learned routes as YAML
+func (api *AdminAPI) getLearnedConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	io.WriteString(w, api.learner.suggestConfig())
+}
+
+// resetLearnedRoutes discards the learned routes
+func (api *AdminAPI) resetLearnedRoutes(w http.ResponseWriter, r *http.Request) {
+	api.learner.reset()
+	audit("Learned routes reset via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signingKeyRequest is the body of a signing key rotation
+type signingKeyRequest struct {
+	KeyPairID     string `json:"key_pair_id"`
+	PublicKey     string `json:"public_key"`     // PEM-encoded RSA or ECDSA public key
+	SignatureHash string `json:"signature_hash"` // sha1 (default) or sha256
+}
+
+// listRequests returns the timelines of the most recent requests, newest first
+func (api *AdminAPI) listRequests(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]any{"requests": api.captures.recent()})
+}
+
+// getRequest returns the timeline of one captured request
+func (api *AdminAPI) getRequest(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	timeline := api.captures.find(id)
+	if timeline == nil {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("request %q is not captured", id))
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, timeline)
+}
+
+// rotateSigningKey replaces the key pair ID and public key used to validate signatures
+func (api *AdminAPI) rotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	var req signingKeyRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes)).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.KeyPairID == "" {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("key_pair_id is required"))
+		return
+	}
+	publicKey, err := parseSigningPublicKey([]byte(req.PublicKey))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := parseSignatureHash(req.SignatureHash); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = api.reloader.Update(func(config *Config) error {
+		if !config.Signing.Enabled {
+			return fmt.Errorf("signing is not enabled")
+		}
+		config.Signing.KeyPairID = req.KeyPairID
+		config.Signing.PublicKey = publicKey
+		config.Signing.PublicKeyURL = "" // Keep refreshes from replacing the rotated key
+		config.Signing.SignatureHash = req.SignatureHash
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	audit("Signing key rotated via admin API", "key_pair_id", req.KeyPairID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bypassTokenRequest is the optional body of a bypass token request
+type bypassTokenRequest struct {
+	TTLSeconds int `json:"ttl_seconds"` // Default: 300
+}
+
+// bypassTokenResponse describes a newly minted bypass token
+type bypassTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Header    string    `json:"header"`
+	Param     string    `json:"query_param"`
+}
+
+// mintBypassToken creates a short-lived token that lets requests skip signature requirements
+func (api *AdminAPI) mintBypassToken(w http.ResponseWriter, r *http.Request) {
+	req := bypassTokenRequest{TTLSeconds: 300}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes)).Decode(&req); err != nil && err != io.EOF {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	maxTTL := api.reloader.Config().Admin.BypassTokenMaxTTLSeconds
+	if req.TTLSeconds <= 0 || req.TTLSeconds > maxTTL {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("ttl_seconds must be 1-%d", maxTTL))
+		return
+	}
+
+	token, expires := api.bypass.Mint(time.Duration(req.TTLSeconds) * time.Second)
+	audit("Bypass token minted via admin API", "token", tokenPrefix(token), "expires", expires, "client", clientIP(r))
+	writeAdminJSON(w, http.StatusCreated, bypassTokenResponse{
+		Token:     token,
+		ExpiresAt: expires.UTC(),
+		Header:    bypassTokenHeader,
+		Param:     bypassTokenParam,
+	})
+}
+
+// debugSignature explains a signed URL or cookie set against the current configuration, while
+// signing debug mode is on
+func (api *AdminAPI) debugSignature(w http.ResponseWriter, r *http.Request) {
+	config := api.reloader.Config()
+	if !config.signingDebug() {
+		writeAdminError(w, http.StatusNotFound, fmt.Errorf("signing debug mode is off"))
+		return
+	}
+	SignatureDebugHandler(config)(w, r)
+}
+
+// writeAdminYAMLAsJSON writes a configuration value as JSON keyed by its YAML field names
+func writeAdminYAMLAsJSON(w http.ResponseWriter, status int, value any) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeAdminJSON(w, status, doc)
+}
+
+// writeAdminJSON writes a JSON response
+func writeAdminJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeAdminError writes a JSON error response
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bypassTokenHeader carries a bypass token on a viewer request
+	bypassTokenHeader = "X-CloudFauxnt-Bypass"
+	// bypassTokenParam carries a bypass token in the query string
+	bypassTokenParam = "cloudfauxnt-bypass"
+)
+
+// BypassTokens holds short-lived tokens, minted through the admin API, that let a request skip
+// WAF rules and signature requirements. Tokens survive configuration reloads.
+type BypassTokens struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time // Token to expiry
+}
+
+// NewBypassTokens creates an empty bypass token store
+func NewBypassTokens() *BypassTokens {
+	return &BypassTokens{tokens: make(map[string]time.Time)}
+}
+
+// Mint creates a token valid for ttl and returns it with its expiry
+func (b *BypassTokens) Mint(ttl time.Duration) (string, time.Time) {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	token := hex.EncodeToString(raw)
+	expires := time.Now().Add(ttl)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pruneLocked(time.Now())
+	b.tokens[token] = expires
+	return token, expires
+}
+
+// check returns the bypass token presented by a request and whether it is currently valid.
+// A nil store accepts no tokens.
+func (b *BypassTokens) check(r *http.Request) (string, bool) {
+	token := r.Header.Get(bypassTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get(bypassTokenParam)
+	}
+	if token == "" || b == nil {
+		return token, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expires, ok := b.tokens[token]
+	if !ok {
+		return token, false
+	}
+	if time.Now().After(expires) {
+		delete(b.tokens, token)
+		return token, false
+	}
+	return token, true
+}
+
+// pruneLocked drops expired tokens; the caller must hold b.mu
+func (b *BypassTokens) pruneLocked(now time.Time) {
+	for token, expires := range b.tokens {
+		if now.After(expires) {
+			delete(b.tokens, token)
+		}
+	}
+}
+
+// stripBypassToken removes the bypass token from a request before it is forwarded to the origin
+func stripBypassToken(req *http.Request) {
+	req.Header.Del(bypassTokenHeader)
+	if req.URL.Query().Has(bypassTokenParam) {
+		req.URL.RawQuery = removeBypassParam(req.URL.RawQuery)
+	}
+}
+
+// removeBypassParam drops the bypass token parameter from a raw query string without decoding and
+// re-encoding the rest, so the origin sees the other parameters as the viewer sent them
+func removeBypassParam(rawQuery string) string {
+	var kept []string
+	for param := range strings.SplitSeq(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if name == bypassTokenParam {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+// tokenPrefix shortens a token for logging so the audit log can't be used to replay it
+func tokenPrefix(token string) string {
+	if len(token) > 8 {
+		return token[:8] + "..."
+	}
+	return token
+}
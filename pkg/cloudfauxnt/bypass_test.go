@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripBypassTokenKeepsQueryOrder(t *testing.T) {
+	req := httptest.NewRequest("GET", "/file?z=1&cloudfauxnt-bypass=abc&a=%7E2&m", nil)
+	req.Header.Set(bypassTokenHeader, "abc")
+	stripBypassToken(req)
+
+	if got, want := req.URL.RawQuery, "z=1&a=%7E2&m"; got != want {
+		t.Errorf("RawQuery = %q, want %q", got, want)
+	}
+	if req.Header.Get(bypassTokenHeader) != "" {
+		t.Errorf("bypass header was not removed")
+	}
+}
+
+func TestStripBypassTokenLeavesQueryWithoutToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/file?b=2&a=1", nil)
+	stripBypassToken(req)
+
+	if got, want := req.URL.RawQuery, "b=2&a=1"; got != want {
+		t.Errorf("RawQuery = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,890 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheSettings enables response caching for a behavior, with TTLs like a CloudFront cache policy.
+// The origin's Cache-Control (s-maxage, max-age, stale-while-revalidate, stale-if-error) and Expires
+// headers take precedence over the defaults, within min_ttl_seconds and max_ttl_seconds.
+type CacheSettings struct {
+	DefaultTTLSeconds int `yaml:"default_ttl_seconds"` // TTL when the origin sends no max-age, s-maxage, or Expires (default: 86400)
+	MinTTLSeconds     int `yaml:"min_ttl_seconds"`
+	MaxTTLSeconds     int `yaml:"max_ttl_seconds"` // Default: 31536000 (one year)
+	// Optional: how long after expiry a stale object is served while it is refetched in the background
+	StaleWhileRevalidateSeconds int `yaml:"stale_while_revalidate_seconds"`
+	// Optional: how long after expiry a stale object is served when the origin fails or returns 5xx
+	StaleIfErrorSeconds int `yaml:"stale_if_error_seconds"`
+	// Optional: request headers included in the cache key and forwarded to the origin, e.g. Accept-Language
+	// or CloudFront-Is-Mobile-Viewer; CloudFront viewer headers get the values CloudFront would compute
+	Headers []string `yaml:"headers"`
+	// Optional: let concurrent misses for the same object wait for one origin fetch, like CloudFront does
+	CollapseRequests bool `yaml:"collapse_requests"`
+}
+
+// validate checks cache settings and fills in CloudFront's default TTLs
+func (s *CacheSettings) validate() error {
+	if s.DefaultTTLSeconds == 0 {
+		s.DefaultTTLSeconds = 86400
+	}
+	if s.MaxTTLSeconds == 0 {
+		s.MaxTTLSeconds = 31536000
+	}
+	if s.MinTTLSeconds < 0 || s.DefaultTTLSeconds < 0 || s.StaleWhileRevalidateSeconds < 0 || s.StaleIfErrorSeconds < 0 {
+		return fmt.Errorf("cache TTLs and stale windows cannot be negative")
+	}
+	if s.MinTTLSeconds > s.DefaultTTLSeconds || s.DefaultTTLSeconds > s.MaxTTLSeconds {
+		return fmt.Errorf("cache TTLs must satisfy min_ttl_seconds <= default_ttl_seconds <= max_ttl_seconds")
+	}
+	seen := make(map[string]bool)
+	for _, name := range s.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if name == "" || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("cache headers must be header names, got %q", name)
+		}
+		if seen[canonical] {
+			return fmt.Errorf("cache header %s is listed twice", name)
+		}
+		seen[canonical] = true
+	}
+	return nil
+}
+
+// cacheableStatuses are the origin responses CloudFauxnt stores
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+}
+
+// freshness returns how long a response stays fresh and the stale windows that follow,
+// or false if the response must not be cached
+func (s *CacheSettings) freshness(header http.Header, now time.Time) (ttl, staleWhileRevalidate, staleIfError time.Duration, ok bool) {
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	if _, noStore := directives["no-store"]; noStore {
+		return 0, 0, 0, false
+	}
+	if _, private := directives["private"]; private {
+		return 0, 0, 0, false
+	}
+
+	seconds := s.DefaultTTLSeconds
+	if value, found := directiveSeconds(directives, "s-maxage"); found {
+		seconds = value
+	} else if value, found := directiveSeconds(directives, "max-age"); found {
+		seconds = value
+	} else if expires := header.Get("Expires"); expires != "" {
+		seconds = 0
+		if at, err := http.ParseTime(expires); err == nil {
+			seconds = max(int(at.Sub(now).Seconds()), 0)
+		}
+	}
+	if _, noCache := directives["no-cache"]; noCache {
+		seconds = 0
+	}
+	seconds = min(max(seconds, s.MinTTLSeconds), s.MaxTTLSeconds)
+
+	swr := s.StaleWhileRevalidateSeconds
+	if value, found := directiveSeconds(directives, "stale-while-revalidate"); found {
+		swr = value
+	}
+	sie := s.StaleIfErrorSeconds
+	if value, found := directiveSeconds(directives, "stale-if-error"); found {
+		sie = value
+	}
+	if seconds == 0 && swr == 0 && sie == 0 {
+		return 0, 0, 0, false
+	}
+	return time.Duration(seconds) * time.Second, time.Duration(swr) * time.Second, time.Duration(sie) * time.Second, true
+}
+
+// parseCacheControl splits Cache-Control header values into lowercase directive names and their values
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// directiveSeconds returns a Cache-Control directive's delta-seconds value, if present and valid
+func directiveSeconds(directives map[string]string, name string) (int, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// cachedResponse is a stored origin response
+type cachedResponse struct {
+	key                  string
+	status               int
+	header               http.Header
+	body                 []byte
+	disk                 *diskCache // Holds the body of a disk cache object, which isn't kept in memory
+	size                 int64
+	stored               time.Time
+	ttl                  time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	revalidating         bool  // A background refetch is in flight; guarded by the cache's mutex
+	hits                 int64 // Lookups that found the object; guarded by the cache's mutex
+}
+
+// write serves the stored response to a viewer with the given X-Cache result, as a 304 when the
+// viewer already has the current version
+func (c *cachedResponse) write(w http.ResponseWriter, r *http.Request, age time.Duration, result string) {
+	for name, values := range c.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-Cache", result)
+	w.Header().Set("X-Amz-Cf-Id", generateCloudFrontID())
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	if c.status == http.StatusOK && notModified(r.Header, c.header) {
+		writeNotModified(w)
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(c.status)
+		return
+	}
+	if c.disk == nil {
+		w.WriteHeader(c.status)
+		w.Write(c.body)
+		return
+	}
+	body, _, err := c.disk.open(c.key)
+	if err != nil {
+		slog.Warn("Cached object unreadable", "key", c.key, "error", err)
+		for name := range c.header {
+			w.Header().Del(name)
+		}
+		writeEdgeError(w, http.StatusBadGateway, diskCacheErrorReason)
+		return
+	}
+	defer body.Close()
+	w.WriteHeader(c.status)
+	io.Copy(w, body)
+}
+
+// diskCacheErrorReason is the error page text when a disk cache object can't be read
+const diskCacheErrorReason = "CloudFauxnt could not read the cached object from disk."
+
+// conditionalRequest returns a GET for r that asks the origin whether the stored response is still
+// current, using its ETag and Last-Modified in place of any validators the viewer sent
+func (c *cachedResponse) conditionalRequest(r *http.Request) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := c.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := c.header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// responseCache is a bounded LRU of origin responses for behaviors with caching enabled
+type responseCache struct {
+	mu             sync.Mutex
+	capacity       int
+	maxObjectBytes int64
+	entries        map[string]*list.Element
+	order          *list.List
+	disk           *diskCache // nil when bodies are kept in memory
+	shield         string     // Region of an origin shield's cache; empty for edge caches
+	// inflight are the origin fetches of collapsing behaviors' misses, closed when each is done
+	inflight map[string]chan struct{}
+}
+
+// newResponseCache returns the response cache the server settings describe: disk, the opened disk
+// cache of cache_dir, or a cache in memory holding at most cache_size objects when there is none.
+// It returns nil if cache_size is negative.
+func newResponseCache(server *ServerConfig, disk *responseCache) *responseCache {
+	if server.CacheSize < 0 {
+		return nil
+	}
+	if server.CacheDir != "" && disk != nil {
+		return disk
+	}
+	return newMemoryCache(server.CacheSize, server.CacheMaxObjectBytes)
+}
+
+// newMemoryCache creates a response cache holding at most capacity objects
+func newMemoryCache(capacity int, maxObjectBytes int64) *responseCache {
+	return &responseCache{
+		capacity:       capacity,
+		maxObjectBytes: maxObjectBytes,
+		entries:        make(map[string]*list.Element),
+		order:          list.New(),
+		inflight:       make(map[string]chan struct{}),
+	}
+}
+
+// cacheKey identifies a cached object by host, path, and the query parameters (sorted, see
+// cacheQuery) and cookies the behavior forwards, ignoring signature parameters and bypass tokens so
+// signed and unsigned requests share objects
+func cacheKey(r *http.Request, origin *Origin) string {
+	clean := r.Clone(r.Context())
+	clean.URL = RemoveSignatureParams(r.URL)
+	stripBypassToken(clean)
+	key := r.Host + clean.URL.EscapedPath()
+	if query := origin.cacheQuery(clean.URL); query != "" {
+		key += "?" + query
+	}
+	if cookies := origin.cacheCookies(r); cookies != "" {
+		key += "\n" + cookies
+	}
+	if headers := origin.Cache.keyHeaders(r); headers != "" {
+		key += "\n" + headers
+	}
+	return key
+}
+
+// keyHeaders returns the values of the cache key headers, sorted by name, so viewers sending
+// different values, or CloudFront computing different ones, get separate objects
+func (s *CacheSettings) keyHeaders(r *http.Request) string {
+	if s == nil || len(s.Headers) == 0 {
+		return ""
+	}
+	names := make([]string, len(s.Headers))
+	for i, name := range s.Headers {
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + ": " + keyHeaderValue(r, name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// keyHeaderValue returns the value of a cache key header: the computed value of a viewer
+// metadata header, or what the viewer sent for any other header
+func keyHeaderValue(r *http.Request, name string) string {
+	if value, ok := viewerMetadataHeaders[name]; ok {
+		return value(r)
+	}
+	return strings.Join(r.Header.Values(name), ",")
+}
+
+// forwardKeyHeaders sets the cache key headers on an origin request, which CloudFront forwards
+// whatever the origin request policy says, so the origin sees what the object was cached under
+func (s *CacheSettings) forwardKeyHeaders(req, viewer *http.Request) {
+	if s == nil {
+		return
+	}
+	for _, name := range s.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := viewerMetadataHeaders[name]; ok {
+			req.Header.Del(name)
+			if v := keyHeaderValue(viewer, name); v != "" {
+				req.Header.Set(name, v)
+			}
+		} else if values := viewer.Header.Values(name); len(values) > 0 {
+			req.Header[name] = slices.Clone(values)
+		}
+	}
+}
+
+// get returns the stored response for key, or nil
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cachedResponse)
+	if c.disk != nil && !c.disk.touch(entry) {
+		// Its files were deleted from under the cache
+		c.drop(elem)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	entry.hits++
+	return entry
+}
+
+// store caches a recorded origin response if it is cacheable, returning the stored entry or nil
+func (c *responseCache) store(key string, rec *responseRecorder, settings *CacheSettings) *cachedResponse {
+	if !cacheableStatuses[rec.status] || rec.truncated || int64(rec.body.Len()) > c.maxObjectBytes ||
+		len(rec.header.Values("Set-Cookie")) > 0 || isEventStream(rec.header) || settings.variesOutsideKey(rec.header) {
+		return nil
+	}
+	return c.put(key, rec.status, rec.header, rec.body.Bytes(), settings, time.Now())
+}
+
+// variesOutsideKey reports whether a response's Vary header is * or names a request header the
+// cache key leaves out, so a stored copy could reach viewers who asked for another variant
+func (s *CacheSettings) variesOutsideKey(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && (name == "*" || !s.keysOn(name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keysOn reports whether a request header is one of the cache key headers
+func (s *CacheSettings) keysOn(name string) bool {
+	if s == nil {
+		return false
+	}
+	for _, header := range s.Headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh renews a stored response the origin confirmed with a 304, taking the freshness headers
+// of the 304 and returning the renewed entry, or nil if it may no longer be cached
+func (c *responseCache) refresh(key string, entry *cachedResponse, notModified http.Header, settings *CacheSettings) *cachedResponse {
+	header := entry.header.Clone()
+	for _, name := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Vary"} {
+		if values := notModified.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if settings.variesOutsideKey(header) {
+		c.remove(key)
+		return nil
+	}
+	if c.disk == nil {
+		renewed := c.put(key, entry.status, header, entry.body, settings, time.Now())
+		if renewed != nil {
+			c.mu.Lock()
+			renewed.hits = entry.hits
+			c.mu.Unlock()
+		}
+		return renewed
+	}
+
+	// Keep the body already on disk, rewriting only the metadata
+	renewed := newCachedResponse(key, entry.status, header, settings, time.Now())
+	if renewed == nil {
+		c.remove(key)
+		return nil
+	}
+	renewed.disk, renewed.size = entry.disk, entry.size
+	renewed.hits = entry.hits
+	if err := c.disk.writeMeta(renewed, time.Now()); err != nil {
+		slog.Warn("Failed to write disk cache object", "error", err)
+		c.remove(key)
+		return nil
+	}
+	return c.insert(renewed)
+}
+
+// put stores a response fetched at stored under key, evicting the least recently used objects when
+// the cache is full
+func (c *responseCache) put(key string, status int, header http.Header, body []byte, settings *CacheSettings, stored time.Time) *cachedResponse {
+	entry := newCachedResponse(key, status, header, settings, stored)
+	if entry == nil {
+		c.remove(key)
+		return nil
+	}
+	entry.size = int64(len(body))
+	if c.disk == nil {
+		entry.body = body
+		return c.insert(entry)
+	}
+
+	c.mu.Lock()
+	tooLarge := entry.size > c.disk.maxBytes
+	c.mu.Unlock()
+	if tooLarge {
+		c.remove(key)
+		return nil
+	}
+	if err := c.disk.writeBody(entry, body); err != nil {
+		slog.Warn("Failed to write disk cache object", "error", err)
+		c.remove(key)
+		return nil
+	}
+	if err := c.disk.writeMeta(entry, time.Now()); err != nil {
+		slog.Warn("Failed to write disk cache object", "error", err)
+		c.remove(key)
+		return nil
+	}
+	return c.insert(entry)
+}
+
+// newCachedResponse creates the entry for a response fetched at now with its freshness, or returns
+// nil if the response must not be cached
+func newCachedResponse(key string, status int, header http.Header, settings *CacheSettings, now time.Time) *cachedResponse {
+	ttl, swr, sie, ok := settings.freshness(header, now)
+	if !ok {
+		return nil
+	}
+	return &cachedResponse{
+		key:                  key,
+		status:               status,
+		header:               header,
+		stored:               now,
+		ttl:                  ttl,
+		staleWhileRevalidate: swr,
+		staleIfError:         sie,
+	}
+}
+
+// insert makes entry the most recently used object, replacing any stored under its key
+func (c *responseCache) insert(entry *cachedResponse) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		// The files of a disk object are already replaced, so only the index changes
+		previous := c.order.Remove(elem).(*cachedResponse)
+		if c.disk != nil {
+			c.disk.bytes -= previous.size
+		}
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	if c.disk != nil {
+		c.disk.bytes += entry.size
+	}
+	c.evict()
+	return entry
+}
+
+// evict discards least recently used objects until the cache is within its limits; c.mu must be held
+func (c *responseCache) evict() {
+	for c.order.Len() > c.capacity || (c.disk != nil && c.disk.bytes > c.disk.maxBytes && c.order.Len() > 0) {
+		c.drop(c.order.Back())
+	}
+}
+
+// drop discards a stored object, along with its files on disk; c.mu must be held
+func (c *responseCache) drop(elem *list.Element) {
+	entry := c.order.Remove(elem).(*cachedResponse)
+	delete(c.entries, entry.key)
+	if c.disk != nil {
+		c.disk.remove(entry)
+	}
+}
+
+// flush discards every stored object
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.order.Len() > 0 {
+		c.drop(c.order.Back())
+	}
+}
+
+// remove discards the stored response for key
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.drop(elem)
+	}
+}
+
+// cacheObject describes a stored object for the admin API
+type cacheObject struct {
+	Key     string    `json:"key"`
+	Status  int       `json:"status"`
+	Size    int64     `json:"size"`
+	Hits    int64     `json:"hits"`
+	Stored  time.Time `json:"stored"`
+	Storage string    `json:"storage"`          // memory or disk
+	Shield  string    `json:"shield,omitempty"` // Region of the origin shield holding the object
+	// TTLRemainingSeconds is how long the object stays fresh, negative once it is stale
+	TTLRemainingSeconds float64     `json:"ttl_remaining_seconds"`
+	Header              http.Header `json:"header,omitempty"` // Only when a single object is requested
+}
+
+// describe returns what the admin API reports about an entry; c.mu must be held
+func (c *responseCache) describe(entry *cachedResponse, now time.Time) cacheObject {
+	storage := "memory"
+	if c.disk != nil {
+		storage = "disk"
+	}
+	return cacheObject{
+		Key:                 entry.key,
+		Status:              entry.status,
+		Size:                entry.size,
+		Hits:                entry.hits,
+		Stored:              entry.stored,
+		Storage:             storage,
+		Shield:              c.shield,
+		TTLRemainingSeconds: (entry.ttl - now.Sub(entry.stored)).Seconds(),
+	}
+}
+
+// objects describes the stored objects whose keys match pattern (all of them if it is empty),
+// most recently used first
+func (c *responseCache) objects(pattern string) []cacheObject {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var objects []cacheObject
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*cachedResponse)
+		if pattern == "" || matchCacheKey(pattern, entry.key) {
+			objects = append(objects, c.describe(entry, now))
+		}
+	}
+	return objects
+}
+
+// object describes the object stored under key, with its headers, without counting a hit
+func (c *responseCache) object(key string) (cacheObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheObject{}, false
+	}
+	entry := elem.Value.(*cachedResponse)
+	object := c.describe(entry, time.Now())
+	object.Header = entry.header.Clone()
+	return object, true
+}
+
+// purge discards the objects whose keys match pattern, returning how many were removed
+func (c *responseCache) purge(pattern string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if matchCacheKey(pattern, elem.Value.(*cachedResponse).key) {
+			c.drop(elem)
+			purged++
+		}
+		elem = next
+	}
+	return purged
+}
+
+// matchCacheKey matches a cache key against a pattern where * matches any run of characters and
+// ? exactly one. Patterns starting with / match the key without its host, e.g. /images/*.
+func matchCacheKey(pattern, key string) bool {
+	if strings.HasPrefix(pattern, "/") {
+		if slash := strings.IndexByte(key, '/'); slash >= 0 {
+			key = key[slash:]
+		}
+	}
+	return matchPolicyResource(pattern, key)
+}
+
+// collapse makes the caller the one fetching key from the origin, returning a function to call once
+// the response is stored, unless another request already is; then it waits for that fetch to finish
+// (or ctx to end) and returns nil
+func (c *responseCache) collapse(ctx context.Context, key string) func() {
+	c.mu.Lock()
+	fetching, ok := c.inflight[key]
+	if !ok {
+		done := make(chan struct{})
+		c.inflight[key] = done
+		c.mu.Unlock()
+		return func() {
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+			close(done)
+		}
+	}
+	c.mu.Unlock()
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// startRevalidation marks entry as being refetched, returning false if a refetch is already in flight
+func (c *responseCache) startRevalidation(entry *cachedResponse) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.revalidating {
+		return false
+	}
+	entry.revalidating = true
+	return true
+}
+
+// endRevalidation clears the refetch mark of an entry that is still being served
+func (c *responseCache) endRevalidation(entry *cachedResponse) {
+	c.mu.Lock()
+	entry.revalidating = false
+	c.mu.Unlock()
+}
+
+// serveCached answers a GET or HEAD request for a caching behavior from the cache when it can,
+// fetching from the origin (and storing the response) otherwise
+func (ph *ProxyHandler) serveCached(w http.ResponseWriter, r *http.Request, origin *Origin) {
+	key := cacheKey(r, origin)
+	lookupStarted := time.Now()
+	if !ph.config.ProcessingLatency.CacheLookup.wait(r, "cache_latency") {
+		return
+	}
+
+	entry := ph.cache.get(key)
+	ruleTraceFrom(r.Context()).phase("cache", lookupStarted)
+	if entry != nil {
+		started := time.Now()
+		age := started.Sub(entry.stored)
+		switch {
+		case age < entry.ttl:
+			entry.write(w, r, age, "Hit from cloudfauxnt")
+		case age < entry.ttl+entry.staleWhileRevalidate:
+			// Serve the stale object now and refresh it for the viewers that follow
+			if ph.cache.startRevalidation(entry) {
+				go ph.revalidate(r, origin, key, entry)
+			}
+			entry.write(w, r, age, "Hit from cloudfauxnt")
+			ruleTraceFrom(r.Context()).record("stale_while_revalidate", started)
+		default:
+			ph.revalidateForViewer(w, r, origin, key, entry, age)
+		}
+		return
+	}
+
+	// Concurrent misses wait for the first to fetch the object, then share it if it could be cached
+	if origin.Cache.CollapseRequests && r.Method == http.MethodGet {
+		started := time.Now()
+		done := ph.cache.collapse(r.Context(), key)
+		if done == nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			if entry := ph.cache.get(key); entry != nil {
+				ruleTraceFrom(r.Context()).record("collapsed_request", started)
+				entry.write(w, r, time.Since(entry.stored), "Hit from cloudfauxnt")
+				return
+			}
+		} else {
+			defer done()
+		}
+	}
+
+	if shield := ph.shields[origin.shieldRegion()]; shield != nil {
+		ph.serveShielded(w, r, origin, key, shield)
+		return
+	}
+
+	// Viewer validators are forwarded, so the origin answers conditional misses itself
+	rec := &responseRecorder{ResponseWriter: w, limit: int(ph.cache.maxObjectBytes)}
+	ph.fetch(rec, r, origin)
+	if r.Method == http.MethodGet && r.Context().Err() == nil {
+		ph.cache.store(key, rec, origin.Cache)
+	}
+}
+
+// revalidateForViewer asks the origin whether an expired object is still current while the viewer
+// waits, serving the stale copy if the origin fails within the stale-if-error window
+func (ph *ProxyHandler) revalidateForViewer(w http.ResponseWriter, r *http.Request, origin *Origin, key string, entry *cachedResponse, age time.Duration) {
+	trace := ruleTraceFrom(r.Context())
+	started := time.Now()
+
+	limit := int(ph.cache.maxObjectBytes)
+	gate := &revalidationGate{
+		viewer:  w,
+		request: r,
+		header:  make(http.Header),
+		limit:   limit,
+		hold:    age < entry.ttl+entry.staleIfError,
+	}
+	rec := &responseRecorder{ResponseWriter: gate, limit: limit}
+	ph.fetch(rec, entry.conditionalRequest(r), origin)
+	switch {
+	case rec.status == http.StatusNotModified:
+		trace.record("cache_revalidated", started)
+		if refreshed := ph.cache.refresh(key, entry, rec.header, origin.Cache); refreshed != nil {
+			entry = refreshed
+		}
+		entry.write(w, r, 0, "RefreshHit from cloudfauxnt")
+
+	case (rec.status == 0 || rec.status >= http.StatusInternalServerError) && gate.hold:
+		entry.write(w, r, age, "Hit from cloudfauxnt")
+		trace.record("stale_if_error", started)
+
+	default:
+		if ph.cache.store(key, rec, origin.Cache) == nil {
+			ph.cache.remove(key)
+		}
+		gate.release()
+	}
+}
+
+// revalidationGate decides what of the origin's answer to a viewer's revalidation reaches the
+// viewer. A 304 never does, as the viewer is served the refreshed object instead. Otherwise the
+// response streams through, unless the stale-if-error window is open: then it's held back until
+// it's complete or outgrows limit, and errors are dropped, so the stale copy can be served instead.
+type revalidationGate struct {
+	viewer   http.ResponseWriter
+	request  *http.Request
+	header   http.Header
+	limit    int
+	hold     bool
+	status   int
+	held     bytes.Buffer
+	released bool // The response has been passed on to the viewer
+	discard  bool // The body isn't for the viewer
+}
+
+// Header returns the origin response headers, kept apart from the viewer's until released
+func (g *revalidationGate) Header() http.Header {
+	return g.header
+}
+
+// WriteHeader decides whether the response is held back, dropped, or passed on
+func (g *revalidationGate) WriteHeader(status int) {
+	if g.status != 0 || status < 200 {
+		return
+	}
+	g.status = status
+	switch {
+	case status == http.StatusNotModified:
+		g.discard = true
+	case !g.hold:
+		g.release()
+	case status >= http.StatusInternalServerError:
+		g.discard = true
+	}
+}
+
+// Write passes the body on, holds it back, or drops it, releasing held bodies that outgrow limit
+func (g *revalidationGate) Write(b []byte) (int, error) {
+	if g.status == 0 {
+		g.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case g.released && !g.discard:
+		return g.viewer.Write(b)
+	case g.released || g.discard:
+		return len(b), nil
+	}
+	g.held.Write(b)
+	if g.held.Len() > g.limit {
+		g.release()
+	}
+	return len(b), nil
+}
+
+// Flush flushes a response that has been passed on; held responses wait
+func (g *revalidationGate) Flush() {
+	if g.released {
+		http.NewResponseController(g.viewer).Flush()
+	}
+}
+
+// release sends the response and any body held so far to the viewer, as a 304 when the viewer
+// already has this version
+func (g *revalidationGate) release() {
+	if g.released || g.status == 0 {
+		return
+	}
+	g.released = true
+	for name, values := range g.header {
+		g.viewer.Header()[name] = values
+	}
+	if g.status == http.StatusOK && notModified(g.request.Header, g.header) {
+		writeNotModified(g.viewer)
+		g.discard = true
+		return
+	}
+	g.viewer.WriteHeader(g.status)
+	if g.request.Method == http.MethodHead {
+		g.discard = true
+		return
+	}
+	g.viewer.Write(g.held.Bytes())
+	g.held.Reset()
+}
+
+// revalidate refetches a stale object in the background, replacing it unless the origin fails
+func (ph *ProxyHandler) revalidate(r *http.Request, origin *Origin, key string, stale *cachedResponse) {
+	background := stale.conditionalRequest(r.WithContext(context.WithoutCancel(r.Context())))
+
+	// A body the proxy couldn't finish copying (e.g. one over max_response_bytes) aborts the
+	// fetch, which has no viewer connection to drop here; the stale object is kept
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered != http.ErrAbortHandler {
+				panic(recovered)
+			}
+			ph.cache.endRevalidation(stale)
+		}
+	}()
+
+	rec := newBufferedRecorder()
+	ph.fetch(rec, background, origin)
+	switch {
+	case rec.status == http.StatusNotModified:
+		ph.cache.refresh(key, stale, rec.header, origin.Cache)
+	case rec.status == 0 || rec.status >= http.StatusInternalServerError:
+		ph.cache.endRevalidation(stale)
+	case ph.cache.store(key, rec, origin.Cache) == nil:
+		ph.cache.remove(key)
+	}
+}
+
+// notModified reports whether a viewer's If-None-Match or If-Modified-Since validators match a
+// response, so a 304 can be sent instead of the body. If-None-Match takes precedence when present.
+func notModified(request, response http.Header) bool {
+	if ifNoneMatch := request.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(response.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(request.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(response.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
+
+// writeNotModified sends a 304 with the headers already set on w, minus those describing a body
+func writeNotModified(w http.ResponseWriter) {
+	for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding"} {
+		w.Header().Del(name)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheKeepsVariantsApart(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", r.URL.Query().Get("vary"))
+		io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+	defer origin.Close()
+
+	tests := []struct {
+		name        string
+		vary        string
+		headers     string
+		secondCache string
+	}{
+		{"vary on a key header", "Accept-Language", `["Accept-Language"]`, "Miss from cloudfauxnt"},
+		{"vary on a header outside the key", "Accept-Language", `[]`, "Miss from cloudfauxnt"},
+		{"vary on everything", "*", `[]`, "Miss from cloudfauxnt"},
+		{"no vary", "", `[]`, "Hit from cloudfauxnt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := loadTestConfig(t, `
+config_version: 2
+server:
+  port: 8080
+signing:
+  enabled: false
+origins:
+  - name: app
+    url: `+origin.URL+`
+behaviors:
+  - target_origin: app
+    path_patterns: ["/*"]
+    cache:
+      headers: `+tt.headers+`
+`)
+			handler := NewProxyHandler(config, NewValidatorFromConfig(config), NewMetrics(), NewBypassTokens())
+			get := func(language string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", "/page?vary="+tt.vary, nil)
+				req.Header.Set("Accept-Language", language)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			get("fr")
+			second := get("de")
+			if cache := second.Header().Get("X-Cache"); cache != tt.secondCache {
+				t.Errorf("X-Cache = %q, want %q", cache, tt.secondCache)
+			}
+			// Only an object without Vary is shared between the two languages
+			want := "de"
+			if tt.vary == "" {
+				want = "fr"
+			}
+			if body := second.Body.String(); body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestCacheStoresVariantsOfKeyHeaders(t *testing.T) {
+	fetches := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+	defer origin.Close()
+
+	config := loadTestConfig(t, `
+config_version: 2
+server:
+  port: 8080
+signing:
+  enabled: false
+origins:
+  - name: app
+    url: `+origin.URL+`
+behaviors:
+  - target_origin: app
+    path_patterns: ["/*"]
+    cache:
+      headers: ["Accept-Language"]
+`)
+	handler := NewProxyHandler(config, NewValidatorFromConfig(config), NewMetrics(), NewBypassTokens())
+	for _, language := range []string{"fr", "de", "fr", "de"} {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.Header.Set("Accept-Language", language)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if body := rec.Body.String(); body != language {
+			t.Errorf("body for %s = %q", language, body)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("origin fetches = %d, want 2 (one per language)", fetches)
+	}
+}
+
+func TestCacheSkipsVaryOutsideKey(t *testing.T) {
+	fetches := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding, Accept-Language")
+		io.WriteString(w, r.Header.Get("Accept-Language"))
+	}))
+	defer origin.Close()
+
+	config := loadTestConfig(t, `
+config_version: 2
+server:
+  port: 8080
+signing:
+  enabled: false
+origins:
+  - name: app
+    url: `+origin.URL+`
+behaviors:
+  - target_origin: app
+    path_patterns: ["/*"]
+    cache:
+      headers: ["Accept-Language"]
+`)
+	handler := NewProxyHandler(config, NewValidatorFromConfig(config), NewMetrics(), NewBypassTokens())
+	for range 2 {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.Header.Set("Accept-Language", "fr")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if fetches != 2 {
+		t.Errorf("origin fetches = %d, want 2 (Accept-Encoding isn't in the cache key)", fetches)
+	}
+}
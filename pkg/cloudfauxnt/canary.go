@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// canaryPrimary and canaryAssigned are the assignment cookie values
+	canaryPrimary  = "primary"
+	canaryAssigned = "canary"
+)
+
+// validate checks canary settings and fills in defaults
+func (c *CanaryConfig) validate(originName string, security *OriginSecurityConfig) error {
+	if c.URL == "" {
+		return fmt.Errorf("canary.url is required")
+	}
+	if err := security.CheckOriginURL(c.URL); err != nil {
+		return fmt.Errorf("canary: %w", err)
+	}
+	if c.Weight < 0 || c.Weight > 100 {
+		return fmt.Errorf("canary.weight must be 0-100, got %d", c.Weight)
+	}
+	if c.CookieName == "" {
+		c.CookieName = "cloudfauxnt-canary-" + originName
+	}
+	if err := (&http.Cookie{Name: c.CookieName, Value: canaryPrimary}).Valid(); err != nil {
+		return fmt.Errorf("canary.cookie_name: %w", err)
+	}
+	if c.CookieMaxAgeSeconds == 0 {
+		c.CookieMaxAgeSeconds = 7 * 24 * 3600
+	}
+	return nil
+}
+
+// assign decides whether the canary serves a viewer. Viewers carrying an assignment cookie keep
+// their assignment; the rest are assigned by weight and need the cookie set.
+func (c *CanaryConfig) assign(r *http.Request) (canary bool, setCookie bool) {
+	if cookie, err := r.Cookie(c.CookieName); err == nil {
+		switch cookie.Value {
+		case canaryPrimary:
+			return false, false
+		case canaryAssigned:
+			return true, false
+		}
+	}
+	return canaryRandom.IntN(100) < c.Weight, true
+}
+
+// cookie returns the assignment cookie for a viewer
+func (c *CanaryConfig) cookie(canary bool) *http.Cookie {
+	value := canaryPrimary
+	if canary {
+		value = canaryAssigned
+	}
+	return &http.Cookie{Name: c.CookieName, Value: value, Path: "/", MaxAge: c.CookieMaxAgeSeconds}
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPKey is the context key for the resolved viewer IP
+type clientIPKey struct{}
+
+// prepare validates the client IP policy and parses the trusted proxy ranges
+func (c *ClientIPConfig) prepare() error {
+	if c.Source == "" {
+		c.Source = "remote_addr"
+	}
+	switch c.Source {
+	case "remote_addr", "xff_first", "xff_last":
+	case "header":
+		if c.Header == "" {
+			return fmt.Errorf("header is required when source is header")
+		}
+	default:
+		return fmt.Errorf("source must be remote_addr, xff_first, xff_last, or header, got %q", c.Source)
+	}
+
+	c.trusted = nil
+	for _, cidr := range c.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err)
+		}
+		c.trusted = append(c.trusted, prefix.Masked())
+	}
+	return nil
+}
+
+// isTrusted reports whether an address is a trusted proxy; with no ranges configured every peer is trusted
+func (c *ClientIPConfig) isTrusted(addr netip.Addr) bool {
+	if len(c.trusted) == 0 {
+		return true
+	}
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve determines the viewer IP for a request. Forwarding headers are only believed when the
+// connecting peer is a trusted proxy; otherwise, or when the header is missing or malformed, the
+// peer address is used.
+func (c *ClientIPConfig) resolve(r *http.Request) string {
+	remote := remoteIP(r)
+	peer, err := netip.ParseAddr(remote)
+	if err != nil || c.Source == "remote_addr" || !c.isTrusted(peer) {
+		return remote
+	}
+
+	var candidate string
+	switch c.Source {
+	case "header":
+		candidate, _, _ = strings.Cut(r.Header.Get(c.Header), ",")
+	case "xff_first":
+		hops := forwardedHops(r.Header)
+		if len(hops) > 0 {
+			candidate = hops[0]
+		}
+	case "xff_last":
+		// Walk back from the nearest hop, skipping our own trusted proxies
+		hops := forwardedHops(r.Header)
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(hops[i])
+			if err != nil {
+				break
+			}
+			candidate = hops[i]
+			if len(c.trusted) == 0 || !c.isTrusted(addr) {
+				break
+			}
+		}
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(candidate))
+	if err != nil {
+		return remote
+	}
+	return addr.Unmap().String()
+}
+
+// trustsForwarding reports whether the forwarding headers of a request are believed: the viewer IP
+// comes from a header and the connecting peer is a trusted proxy
+func (c *ClientIPConfig) trustsForwarding(r *http.Request) bool {
+	peer, err := netip.ParseAddr(remoteIP(r))
+	return err == nil && c.Source != "remote_addr" && c.isTrusted(peer)
+}
+
+// setForwardedHeaders sets the forwarding headers of an origin request like CloudFront.
+// X-Forwarded-Proto and X-Forwarded-Port describe the viewer's connection, overwriting values the
+// viewer sent unless they came through a trusted proxy. The connecting IP is appended to
+// X-Forwarded-For by the reverse proxy; with strip_forwarded_for, untrusted chains are dropped first.
+func (c *ClientIPConfig) setForwardedHeaders(req, viewer *http.Request) {
+	trusted := c.trustsForwarding(viewer)
+	if c.StripForwardedFor && !trusted {
+		req.Header.Del("X-Forwarded-For")
+	}
+	if trusted && req.Header.Get("X-Forwarded-Proto") != "" {
+		return
+	}
+
+	proto := "http"
+	if viewer.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Del("X-Forwarded-Port")
+	if local, ok := viewer.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(local.String()); err == nil {
+			req.Header.Set("X-Forwarded-Port", port)
+		}
+	}
+}
+
+// forwardedHops returns the X-Forwarded-For entries across all header lines, nearest hop last
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// remoteIP returns the IP of the connecting peer
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// ClientIPMiddleware resolves the viewer IP once per request so every consumer sees the same address
+func ClientIPMiddleware(config *ClientIPConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey{}, config.resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP returns the viewer IP resolved by the client IP policy, or the peer address when the
+// request did not pass through ClientIPMiddleware
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
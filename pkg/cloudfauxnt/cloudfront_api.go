@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultCloudFrontEndpoint is the global CloudFront API endpoint
+const defaultCloudFrontEndpoint = "https://cloudfront.amazonaws.com"
+
+// CloudFrontAPI reads distribution settings from the CloudFront API for ImportDistributionConfig,
+// signing requests with AWS Signature Version 4
+type CloudFrontAPI struct {
+	Endpoint        string // Default: https://cloudfront.amazonaws.com
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string       // Optional: for temporary credentials
+	Client          *http.Client // Default: http.DefaultClient
+}
+
+// GetDistributionConfig returns the DistributionConfig XML of a distribution
+func (a *CloudFrontAPI) GetDistributionConfig(ctx context.Context, distributionID string) ([]byte, error) {
+	return a.get(ctx, "/2020-05-31/distribution/"+url.PathEscape(distributionID)+"/config")
+}
+
+// KeyGroupPublicKeys returns the IDs of the public keys in a key group, which signed URLs name as
+// their Key-Pair-Id
+func (a *CloudFrontAPI) KeyGroupPublicKeys(ctx context.Context, keyGroupID string) ([]string, error) {
+	data, err := a.get(ctx, "/2020-05-31/key-group/"+url.PathEscape(keyGroupID))
+	if err != nil {
+		return nil, err
+	}
+	var keyGroup struct {
+		PublicKeys []string `xml:"KeyGroupConfig>Items>PublicKey"`
+	}
+	if err := xml.Unmarshal(data, &keyGroup); err != nil {
+		return nil, fmt.Errorf("failed to parse key group %s: %w", keyGroupID, err)
+	}
+	return keyGroup.PublicKeys, nil
+}
+
+// PublicKey returns the PEM-encoded key of a CloudFront public key, whose ID signed URLs name as
+// their Key-Pair-Id
+func (a *CloudFrontAPI) PublicKey(ctx context.Context, publicKeyID string) ([]byte, error) {
+	data, err := a.get(ctx, "/2020-05-31/public-key/"+url.PathEscape(publicKeyID))
+	if err != nil {
+		return nil, err
+	}
+	var publicKey struct {
+		EncodedKey string `xml:"PublicKeyConfig>EncodedKey"`
+	}
+	if err := xml.Unmarshal(data, &publicKey); err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", publicKeyID, err)
+	}
+	if publicKey.EncodedKey == "" {
+		return nil, fmt.Errorf("public key %s has no encoded key", publicKeyID)
+	}
+	return []byte(publicKey.EncodedKey), nil
+}
+
+// get sends a signed GET request to the CloudFront API and returns the response body
+func (a *CloudFrontAPI) get(ctx context.Context, path string) ([]byte, error) {
+	endpoint := a.Endpoint
+	if endpoint == "" {
+		endpoint = defaultCloudFrontEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(endpoint, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+	// CloudFront is a global service, signed for us-east-1
+	signAWSRequest(req, nil, "cloudfront", "us-east-1", a.AccessKeyID, a.SecretAccessKey, time.Now())
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("CloudFront API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CloudFront API response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiError struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(body, &apiError) == nil && apiError.Code != "" {
+			return nil, fmt.Errorf("CloudFront API returned %d: %s: %s", resp.StatusCode, apiError.Code, apiError.Message)
+		}
+		return nil, fmt.Errorf("CloudFront API returned %d", resp.StatusCode)
+	}
+	return body, nil
+}
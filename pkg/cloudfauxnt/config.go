@@ -0,0 +1,1395 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the CloudFauxnt configuration
+type Config struct {
+	Server        ServerConfig   `yaml:"server"`
+	Origins       []Origin       `yaml:"origins"`
+	CORS          CORSConfig     `yaml:"cors"`
+	Signing       SigningConfig  `yaml:"signing"`
+	Distributions []Distribution `yaml:"distributions"` // Optional: extra distributions routed by Host header
+	// Optional: host names the top-level distribution serves; once set, requests for hosts no
+	// distribution serves get CloudFront's 403 instead of the top-level behaviors
+	Aliases []string `yaml:"aliases"`
+	// Optional: serve part of the top-level distribution's traffic with a staging variant of its behaviors
+	ContinuousDeployment ContinuousDeploymentConfig `yaml:"continuous_deployment"`
+	// Optional: "deny" requires every behavior to declare public: true or require_signature: true (default: "allow")
+	DefaultAccess string `yaml:"default_access"`
+	// Optional: "ordered" picks the first behavior listed whose path pattern matches, as CloudFront does,
+	// and "longest" the longest matching pattern (default: "ordered")
+	MatchStrategy           string                  `yaml:"match_strategy"`
+	ResponseHeadersPolicies []ResponseHeadersPolicy `yaml:"response_headers_policies"`
+	OriginRequestPolicies   []OriginRequestPolicy   `yaml:"origin_request_policies"`
+	OriginSecurity          OriginSecurityConfig    `yaml:"origin_security"`
+	ClientIP                ClientIPConfig          `yaml:"client_ip"`
+	AccessLog               AccessLogConfig         `yaml:"access_log"`
+	RealtimeLog             RealtimeLogConfig       `yaml:"realtime_log"`
+	Metrics                 MetricsConfig           `yaml:"metrics"`
+	Logging                 LoggingConfig           `yaml:"logging"`
+	Admin                   AdminConfig             `yaml:"admin"`
+	// Optional: IP set, URI, header, and rate-based rules that block requests like AWS WAF
+	WAF WAFConfig `yaml:"waf"`
+	// Optional: token-bucket rate limit per client IP or signed cookie identity
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// Optional: daily request and byte quotas of the top-level distribution, and the default for the others
+	Quota *QuotaConfig `yaml:"quota"`
+	// Optional: record the origins and path prefixes traffic uses, to suggest configuration
+	Learning LearningConfig `yaml:"learning"`
+	// Optional: named viewer latency profiles, referenced by a behavior's latency_profile or chosen per request by header
+	LatencyProfiles LatencyProfilesConfig `yaml:"latency_profiles"`
+	// Optional: artificial delays in signature validation and cache lookups, modelling heavier edge compute
+	ProcessingLatency ProcessingLatencyConfig `yaml:"processing_latency"`
+	// Optional: field-level encryption profiles, referenced by a behavior's field_level_encryption
+	FieldLevelEncryptionProfiles []FieldLevelEncryptionProfile `yaml:"field_level_encryption_profiles"`
+	// Optional: backend shared by features that persist objects, such as access log shipping
+	Storage StorageConfig `yaml:"storage"`
+	// Optional: pass only standard and allowlisted origin response headers to viewers
+	ViewerResponseHeaders ViewerResponseHeadersConfig `yaml:"viewer_response_headers"`
+	// Optional: reject settings exceeding CloudFront quotas, such as cache behaviors per distribution
+	AWSLimits AWSLimitsConfig `yaml:"aws_limits"`
+	// Optional: false logs unknown configuration keys as warnings instead of refusing to start (default: true)
+	StrictConfig *bool `yaml:"strict_config"`
+
+	path string // File the configuration was loaded from, used for reloads
+}
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Port              int    `yaml:"port"`
+	Host              string `yaml:"host"`
+	DefaultRootObject string `yaml:"default_root_object"` // Global default (fallback if origin doesn't specify one)
+	IndexDocument     string `yaml:"index_document"`      // Global default appended to subdirectory requests (fallback if origin doesn't specify one)
+	TimeoutSeconds    int    `yaml:"timeout_seconds"`
+	MaxHops           int    `yaml:"max_hops"`         // Reject requests that already passed through CloudFauxnt this many times
+	ErrorCacheSize    int    `yaml:"error_cache_size"` // Number of rendered error bodies kept in memory (negative disables)
+	EdgeLocation      string `yaml:"edge_location"`    // Fake POP code sent as X-Amz-Cf-Pop and reported in logs (default: "LOCAL1-C1")
+	DumpConfig        bool   `yaml:"dump_config"`      // Log the effective configuration (secrets redacted) at startup
+	// WatchConfig reloads the configuration when the file changes (SIGHUP always triggers a reload)
+	WatchConfig          bool `yaml:"watch_config"`
+	WatchIntervalSeconds int  `yaml:"watch_interval_seconds"` // How often the file is checked (default: 2)
+	// TLSCertFile and TLSKeyFile serve HTTPS instead of HTTP when both are set
+	TLSCertFile string        `yaml:"tls_cert_file"`
+	TLSKeyFile  string        `yaml:"tls_key_file"`
+	HTTP2       HTTP2Settings `yaml:"http2"` // Optional: viewer-side HTTP/2
+	// CacheSize is the number of origin responses kept for behaviors with caching (negative disables caching)
+	CacheSize           int   `yaml:"cache_size"`
+	CacheMaxObjectBytes int64 `yaml:"cache_max_object_bytes"` // Larger responses are never cached (default: 10MiB)
+	// CacheDir keeps cached responses on disk instead of in memory, where they survive restarts
+	CacheDir      string `yaml:"cache_dir"`
+	CacheMaxBytes int64  `yaml:"cache_max_bytes"` // Total size of the disk cache's objects (default: 1GiB)
+	// ShieldCacheSize is the number of objects each origin shield region keeps in memory (default: 4096)
+	ShieldCacheSize int `yaml:"shield_cache_size"`
+	// ReadinessTimeoutSeconds bounds how long /health/ready waits for origin probes (default: 2)
+	ReadinessTimeoutSeconds int `yaml:"readiness_timeout_seconds"`
+	// PrewarmConnections opens this many idle connections to every origin at startup and after each
+	// reload, so benchmarks don't start with DNS, TCP, and TLS setup (0 disables, max 32)
+	PrewarmConnections int `yaml:"prewarm_connections"`
+	// KeepWarm refreshes the prewarmed connections before keepalive_timeout_seconds closes them
+	KeepWarm bool `yaml:"keep_warm"`
+	// OriginCertWarningDays warns at startup and on reload about https origins whose certificates
+	// expire within this many days (default: 14, -1 disables)
+	OriginCertWarningDays int `yaml:"origin_cert_warning_days"`
+	// OnOptionalFailure is fail (default), stopping startup when an optional subsystem such as storage,
+	// the access log, the disk cache, or the admin API can't start, or degrade, running without it
+	OnOptionalFailure string `yaml:"on_optional_failure"`
+}
+
+// HTTP2Settings holds viewer-side HTTP/2 support and the SETTINGS advertised to clients.
+// Zero values leave Go's defaults in place.
+type HTTP2Settings struct {
+	Enabled                     bool `yaml:"enabled"`                        // HTTP/2 over TLS (ALPN) and cleartext h2c with prior knowledge
+	MaxConcurrentStreams        int  `yaml:"max_concurrent_streams"`         // SETTINGS_MAX_CONCURRENT_STREAMS
+	InitialStreamWindowSize     int  `yaml:"initial_stream_window_size"`     // SETTINGS_INITIAL_WINDOW_SIZE in bytes (max 4MiB)
+	InitialConnectionWindowSize int  `yaml:"initial_connection_window_size"` // Connection flow control window in bytes (64KiB-4MiB)
+	MaxFrameSize                int  `yaml:"max_frame_size"`                 // SETTINGS_MAX_FRAME_SIZE (16KiB-16MiB)
+}
+
+// Origin represents a backend origin server
+type Origin struct {
+	Name              string   `yaml:"name"`
+	URL               string   `yaml:"url"`
+	PathPatterns      []string `yaml:"path_patterns"`
+	StripPrefix       string   `yaml:"strip_prefix"`        // Optional: remove this prefix from request path
+	TargetPrefix      string   `yaml:"target_prefix"`       // Optional: add this prefix to proxied path
+	RequireSignature  *bool    `yaml:"require_signature"`   // Optional: require CloudFront signature for this origin (null/empty uses global setting)
+	DefaultRootObject *string  `yaml:"default_root_object"` // Optional: default root object for this origin (null/empty uses global setting)
+	// Optional: object appended to requests for subdirectories ending in "/", like an S3 website
+	// index document (null uses global setting, "" disables)
+	IndexDocument *string `yaml:"index_document"`
+	// Optional: key pairs accepted under narrower path patterns of this behavior; the first matching scope applies
+	SigningScopes []SigningScope `yaml:"signing_scopes"`
+	// Optional: the key pairs this behavior accepts signatures from, like the trusted key groups of a
+	// CloudFront cache behavior; setting them requires signatures (default: every trusted key pair)
+	TrustedKeyPairIDs []string `yaml:"trusted_key_pair_ids"`
+	// Optional: name of a response headers policy applied to every response from this origin
+	ResponseHeadersPolicy string `yaml:"response_headers_policy"`
+	// Optional: name of an origin request policy controlling which viewer headers, cookies, and query strings are forwarded
+	OriginRequestPolicy string `yaml:"origin_request_policy"`
+	// Optional: act as a transparent proxy, skipping CloudFront header injection and error-body rewriting
+	PlainProxy bool `yaml:"plain_proxy"`
+	// Optional: proxy methods CloudFront rejects (TRACE, CONNECT, custom verbs) instead of answering 403
+	ForwardNonStandardMethods bool `yaml:"forward_non_standard_methods"`
+	// Optional: send a weighted share of viewers to a canary origin, sticky per viewer
+	Canary *CanaryConfig `yaml:"canary"`
+	// Optional: proxy gRPC and gRPC-web over HTTP/2 (h2c for http URLs), streaming responses and trailers
+	GRPC bool `yaml:"grpc"`
+	// Optional: how often response data is flushed to viewers while it streams in, -1 after every write
+	// (default: 0, when the write buffer fills; event streams and responses without Content-Length flush immediately)
+	FlushIntervalMs int `yaml:"flush_interval_ms"`
+	// Optional: header names sent to this origin with exactly this casing (e.g. SOAPAction) instead of Go's canonical form
+	HeaderCasing []string `yaml:"header_casing"`
+	// Optional: methods this behavior accepts, one of CloudFront's sets (default: all seven); others get 403
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// Optional: largest request body accepted, in bytes; larger bodies get 413 (default: 0, unlimited)
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// Optional: largest origin response body proxied, in bytes; larger responses are refused with 502,
+	// or cut off while streaming when their length isn't declared (default: 0, unlimited)
+	MaxResponseBytes int64 `yaml:"max_response_bytes"`
+	// Optional: serve this behavior without signatures, even when signing is enabled
+	Public bool `yaml:"public"`
+	// Optional: inject latency, 5xx errors, connection resets, or slow bodies; the first matching rule applies
+	Faults []FaultRule `yaml:"faults"`
+	// Optional: answer identical POSTs (same host, URL, and body) within this many seconds with the first response
+	PostDedupeWindowSeconds int `yaml:"post_dedupe_window_seconds"`
+	// Optional: name of a latency profile simulating this behavior's viewers' network
+	LatencyProfile string `yaml:"latency_profile"`
+	// Optional: cache GET and HEAD responses, serving stale objects while revalidating or when the origin fails
+	Cache *CacheSettings `yaml:"cache"`
+	// Optional: send cache misses of caching behaviors through a simulated Origin Shield, a second cache layer in front of the origin
+	OriginShield *OriginShieldConfig `yaml:"origin_shield"`
+	// Optional: copy the bodies of responses fetched from the origin to a local directory
+	Tee *ResponseTeeConfig `yaml:"tee"`
+	// Optional: build the upstream path from the viewer path, e.g. /avatars/${1}.png for /users/*/avatar
+	PathTemplate string `yaml:"path_template"`
+	// Optional: name of a field-level encryption profile applied to POST form and JSON bodies
+	FieldLevelEncryption string `yaml:"field_level_encryption"`
+	// Optional: which viewer cookies are forwarded and cached on: all, none, whitelist, or allExcept listed names
+	Cookies *ForwardingRuleConfig `yaml:"cookies"`
+	// Optional: which query parameters are forwarded and cached on: all, none, whitelist, or allExcept listed names
+	QueryStrings *ForwardingRuleConfig `yaml:"query_strings"`
+	// Optional: which Set-Cookie headers reach the viewer: all, none, whitelist, or allExcept listed names
+	ResponseCookies *ForwardingRuleConfig `yaml:"response_cookies"`
+	// Optional: static headers added to every request sent to this origin (e.g. an X-Origin-Verify secret),
+	// replacing viewer headers with the same name
+	CustomHeaders map[string]string `yaml:"custom_headers"`
+	// Optional: response header the origin must echo the X-Amz-Cf-Id it was sent in (e.g. X-Amz-Cf-Id);
+	// missing or wrong values are reported in the rule trace, metrics, and log
+	RequestIDEchoHeader string `yaml:"request_id_echo_header"`
+	// Optional: local IP address origin connections are made from, for multi-homed hosts
+	SourceIP string `yaml:"source_ip"`
+	// Optional: network interface whose address origin connections are made from (alternative to source_ip)
+	SourceInterface string `yaml:"source_interface"`
+	// Optional: CloudFront's ConnectionAttempts, how many times the origin is tried (1-3, default: 3)
+	ConnectionAttempts int `yaml:"connection_attempts"`
+	// Optional: CloudFront's ConnectionTimeout, seconds to wait for a connection (1-10, default: 10)
+	ConnectionTimeoutSeconds int `yaml:"connection_timeout_seconds"`
+	// Optional: CloudFront's OriginReadTimeout, seconds to wait for the response (1-180, default: 30)
+	ReadTimeoutSeconds int `yaml:"read_timeout_seconds"`
+	// Optional: CloudFront's OriginKeepaliveTimeout, seconds an idle connection is kept (1-180, default: 5)
+	KeepaliveTimeoutSeconds int `yaml:"keepalive_timeout_seconds"`
+	// Optional: path /health/ready sends its HEAD probe to (default: "/")
+	HealthCheckPath string `yaml:"health_check_path"`
+}
+
+// CanaryConfig routes a percentage of viewers to an alternate origin URL. Each viewer's assignment is
+// stored in a cookie, so it survives CloudFauxnt restarts and weight changes.
+type CanaryConfig struct {
+	URL                 string `yaml:"url"`
+	Weight              int    `yaml:"weight"`                 // Percentage of new viewers sent to the canary (0-100)
+	CookieName          string `yaml:"cookie_name"`            // Default: cloudfauxnt-canary-<origin name>
+	CookieMaxAgeSeconds int    `yaml:"cookie_max_age_seconds"` // Default: 604800 (one week)
+}
+
+// Distribution is an additional CloudFront distribution selected by the request's Host header.
+// Requests whose host matches no distribution alias use the top-level origins and signing settings.
+type Distribution struct {
+	Name    string         `yaml:"name"`
+	Aliases []string       `yaml:"aliases"` // Host names served by this distribution; "*.example.com" matches any subdomain
+	Origins []Origin       `yaml:"origins"`
+	Signing *SigningConfig `yaml:"signing"` // Optional: signing settings for this distribution (null uses the top-level settings)
+	// Optional: "allow" or "deny" for this distribution (empty uses the top-level default_access)
+	DefaultAccess string `yaml:"default_access"`
+	// Optional: "ordered" or "longest" for this distribution (empty uses the top-level match_strategy)
+	MatchStrategy string `yaml:"match_strategy"`
+	// Optional: daily quotas for this distribution (null uses the top-level quota, counted separately)
+	Quota *QuotaConfig `yaml:"quota"`
+}
+
+// CORSConfig holds CORS policy settings
+type CORSConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	MaxAge         int      `yaml:"max_age"`
+}
+
+// ResponseHeadersPolicy mirrors a CloudFront response headers policy
+type ResponseHeadersPolicy struct {
+	Name            string                 `yaml:"name"`
+	SecurityHeaders SecurityHeadersConfig  `yaml:"security_headers"`
+	CORS            *ResponseCORSConfig    `yaml:"cors"`           // Optional: CORS headers added to responses
+	CustomHeaders   []ResponseCustomHeader `yaml:"custom_headers"` // Optional: arbitrary headers to add or override
+	RemoveHeaders   []string               `yaml:"remove_headers"` // Optional: headers removed from origin responses
+}
+
+// SecurityHeadersConfig holds the security headers section of a response headers policy
+type SecurityHeadersConfig struct {
+	StrictTransportSecurity *HSTSHeaderConfig   `yaml:"strict_transport_security"`
+	FrameOptions            *PolicyHeaderConfig `yaml:"frame_options"`           // DENY or SAMEORIGIN
+	ContentSecurityPolicy   *PolicyHeaderConfig `yaml:"content_security_policy"` // Full CSP header value
+	ReferrerPolicy          *PolicyHeaderConfig `yaml:"referrer_policy"`         // e.g. strict-origin-when-cross-origin
+	ContentTypeOptions      *PolicyHeaderConfig `yaml:"content_type_options"`    // Always emits nosniff; value is ignored
+	XSSProtection           *PolicyHeaderConfig `yaml:"xss_protection"`          // e.g. "1; mode=block"
+	// ContentSecurityPolicyReportOnly is a CSP that only reports violations, for staging a rollout
+	ContentSecurityPolicyReportOnly *CSPReportOnlyConfig `yaml:"content_security_policy_report_only"`
+}
+
+// PolicyHeaderConfig is a single policy-managed header value
+type PolicyHeaderConfig struct {
+	Value    string `yaml:"value"`
+	Override bool   `yaml:"override"` // Replace the origin's value instead of only filling it in when missing
+}
+
+// HSTSHeaderConfig holds Strict-Transport-Security settings
+type HSTSHeaderConfig struct {
+	MaxAgeSeconds     int  `yaml:"max_age_seconds"`
+	IncludeSubdomains bool `yaml:"include_subdomains"`
+	Preload           bool `yaml:"preload"`
+	Override          bool `yaml:"override"`
+}
+
+// ResponseCORSConfig holds the CORS section of a response headers policy
+type ResponseCORSConfig struct {
+	AllowOrigins     []string `yaml:"allow_origins"`
+	AllowMethods     []string `yaml:"allow_methods"`
+	AllowHeaders     []string `yaml:"allow_headers"`
+	ExposeHeaders    []string `yaml:"expose_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAgeSeconds    int      `yaml:"max_age_seconds"`
+	OriginOverride   bool     `yaml:"origin_override"` // Replace CORS headers sent by the origin
+}
+
+// ResponseCustomHeader is a custom header added by a response headers policy
+type ResponseCustomHeader struct {
+	Header   string `yaml:"header"`
+	Value    string `yaml:"value"`
+	Override bool   `yaml:"override"`
+}
+
+// OriginRequestPolicy mirrors a CloudFront origin request policy
+type OriginRequestPolicy struct {
+	Name         string               `yaml:"name"`
+	Headers      ForwardingRuleConfig `yaml:"headers"`       // none, whitelist, allViewer, allViewerAndCloudFrontHeaders
+	Cookies      ForwardingRuleConfig `yaml:"cookies"`       // none, whitelist, all
+	QueryStrings ForwardingRuleConfig `yaml:"query_strings"` // none, whitelist, all
+}
+
+// ForwardingRuleConfig selects which request attributes of one kind are forwarded to the origin
+type ForwardingRuleConfig struct {
+	Behavior string   `yaml:"behavior"`
+	Items    []string `yaml:"items"` // Names forwarded when behavior is whitelist
+}
+
+// validateNames checks a rule that selects names with all, none, whitelist, or allExcept, like the
+// cookie and query string behaviors of a CloudFront cache policy, defaulting to all
+func (r *ForwardingRuleConfig) validateNames(field string) error {
+	if r == nil {
+		return nil
+	}
+	if r.Behavior == "" {
+		r.Behavior = "all"
+	}
+	switch r.Behavior {
+	case "all", "none":
+	case "whitelist", "allExcept":
+		if len(r.Items) == 0 {
+			return fmt.Errorf("%s.items is required when behavior is %s", field, r.Behavior)
+		}
+	default:
+		return fmt.Errorf("%s.behavior must be all, none, whitelist, or allExcept, got %q", field, r.Behavior)
+	}
+	return nil
+}
+
+// allows reports whether a rule checked by validateNames selects a name. Items may use * and ?
+// wildcards, so _ga* covers every Google Analytics cookie.
+func (r *ForwardingRuleConfig) allows(name string) bool {
+	listed := false
+	for _, item := range r.Items {
+		if matchPolicyResource(item, name) {
+			listed = true
+			break
+		}
+	}
+	switch r.Behavior {
+	case "none":
+		return false
+	case "whitelist":
+		return listed
+	case "allExcept":
+		return !listed
+	}
+	return true
+}
+
+// OriginSecurityConfig holds SSRF guardrails applied to origin URLs and origin connections
+type OriginSecurityConfig struct {
+	AllowedSchemes      []string `yaml:"allowed_schemes"`       // Default: http, https
+	BlockMetadataRanges bool     `yaml:"block_metadata_ranges"` // Deny link-local and cloud metadata addresses
+	DeniedCIDRs         []string `yaml:"denied_cidrs"`          // Additional address ranges origins may not connect to
+
+	deniedPrefixes []netip.Prefix
+}
+
+// ClientIPConfig controls how the viewer IP is determined for logs and IP-based rules, and which
+// forwarding headers are passed on to origins
+type ClientIPConfig struct {
+	Source string `yaml:"source"` // remote_addr, xff_first, xff_last, or header (default: remote_addr)
+	Header string `yaml:"header"` // Header to read when source is header, e.g. CF-Connecting-IP
+	// TrustedProxies lists peers whose forwarding headers are believed (default: any peer)
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// StripForwardedFor drops X-Forwarded-For values from peers whose forwarding headers aren't believed,
+	// so origins see only the connecting IP instead of a chain the viewer may have forged
+	StripForwardedFor bool `yaml:"strip_forwarded_for"`
+
+	trusted []netip.Prefix
+}
+
+// AccessLogConfig holds CloudFront standard access log settings
+type AccessLogConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	File           string `yaml:"file"`             // Append all log lines to this file
+	Directory      string `yaml:"directory"`        // Or write hourly rotated files into this directory
+	FilePrefix     string `yaml:"file_prefix"`      // Rotated file name prefix (default: "cloudfauxnt")
+	IncludeCookies bool   `yaml:"include_cookies"`  // Log the Cookie header like CloudFront's cookie logging option
+	IncludeRules   bool   `yaml:"include_rules"`    // Append the matched behavior and fired rewrite rules with their latency
+	IncludeTraceID bool   `yaml:"include_trace_id"` // Append the trace ID from the viewer's W3C traceparent header
+	// Append the response body size and time to last byte
+	IncludeBodyStats bool `yaml:"include_body_stats"`
+	// Append a SHA-256 digest of each response body, computed as it streams to the viewer
+	IncludeBodySHA256 bool `yaml:"include_body_sha256"`
+	// Append the key pair ID, expiry, and validation outcome of each request's signature
+	IncludeSignature bool `yaml:"include_signature"`
+	// Upload each rotated file to the storage backend under access-logs/ (directory mode only)
+	ShipToStorage bool `yaml:"ship_to_storage"`
+}
+
+// RealtimeLogConfig holds CloudFront real-time log settings
+type RealtimeLogConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Endpoint        string   `yaml:"endpoint"`          // Kinesis-compatible endpoint, e.g. http://localstack:4566
+	StreamName      string   `yaml:"stream_name"`       // Kinesis data stream receiving the records
+	Region          string   `yaml:"region"`            // Signing region (default: us-east-1)
+	AccessKeyID     string   `yaml:"access_key_id"`     // Signing credentials (default: "test", as LocalStack expects)
+	SecretAccessKey string   `yaml:"secret_access_key"` // Default: "test"; redacted from the effective configuration
+	SamplingRate    int      `yaml:"sampling_rate"`     // Percentage of requests logged, 1-100 (default: 100)
+	Fields          []string `yaml:"fields"`            // Real-time log field names, in record order
+	BatchSize       int      `yaml:"batch_size"`        // Records per PutRecords call, max 500 (default: 100)
+	FlushIntervalMs int      `yaml:"flush_interval_ms"` // Maximum delay before a partial batch is sent (default: 1000)
+}
+
+// LoggingConfig holds application log settings
+type LoggingConfig struct {
+	Format   string `yaml:"format"`   // text or json (default: text)
+	Level    string `yaml:"level"`    // debug, info, warn, error (default: info)
+	Requests *bool  `yaml:"requests"` // Log one line per request (default: true)
+}
+
+// AdminConfig holds settings for the admin REST API listener
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Host    string `yaml:"host"` // Listen address (default: 127.0.0.1)
+	Port    int    `yaml:"port"` // Listen port, must differ from server.port (default: 8081)
+	// BypassTokenMaxTTLSeconds caps the lifetime of bypass tokens minted through the API (default: 3600)
+	BypassTokenMaxTTLSeconds int `yaml:"bypass_token_max_ttl_seconds"`
+	// CapturedRequests is how many recent request timelines the dashboard keeps (default: 100)
+	CapturedRequests int `yaml:"captured_requests"`
+}
+
+// MetricsConfig holds Prometheus metrics endpoint settings
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // Default: /metrics
+}
+
+// SigningConfig holds CloudFront signing settings
+type SigningConfig struct {
+	Enabled       bool             `yaml:"enabled"`
+	KeyPairID     string           `yaml:"key_pair_id"`
+	PublicKeyPath string           `yaml:"public_key_path"`
+	PublicKey     crypto.PublicKey `yaml:"-"` // *rsa.PublicKey or *ecdsa.PublicKey
+	// Optional: fetch the public key instead of reading public_key_path: an http(s) URL serving a PEM key or a
+	// JWKS document, or cloudfront:<public key ID> for the CloudFront API
+	PublicKeyURL string `yaml:"public_key_url"`
+	// Optional: how often keys from public_key_url are fetched again (default: 300)
+	KeyRefreshSeconds int `yaml:"key_refresh_seconds"`
+	// Optional: CloudFront API endpoint for cloudfront: key URLs, e.g. LocalStack's (default: https://cloudfront.amazonaws.com)
+	CloudFrontEndpoint string `yaml:"cloudfront_endpoint"`
+	// Optional: hash the key pair's signatures use, sha1 (default, as CloudFront signs) or sha256
+	SignatureHash string `yaml:"signature_hash"`
+	// Optional: matching private key, used only by tooling such as `cloudfauxnt smoke` to sign test URLs
+	PrivateKeyPath string `yaml:"private_key_path"`
+	// Optional: more key pairs signatures are accepted from, like the other keys of a key group
+	TrustedKeyPairs []TrustedKeyPair `yaml:"trusted_key_pairs"`
+	// Optional: log the exact string each signature is verified against, to debug signer mismatches,
+	// and serve the admin API's /debug/verify endpoint
+	Debug bool `yaml:"debug"`
+	// Token options for testing and configuration
+	TokenOptions TokenOptions `yaml:"token_options"`
+}
+
+// TokenOptions holds configuration for signed URL and cookie tokens
+type TokenOptions struct {
+	// ClockSkewSeconds allows for clock skew between client and server when validating expiration
+	ClockSkewSeconds int `yaml:"clock_skew_seconds"`
+	// DefaultURLTTLSeconds is the default TTL for signed URLs if not otherwise specified
+	DefaultURLTTLSeconds int `yaml:"default_url_ttl_seconds"`
+	// DefaultCookieTTLSeconds is the default TTL for signed cookies if not otherwise specified
+	DefaultCookieTTLSeconds int `yaml:"default_cookie_ttl_seconds"`
+	// AllowWildcardPatterns controls whether signed URLs can use wildcard patterns (default: false)
+	AllowWildcardPatterns bool `yaml:"allow_wildcard_patterns"`
+}
+
+// LoadConfig reads and parses the YAML configuration file, expanding ${VAR} references in its values
+// and applying CLOUDFAUXNT_* environment variables on top. The file may be missing when such
+// variables are set.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && hasEnvOverrides() {
+		// Containers may be configured entirely through CLOUDFAUXNT_* environment variables
+		slog.Info("Configuration file not found, using environment variables only", "path", path)
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config Config
+	version, unknown, err := decodeConfig(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range unknown {
+		slog.Warn("Ignoring unknown configuration key", "path", path, "key", field.String())
+	}
+	if version < CurrentConfigVersion {
+		slog.Warn("Configuration uses an older config_version; run `cloudfauxnt config migrate` to upgrade it",
+			"path", path, "config_version", version, "current", CurrentConfigVersion)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	config.path = path
+
+	// Load public keys for every distribution with signing enabled
+	if err := config.loadPublicKeys(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// strict reports whether unknown configuration keys are rejected
+func (c *Config) strict() bool {
+	return c.StrictConfig == nil || *c.StrictConfig
+}
+
+// Validate checks if the configuration is valid, filling in defaults. Every problem found is
+// reported at once, as ConfigErrors.
+func (c *Config) Validate() error {
+	var problems ConfigErrors
+
+	// Validate server config
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		problems.addf("server.port", "must be 1-65535, got %d", c.Server.Port)
+	}
+	if c.Server.Host == "" {
+		c.Server.Host = "0.0.0.0"
+	}
+	c.Server.DefaultRootObject = normalizeObjectName(c.Server.DefaultRootObject)
+	c.Server.IndexDocument = normalizeObjectName(c.Server.IndexDocument)
+	if strings.Contains(c.Server.IndexDocument, "/") {
+		problems.addf("server.index_document", "must be an object name, not a path")
+	}
+	if c.Server.TimeoutSeconds <= 0 {
+		c.Server.TimeoutSeconds = 30
+	}
+	if c.Server.MaxHops <= 0 {
+		c.Server.MaxHops = 5
+	}
+	if c.Server.ErrorCacheSize == 0 {
+		c.Server.ErrorCacheSize = 256
+	}
+	if c.Server.CacheSize == 0 {
+		c.Server.CacheSize = 1024
+	}
+	if c.Server.ShieldCacheSize <= 0 {
+		c.Server.ShieldCacheSize = 4096
+	}
+	if c.Server.CacheMaxObjectBytes <= 0 {
+		c.Server.CacheMaxObjectBytes = 10 << 20
+	}
+	if c.Server.CacheMaxBytes < 0 {
+		problems.addf("server.cache_max_bytes", "cannot be negative")
+	} else if c.Server.CacheMaxBytes == 0 {
+		c.Server.CacheMaxBytes = 1 << 30
+	}
+	if c.Server.WatchIntervalSeconds <= 0 {
+		c.Server.WatchIntervalSeconds = 2
+	}
+	if c.Server.ReadinessTimeoutSeconds <= 0 {
+		c.Server.ReadinessTimeoutSeconds = 2
+	}
+	if c.Server.PrewarmConnections < 0 || c.Server.PrewarmConnections > 32 {
+		problems.addf("server.prewarm_connections", "must be 0-32, got %d", c.Server.PrewarmConnections)
+	} else if c.Server.KeepWarm && c.Server.PrewarmConnections == 0 {
+		problems.addf("server.keep_warm", "requires prewarm_connections")
+	}
+	if c.Server.OriginCertWarningDays == 0 {
+		c.Server.OriginCertWarningDays = 14
+	}
+	if c.Server.EdgeLocation == "" {
+		c.Server.EdgeLocation = "LOCAL1-C1"
+	} else if strings.ContainsFunc(c.Server.EdgeLocation, func(r rune) bool { return r <= ' ' || r >= 0x7f }) {
+		problems.addf("server.edge_location", "must be a POP code like SFO53-P1, got %q", c.Server.EdgeLocation)
+	}
+	switch c.Server.OnOptionalFailure {
+	case "":
+		c.Server.OnOptionalFailure = "fail"
+	case "fail", "degrade":
+	default:
+		problems.addf("server.on_optional_failure", "must be fail or degrade, got %q", c.Server.OnOptionalFailure)
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		problems.addf("server", "tls_cert_file and tls_key_file must be set together")
+	}
+	problems.add("server.http2", c.Server.HTTP2.validate())
+
+	// Validate response headers policies before origins so references can be checked
+	policyNames := make(map[string]bool)
+	for i, policy := range c.ResponseHeadersPolicies {
+		path := fmt.Sprintf("response_headers_policies[%d]", i)
+		if policy.Name == "" {
+			problems.addf(path, "name is required")
+			continue
+		}
+		if policyNames[policy.Name] {
+			problems.addf(path, "duplicate name %s", policy.Name)
+		}
+		policyNames[policy.Name] = true
+		problems.add(path, policy.validate())
+	}
+
+	requestPolicyNames := make(map[string]bool)
+	for i := range c.OriginRequestPolicies {
+		policy := &c.OriginRequestPolicies[i]
+		path := fmt.Sprintf("origin_request_policies[%d]", i)
+		if policy.Name == "" {
+			problems.addf(path, "name is required")
+			continue
+		}
+		if requestPolicyNames[policy.Name] {
+			problems.addf(path, "duplicate name %s", policy.Name)
+		}
+		requestPolicyNames[policy.Name] = true
+		problems.add(path, policy.validate())
+	}
+
+	fleProfileNames := make(map[string]bool)
+	for i := range c.FieldLevelEncryptionProfiles {
+		profile := &c.FieldLevelEncryptionProfiles[i]
+		path := fmt.Sprintf("field_level_encryption_profiles[%d]", i)
+		if profile.Name == "" {
+			problems.addf(path, "name is required")
+			continue
+		}
+		if fleProfileNames[profile.Name] {
+			problems.addf(path, "duplicate name %s", profile.Name)
+		}
+		fleProfileNames[profile.Name] = true
+		problems.add(path, profile.validate())
+	}
+
+	problems.add("origin_security", c.OriginSecurity.prepare())
+	problems.add("client_ip", c.ClientIP.prepare())
+	problems.add("waf", c.WAF.prepare())
+	problems.add("rate_limit", c.RateLimit.validate())
+	if c.Quota != nil {
+		problems.add("quota", c.Quota.validate())
+	}
+	problems.add("storage", c.Storage.validate())
+	problems.add("viewer_response_headers", c.ViewerResponseHeaders.validate())
+	problems.add("learning", c.Learning.validate())
+	if c.Learning.Enabled && c.Learning.OutputKey != "" && c.Storage.Backend == "" {
+		problems.addf("learning.output_key", "requires a storage backend")
+	}
+	problems.add("latency_profiles", c.LatencyProfiles.validate())
+	problems.add("processing_latency", c.ProcessingLatency.validate())
+
+	// Validate origins, including those of every distribution
+	originCount := len(c.Origins)
+	for _, d := range c.Distributions {
+		originCount += len(d.Origins)
+	}
+	if originCount == 0 {
+		problems.addf("behaviors", "at least one behavior must be configured")
+	}
+	defaultAccess, err := normalizeDefaultAccess(c.DefaultAccess, "allow")
+	problems.add("default_access", err)
+	c.DefaultAccess = defaultAccess
+	matchStrategy, err := normalizeMatchStrategy(c.MatchStrategy, "ordered")
+	problems.add("match_strategy", err)
+	c.MatchStrategy = matchStrategy
+	c.validateOrigins(&problems, "", c.Origins, c.DefaultAccess, policyNames, requestPolicyNames)
+	aliases := make(map[string]string)
+	checkAliases(&problems, "aliases", c.Aliases, defaultDistributionName, aliases)
+	distributionNames := make(map[string]bool)
+	for i := range c.Distributions {
+		d := &c.Distributions[i]
+		path := fmt.Sprintf("distributions[%d]", i)
+		if d.Name == "" {
+			problems.addf(path, "name is required")
+		} else if d.Name == defaultDistributionName {
+			problems.addf(path, "name %s is reserved for the top-level distribution", d.Name)
+		} else if distributionNames[d.Name] {
+			problems.addf(path, "duplicate name %s", d.Name)
+		}
+		distributionNames[d.Name] = true
+		if len(d.Aliases) == 0 {
+			problems.addf(path+".aliases", "at least one alias is required")
+		}
+		checkAliases(&problems, path+".aliases", d.Aliases, d.Name, aliases)
+		if d.DefaultAccess, err = normalizeDefaultAccess(d.DefaultAccess, c.DefaultAccess); err != nil {
+			problems.add(path+".default_access", err)
+		}
+		if d.MatchStrategy, err = normalizeMatchStrategy(d.MatchStrategy, c.MatchStrategy); err != nil {
+			problems.add(path+".match_strategy", err)
+		}
+		c.validateOrigins(&problems, path+".", d.Origins, d.DefaultAccess, policyNames, requestPolicyNames)
+		if d.Signing != nil {
+			problems.add(path+".signing", d.Signing.validate())
+			checkSigningScopes(&problems, path+".", d.Origins, d.Signing)
+		} else {
+			checkSigningScopes(&problems, path+".", d.Origins, &c.Signing)
+		}
+		if d.Quota != nil {
+			problems.add(path+".quota", d.Quota.validate())
+		}
+	}
+
+	if c.ContinuousDeployment.Enabled {
+		c.validateContinuousDeployment(&problems, policyNames, requestPolicyNames)
+	}
+
+	// Validate CORS config
+	if c.CORS.Enabled {
+		if len(c.CORS.AllowedOrigins) == 0 {
+			c.CORS.AllowedOrigins = []string{"*"}
+		}
+		if len(c.CORS.AllowedMethods) == 0 {
+			c.CORS.AllowedMethods = []string{"GET", "HEAD", "OPTIONS"}
+		}
+		if len(c.CORS.AllowedHeaders) == 0 {
+			c.CORS.AllowedHeaders = []string{"*"}
+		}
+		if c.CORS.MaxAge <= 0 {
+			c.CORS.MaxAge = 3600
+		}
+	}
+
+	// Validate access log config
+	if c.AccessLog.Enabled {
+		if (c.AccessLog.File == "") == (c.AccessLog.Directory == "") {
+			problems.addf("access_log", "exactly one of file or directory must be set when enabled")
+		}
+		if c.AccessLog.FilePrefix == "" {
+			c.AccessLog.FilePrefix = "cloudfauxnt"
+		}
+		if c.AccessLog.ShipToStorage && c.AccessLog.Directory == "" {
+			problems.addf("access_log.ship_to_storage", "requires directory, whose hourly files are shipped")
+		}
+		if c.AccessLog.ShipToStorage && c.Storage.Backend == "" {
+			problems.addf("access_log.ship_to_storage", "requires a storage backend")
+		}
+	}
+
+	// Validate real-time log config
+	if c.RealtimeLog.Enabled {
+		rt := &c.RealtimeLog
+		if rt.Endpoint == "" || rt.StreamName == "" {
+			problems.addf("realtime_log", "endpoint and stream_name are required when enabled")
+		}
+		if len(rt.Fields) == 0 {
+			problems.addf("realtime_log.fields", "at least one field is required")
+		}
+		for i, field := range rt.Fields {
+			if !knownLogFields[field] {
+				problems.addf(fmt.Sprintf("realtime_log.fields[%d]", i), "unknown field %q", field)
+			}
+		}
+		if rt.Region == "" {
+			rt.Region = "us-east-1"
+		}
+		if rt.AccessKeyID == "" {
+			rt.AccessKeyID = "test"
+		}
+		if rt.SecretAccessKey == "" {
+			rt.SecretAccessKey = "test"
+		}
+		if rt.SamplingRate == 0 {
+			rt.SamplingRate = 100
+		}
+		if rt.SamplingRate < 1 || rt.SamplingRate > 100 {
+			problems.addf("realtime_log.sampling_rate", "must be 1-100, got %d", rt.SamplingRate)
+		}
+		if rt.BatchSize <= 0 {
+			rt.BatchSize = 100
+		}
+		if rt.BatchSize > 500 {
+			problems.addf("realtime_log.batch_size", "cannot exceed 500 (Kinesis PutRecords limit)")
+		}
+		if rt.FlushIntervalMs <= 0 {
+			rt.FlushIntervalMs = 1000
+		}
+	}
+
+	// Validate logging config
+	if c.Logging.Format == "" {
+		c.Logging.Format = "text"
+	}
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		problems.addf("logging.format", "must be text or json, got %q", c.Logging.Format)
+	}
+	if c.Logging.Level == "" {
+		c.Logging.Level = "info"
+	}
+	switch strings.ToLower(c.Logging.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		problems.addf("logging.level", "must be debug, info, warn, or error, got %q", c.Logging.Level)
+	}
+
+	// Validate metrics config
+	if c.Metrics.Enabled && c.Metrics.Path == "" {
+		c.Metrics.Path = "/metrics"
+	}
+
+	// Validate admin config
+	if c.Admin.Enabled {
+		if c.Admin.Host == "" {
+			c.Admin.Host = "127.0.0.1"
+		}
+		if c.Admin.Port == 0 {
+			c.Admin.Port = 8081
+		}
+		if c.Admin.Port < 1 || c.Admin.Port > 65535 {
+			problems.addf("admin.port", "must be 1-65535, got %d", c.Admin.Port)
+		}
+		if c.Admin.Port == c.Server.Port {
+			problems.addf("admin.port", "must differ from server.port")
+		}
+		if c.Admin.BypassTokenMaxTTLSeconds <= 0 {
+			c.Admin.BypassTokenMaxTTLSeconds = 3600
+		}
+		if c.Admin.CapturedRequests == 0 {
+			c.Admin.CapturedRequests = 100
+		}
+		if c.Admin.CapturedRequests < 0 {
+			problems.addf("admin.captured_requests", "must be positive, got %d", c.Admin.CapturedRequests)
+		}
+	}
+
+	// The CSP report collector is served on the admin listener
+	for i, policy := range c.ResponseHeadersPolicies {
+		csp := policy.SecurityHeaders.ContentSecurityPolicyReportOnly
+		if csp == nil || !csp.CollectReports {
+			continue
+		}
+		if !c.Admin.Enabled {
+			problems.addf(fmt.Sprintf("response_headers_policies[%d].security_headers.content_security_policy_report_only", i),
+				"collect_reports requires admin.enabled")
+		}
+		csp.adminPort = c.Admin.Port
+	}
+
+	// Validate signing config
+	problems.add("signing", c.Signing.validate())
+	checkSigningScopes(&problems, "", c.Origins, &c.Signing)
+
+	// Check CloudFront quotas once everything else is known
+	if c.AWSLimits.Enabled {
+		problems.add("aws_limits", c.AWSLimits.validate())
+		c.checkAWSLimits(&problems)
+	}
+
+	return problems.err()
+}
+
+// validateOrigins checks a list of origins against the configured policies and origin security
+// rules, recording problems under scope (empty for the top level)
+func (c *Config) validateOrigins(problems *ConfigErrors, scope string, origins []Origin, defaultAccess string, policyNames, requestPolicyNames map[string]bool) {
+	originNames := make(map[string]bool)
+	for i := range origins {
+		origin := &origins[i]
+		path := fmt.Sprintf("%sbehaviors[%d]", scope, i)
+		if origin.Name == "" {
+			problems.addf(path, "name is required")
+		} else {
+			path += " (" + origin.Name + ")"
+		}
+		if originNames[origin.Name] && origin.Name != "" {
+			problems.addf(path, "duplicate name")
+		}
+		originNames[origin.Name] = true
+		if origin.URL == "" {
+			problems.addf(path, "URL is required")
+		} else {
+			problems.add(path, c.OriginSecurity.CheckOriginURL(origin.URL))
+		}
+		if len(origin.PathPatterns) == 0 {
+			problems.addf(path, "at least one path pattern is required")
+		}
+		signed := (origin.RequireSignature != nil && *origin.RequireSignature) || len(origin.TrustedKeyPairIDs) > 0
+		if origin.Public && signed {
+			problems.addf(path, "public cannot be combined with require_signature: true or trusted_key_pair_ids")
+		}
+		if defaultAccess == "deny" && !origin.Public && !signed {
+			problems.addf(path, "default_access is deny, so it must set public: true or require_signature: true")
+		}
+		problems.add(path, origin.validateSigningScopes())
+		problems.add(path, validateHeaderCasing(origin.HeaderCasing))
+		problems.add(path, validateAllowedMethods(origin.AllowedMethods))
+		if origin.PathTemplate != "" {
+			if origin.StripPrefix != "" {
+				problems.addf(path, "path_template cannot be combined with strip_prefix")
+			}
+			problems.add(path, validatePathTemplate(origin.PathTemplate, origin.PathPatterns))
+		}
+		if origin.MaxBodyBytes < 0 {
+			problems.addf(path, "max_body_bytes cannot be negative")
+		}
+		if origin.MaxResponseBytes < 0 {
+			problems.addf(path, "max_response_bytes cannot be negative")
+		}
+		problems.add(path, origin.Cookies.validateNames("cookies"))
+		problems.add(path, origin.QueryStrings.validateNames("query_strings"))
+		problems.add(path, origin.ResponseCookies.validateNames("response_cookies"))
+		if origin.RequestIDEchoHeader != "" && origin.PlainProxy {
+			problems.addf(path, "request_id_echo_header cannot be combined with plain_proxy, which doesn't send X-Amz-Cf-Id")
+		}
+		problems.add(path, validateOriginCustomHeaders(origin.CustomHeaders))
+		problems.add(path, origin.validateConnectionSettings())
+		if origin.HealthCheckPath == "" {
+			origin.HealthCheckPath = "/"
+		}
+		if !strings.HasPrefix(origin.HealthCheckPath, "/") {
+			problems.addf(path, "health_check_path must start with /")
+		}
+		if origin.FlushIntervalMs < -1 {
+			problems.addf(path, "flush_interval_ms must be -1 (flush every write), 0, or positive, got %d", origin.FlushIntervalMs)
+		}
+		if origin.PostDedupeWindowSeconds < 0 {
+			problems.addf(path, "post_dedupe_window_seconds cannot be negative")
+		}
+		if origin.Tee != nil {
+			problems.add(path, origin.Tee.validate())
+		}
+		if origin.Cache != nil {
+			if origin.Canary != nil || origin.PlainProxy || origin.GRPC {
+				problems.addf(path, "cache cannot be combined with canary, plain_proxy, or grpc")
+			}
+			problems.add(path, origin.Cache.validate())
+		}
+		if origin.OriginShield != nil {
+			problems.add(path, origin.OriginShield.validate())
+		}
+		for j := range origin.Faults {
+			problems.add(fmt.Sprintf("%s.faults[%d]", path, j), origin.Faults[j].validate())
+		}
+		if origin.Canary != nil {
+			problems.add(path, origin.Canary.validate(origin.Name, &c.OriginSecurity))
+		}
+		if origin.ResponseHeadersPolicy != "" && !policyNames[origin.ResponseHeadersPolicy] {
+			problems.addf(path, "unknown response_headers_policy %q", origin.ResponseHeadersPolicy)
+		}
+		if origin.OriginRequestPolicy != "" && !requestPolicyNames[origin.OriginRequestPolicy] {
+			problems.addf(path, "unknown origin_request_policy %q", origin.OriginRequestPolicy)
+		}
+		if origin.FieldLevelEncryption != "" && c.FindFieldLevelEncryptionProfile(origin.FieldLevelEncryption) == nil {
+			problems.addf(path, "unknown field_level_encryption profile %q", origin.FieldLevelEncryption)
+		}
+		if origin.LatencyProfile != "" && c.LatencyProfiles.find(origin.LatencyProfile) == nil {
+			problems.addf(path, "unknown latency_profile %q", origin.LatencyProfile)
+		}
+		// Normalize per-origin object names if set
+		if origin.DefaultRootObject != nil {
+			normalized := normalizeObjectName(*origin.DefaultRootObject)
+			origin.DefaultRootObject = &normalized
+		}
+		if origin.IndexDocument != nil {
+			normalized := normalizeObjectName(*origin.IndexDocument)
+			if strings.Contains(normalized, "/") {
+				problems.addf(path, "index_document must be an object name, not a path")
+			}
+			origin.IndexDocument = &normalized
+		}
+	}
+}
+
+// normalizeDefaultAccess lowercases a default_access value, using fallback when it is empty
+func normalizeDefaultAccess(value, fallback string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "":
+		return fallback, nil
+	case "allow", "deny":
+		return value, nil
+	}
+	return "", fmt.Errorf("default_access must be allow or deny, got %q", value)
+}
+
+// normalizeMatchStrategy lowercases a match_strategy value, using fallback when it is empty
+func normalizeMatchStrategy(value, fallback string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "":
+		return fallback, nil
+	case "ordered", "longest":
+		return value, nil
+	}
+	return "", fmt.Errorf("match_strategy must be ordered or longest, got %q", value)
+}
+
+// validate checks HTTP/2 settings against the ranges the protocol allows
+func (h *HTTP2Settings) validate() error {
+	const maxWindow = 4 << 20
+	if h.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("max_concurrent_streams cannot be negative")
+	}
+	if h.InitialStreamWindowSize < 0 || h.InitialStreamWindowSize >= maxWindow {
+		return fmt.Errorf("initial_stream_window_size must be below 4MiB")
+	}
+	if h.InitialConnectionWindowSize != 0 && (h.InitialConnectionWindowSize < 64<<10 || h.InitialConnectionWindowSize >= maxWindow) {
+		return fmt.Errorf("initial_connection_window_size must be at least 64KiB and below 4MiB")
+	}
+	if h.MaxFrameSize != 0 && (h.MaxFrameSize < 16<<10 || h.MaxFrameSize > 16<<20) {
+		return fmt.Errorf("max_frame_size must be between 16KiB and 16MiB")
+	}
+	return nil
+}
+
+// normalizeObjectName trims whitespace and leading slashes from a default root object or index document
+func normalizeObjectName(name string) string {
+	return strings.TrimLeft(strings.TrimSpace(name), "/")
+}
+
+// validate checks signing settings and fills in defaults
+func (s *SigningConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.KeyPairID == "" {
+		return fmt.Errorf("key_pair_id is required when signing is enabled")
+	}
+	// Keys may be supplied directly when the configuration is built in code
+	if s.PublicKeyPath == "" && s.PublicKeyURL == "" && s.PublicKey == nil {
+		return fmt.Errorf("public_key_path or public_key_url is required when signing is enabled")
+	}
+	if err := validatePublicKeySource(s.PublicKeyPath, s.PublicKeyURL); err != nil {
+		return err
+	}
+	if s.KeyRefreshSeconds < 0 {
+		return fmt.Errorf("key_refresh_seconds cannot be negative")
+	}
+	if s.KeyRefreshSeconds == 0 {
+		s.KeyRefreshSeconds = defaultKeyRefreshSeconds
+	}
+	if s.TokenOptions.ClockSkewSeconds == 0 {
+		s.TokenOptions.ClockSkewSeconds = 30 // Default 30 seconds clock skew
+	}
+	if _, err := parseSignatureHash(s.SignatureHash); err != nil {
+		return err
+	}
+	return s.validateTrustedKeyPairs()
+}
+
+// validate checks a response headers policy for values CloudFront would reject
+func (p *ResponseHeadersPolicy) validate() error {
+	sec := p.SecurityHeaders
+	if sec.StrictTransportSecurity != nil && sec.StrictTransportSecurity.MaxAgeSeconds <= 0 {
+		return fmt.Errorf("strict_transport_security.max_age_seconds must be positive")
+	}
+	if sec.FrameOptions != nil {
+		value := strings.ToUpper(sec.FrameOptions.Value)
+		if value != "DENY" && value != "SAMEORIGIN" {
+			return fmt.Errorf("frame_options.value must be DENY or SAMEORIGIN, got %q", sec.FrameOptions.Value)
+		}
+		sec.FrameOptions.Value = value
+	}
+	if sec.ContentSecurityPolicy != nil && sec.ContentSecurityPolicy.Value == "" {
+		return fmt.Errorf("content_security_policy.value is required")
+	}
+	if sec.ContentSecurityPolicyReportOnly != nil && sec.ContentSecurityPolicyReportOnly.Value == "" {
+		return fmt.Errorf("content_security_policy_report_only.value is required")
+	}
+	if sec.ReferrerPolicy != nil && sec.ReferrerPolicy.Value == "" {
+		return fmt.Errorf("referrer_policy.value is required")
+	}
+	if sec.XSSProtection != nil && sec.XSSProtection.Value == "" {
+		return fmt.Errorf("xss_protection.value is required")
+	}
+	if p.CORS != nil && len(p.CORS.AllowOrigins) == 0 {
+		return fmt.Errorf("cors.allow_origins must list at least one origin")
+	}
+	for i, header := range p.CustomHeaders {
+		if header.Header == "" {
+			return fmt.Errorf("custom_headers %d: header is required", i)
+		}
+	}
+	return nil
+}
+
+// redactedValue replaces secrets in configuration dumps
+const redactedValue = "REDACTED"
+
+// EffectiveYAML renders the fully resolved configuration, after defaults are applied, with secrets redacted
+func (c *Config) EffectiveYAML() ([]byte, error) {
+	redacted := *c
+	if redacted.RealtimeLog.SecretAccessKey != "" {
+		redacted.RealtimeLog.SecretAccessKey = redactedValue
+	}
+	if redacted.Storage.S3.SecretAccessKey != "" {
+		redacted.Storage.S3.SecretAccessKey = redactedValue
+	}
+	if redacted.Storage.Redis.Password != "" {
+		redacted.Storage.Redis.Password = redactedValue
+	}
+	redacted.Origins = redactOrigins(c.Origins)
+	redacted.ContinuousDeployment.Staging.Origins = redactOrigins(c.ContinuousDeployment.Staging.Origins)
+	redacted.Distributions = append([]Distribution(nil), c.Distributions...)
+	for i := range redacted.Distributions {
+		redacted.Distributions[i].Origins = redactOrigins(c.Distributions[i].Origins)
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redacted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactOrigins returns a copy of origins with their custom header values redacted, as they often
+// hold a secret proving requests came through the CDN
+func redactOrigins(origins []Origin) []Origin {
+	if len(origins) == 0 {
+		return origins
+	}
+	redacted := append([]Origin(nil), origins...)
+	for i, origin := range redacted {
+		if len(origin.CustomHeaders) == 0 {
+			continue
+		}
+		headers := make(map[string]string, len(origin.CustomHeaders))
+		for name := range origin.CustomHeaders {
+			headers[name] = redactedValue
+		}
+		redacted[i].CustomHeaders = headers
+	}
+	return redacted
+}
+
+// clone returns a deep copy of the configuration for modification; parsed public keys are shared
+func (c *Config) clone() (*Config, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var copied Config
+	if err := yaml.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	copied.Signing.copyPublicKeys(&c.Signing)
+	for i, profile := range c.FieldLevelEncryptionProfiles {
+		copied.FieldLevelEncryptionProfiles[i].PublicKey = profile.PublicKey
+	}
+	for i, d := range c.Distributions {
+		if d.Signing != nil {
+			copied.Distributions[i].Signing.copyPublicKeys(d.Signing)
+		}
+	}
+	copied.path = c.path
+	return &copied, nil
+}
+
+// FindResponseHeadersPolicy returns the named response headers policy, or nil if none is configured
+func (c *Config) FindResponseHeadersPolicy(name string) *ResponseHeadersPolicy {
+	if name == "" {
+		return nil
+	}
+	for i := range c.ResponseHeadersPolicies {
+		if c.ResponseHeadersPolicies[i].Name == name {
+			return &c.ResponseHeadersPolicies[i]
+		}
+	}
+	return nil
+}
+
+// validate checks an origin request policy and fills in default behaviors
+func (p *OriginRequestPolicy) validate() error {
+	rules := []struct {
+		field   string
+		rule    *ForwardingRuleConfig
+		allName string
+	}{
+		{"headers", &p.Headers, "allViewer"},
+		{"cookies", &p.Cookies, "all"},
+		{"query_strings", &p.QueryStrings, "all"},
+	}
+	for _, r := range rules {
+		if r.rule.Behavior == "" {
+			r.rule.Behavior = "none"
+		}
+		// Only headers can forward every viewer value plus the listed CloudFront headers
+		withCloudFront := r.field == "headers" && r.rule.Behavior == "allViewerAndCloudFrontHeaders"
+		switch {
+		case r.rule.Behavior == "none", r.rule.Behavior == r.allName:
+		case r.rule.Behavior == "whitelist", withCloudFront:
+			if len(r.rule.Items) == 0 {
+				return fmt.Errorf("%s.items is required when behavior is %s", r.field, r.rule.Behavior)
+			}
+		case r.field == "headers":
+			return fmt.Errorf("headers.behavior must be none, whitelist, allViewer, or allViewerAndCloudFrontHeaders, got %q", r.rule.Behavior)
+		default:
+			return fmt.Errorf("%s.behavior must be none, whitelist, or %s, got %q", r.field, r.allName, r.rule.Behavior)
+		}
+	}
+	return nil
+}
+
+// FindOriginRequestPolicy returns the named origin request policy, or nil if none is configured
+func (c *Config) FindOriginRequestPolicy(name string) *OriginRequestPolicy {
+	if name == "" {
+		return nil
+	}
+	for i := range c.OriginRequestPolicies {
+		if c.OriginRequestPolicies[i].Name == name {
+			return &c.OriginRequestPolicies[i]
+		}
+	}
+	return nil
+}
+
+// loadPublicKeys loads the RSA public key of every enabled signing configuration and field-level
+// encryption profile that doesn't have one yet
+func (c *Config) loadPublicKeys() error {
+	if err := c.Signing.loadPublicKey(); err != nil {
+		return fmt.Errorf("failed to load public key: %w", err)
+	}
+	for i := range c.FieldLevelEncryptionProfiles {
+		profile := &c.FieldLevelEncryptionProfiles[i]
+		if err := profile.loadPublicKey(); err != nil {
+			return fmt.Errorf("field-level encryption profile %s: failed to load public key: %w", profile.Name, err)
+		}
+	}
+	for _, d := range c.Distributions {
+		if d.Signing == nil {
+			continue
+		}
+		if err := d.Signing.loadPublicKey(); err != nil {
+			return fmt.Errorf("distribution %s: failed to load public key: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// loadPublicKey loads the RSA or ECDSA public keys from the configured paths and URLs
+func (s *SigningConfig) loadPublicKey() error {
+	if !s.Enabled || s.PublicKey != nil {
+		return nil
+	}
+	publicKey, err := s.readPublicKey(s.KeyPairID, s.PublicKeyPath, s.PublicKeyURL)
+	if err != nil {
+		return err
+	}
+
+	s.PublicKey = publicKey
+	for i := range s.TrustedKeyPairs {
+		trusted := &s.TrustedKeyPairs[i]
+		if trusted.PublicKey != nil {
+			continue
+		}
+		if trusted.PublicKey, err = s.readPublicKey(trusted.KeyPairID, trusted.PublicKeyPath, trusted.PublicKeyURL); err != nil {
+			return fmt.Errorf("key pair %s: %w", trusted.KeyPairID, err)
+		}
+	}
+	return nil
+}
+
+// readPublicKey loads a key pair's public key from its public_key_url, or else its public_key_path
+func (s *SigningConfig) readPublicKey(keyPairID, path, keyURL string) (crypto.PublicKey, error) {
+	if keyURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+		defer cancel()
+		return fetchPublicKey(ctx, keyURL, keyPairID, s.CloudFrontEndpoint)
+	}
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+	return parseSigningPublicKey(keyData)
+}
+
+// copyPublicKeys copies the loaded public keys of another copy of the same signing configuration
+func (s *SigningConfig) copyPublicKeys(from *SigningConfig) {
+	s.PublicKey = from.PublicKey
+	for i := range s.TrustedKeyPairs {
+		s.TrustedKeyPairs[i].PublicKey = from.TrustedKeyPairs[i].PublicKey
+	}
+}
+
+// parseRSAPublicKey parses a PEM-encoded PKIX RSA public key
+func parseRSAPublicKey(keyData []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block from public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return rsaPub, nil
+}
+
+// RequiresSignature reports whether requests matching the origin must be signed under the given
+// signing settings
+func (o *Origin) RequiresSignature(signing *SigningConfig) bool {
+	if o.Public {
+		return false
+	}
+	if o.RequireSignature != nil {
+		return *o.RequireSignature
+	}
+	if len(o.TrustedKeyPairIDs) > 0 {
+		return true
+	}
+	return signing.Enabled
+}
+
+// FindOrigin returns the origin that matches the given path. With the ordered match strategy that
+// is the first behavior listed with a matching path pattern, apart from the default patterns * and
+// /*, which CloudFront only tries after every other behavior; with longest it is the behavior with
+// the longest matching pattern.
+func (c *Config) FindOrigin(path string) (*Origin, error) {
+	var bestMatch *Origin
+	bestPattern := ""
+	for i := range c.Origins {
+		origin := &c.Origins[i]
+		pattern, ok := origin.matchedPattern(path, c.MatchStrategy)
+		if !ok {
+			continue
+		}
+		if c.MatchStrategy == "longest" {
+			if len(pattern) > len(bestPattern) {
+				bestMatch, bestPattern = origin, pattern
+			}
+			continue
+		}
+		if !isDefaultPattern(pattern) {
+			return origin, nil
+		}
+		if bestMatch == nil {
+			bestMatch = origin
+		}
+	}
+
+	if bestMatch == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoOrigin, path)
+	}
+
+	return bestMatch, nil
+}
+
+// matchedPattern returns the path pattern of the behavior that path matches under strategy: the
+// first listed, with the default patterns last, or the longest
+func (o *Origin) matchedPattern(path, strategy string) (string, bool) {
+	matched, ok := "", false
+	for _, pattern := range o.PathPatterns {
+		if !matchPath(pattern, path) {
+			continue
+		}
+		if strategy == "longest" {
+			if !ok || len(pattern) > len(matched) {
+				matched, ok = pattern, true
+			}
+			continue
+		}
+		if !isDefaultPattern(pattern) {
+			return pattern, true
+		}
+		if !ok {
+			matched, ok = pattern, true
+		}
+	}
+	return matched, ok
+}
+
+// isDefaultPattern reports whether pattern is that of CloudFront's default cache behavior
+func isDefaultPattern(pattern string) bool {
+	return pattern == "*" || pattern == "/*"
+}
+
+// matchPath checks if a path matches a pattern (simple glob matching)
+func matchPath(pattern, path string) bool {
+	// Handle exact match
+	if pattern == path {
+		return true
+	}
+
+	// Handle wildcards inside the pattern, e.g. /users/*/avatar
+	if hasInnerWildcards(pattern) {
+		_, ok := globCaptures(pattern, path)
+		return ok
+	}
+
+	// Handle wildcard patterns
+	if strings.HasSuffix(pattern, "/*") {
+		prefix := strings.TrimSuffix(pattern, "/*")
+		return strings.HasPrefix(path, prefix)
+	}
+
+	// Handle prefix match
+	if strings.HasSuffix(pattern, "*") {
+		prefix := strings.TrimSuffix(pattern, "*")
+		return strings.HasPrefix(path, prefix)
+	}
+
+	return false
+}
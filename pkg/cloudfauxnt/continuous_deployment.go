@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContinuousDeploymentConfig sends part of the top-level distribution's traffic to a staging
+// variant of its behaviors, like a CloudFront continuous deployment policy
+type ContinuousDeploymentConfig struct {
+	Enabled bool                `yaml:"enabled"`
+	Staging StagingDistribution `yaml:"staging"`
+	// Type is weight (a share of requests, optionally sticky per viewer) or header (requests
+	// carrying header_name with header_value) (default: weight)
+	Type              string                   `yaml:"type"`
+	Weight            float64                  `yaml:"weight"` // Share of requests sent to staging, 0-1 (CloudFront allows up to 0.15)
+	SessionStickiness *SessionStickinessConfig `yaml:"session_stickiness"`
+	HeaderName        string                   `yaml:"header_name"` // Must start with aws-cf-cd-, as in CloudFront
+	HeaderValue       string                   `yaml:"header_value"`
+}
+
+// StagingDistribution is the staging variant of the top-level distribution: its own origins and
+// behaviors, served with the top-level signing settings
+type StagingDistribution struct {
+	Origins       []Origin `yaml:"origins"`
+	DefaultAccess string   `yaml:"default_access"`
+	MatchStrategy string   `yaml:"match_strategy"`
+}
+
+// SessionStickinessConfig keeps a viewer on the variant it was first sent to, like CloudFront's
+// session stickiness for weight-based policies
+type SessionStickinessConfig struct {
+	IdleTTLSeconds    int `yaml:"idle_ttl_seconds"`    // Assignment forgotten after this long without requests, 300-3600 (default: 300)
+	MaximumTTLSeconds int `yaml:"maximum_ttl_seconds"` // Assignment forgotten this long after it was made, 300-3600 (default: 600)
+}
+
+const (
+	// deploymentHeader reports which variant served a request: primary or staging
+	deploymentHeader = "X-Cloudfauxnt-Deployment"
+	// stagingCookie holds a viewer's sticky assignment: the variant and when it was made
+	stagingCookie = "cloudfauxnt-staging"
+	// stagingHeaderPrefix starts the names of the headers that header-based policies match
+	stagingHeaderPrefix = "aws-cf-cd-"
+
+	deploymentPrimary = "primary"
+	deploymentStaging = "staging"
+)
+
+// validateContinuousDeployment checks the traffic policy and the staging behaviors, filling in defaults
+func (c *Config) validateContinuousDeployment(problems *ConfigErrors, policyNames, requestPolicyNames map[string]bool) {
+	d := &c.ContinuousDeployment
+	const path = "continuous_deployment"
+	switch d.Type {
+	case "", "weight":
+		d.Type = "weight"
+		if d.Weight < 0 || d.Weight > 1 {
+			problems.addf(path+".weight", "must be 0-1, got %g", d.Weight)
+		}
+		if d.HeaderName != "" || d.HeaderValue != "" {
+			problems.addf(path, "header_name and header_value only apply to type header")
+		}
+		if d.SessionStickiness != nil {
+			problems.add(path+".session_stickiness", d.SessionStickiness.validate())
+		}
+	case "header":
+		if !strings.HasPrefix(strings.ToLower(d.HeaderName), stagingHeaderPrefix) || len(d.HeaderName) == len(stagingHeaderPrefix) {
+			problems.addf(path+".header_name", "must start with %s, got %q", stagingHeaderPrefix, d.HeaderName)
+		}
+		if d.HeaderValue == "" {
+			problems.addf(path+".header_value", "is required")
+		}
+		if d.Weight != 0 || d.SessionStickiness != nil {
+			problems.addf(path, "weight and session_stickiness only apply to type weight")
+		}
+	default:
+		problems.addf(path+".type", "must be weight or header, got %q", d.Type)
+	}
+
+	staging := &d.Staging
+	scope := path + ".staging."
+	if len(staging.Origins) == 0 {
+		problems.addf(scope+"behaviors", "at least one behavior must be configured")
+	}
+	var err error
+	if staging.DefaultAccess, err = normalizeDefaultAccess(staging.DefaultAccess, c.DefaultAccess); err != nil {
+		problems.add(scope+"default_access", err)
+	}
+	if staging.MatchStrategy, err = normalizeMatchStrategy(staging.MatchStrategy, c.MatchStrategy); err != nil {
+		problems.add(scope+"match_strategy", err)
+	}
+	c.validateOrigins(problems, scope, staging.Origins, staging.DefaultAccess, policyNames, requestPolicyNames)
+	checkSigningScopes(problems, scope, staging.Origins, &c.Signing)
+}
+
+// validate checks the stickiness TTLs against CloudFront's bounds, filling in defaults
+func (s *SessionStickinessConfig) validate() error {
+	if s.IdleTTLSeconds == 0 {
+		s.IdleTTLSeconds = 300
+	}
+	if s.MaximumTTLSeconds == 0 {
+		s.MaximumTTLSeconds = 600
+	}
+	if s.IdleTTLSeconds < 300 || s.IdleTTLSeconds > 3600 || s.MaximumTTLSeconds < 300 || s.MaximumTTLSeconds > 3600 {
+		return fmt.Errorf("idle_ttl_seconds and maximum_ttl_seconds must be 300-3600")
+	}
+	if s.IdleTTLSeconds > s.MaximumTTLSeconds {
+		return fmt.Errorf("idle_ttl_seconds cannot exceed maximum_ttl_seconds")
+	}
+	return nil
+}
+
+// stagingConfig returns the configuration the staging variant is served with
+func (c *Config) stagingConfig() *Config {
+	derived := *c
+	derived.Origins = c.ContinuousDeployment.Staging.Origins
+	derived.MatchStrategy = c.ContinuousDeployment.Staging.MatchStrategy
+	derived.Distributions = nil
+	derived.ContinuousDeployment = ContinuousDeploymentConfig{}
+	// Cache keys don't tell the variants apart, so staging caches in memory rather than sharing
+	// the primary's disk cache
+	derived.Server.CacheDir = ""
+	return &derived
+}
+
+// assign decides whether a request goes to staging. Sticky viewers keep the variant named by their
+// cookie until it has been idle or held too long; the cookie to set, if any, is returned with it.
+func (d *ContinuousDeploymentConfig) assign(r *http.Request, now time.Time) (staging bool, cookie *http.Cookie) {
+	if d.Type == "header" {
+		return r.Header.Get(d.HeaderName) == d.HeaderValue, nil
+	}
+	sticky := d.SessionStickiness
+	if sticky == nil {
+		return stagingRandom.Float64() < d.Weight, nil
+	}
+
+	assigned := now
+	if existing, err := r.Cookie(stagingCookie); err == nil {
+		variant, at, _ := strings.Cut(existing.Value, ".")
+		unix, err := strconv.ParseInt(at, 10, 64)
+		held := now.Sub(time.Unix(unix, 0))
+		if err == nil && (variant == deploymentPrimary || variant == deploymentStaging) &&
+			held >= 0 && held < time.Duration(sticky.MaximumTTLSeconds)*time.Second {
+			staging, assigned = variant == deploymentStaging, time.Unix(unix, 0)
+		} else {
+			staging = stagingRandom.Float64() < d.Weight
+		}
+	} else {
+		staging = stagingRandom.Float64() < d.Weight
+	}
+
+	// The cookie lasts for the idle TTL, renewed by every request, but never past the maximum TTL
+	remaining := time.Duration(sticky.MaximumTTLSeconds)*time.Second - now.Sub(assigned)
+	maxAge := min(sticky.IdleTTLSeconds, int(remaining.Seconds()))
+	variant := deploymentPrimary
+	if staging {
+		variant = deploymentStaging
+	}
+	value := variant + "." + strconv.FormatInt(assigned.Unix(), 10)
+	return staging, &http.Cookie{Name: stagingCookie, Value: value, Path: "/", MaxAge: max(maxAge, 1)}
+}
+
+// continuousDeployment sends each request to the primary or the staging variant of the top-level
+// distribution, as its continuous deployment policy decides
+type continuousDeployment struct {
+	policy  *ContinuousDeploymentConfig
+	primary http.Handler
+	staging *ProxyHandler
+}
+
+// newContinuousDeployment builds the proxy handler of the staging variant
+func newContinuousDeployment(config *Config, primary http.Handler, metrics *Metrics, bypass *BypassTokens) *continuousDeployment {
+	staging := config.stagingConfig()
+	return &continuousDeployment{
+		policy:  &config.ContinuousDeployment,
+		primary: primary,
+		staging: NewProxyHandler(staging, NewValidatorFromConfig(staging), metrics, bypass),
+	}
+}
+
+// ServeHTTP dispatches to the variant the policy assigns the request to
+func (cd *continuousDeployment) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	staging, cookie := cd.policy.assign(r, started)
+	if cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+	if !staging {
+		w.Header().Set(deploymentHeader, deploymentPrimary)
+		cd.primary.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(deploymentHeader, deploymentStaging)
+	ruleTraceFrom(r.Context()).record("continuous_deployment_staging", started)
+	cd.staging.ServeHTTP(w, r)
+}
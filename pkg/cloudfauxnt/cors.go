@@ -1,6 +1,6 @@
 // SPDX-License-Identifier: Apache-2.0
 
-package main
+package cloudfauxnt
 
 import (
 	"net/http"
@@ -77,7 +77,12 @@ func (cm *CORSMiddleware) handlePreflight(w http.ResponseWriter, r *http.Request
 
 // isOriginAllowed checks if an origin is in the allowed list
 func (cm *CORSMiddleware) isOriginAllowed(origin string) bool {
-	for _, allowed := range cm.config.AllowedOrigins {
+	return originAllowed(cm.config.AllowedOrigins, origin)
+}
+
+// originAllowed checks an origin against a list supporting "*" and "*.example.com" entries
+func originAllowed(allowedOrigins []string, origin string) bool {
+	for _, allowed := range allowedOrigins {
 		if allowed == "*" {
 			return true
 		}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cspReportPath is where the built-in collector receives violation reports on the admin listener
+	cspReportPath = "/csp-reports"
+	// cspReportGroup is the Reporting API endpoint name report-to directives use for the collector
+	cspReportGroup = "cloudfauxnt-csp"
+	// maxCSPReports is how many reports the collector keeps, dropping the oldest
+	maxCSPReports = 1000
+	// maxCSPReportBodyBytes bounds the size of a report submission
+	maxCSPReportBodyBytes = 64 << 10
+)
+
+// CSPReportOnlyConfig is a Content-Security-Policy-Report-Only header, which reports violations of
+// the policy without enforcing it
+type CSPReportOnlyConfig struct {
+	Value    string `yaml:"value"`
+	Override bool   `yaml:"override"`
+	// CollectReports sends violation reports to the built-in collector at /csp-reports on the admin
+	// listener, replacing any report-uri and report-to directives in value. Requires admin.enabled.
+	CollectReports bool `yaml:"collect_reports"`
+
+	adminPort int // Port of the admin listener the collector is on, set by Validate
+}
+
+// reportURL returns the collector's address for a viewer request: the host the viewer reached the
+// proxy by, on the admin port
+func (c *CSPReportOnlyConfig) reportURL(r *http.Request) string {
+	host := strings.Trim(requestHost(r), "[]")
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(c.adminPort)) + cspReportPath
+}
+
+// header returns the header value for a viewer request, pointing its reports at the collector when
+// it collects them
+func (c *CSPReportOnlyConfig) header(r *http.Request) string {
+	if !c.CollectReports {
+		return c.Value
+	}
+	var directives []string
+	for directive := range strings.SplitSeq(c.Value, ";") {
+		directive = strings.TrimSpace(directive)
+		name, _, _ := strings.Cut(strings.ToLower(directive), " ")
+		if directive != "" && name != "report-uri" && name != "report-to" {
+			directives = append(directives, directive)
+		}
+	}
+	directives = append(directives, "report-uri "+c.reportURL(r), "report-to "+cspReportGroup)
+	return strings.Join(directives, "; ")
+}
+
+// cspReport is a violation report the collector received, with the fields both report formats
+// share pulled out of the original
+type cspReport struct {
+	ReceivedAt        time.Time       `json:"received_at"`
+	Host              string          `json:"host"`
+	UserAgent         string          `json:"user_agent,omitempty"`
+	DocumentURI       string          `json:"document_uri"`
+	BlockedURI        string          `json:"blocked_uri"`
+	ViolatedDirective string          `json:"violated_directive"`
+	Disposition       string          `json:"disposition,omitempty"`
+	Report            json.RawMessage `json:"report"` // As the browser sent it
+}
+
+// cspReports keeps the most recent violation reports for inspection. It lives for the whole server,
+// so reports survive reloads.
+type cspReports struct {
+	mu      sync.Mutex
+	reports []cspReport
+	dropped int // Reports discarded to stay within maxCSPReports
+}
+
+// newCSPReports creates an empty report collector
+func newCSPReports() *cspReports {
+	return &cspReports{}
+}
+
+// ServeHTTP stores reports POSTed by browsers, lists them for GET, and clears them for DELETE.
+// Browsers send reports from the pages' own origin, so submissions are allowed from any origin.
+func (c *cspReports) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		c.receive(w, r)
+	case http.MethodGet, http.MethodHead:
+		c.mu.Lock()
+		body := struct {
+			Reports []cspReport `json:"reports"`
+			Dropped int         `json:"dropped"`
+		}{append([]cspReport{}, c.reports...), c.dropped}
+		c.mu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		writeAdminJSON(w, http.StatusOK, body)
+	case http.MethodDelete:
+		c.mu.Lock()
+		c.reports, c.dropped = nil, 0
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, DELETE, OPTIONS")
+		writeAdminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// receive parses a report submission: a legacy report-uri report (application/csp-report) or a
+// Reporting API batch (application/reports+json), whose reports other than CSP violations are ignored
+func (c *cspReports) receive(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportBodyBytes))
+	if err != nil {
+		writeAdminError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	var bodies []json.RawMessage
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/reports+json" {
+		var batch []struct {
+			Type string          `json:"type"`
+			Body json.RawMessage `json:"body"`
+		}
+		err = json.Unmarshal(body, &batch)
+		for _, report := range batch {
+			if report.Type == "csp-violation" {
+				bodies = append(bodies, report.Body)
+			}
+		}
+	} else {
+		var legacy struct {
+			Report json.RawMessage `json:"csp-report"`
+		}
+		if err = json.Unmarshal(body, &legacy); err == nil && legacy.Report == nil {
+			err = fmt.Errorf("missing csp-report")
+		}
+		bodies = append(bodies, legacy.Report)
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid CSP report: %w", err))
+		return
+	}
+
+	for _, raw := range bodies {
+		report, err := parseCSPReport(raw)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid CSP report: %w", err))
+			return
+		}
+		report.ReceivedAt = time.Now().UTC()
+		report.Host = documentHost(report.DocumentURI)
+		report.UserAgent = r.UserAgent()
+		slog.Info("CSP violation reported", "document", report.DocumentURI, "blocked", report.BlockedURI,
+			"directive", report.ViolatedDirective)
+		c.add(report)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCSPReport reads a violation report in either the legacy (document-uri) or the Reporting API
+// (documentURL) spelling
+func parseCSPReport(raw json.RawMessage) (cspReport, error) {
+	var fields struct {
+		DocumentURI        string `json:"document-uri"`
+		DocumentURL        string `json:"documentURL"`
+		BlockedURI         string `json:"blocked-uri"`
+		BlockedURL         string `json:"blockedURL"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return cspReport{}, err
+	}
+	return cspReport{
+		DocumentURI:       cmp.Or(fields.DocumentURI, fields.DocumentURL),
+		BlockedURI:        cmp.Or(fields.BlockedURI, fields.BlockedURL),
+		ViolatedDirective: cmp.Or(fields.ViolatedDirective, fields.EffectiveDirective),
+		Disposition:       fields.Disposition,
+		Report:            raw,
+	}, nil
+}
+
+// documentHost returns the host of the page a report is about, which the proxy served
+func documentHost(documentURI string) string {
+	u, err := url.Parse(documentURI)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// add keeps a report, dropping the oldest once maxCSPReports are kept
+func (c *cspReports) add(report cspReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reports) >= maxCSPReports {
+		c.reports = c.reports[1:]
+		c.dropped++
+	}
+	c.reports = append(c.reports, report)
+}
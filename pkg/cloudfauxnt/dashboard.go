@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+)
+
+// serveDashboard serves the admin dashboard, which draws a waterfall of where each captured
+// request's time went from GET /requests
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML is the self-contained dashboard page; it uses no external resources, so it works offline
+const dashboardHTML = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CloudFauxnt dashboard</title>
+<style>
+  body { font: 13px system-ui, sans-serif; margin: 0; display: flex; height: 100vh; color: #232f3e; }
+  #list { width: 42%; overflow-y: auto; border-right: 1px solid #ddd; }
+  #detail { flex: 1; overflow-y: auto; padding: 12px 16px; }
+  header { padding: 8px 12px; background: #232f3e; color: #fff; display: flex; gap: 12px; align-items: center; }
+  table { border-collapse: collapse; width: 100%; }
+  td, th { padding: 4px 8px; text-align: left; white-space: nowrap; }
+  tbody tr { cursor: pointer; border-bottom: 1px solid #eee; }
+  tbody tr:hover, tbody tr.selected { background: #fff4e0; }
+  .path { max-width: 260px; overflow: hidden; text-overflow: ellipsis; }
+  .error { color: #c0392b; }
+  .row { display: flex; align-items: center; height: 22px; }
+  .label { width: 190px; flex: none; overflow: hidden; text-overflow: ellipsis; }
+  .lane { position: relative; flex: 1; height: 14px; background: #f4f4f4; }
+  .bar { position: absolute; height: 14px; min-width: 2px; }
+  .ms { width: 80px; flex: none; text-align: right; font-variant-numeric: tabular-nums; }
+  .routing { background: #8e44ad; } .auth { background: #c0392b; } .cache { background: #27ae60; }
+  .origin { background: #2980b9; } .response_write { background: #ff9900; } .rule { background: #95a5a6; }
+  h3 { margin: 16px 0 6px; }
+</style>
+</head>
+<body>
+<div id="list">
+  <header><b>Recent requests</b><label><input type="checkbox" id="live" checked> live</label></header>
+  <table><thead><tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Cache</th><th>ms</th></tr></thead>
+  <tbody id="requests"></tbody></table>
+</div>
+<div id="detail">Select a request to see its waterfall.</div>
+<script>
+let selected = null;
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) td.className = className;
+}
+
+function lane(name, className, span, total, title) {
+  const row = document.createElement("div");
+  row.className = "row";
+  const label = document.createElement("div");
+  label.className = "label";
+  label.textContent = name;
+  const track = document.createElement("div");
+  track.className = "lane";
+  const bar = document.createElement("div");
+  bar.className = "bar " + className;
+  bar.style.left = (100 * span.start_ms / total) + "%";
+  bar.style.width = (100 * span.duration_ms / total) + "%";
+  bar.title = title + ": starts at " + span.start_ms.toFixed(3) + " ms, takes " + span.duration_ms.toFixed(3) + " ms";
+  track.appendChild(bar);
+  const ms = document.createElement("div");
+  ms.className = "ms";
+  ms.textContent = span.duration_ms.toFixed(3) + " ms";
+  row.append(label, track, ms);
+  return row;
+}
+
+function show(t) {
+  const detail = document.getElementById("detail");
+  detail.replaceChildren();
+  const title = document.createElement("h2");
+  title.textContent = t.method + " " + t.host + t.path;
+  const summary = document.createElement("div");
+  summary.textContent = "Status " + t.status + ", behavior " + (t.behavior || "none") + ", " + (t.cache || "no cache result") +
+    ", " + t.bytes + " bytes, " + t.duration_ms.toFixed(3) + " ms total, first byte at " + t.time_to_first_byte_ms.toFixed(3) +
+    " ms. Request ID " + (t.request_id || "unknown");
+  detail.append(title, summary);
+  const total = Math.max(t.duration_ms, 0.001);
+  const phases = document.createElement("h3");
+  phases.textContent = "Phases";
+  detail.append(phases);
+  for (const span of t.phases) detail.append(lane(span.name, span.name, span, total, span.name));
+  if (t.rules.length) {
+    const rules = document.createElement("h3");
+    rules.textContent = "Rules";
+    detail.append(rules);
+    for (const span of t.rules) detail.append(lane(span.name, "rule", span, total, span.name));
+  }
+}
+
+async function refresh() {
+  const response = await fetch("requests", {cache: "no-store"});
+  const {requests} = await response.json();
+  const body = document.getElementById("requests");
+  body.replaceChildren();
+  for (const t of requests) {
+    const row = body.insertRow();
+    if (selected && t.request_id === selected) row.className = "selected";
+    cell(row, new Date(t.time).toLocaleTimeString());
+    cell(row, t.method);
+    cell(row, t.path, "path");
+    cell(row, t.status, t.status >= 400 ? "error" : "");
+    cell(row, t.cache);
+    cell(row, t.duration_ms.toFixed(1));
+    row.onclick = () => { selected = t.request_id; show(t); refresh(); };
+  }
+}
+
+refresh();
+setInterval(() => { if (document.getElementById("live").checked) refresh(); }, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dedupeMaxResponseBytes is the largest response body kept for replay; duplicates of requests with
+// larger responses are sent to the origin again
+const dedupeMaxResponseBytes = 1 << 20
+
+// postDedupe remembers the responses to recent POST requests, so identical retries within a
+// behavior's post_dedupe_window_seconds are answered with the first response instead of reaching the origin
+type postDedupe struct {
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+}
+
+// dedupeEntry is the response to the first of a set of identical POST requests
+type dedupeEntry struct {
+	ready   chan struct{} // closed once the response is complete
+	expires time.Time
+	stored  bool // false when the response couldn't be kept for replay
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+// newPostDedupe creates an empty POST deduplication store
+func newPostDedupe() *postDedupe {
+	return &postDedupe{entries: make(map[string]*dedupeEntry)}
+}
+
+// dedupeKey identifies a POST request by host, URL, and a hash of its body. The body is read in
+// full and replaced so it can still be sent to the origin.
+func dedupeKey(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	io.WriteString(hash, r.Host+"\n"+r.URL.RequestURI()+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// claim returns the live entry for key and false, or registers a new entry and returns it with true
+// when the caller's request is the first within the window
+func (d *postDedupe) claim(key string, window time.Duration) (*dedupeEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range d.entries {
+		if now.After(entry.expires) {
+			delete(d.entries, k)
+		}
+	}
+	if entry, ok := d.entries[key]; ok {
+		return entry, false
+	}
+	entry := &dedupeEntry{ready: make(chan struct{}), expires: now.Add(window)}
+	d.entries[key] = entry
+	return entry, true
+}
+
+// finish stores the recorded response in entry and releases requests waiting for it
+func (d *postDedupe) finish(entry *dedupeEntry, rec *responseRecorder, completed bool) {
+	d.mu.Lock()
+	if completed && !rec.truncated && rec.status != 0 {
+		entry.stored = true
+		entry.status = rec.status
+		entry.header = rec.header
+		entry.body = rec.body.Bytes()
+	}
+	d.mu.Unlock()
+	close(entry.ready)
+}
+
+// replay waits for the first request's response and writes it to w, marked as a duplicate.
+// It returns false when there is no response to replay and the request should go to the origin.
+func (entry *dedupeEntry) replay(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case <-entry.ready:
+	case <-r.Context().Done():
+		return true
+	}
+	if !entry.stored {
+		return false
+	}
+
+	for name, values := range entry.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set("X-CloudFauxnt-Dedupe", "duplicate")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+	return true
+}
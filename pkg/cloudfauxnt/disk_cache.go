@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// diskCache keeps the bodies of cached responses, and their metadata, in a filesystem storage
+// backend under cache_dir. The metadata records when each object was last served, so least
+// recently used eviction carries over restarts.
+type diskCache struct {
+	dir      string
+	storage  Storage
+	maxBytes int64
+	bytes    int64 // Total size of the stored bodies; guarded by the response cache's mutex
+}
+
+// diskCacheEntry is the metadata object stored beside each cached body
+type diskCacheEntry struct {
+	Key                  string        `json:"key"`
+	Status               int           `json:"status"`
+	Header               http.Header   `json:"header"`
+	Stored               time.Time     `json:"stored"`
+	Used                 time.Time     `json:"used"`
+	TTL                  time.Duration `json:"ttl"`
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate"`
+	StaleIfError         time.Duration `json:"stale_if_error"`
+	Size                 int64         `json:"size"`
+}
+
+// configuredDiskCache opens the disk cache the server settings describe. It returns nil without
+// cache_dir, or if the cache can't be opened, in which case responses are cached in memory.
+func configuredDiskCache(server *ServerConfig) *responseCache {
+	if server.CacheDir == "" || server.CacheSize < 0 {
+		return nil
+	}
+	cache, err := openDiskCache(server.CacheDir, server.CacheMaxBytes, server.CacheMaxObjectBytes)
+	if err != nil {
+		slog.Error("Disk cache unavailable, caching in memory instead", "dir", server.CacheDir, "error", err)
+		return nil
+	}
+	return cache
+}
+
+// openDiskCache returns a response cache stored under dir, loading the objects a previous run left there
+func openDiskCache(dir string, maxBytes, maxObjectBytes int64) (*responseCache, error) {
+	storage, err := NewStorage(StorageConfig{Backend: "filesystem", Directory: dir})
+	if err != nil {
+		return nil, err
+	}
+	cache := newMemoryCache(math.MaxInt, maxObjectBytes)
+	cache.disk = &diskCache{dir: dir, storage: storage, maxBytes: maxBytes}
+	if err := cache.load(); err != nil {
+		return nil, fmt.Errorf("failed to load cache directory: %w", err)
+	}
+	slog.Info("Disk cache opened", "dir", dir, "objects", cache.order.Len(), "bytes", cache.disk.bytes)
+	return cache, nil
+}
+
+// resize applies new limits to a disk cache, evicting the objects that no longer fit
+func (c *responseCache) resize(maxBytes, maxObjectBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxObjectBytes = maxObjectBytes
+	c.disk.maxBytes = maxBytes
+	c.evict()
+}
+
+// keys returns the storage keys of the metadata and body of a cache key
+func (d *diskCache) keys(key string) (meta, body string) {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return name + ".json", name + ".body"
+}
+
+// writeBody stores the body of an entry, pointing the entry at it
+func (d *diskCache) writeBody(entry *cachedResponse, body []byte) error {
+	_, key := d.keys(entry.key)
+	if err := d.storage.Put(context.Background(), key, body); err != nil {
+		return err
+	}
+	entry.disk = d
+	return nil
+}
+
+// writeMeta stores everything about an entry but its body, recording it as last used at used
+func (d *diskCache) writeMeta(entry *cachedResponse, used time.Time) error {
+	data, err := json.Marshal(diskCacheEntry{
+		Key:                  entry.key,
+		Status:               entry.status,
+		Header:               entry.header,
+		Stored:               entry.stored,
+		Used:                 used,
+		TTL:                  entry.ttl,
+		StaleWhileRevalidate: entry.staleWhileRevalidate,
+		StaleIfError:         entry.staleIfError,
+		Size:                 entry.size,
+	})
+	if err != nil {
+		return err
+	}
+	meta, _ := d.keys(entry.key)
+	return d.storage.Put(context.Background(), meta, data)
+}
+
+// open returns the body of a cache key and its size, streamed when the backend supports it
+func (d *diskCache) open(key string) (io.ReadCloser, int64, error) {
+	_, body := d.keys(key)
+	if opener, ok := d.storage.(objectOpener); ok {
+		return opener.Open(context.Background(), body)
+	}
+	data, err := d.storage.Get(context.Background(), body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+// touch records an entry as just used, returning false if its body is gone
+func (d *diskCache) touch(entry *cachedResponse) bool {
+	body, _, err := d.open(entry.key)
+	if err != nil {
+		return false
+	}
+	body.Close()
+	return d.writeMeta(entry, time.Now()) == nil
+}
+
+// remove deletes the objects of an entry
+func (d *diskCache) remove(entry *cachedResponse) {
+	meta, body := d.keys(entry.key)
+	d.storage.Delete(context.Background(), meta)
+	d.storage.Delete(context.Background(), body)
+	d.bytes -= entry.size
+}
+
+// load indexes the objects stored in the cache directory, least recently used last, and deletes
+// incomplete or unreadable ones
+func (c *responseCache) load() error {
+	ctx := context.Background()
+	keys, err := c.disk.storage.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	type loadedEntry struct {
+		entry *cachedResponse
+		used  time.Time
+	}
+	var loaded []loadedEntry
+	kept := make(map[string]bool)
+	for _, key := range keys {
+		hash, ok := strings.CutSuffix(key, ".json")
+		if !ok {
+			continue
+		}
+		entry, used, err := c.disk.read(key)
+		if err != nil {
+			slog.Warn("Discarding unreadable disk cache object", "key", key, "error", err)
+			c.disk.storage.Delete(ctx, key)
+			continue
+		}
+		kept[hash] = true
+		loaded = append(loaded, loadedEntry{entry, used})
+	}
+	for _, key := range keys {
+		if hash, ok := strings.CutSuffix(key, ".body"); ok && !kept[hash] {
+			c.disk.storage.Delete(ctx, key)
+		}
+	}
+
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].used.Before(loaded[j].used) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range loaded {
+		c.entries[l.entry.key] = c.order.PushFront(l.entry)
+		c.disk.bytes += l.entry.size
+	}
+	c.evict()
+	return nil
+}
+
+// read loads an object's metadata, checking its body is complete, and returns when it was last used
+func (d *diskCache) read(meta string) (*cachedResponse, time.Time, error) {
+	data, err := d.storage.Get(context.Background(), meta)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var stored diskCacheEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, time.Time{}, err
+	}
+	if expected, _ := d.keys(stored.Key); expected != meta {
+		return nil, time.Time{}, fmt.Errorf("object name doesn't match key %q", stored.Key)
+	}
+	body, size, err := d.open(stored.Key)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil, time.Time{}, fmt.Errorf("body is missing")
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	body.Close()
+	if size != stored.Size {
+		return nil, time.Time{}, fmt.Errorf("body is %d bytes, expected %d", size, stored.Size)
+	}
+	used := stored.Used
+	if used.IsZero() {
+		used = stored.Stored
+	}
+	return &cachedResponse{
+		key:                  stored.Key,
+		status:               stored.Status,
+		header:               stored.Header,
+		disk:                 d,
+		size:                 stored.Size,
+		stored:               stored.Stored,
+		ttl:                  stored.TTL,
+		staleWhileRevalidate: stored.StaleWhileRevalidate,
+		staleIfError:         stored.StaleIfError,
+	}, used, nil
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// findDistribution returns the distribution serving a host, preferring exact aliases over
+// wildcards and longer wildcards over shorter ones, or nil if the top-level distribution serves it
+func (c *Config) findDistribution(host string) *Distribution {
+	var best *Distribution
+	bestSuffix := 0
+	for i := range c.Distributions {
+		d := &c.Distributions[i]
+		for _, alias := range d.Aliases {
+			if alias == host {
+				return d
+			}
+			if suffix, ok := strings.CutPrefix(alias, "*"); ok && strings.HasSuffix(host, suffix) && len(suffix) > bestSuffix {
+				best, bestSuffix = d, len(suffix)
+			}
+		}
+	}
+	return best
+}
+
+// servesHost reports whether a request for host reaches a distribution: always when the top-level
+// distribution has no aliases, as it then serves every host no other distribution claims
+func (c *Config) servesHost(host string) bool {
+	if len(c.Aliases) == 0 || c.findDistribution(host) != nil {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if suffix, ok := strings.CutPrefix(alias, "*"); alias == host || (ok && strings.HasSuffix(host, suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAliases lowercases a distribution's aliases, recording those that are malformed or already
+// claimed by another distribution in taken
+func checkAliases(problems *ConfigErrors, path string, aliases []string, distribution string, taken map[string]string) {
+	for i, alias := range aliases {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		aliasPath := fmt.Sprintf("%s[%d]", path, i)
+		if strings.Contains(alias, "*") && (!strings.HasPrefix(alias, "*.") || strings.Count(alias, "*") > 1) {
+			problems.addf(aliasPath, "%q: wildcards are only allowed as a leading \"*.\"", alias)
+		}
+		if other, ok := taken[alias]; ok {
+			problems.addf(aliasPath, "%q is already used by distribution %s", alias, other)
+		}
+		taken[alias] = distribution
+		aliases[i] = alias
+	}
+}
+
+// ForHost returns the configuration requests for a host are served with: the top-level
+// configuration, with the origins, signing settings, and match strategy of the distribution the
+// host belongs to
+func (c *Config) ForHost(host string) *Config {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	d := c.findDistribution(strings.ToLower(host))
+	if d == nil {
+		return c
+	}
+	return c.distributionConfig(d)
+}
+
+// distributionConfig returns the configuration a distribution is served with
+func (c *Config) distributionConfig(d *Distribution) *Config {
+	derived := *c
+	derived.Origins = d.Origins
+	derived.MatchStrategy = d.MatchStrategy
+	if d.Signing != nil {
+		derived.Signing = *d.Signing
+	}
+	derived.Distributions = nil
+	return &derived
+}
+
+// distributionRouter picks the proxy for a request by its Host header, falling back to the
+// top-level distribution
+type distributionRouter struct {
+	config   *Config
+	handlers map[string]*ProxyHandler // Keyed by distribution name
+	fallback http.Handler
+}
+
+// newDistributionRouter builds a proxy handler for every configured distribution, sharing the disk cache
+func newDistributionRouter(config *Config, fallback http.Handler, metrics *Metrics, bypass *BypassTokens, disk *responseCache) *distributionRouter {
+	dr := &distributionRouter{config: config, handlers: make(map[string]*ProxyHandler), fallback: fallback}
+	for i := range config.Distributions {
+		d := &config.Distributions[i]
+		derived := config.distributionConfig(d)
+		dr.handlers[d.Name] = newProxyHandler(derived, NewValidatorFromConfig(derived), metrics, bypass, disk)
+	}
+	return dr
+}
+
+// ServeHTTP dispatches to the distribution serving the request's host
+func (dr *distributionRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d := dr.config.findDistribution(requestHost(r)); d != nil {
+		dr.handlers[d.Name].ServeHTTP(w, r)
+		return
+	}
+	dr.fallback.ServeHTTP(w, r)
+}
+
+// aliasGuard answers requests for hosts no distribution serves with the 403 CloudFront returns for a
+// Host header that isn't one of its aliases
+type aliasGuard struct {
+	config *Config
+	next   http.Handler
+}
+
+// ServeHTTP rejects requests for unknown hosts, passing the rest on
+func (g *aliasGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.config.servesHost(requestHost(r)) {
+		writeEdgeError(w, http.StatusForbidden, badRequestReason)
+		return
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+// requestHost returns the request's host name, lowercased and without a port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import "net/http"
+
+// xCacheError is the X-Cache result of error responses, whether CloudFauxnt or the origin raised them
+const xCacheError = "Error from cloudfauxnt"
+
+// EdgeHeadersMiddleware adds X-Amz-Cf-Pop to the responses CloudFauxnt answers as CloudFront (those
+// carrying X-Amz-Cf-Id), and marks their 4xx and 5xx responses with X-Cache: Error like CloudFront does.
+// Transparent proxy origins and plain-text errors are left alone.
+func EdgeHeadersMiddleware(config *Config) func(http.Handler) http.Handler {
+	pop := config.Server.EdgeLocation
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&edgeHeaderWriter{ResponseWriter: w, pop: pop}, r)
+		})
+	}
+}
+
+// edgeHeaderWriter sets the edge headers when the response status is written
+type edgeHeaderWriter struct {
+	http.ResponseWriter
+	pop         string
+	wroteHeader bool
+}
+
+// WriteHeader adds the edge headers to the final response, passing informational responses through
+func (w *edgeHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= http.StatusOK {
+		w.wroteHeader = true
+		if h := w.Header(); h.Get("X-Amz-Cf-Id") != "" {
+			h.Set("X-Amz-Cf-Pop", w.pop)
+			if status >= http.StatusBadRequest {
+				h.Set("X-Cache", xCacheError)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends the edge headers with an implicit 200
+func (w *edgeHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (w *edgeHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
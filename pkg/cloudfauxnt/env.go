@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the names of environment variables that set configuration keys
+const envPrefix = "CLOUDFAUXNT_"
+
+// envAliases are short names for keys nearly every container sets
+var envAliases = map[string]string{
+	"PORT": "SERVER_PORT",
+	"HOST": "SERVER_HOST",
+}
+
+// envReference matches $${...} escapes and ${VAR} or ${VAR:-default} references. Names must start
+// with a letter or underscore, so path_template variables like ${1} are left alone.
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces environment variable references in the scalar values of a document. Keys and
+// comments aren't expanded. A reference to an unset variable without a default is an error.
+func expandEnv(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		var missing string
+		expanded := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			match := envReference.FindStringSubmatch(ref)
+			if value, ok := os.LookupEnv(match[1]); ok && (value != "" || match[2] == "") {
+				return value
+			}
+			if match[2] != "" {
+				return match[3]
+			}
+			if missing == "" {
+				missing = match[1]
+			}
+			return ""
+		})
+		if missing != "" {
+			return fmt.Errorf("line %d: environment variable %s is not set", node.Line, missing)
+		}
+		node.Value = expanded
+		// Unquoted values are resolved again, so port: ${PORT} decodes as a number
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+		return nil
+	}
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := expandEnv(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasEnvOverrides reports whether any CLOUDFAUXNT_* environment variable is set
+func hasEnvOverrides() bool {
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, envPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvOverrides sets configuration keys from CLOUDFAUXNT_* environment variables, so a container
+// can be configured without a file. Names are the dotted key path in upper case with underscores,
+// with list indexes as numbers: CLOUDFAUXNT_SERVER_PORT sets server.port and
+// CLOUDFAUXNT_ORIGINS_0_URL the url of the first origin. Lists of values are comma-separated.
+func applyEnvOverrides(root *yaml.Node) error {
+	var names []string
+	values := make(map[string]string)
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if key, ok := strings.CutPrefix(name, envPrefix); ok && key != "" {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	// Sorted for a stable order; setting a later list index first pads the list with empty entries
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := strings.TrimPrefix(name, envPrefix)
+		if alias, ok := envAliases[key]; ok {
+			key = alias
+		}
+		path, leaf, ok := resolveEnvKey(reflect.TypeFor[Config](), strings.Split(strings.ToLower(key), "_"))
+		if !ok {
+			return fmt.Errorf("environment variable %s doesn't name a configuration key", name)
+		}
+		if err := setEnvValue(root, path, leaf, values[name]); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resolveEnvKey matches the lower-case words of a variable name against the keys of t, returning
+// the key path (keys and list indexes) and the type of the setting it names. Keys containing
+// underscores are tried longest first.
+func resolveEnvKey(t reflect.Type, words []string) ([]string, reflect.Type, bool) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(words) == 0 {
+		if t != nil && (t.Kind() == reflect.Struct || t.Kind() == reflect.Map) {
+			return nil, nil, false
+		}
+		if t != nil && t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.String {
+			return nil, nil, false
+		}
+		return nil, t, true
+	}
+	if t == nil {
+		return nil, nil, false
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(t)
+		for n := len(words); n > 0; n-- {
+			key := strings.Join(words[:n], "_")
+			fieldType, ok := fields[key]
+			if !ok {
+				continue
+			}
+			if key == "config_version" {
+				fieldType = reflect.TypeFor[int]()
+			}
+			if path, leaf, ok := resolveEnvKey(fieldType, words[n:]); ok {
+				return append([]string{key}, path...), leaf, true
+			}
+		}
+	case reflect.Slice:
+		if _, err := strconv.Atoi(words[0]); err != nil || t.Elem().Kind() == reflect.String {
+			return nil, nil, false
+		}
+		if path, leaf, ok := resolveEnvKey(t.Elem(), words[1:]); ok {
+			return append([]string{words[0]}, path...), leaf, true
+		}
+	}
+	return nil, nil, false
+}
+
+// setEnvValue stores a variable's value at a key path of the document, creating the mappings and
+// list entries leading to it
+func setEnvValue(root *yaml.Node, path []string, leaf reflect.Type, value string) error {
+	node := root
+	for i, key := range path {
+		index, err := strconv.Atoi(key)
+		if isIndex := err == nil; isIndex && node.Kind != yaml.SequenceNode || !isIndex && node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s has a different shape in the configuration file", strings.Join(path[:i], "."))
+		}
+
+		var next *yaml.Node
+		if node.Kind == yaml.SequenceNode {
+			for len(node.Content) <= index {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.MappingNode})
+			}
+			next = node.Content[index]
+		} else {
+			next = mappingValue(node, key)
+		}
+		if i == len(path)-1 || next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			if i == len(path)-1 {
+				next = envValueNode(leaf, value)
+			} else if _, err := strconv.Atoi(path[i+1]); err == nil {
+				next.Kind = yaml.SequenceNode
+			}
+			if node.Kind == yaml.SequenceNode {
+				node.Content[index] = next
+			} else {
+				setMappingValue(node, key, next)
+			}
+		}
+		node = next
+	}
+	return nil
+}
+
+// envValueNode turns a variable's value into a node of the setting's type. Values of string
+// settings stay strings, lists are split on commas, and other values are resolved like unquoted YAML.
+func envValueNode(leaf reflect.Type, value string) *yaml.Node {
+	if leaf != nil && leaf.Kind() == reflect.Slice {
+		list := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		}
+		return list
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if leaf != nil && leaf.Kind() == reflect.String {
+		node.Tag = "!!str"
+	}
+	return node
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/xml"
+	"sync"
+)
+
+// errorBodyKey identifies a rendered error body; everything except the request ID is fixed by it
+type errorBodyKey struct {
+	code    string
+	message string
+}
+
+// errorBody is a rendered error document split around the request ID
+type errorBody struct {
+	key    errorBodyKey
+	prefix []byte
+	suffix []byte
+}
+
+// errorBodyCache is a small bounded LRU of rendered error bodies, so error storms don't
+// re-render identical documents for every request
+type errorBodyCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[errorBodyKey]*list.Element
+	order    *list.List
+}
+
+// newErrorBodyCache creates an error body cache holding at most capacity entries
+func newErrorBodyCache(capacity int) *errorBodyCache {
+	return &errorBodyCache{
+		capacity: capacity,
+		entries:  make(map[errorBodyKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the rendered body for a code and message, rendering and caching it on a miss
+func (c *errorBodyCache) get(code, message string) *errorBody {
+	key := errorBodyKey{code: code, message: message}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*errorBody)
+	}
+
+	body := renderErrorBody(key)
+	if c.capacity <= 0 {
+		return body
+	}
+	c.entries[key] = c.order.PushFront(body)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*errorBody).key)
+	}
+	return body
+}
+
+// renderErrorBody renders a CloudFront XML error document up to and after the request ID
+func renderErrorBody(key errorBodyKey) *errorBody {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error>\n  <Code>")
+	xml.EscapeText(&b, []byte(key.code))
+	b.WriteString("</Code>\n  <Message>")
+	xml.EscapeText(&b, []byte(key.message))
+	b.WriteString("</Message>\n  <RequestId>")
+
+	return &errorBody{
+		key:    key,
+		prefix: b.Bytes(),
+		suffix: []byte("</RequestId>\n</Error>"),
+	}
+}
@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by signature validation. Every validation error matches exactly one of them
+// with errors.Is, while keeping its own detailed message.
+var (
+	// ErrNoSignature means the request carried neither a Signature parameter nor signed cookies
+	ErrNoSignature = errors.New("no CloudFront signature found")
+	// ErrMissingSignatureParts means a signed URL or signed cookie set is incomplete
+	ErrMissingSignatureParts = errors.New("missing required signature parameters")
+	// ErrKeyPairMismatch means the signature names a key pair other than the configured one
+	ErrKeyPairMismatch = errors.New("invalid key pair ID")
+	// ErrMalformedSignature means a signature, policy, or Expires value couldn't be decoded or parsed
+	ErrMalformedSignature = errors.New("malformed signature")
+	// ErrSignatureMismatch means the RSA signature doesn't verify against the public key
+	ErrSignatureMismatch = errors.New("signature verification failed")
+	// ErrSignatureExpired means the signed URL's Expires or the policy's DateLessThan has passed
+	ErrSignatureExpired = errors.New("signature has expired")
+	// ErrSignatureNotYetValid means the policy's DateGreaterThan is still in the future
+	ErrSignatureNotYetValid = errors.New("signature is not valid yet")
+	// ErrResourceMismatch means the policy's Resource doesn't cover the requested URL
+	ErrResourceMismatch = errors.New("policy resource does not cover the request")
+	// ErrSourceIPMismatch means the viewer's IP is outside the policy's AWS:SourceIp
+	ErrSourceIPMismatch = errors.New("viewer IP is outside the policy's AWS:SourceIp")
+)
+
+// Errors returned while routing and proxying requests
+var (
+	// ErrNoOrigin means no behavior's path patterns match the request path
+	ErrNoOrigin = errors.New("no origin found for path")
+	// ErrInvalidOriginURL means an origin's URL can't be parsed
+	ErrInvalidOriginURL = errors.New("invalid origin URL")
+)
+
+// ConfigError is one problem Validate found in a configuration
+type ConfigError struct {
+	Path string // Location of the setting in the configuration file, e.g. server.port or behaviors[2] (api)
+	Err  error
+}
+
+// Error returns the problem prefixed with its location
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying problem
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors is every problem Validate found, so a configuration can be fixed in one pass.
+// Use errors.As to get at the individual problems.
+type ConfigErrors []*ConfigError
+
+// Error lists the problems on one line
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	problems := make([]string, len(e))
+	for i, problem := range e {
+		problems[i] = problem.Error()
+	}
+	return fmt.Sprintf("%d problems: %s", len(e), strings.Join(problems, "; "))
+}
+
+// Unwrap returns the individual problems, so errors.Is and errors.As see each of them
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, problem := range e {
+		errs[i] = problem
+	}
+	return errs
+}
+
+// add records a problem at a location, if err is non-nil
+func (e *ConfigErrors) add(path string, err error) {
+	if err != nil {
+		*e = append(*e, &ConfigError{Path: path, Err: err})
+	}
+}
+
+// addf records a formatted problem at a location
+func (e *ConfigErrors) addf(path, format string, args ...any) {
+	e.add(path, fmt.Errorf(format, args...))
+}
+
+// err returns the recorded problems, or nil if there are none
+func (e ConfigErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// kindError is an error with its own message that also matches a sentinel error with errors.Is
+type kindError struct {
+	kind error
+	err  error
+}
+
+// errorOf formats an error that matches kind, wrapping any %w operand as fmt.Errorf does
+func errorOf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// Error returns the detailed message
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the sentinel and the detailed error, so errors.Is and errors.As see both
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// signatureFailureReason names the kind of a signature validation error for metrics
+func signatureFailureReason(err error) string {
+	for _, reason := range []struct {
+		kind error
+		name string
+	}{
+		{ErrNoSignature, "missing"},
+		{ErrMissingSignatureParts, "incomplete"},
+		{ErrKeyPairMismatch, "key_pair_mismatch"},
+		{ErrMalformedSignature, "malformed"},
+		{ErrSignatureMismatch, "signature_mismatch"},
+		{ErrSignatureExpired, "expired"},
+		{ErrSignatureNotYetValid, "not_yet_valid"},
+		{ErrResourceMismatch, "resource_mismatch"},
+		{ErrSourceIPMismatch, "source_ip_mismatch"},
+	} {
+		if errors.Is(err, reason.kind) {
+			return reason.name
+		}
+	}
+	return "other"
+}
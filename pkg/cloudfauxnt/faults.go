@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// faultErrorReason explains an injected error on the CloudFront error page
+const faultErrorReason = "CloudFauxnt injected this error (fault injection)."
+
+// FaultRule injects latency, errors, connection resets, or slow response bodies into requests for
+// an origin, so client retry and timeout logic can be exercised. Rates are percentages of requests.
+type FaultRule struct {
+	PathPatterns           []string `yaml:"path_patterns"`              // Optional: only requests matching these patterns (default: all)
+	LatencyMS              int      `yaml:"latency_ms"`                 // Fixed delay before the request is proxied
+	JitterMS               int      `yaml:"jitter_ms"`                  // Additional random delay of up to this many milliseconds
+	ErrorRate              float64  `yaml:"error_rate"`                 // Share of requests answered with error_status instead of being proxied
+	ErrorStatus            int      `yaml:"error_status"`               // Default: 503
+	ResetRate              float64  `yaml:"reset_rate"`                 // Share of requests whose connection is reset without a response
+	SlowBodyBytesPerSecond int      `yaml:"slow_body_bytes_per_second"` // Throttle response bodies to this rate
+}
+
+// validate checks fault settings and fills in defaults
+func (f *FaultRule) validate() error {
+	if f.LatencyMS < 0 || f.JitterMS < 0 {
+		return fmt.Errorf("latency_ms and jitter_ms cannot be negative")
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 100 || f.ResetRate < 0 || f.ResetRate > 100 {
+		return fmt.Errorf("error_rate and reset_rate must be 0-100")
+	}
+	if f.ErrorStatus == 0 {
+		f.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if f.ErrorStatus < 500 || f.ErrorStatus > 599 {
+		return fmt.Errorf("error_status must be a 5xx status, got %d", f.ErrorStatus)
+	}
+	if f.SlowBodyBytesPerSecond < 0 {
+		return fmt.Errorf("slow_body_bytes_per_second cannot be negative")
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a request path
+func (f *FaultRule) matches(path string) bool {
+	if len(f.PathPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range f.PathPatterns {
+		if matchPath(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// findFault returns the first fault rule of an origin that applies to a request path, or nil
+func (o *Origin) findFault(path string) *FaultRule {
+	for i := range o.Faults {
+		if o.Faults[i].matches(path) {
+			return &o.Faults[i]
+		}
+	}
+	return nil
+}
+
+// injectFault applies a fault rule to a request. It returns the writer to proxy the response
+// through, or false when the fault already answered (or dropped) the request.
+func injectFault(w http.ResponseWriter, r *http.Request, fault *FaultRule) (http.ResponseWriter, bool) {
+	trace := ruleTraceFrom(r.Context())
+
+	if delay := time.Duration(fault.LatencyMS) * time.Millisecond; delay > 0 || fault.JitterMS > 0 {
+		started := time.Now()
+		if fault.JitterMS > 0 {
+			delay += time.Duration(faultRandom.IntN(fault.JitterMS+1)) * time.Millisecond
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return nil, false
+		}
+		trace.record("fault_latency", started)
+	}
+
+	if fault.ResetRate > 0 && faultRandom.Float64()*100 < fault.ResetRate {
+		trace.record("fault_reset", time.Now())
+		resetConnection(w)
+		return nil, false
+	}
+
+	if fault.ErrorRate > 0 && faultRandom.Float64()*100 < fault.ErrorRate {
+		trace.record("fault_error", time.Now())
+		w.Header().Set("X-CloudFauxnt-Fault", "error")
+		writeEdgeError(w, fault.ErrorStatus, faultErrorReason)
+		return nil, false
+	}
+
+	if fault.SlowBodyBytesPerSecond > 0 {
+		trace.record("fault_slow_body", time.Now())
+		return &slowBodyWriter{ResponseWriter: w, bytesPerSecond: fault.SlowBodyBytesPerSecond, done: r.Context().Done()}, true
+	}
+	return w, true
+}
+
+// resetConnection drops the viewer connection without a response, with a TCP RST where possible.
+// HTTP/2 connections, which can't be hijacked, have just the stream reset.
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// slowBodyWriter streams a response body at a fixed rate, flushing each chunk to the viewer
+type slowBodyWriter struct {
+	http.ResponseWriter
+	bytesPerSecond int
+	done           <-chan struct{}
+	started        bool // Whether a chunk was already sent, so the next one waits a tick
+}
+
+// slowBodyTick is how often a throttled chunk is written
+const slowBodyTick = 100 * time.Millisecond
+
+// Write sends b in chunks sized to the configured rate
+func (w *slowBodyWriter) Write(b []byte) (int, error) {
+	chunk := max(w.bytesPerSecond/int(time.Second/slowBodyTick), 1)
+	written := 0
+	for written < len(b) {
+		// Wait between chunks rather than after them, so the handler finishes with the last byte
+		if w.started {
+			select {
+			case <-time.After(slowBodyTick):
+			case <-w.done:
+				return written, http.ErrAbortHandler
+			}
+		}
+		w.started = true
+
+		n, err := w.ResponseWriter.Write(b[written:min(written+chunk, len(b))])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+	return written, nil
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (w *slowBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
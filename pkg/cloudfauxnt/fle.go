@@ -0,0 +1,270 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudFront's field-level encryption limits
+const (
+	maxFLEFieldPatterns = 10
+	fleMaxBodyBytes     = 1 << 20
+)
+
+// fleErrorReason explains a request field-level encryption couldn't process
+const fleErrorReason = "The request could not be processed by field-level encryption."
+
+// FieldLevelEncryptionProfile encrypts sensitive fields of POST bodies with a public key before
+// they reach the origin, like a CloudFront field-level encryption profile. Only a service holding
+// the private key can read the fields.
+type FieldLevelEncryptionProfile struct {
+	Name          string         `yaml:"name"`
+	PublicKeyPath string         `yaml:"public_key_path"` // PEM-encoded RSA public key
+	PublicKey     *rsa.PublicKey `yaml:"-"`
+	ProviderID    string         `yaml:"provider_id"`    // Name the decryption service knows the key by
+	FieldPatterns []string       `yaml:"field_patterns"` // Form or JSON field names to encrypt; * and ? wildcards
+}
+
+// validate checks a field-level encryption profile
+func (p *FieldLevelEncryptionProfile) validate() error {
+	if p.PublicKeyPath == "" && p.PublicKey == nil {
+		return fmt.Errorf("public_key_path is required")
+	}
+	if p.ProviderID == "" {
+		return fmt.Errorf("provider_id is required")
+	}
+	if len(p.FieldPatterns) == 0 || len(p.FieldPatterns) > maxFLEFieldPatterns {
+		return fmt.Errorf("field_patterns must list 1-%d patterns, got %d", maxFLEFieldPatterns, len(p.FieldPatterns))
+	}
+	return nil
+}
+
+// loadPublicKey loads the profile's RSA public key from the configured path
+func (p *FieldLevelEncryptionProfile) loadPublicKey() error {
+	if p.PublicKey != nil {
+		return nil
+	}
+	keyData, err := os.ReadFile(p.PublicKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read public key file: %w", err)
+	}
+	rsaPub, err := parseRSAPublicKey(keyData)
+	if err != nil {
+		return err
+	}
+	p.PublicKey = rsaPub
+	return nil
+}
+
+// FindFieldLevelEncryptionProfile returns the named field-level encryption profile, or nil if none is configured
+func (c *Config) FindFieldLevelEncryptionProfile(name string) *FieldLevelEncryptionProfile {
+	if name == "" {
+		return nil
+	}
+	for i := range c.FieldLevelEncryptionProfiles {
+		if c.FieldLevelEncryptionProfiles[i].Name == name {
+			return &c.FieldLevelEncryptionProfiles[i]
+		}
+	}
+	return nil
+}
+
+// fleError is a request field-level encryption rejects, with the fle-status CloudFront logs for it
+type fleError struct {
+	status     string
+	httpStatus int
+}
+
+// Error returns the fle-status value
+func (e *fleError) Error() string {
+	return e.status
+}
+
+// matches reports whether a field is encrypted by the profile
+func (p *FieldLevelEncryptionProfile) matches(name string) bool {
+	for _, pattern := range p.FieldPatterns {
+		if matchPolicyResource(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptValue encrypts one field value with RSA-OAEP (SHA-256), base64-encoding the ciphertext
+func (p *FieldLevelEncryptionProfile) encryptValue(value string) (string, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, p.PublicKey, []byte(value), nil)
+	if err != nil {
+		if errors.Is(err, rsa.ErrMessageTooLong) {
+			return "", &fleError{status: "FieldLengthLimitClientError", httpStatus: http.StatusBadRequest}
+		}
+		return "", &fleError{status: "ServerError", httpStatus: http.StatusInternalServerError}
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// apply encrypts the profile's fields in a POST body, replacing the request body.
+// It returns the fle-status to log and the number of field values encrypted.
+func (p *FieldLevelEncryptionProfile) apply(r *http.Request) (string, int, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil && r.Header.Get("Content-Type") != "" {
+		return "", 0, &fleError{status: "MalformedContentTypeClientError", httpStatus: http.StatusBadRequest}
+	}
+	if mediaType != "application/x-www-form-urlencoded" && mediaType != "application/json" {
+		return "ForwardedByContentType", 0, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, fleMaxBodyBytes+1))
+	r.Body.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", 0, &fleError{status: "RequestLengthLimitClientError", httpStatus: http.StatusRequestEntityTooLarge}
+		}
+		return "", 0, &fleError{status: "MalformedInputClientError", httpStatus: http.StatusBadRequest}
+	}
+	if len(body) > fleMaxBodyBytes {
+		return "", 0, &fleError{status: "RequestLengthLimitClientError", httpStatus: http.StatusRequestEntityTooLarge}
+	}
+
+	var encrypted []byte
+	var count int
+	if mediaType == "application/json" {
+		encrypted, count, err = p.encryptJSON(body)
+	} else {
+		encrypted, count, err = p.encryptForm(body)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(encrypted))
+	r.ContentLength = int64(len(encrypted))
+	r.Header.Set("Content-Length", strconv.Itoa(len(encrypted)))
+	return "Processed", count, nil
+}
+
+// encryptForm encrypts matching fields of a URL-encoded form, keeping the order of its fields
+func (p *FieldLevelEncryptionProfile) encryptForm(body []byte) ([]byte, int, error) {
+	pairs := strings.Split(string(body), "&")
+	count := 0
+	for i, pair := range pairs {
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			return nil, 0, &fleError{status: "MalformedInputClientError", httpStatus: http.StatusBadRequest}
+		}
+		if !p.matches(name) {
+			continue
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, 0, &fleError{status: "MalformedInputClientError", httpStatus: http.StatusBadRequest}
+		}
+		ciphertext, err := p.encryptValue(value)
+		if err != nil {
+			return nil, 0, err
+		}
+		pairs[i] = rawName + "=" + url.QueryEscape(ciphertext)
+		count++
+	}
+	return []byte(strings.Join(pairs, "&")), count, nil
+}
+
+// encryptJSON encrypts the values of matching keys at any depth of a JSON document. Strings are
+// encrypted as is and other scalars as their JSON text; objects and arrays are searched, not encrypted.
+func (p *FieldLevelEncryptionProfile) encryptJSON(body []byte) ([]byte, int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, 0, &fleError{status: "MalformedInputClientError", httpStatus: http.StatusBadRequest}
+	}
+
+	count := 0
+	var walk func(node any) error
+	walk = func(node any) error {
+		switch node := node.(type) {
+		case map[string]any:
+			for key, value := range node {
+				var plaintext string
+				switch value := value.(type) {
+				case map[string]any, []any:
+					if err := walk(value); err != nil {
+						return err
+					}
+					continue
+				case string:
+					plaintext = value
+				case nil:
+					continue
+				default:
+					plaintext = fmt.Sprint(value)
+				}
+				if !p.matches(key) {
+					continue
+				}
+				ciphertext, err := p.encryptValue(plaintext)
+				if err != nil {
+					return err
+				}
+				node[key] = ciphertext
+				count++
+			}
+		case []any:
+			for _, item := range node {
+				if err := walk(item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return nil, 0, err
+	}
+
+	encrypted, err := json.Marshal(doc)
+	if err != nil {
+		return nil, 0, &fleError{status: "ServerError", httpStatus: http.StatusInternalServerError}
+	}
+	return encrypted, count, nil
+}
+
+// encryptFields applies a behavior's field-level encryption profile to a POST request, recording
+// the outcome for the fle-status and fle-encrypted-fields log fields. It answers the request and
+// returns false when the body can't be processed.
+func (ph *ProxyHandler) encryptFields(w http.ResponseWriter, r *http.Request, profile *FieldLevelEncryptionProfile) bool {
+	started := time.Now()
+	trace := ruleTraceFrom(r.Context())
+	status, count, err := profile.apply(r)
+	if err != nil {
+		var fleErr *fleError
+		if !errors.As(err, &fleErr) {
+			fleErr = &fleError{status: "ServerError", httpStatus: http.StatusInternalServerError}
+		}
+		trace.setFieldLevelEncryption(fleErr.status, 0)
+		writeEdgeError(w, fleErr.httpStatus, fleErrorReason)
+		return false
+	}
+	trace.setFieldLevelEncryption(status, count)
+	if count > 0 {
+		trace.record("field_level_encryption", started)
+	}
+	return true
+}
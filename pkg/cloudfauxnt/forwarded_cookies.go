@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// forwardedCookies returns the viewer cookies a behavior's cookies rule forwards to the origin
+func (o *Origin) forwardedCookies(r *http.Request) []*http.Cookie {
+	var kept []*http.Cookie
+	for _, cookie := range r.Cookies() {
+		if o.Cookies.allows(cookie.Name) {
+			kept = append(kept, cookie)
+		}
+	}
+	return kept
+}
+
+// filterCookies rewrites the Cookie header to hold only the cookies the behavior forwards, which
+// drops CloudFront's signed cookies unless they are listed, and reports whether any were removed
+func (o *Origin) filterCookies(req *http.Request) bool {
+	if o.Cookies == nil || o.Cookies.Behavior == "all" || req.Header.Get("Cookie") == "" {
+		return false
+	}
+
+	kept := o.forwardedCookies(req)
+	if len(kept) == len(req.Cookies()) {
+		return false
+	}
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		pairs := make([]string, len(kept))
+		for i, cookie := range kept {
+			pairs[i] = cookie.Name + "=" + cookie.Value
+		}
+		req.Header.Set("Cookie", strings.Join(pairs, "; "))
+	}
+	return true
+}
+
+// cacheCookies returns the cookies a request is cached under when the behavior sets a cookies rule:
+// the forwarded cookies sorted by name, so viewers with different forwarded cookies get separate objects
+func (o *Origin) cacheCookies(r *http.Request) string {
+	if o.Cookies == nil {
+		return ""
+	}
+	kept := o.forwardedCookies(r)
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+	pairs := make([]string, len(kept))
+	for i, cookie := range kept {
+		pairs[i] = cookie.Name + "=" + cookie.Value
+	}
+	return strings.Join(pairs, "; ")
+}
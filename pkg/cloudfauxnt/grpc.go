@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// newGRPCTransport builds the transport for gRPC origins. It only speaks HTTP/2: h2 negotiated over
+// TLS for https origins and h2c with prior knowledge for http origins, as gRPC servers expect.
+func newGRPCTransport(security *OriginSecurityConfig, connectTimeout time.Duration, localAddr net.Addr) *http.Transport {
+	transport := newOriginTransport(security, connectTimeout, localAddr)
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP2(true)
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	return transport
+}
+
+// isGRPC reports whether a request is a gRPC or gRPC-web call
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
@@ -0,0 +1,661 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ProxyHandler handles incoming requests and proxies them to origins
+type ProxyHandler struct {
+	config    *Config
+	validator *SignatureValidator
+	// transports pool origin connections, one transport per origin
+	transports *originTransports
+	// errorBodies caches rendered error documents
+	errorBodies *errorBodyCache
+	// cache holds origin responses for behaviors with caching; nil when server.cache_size is negative
+	cache *responseCache
+	// shields are the origin shield caches of the behaviors with origin_shield, by region
+	shields map[string]*responseCache
+	// dedupe holds recent POST responses for behaviors with post_dedupe_window_seconds
+	dedupe  *postDedupe
+	waf     *wafState     // Request counts for rate-based WAF rules
+	tees    *responseTees // Bytes written by behaviors' response tees
+	metrics *Metrics      // nil when metrics are disabled
+	bypass  *BypassTokens // nil when the admin API is disabled
+}
+
+// NewProxyHandler creates a new proxy handler
+func NewProxyHandler(config *Config, validator *SignatureValidator, metrics *Metrics, bypass *BypassTokens) *ProxyHandler {
+	return newProxyHandler(config, validator, metrics, bypass, configuredDiskCache(&config.Server))
+}
+
+// newProxyHandler creates a proxy handler caching in disk, an opened disk cache, when cache_dir is set
+func newProxyHandler(config *Config, validator *SignatureValidator, metrics *Metrics, bypass *BypassTokens, disk *responseCache) *ProxyHandler {
+	return &ProxyHandler{
+		config:      config,
+		validator:   validator,
+		transports:  newOriginTransports(&config.OriginSecurity, config.Server.PrewarmConnections),
+		errorBodies: newErrorBodyCache(config.Server.ErrorCacheSize),
+		cache:       newResponseCache(&config.Server, disk),
+		shields:     openOriginShields(config),
+		dedupe:      newPostDedupe(),
+		waf:         newWAFState(),
+		tees:        newResponseTees(),
+		metrics:     metrics,
+		bypass:      bypass,
+	}
+}
+
+// ServeHTTP handles the proxy request
+func (ph *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// CloudFront rejects the asterisk-form request target (OPTIONS *) outright
+	if r.RequestURI == "*" {
+		writeEdgeError(w, http.StatusBadRequest, badRequestReason)
+		return
+	}
+
+	// Refuse requests that have already looped through CloudFauxnt too many times
+	if hops := viaHopCount(r.Header); hops >= ph.config.Server.MaxHops {
+		ph.writeCloudFrontError(w, "LoopDetected",
+			fmt.Sprintf("Request has already passed through CloudFauxnt %d times (max_hops: %d)", hops, ph.config.Server.MaxHops),
+			http.StatusLoopDetected)
+		return
+	}
+
+	// Find matching origin first to determine signature requirement and default root object
+	started := time.Now()
+	origin, err := ph.config.FindOrigin(r.URL.Path)
+	ruleTraceFrom(r.Context()).phase("routing", started)
+	if err != nil {
+		ph.writeCloudFrontError(w, "NoSuchKey", "The specified path does not match any configured origin", http.StatusNotFound)
+		return
+	}
+	ruleTraceFrom(r.Context()).setBehavior(origin.Name)
+
+	// A valid bypass token skips the WAF rules and the signature requirement
+	token, bypassed := ph.bypass.check(r)
+	if bypassed {
+		ruleTraceFrom(r.Context()).record("bypass_token", time.Now())
+		audit("Bypass token used", "token", tokenPrefix(token), "origin", origin.Name,
+			"method", r.Method, "path", r.URL.Path, "client", clientIP(r))
+	} else if token != "" {
+		audit("Bypass token rejected", "token", tokenPrefix(token), "origin", origin.Name,
+			"method", r.Method, "path", r.URL.Path, "client", clientIP(r))
+	}
+
+	// Let the WAF rules block the request before CloudFront handles it
+	if !bypassed && !ph.checkWAF(w, r) {
+		return
+	}
+
+	// Simulate the viewer's network between it and the edge location
+	if profile := ph.config.latencyProfileFor(r, origin); profile != nil {
+		var ok bool
+		if w, ok = applyLatencyProfile(w, r, profile); !ok {
+			return
+		}
+	}
+
+	// TRACE, CONNECT, and other verbs CloudFront never accepts are rejected at the edge
+	if !cloudFrontMethods[r.Method] && !origin.ForwardNonStandardMethods {
+		writeEdgeError(w, http.StatusForbidden, methodNotAllowedReason)
+		return
+	}
+	if cloudFrontMethods[r.Method] && !origin.allowsMethod(r.Method) {
+		writeEdgeError(w, http.StatusForbidden, methodNotAllowedReason)
+		return
+	}
+
+	// Bodies over the behavior's limit are refused up front when the length is declared,
+	// and cut off while streaming to the origin otherwise
+	if origin.MaxBodyBytes > 0 {
+		if r.ContentLength > origin.MaxBodyBytes {
+			writeEdgeError(w, http.StatusRequestEntityTooLarge, bodyTooLargeReason)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, origin.MaxBodyBytes)
+	}
+
+	// Determine if signature is required for this origin; per-origin settings override the global one
+	requireSignature := origin.RequiresSignature(&ph.config.Signing)
+
+	if bypassed {
+		if requireSignature {
+			ruleTraceFrom(r.Context()).setSignatureStatus("bypassed")
+		}
+		requireSignature = false
+	}
+
+	// Validate signature if required
+	if requireSignature {
+		started := time.Now()
+		if !ph.config.ProcessingLatency.SignatureValidation.wait(r, "signature_latency") {
+			return
+		}
+		err := ph.validator.forBehavior(origin, r.URL.Path).ValidateRequest(r)
+		trace := ruleTraceFrom(r.Context())
+		trace.phase("auth", started)
+		if err != nil {
+			reason := signatureFailureReason(err)
+			trace.setSignatureStatus(reason)
+			ph.metrics.signatureFailed(origin.Name, reason)
+			ph.writeOriginError(w, origin, "AccessDenied", err.Error(), http.StatusForbidden)
+			return
+		}
+		trace.setSignatureStatus("valid")
+	}
+
+	// Simulate edge latency and failures for clients' retry and timeout logic
+	if fault := origin.findFault(r.URL.Path); fault != nil {
+		var ok bool
+		if w, ok = injectFault(w, r, fault); !ok {
+			return
+		}
+	}
+
+	// Identical POSTs within the dedupe window get the first request's response
+	if origin.PostDedupeWindowSeconds > 0 && r.Method == http.MethodPost {
+		key, err := dedupeKey(r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeEdgeError(w, http.StatusRequestEntityTooLarge, bodyTooLargeReason)
+			} else {
+				writeEdgeError(w, http.StatusBadRequest, badRequestReason)
+			}
+			return
+		}
+		entry, first := ph.dedupe.claim(key, time.Duration(origin.PostDedupeWindowSeconds)*time.Second)
+		if !first {
+			started := time.Now()
+			if entry.replay(w, r) {
+				ruleTraceFrom(r.Context()).record("post_dedupe", started)
+				return
+			}
+		} else {
+			rec := &responseRecorder{ResponseWriter: w, limit: dedupeMaxResponseBytes}
+			w = rec
+			defer func() { ph.dedupe.finish(entry, rec, r.Context().Err() == nil) }()
+		}
+	}
+
+	// Encrypt sensitive form and JSON fields before they reach the origin
+	if profile := ph.config.FindFieldLevelEncryptionProfile(origin.FieldLevelEncryption); profile != nil && r.Method == http.MethodPost {
+		if !ph.encryptFields(w, r, profile) {
+			return
+		}
+	}
+
+	// Serve cacheable requests from the cache when the behavior has one
+	if origin.Cache != nil && ph.cache != nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) && !isWebSocketUpgrade(r) {
+		ph.serveCached(w, r, origin)
+		return
+	}
+
+	// Proxy to origin
+	ph.fetch(w, r, origin)
+}
+
+// fetch proxies the request to origin, answering with an error if the proxy can't be set up
+func (ph *ProxyHandler) fetch(w http.ResponseWriter, r *http.Request, origin *Origin) {
+	if err := ph.proxyToOrigin(w, r, origin); err != nil {
+		ph.writeOriginError(w, origin, "ServiceUnavailable", err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
+// proxyToOrigin forwards the request to the origin server
+func (ph *ProxyHandler) proxyToOrigin(w http.ResponseWriter, r *http.Request, origin *Origin) error {
+	// Rewrite rules and policies that fire are recorded for the access log
+	trace := ruleTraceFrom(r.Context())
+
+	// WebSocket connections outlive the server's request timeouts once upgraded
+	if isWebSocketUpgrade(r) {
+		started := time.Now()
+		controller := http.NewResponseController(w)
+		controller.SetReadDeadline(time.Time{})
+		controller.SetWriteDeadline(time.Time{})
+		trace.record("websocket", started)
+	}
+
+	// gRPC calls are streamed over HTTP/2, with the status relayed in trailers
+	if origin.GRPC && isGRPC(r) {
+		trace.record("grpc", time.Now())
+	}
+
+	// Pick the canary URL for viewers assigned to it
+	targetURL := origin.URL
+	var assignment *http.Cookie
+	if origin.Canary != nil {
+		started := time.Now()
+		canary, setCookie := origin.Canary.assign(r)
+		if canary {
+			targetURL = origin.Canary.URL
+			trace.record("canary", started)
+		}
+		if setCookie {
+			assignment = origin.Canary.cookie(canary)
+		}
+	}
+
+	// Parse origin URL
+	originURL, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOriginURL, err)
+	}
+
+	// The origin and the viewer see the same request ID, so origin logs can be matched to edge logs
+	requestID := generateCloudFrontID()
+
+	// Create reverse proxy
+	proxy := httputil.NewSingleHostReverseProxy(originURL)
+	transport, err := ph.transports.get(origin)
+	if err != nil {
+		return err
+	}
+	proxy.Transport = transport
+	if origin.GRPC {
+		// Stream messages to the viewer as they arrive instead of buffering them
+		proxy.FlushInterval = -1
+	} else if origin.FlushIntervalMs != 0 {
+		proxy.FlushInterval = time.Duration(origin.FlushIntervalMs) * time.Millisecond
+	}
+
+	// Customize the director to modify the request
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+
+		// Remove CloudFront signature parameters
+		req.URL = RemoveSignatureParams(req.URL)
+		stripBypassToken(req)
+
+		// Drop the cookies the behavior doesn't forward
+		if started := time.Now(); origin.filterCookies(req) {
+			trace.record("cookies", started)
+		}
+
+		// Drop the query parameters the behavior doesn't forward
+		if started := time.Now(); origin.filterQuery(req.URL) {
+			trace.record("query_strings", started)
+		}
+
+		// Apply the origin request policy, if any; without one every viewer header is forwarded
+		if policy := ph.config.FindOriginRequestPolicy(origin.OriginRequestPolicy); policy != nil {
+			started := time.Now()
+			policy.Apply(req)
+			policy.addViewerMetadata(req, r)
+			trace.record("origin_request_policy", started)
+		} else if userAgent := r.Header.Get("User-Agent"); userAgent != "" {
+			// Preserve original headers
+			req.Header.Set("User-Agent", userAgent)
+		}
+		origin.Cache.forwardKeyHeaders(req, r)
+
+		// Apply path rewriting if configured
+		if origin.PathTemplate != "" {
+			started := time.Now()
+			req.URL.Path = origin.expandPathTemplate(req.URL.Path, ph.config.MatchStrategy)
+			req.URL.RawPath = ""
+			trace.record("path_template", started)
+		} else if origin.StripPrefix != "" && strings.HasPrefix(req.URL.Path, origin.StripPrefix) {
+			started := time.Now()
+			req.URL.Path = strings.TrimPrefix(req.URL.Path, origin.StripPrefix)
+			trace.record("strip_prefix", started)
+		}
+
+		// Apply default root object before adding target prefix
+		// Check if the path is "/" or empty (both mean root) and if so, rewrite to the configured default
+		if req.URL.Path == "" || req.URL.Path == "/" {
+			started := time.Now()
+			if origin.DefaultRootObject != nil && *origin.DefaultRootObject != "" {
+				req.URL.Path = "/" + *origin.DefaultRootObject
+				trace.record("default_root_object", started)
+			} else if ph.config.Server.DefaultRootObject != "" {
+				req.URL.Path = "/" + ph.config.Server.DefaultRootObject
+				trace.record("default_root_object", started)
+			}
+		}
+
+		// Append the index document to directory requests, including the root when no default root object applies
+		if index := ph.indexDocument(origin); index != "" && (req.URL.Path == "" || strings.HasSuffix(req.URL.Path, "/")) {
+			started := time.Now()
+			req.URL.Path = strings.TrimSuffix(req.URL.Path, "/") + "/" + index
+			req.URL.RawPath = ""
+			trace.record("index_document", started)
+		}
+
+		if origin.TargetPrefix != "" {
+			started := time.Now()
+			req.URL.Path = origin.TargetPrefix + req.URL.Path
+			trace.record("target_prefix", started)
+		}
+
+		// Set proper Host header
+		req.Host = originURL.Host
+		req.Header.Set("Host", originURL.Host)
+
+		// Add CloudFront headers unless the origin is configured as a transparent proxy
+		if !origin.PlainProxy {
+			req.Header.Set("X-Amz-Cf-Id", requestID)
+			appendVia(req.Header, r.Header)
+			ph.config.ClientIP.setForwardedHeaders(req, r)
+		}
+
+		// Add the origin's custom headers, which take precedence over viewer headers
+		if len(origin.CustomHeaders) > 0 {
+			started := time.Now()
+			applyOriginCustomHeaders(req.Header, origin.CustomHeaders)
+			trace.record("custom_headers", started)
+		}
+
+		// Restore the header spellings case-sensitive origins expect
+		if len(origin.HeaderCasing) > 0 {
+			started := time.Now()
+			applyHeaderCasing(req.Header, origin.HeaderCasing)
+			trace.record("header_casing", started)
+		}
+
+		trace.setUpstream(req.URL.Scheme+"://"+req.URL.Host, req.URL.Path)
+	}
+
+	// Customize response modifier to add CloudFront headers
+	var originStarted time.Time
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		trace.phase("origin", originStarted)
+		trace.setOriginStatus(resp.StatusCode)
+
+		// Break redirect loops instead of letting clients follow them until they give up
+		if isRedirectLoop(resp, r) {
+			return ErrRedirectLoop
+		}
+
+		// Keep oversized responses from streaming through, before anything else reads the body
+		if origin.MaxResponseBytes > 0 {
+			if err := limitResponse(resp, r, origin); err != nil {
+				return err
+			}
+		}
+
+		// Event streams stay open for as long as the origin keeps sending, past the server's write timeout
+		if isEventStream(resp.Header) {
+			http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+
+		// Keep a copy of the body as the viewer receives it
+		if origin.Tee != nil {
+			ph.tee(resp, r, origin)
+		}
+
+		// Keep the cookies the behavior doesn't allow from reaching the viewer (or the cache)
+		if started := time.Now(); origin.filterSetCookies(resp.Header) {
+			trace.record("response_cookies", started)
+		}
+
+		// Keep origin-internal headers from reaching viewers, before the policy adds its own headers
+		if ph.config.ViewerResponseHeaders.Strict && !origin.PlainProxy {
+			ph.config.ViewerResponseHeaders.apply(resp, origin)
+		}
+
+		// Make the viewer's canary assignment sticky
+		if assignment != nil {
+			resp.Header.Add("Set-Cookie", assignment.String())
+		}
+
+		// Apply the origin's response headers policy, if any
+		if policy := ph.config.FindResponseHeadersPolicy(origin.ResponseHeadersPolicy); policy != nil {
+			started := time.Now()
+			policy.Apply(resp.Header, r)
+			trace.record("response_headers_policy", started)
+		}
+
+		// Transparent proxy origins pass the response through exactly as the origin sent it
+		if origin.PlainProxy {
+			return nil
+		}
+
+		// Check the origin echoed the request ID before the header is replaced below
+		if origin.RequestIDEchoHeader != "" {
+			ph.verifyRequestIDEcho(resp, origin, requestID)
+		}
+
+		resp.Header.Set("X-Cache", "Miss from cloudfauxnt")
+		resp.Header.Set("X-Amz-Cf-Id", requestID)
+		resp.Header.Set("Via", "1.1 cloudfauxnt")
+
+		// A 304 is forwarded with the origin's headers untouched (including its Date, or lack of one)
+		// so downstream caches can merge them into their stored response
+		if resp.StatusCode == http.StatusNotModified {
+			return nil
+		}
+
+		resp.Header.Set("Server", "CloudFauxnt")
+		resp.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		return nil
+	}
+
+	// Handle errors
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// A viewer that disconnects cancels its origin request, and there's nobody left to answer
+		if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+			trace.phase("origin", originStarted)
+			return
+		}
+		if errors.Is(err, ErrRedirectLoop) {
+			ph.writeOriginError(w, origin, "LoopDetected", err.Error(), http.StatusLoopDetected)
+			return
+		}
+		if errors.Is(err, ErrResponseTooLarge) {
+			trace.record("max_response_bytes", originStarted)
+			ph.writeOriginError(w, origin, "ResponseTooLarge", err.Error(), http.StatusBadGateway)
+			return
+		}
+		trace.phase("origin", originStarted)
+		if maxBytes := new(http.MaxBytesError); errors.As(err, &maxBytes) {
+			writeEdgeError(w, http.StatusRequestEntityTooLarge, bodyTooLargeReason)
+			return
+		}
+		ph.metrics.originConnectionFailed(origin.Name)
+		ph.transports.certificates.recordFailure(origin, r.URL.Hostname(), err)
+		if isOriginTimeout(err) {
+			ph.writeOriginError(w, origin, "GatewayTimeout", fmt.Sprintf("Origin did not respond in time: %v", err), http.StatusGatewayTimeout)
+			return
+		}
+		ph.writeOriginError(w, origin, "BadGateway", fmt.Sprintf("Failed to reach origin: %v", err), http.StatusBadGateway)
+	}
+
+	// Serve the proxy request
+	originStarted = time.Now()
+	proxy.ServeHTTP(notModifiedWriter{w}, r)
+	return nil
+}
+
+// isWebSocketUpgrade reports whether a viewer request asks to open a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEventStream reports whether a response is a stream of Server-Sent Events, which the reverse
+// proxy flushes to the viewer as each event arrives
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// indexDocument returns the index document for an origin, falling back to the server-level setting
+func (ph *ProxyHandler) indexDocument(origin *Origin) string {
+	if origin.IndexDocument != nil {
+		return *origin.IndexDocument
+	}
+	return ph.config.Server.IndexDocument
+}
+
+// notModifiedWriter stops net/http from adding a Date header to 304 responses the origin sent without one
+type notModifiedWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader suppresses the automatic Date header for 304 responses
+func (w notModifiedWriter) WriteHeader(status int) {
+	if status == http.StatusNotModified {
+		if _, ok := w.Header()["Date"]; !ok {
+			w.Header()["Date"] = nil
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (w notModifiedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeOriginError writes an error for a request routed to origin, as a plain-text body
+// for transparent proxy origins and in CloudFront XML format otherwise
+func (ph *ProxyHandler) writeOriginError(w http.ResponseWriter, origin *Origin, code, message string, status int) {
+	if origin.PlainProxy {
+		http.Error(w, message, status)
+		return
+	}
+	ph.writeCloudFrontError(w, code, message, status)
+}
+
+// writeCloudFrontError writes an error response in CloudFront XML format
+func (ph *ProxyHandler) writeCloudFrontError(w http.ResponseWriter, code, message string, status int) {
+	requestID := generateCloudFrontID()
+	body := ph.errorBodies.get(code, message)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("X-Amz-Cf-Id", requestID)
+	w.Header().Set("Server", "CloudFauxnt")
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.WriteHeader(status)
+
+	w.Write(body.prefix)
+	io.WriteString(w, requestID)
+	w.Write(body.suffix)
+}
+
+// generateCloudFrontID generates a unique CloudFront request ID
+func generateCloudFrontID() string {
+	id := uuid.Must(uuid.NewRandomFromReader(requestIDRandom)).String()
+	return strings.ToUpper(strings.ReplaceAll(id, "-", ""))
+}
+
+// HealthHandler handles health check requests
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"status":"healthy","service":"cloudfauxnt"}`)
+}
+
+// SetupRouter configures the Chi router with all routes
+func SetupRouter(config *Config, validator *SignatureValidator, metrics *Metrics, bypass *BypassTokens, logSinks ...requestLogSink) chi.Router {
+	r, _ := setupRouter(config, validator, metrics, bypass, configuredDiskCache(&config.Server), nil, nil, logSinks...)
+	return r
+}
+
+// setupRouter builds the router, also returning the proxy handlers of the top level and every
+// distribution, whose caches the admin API inspects. With cache_dir, the handlers share disk, the opened
+// disk cache. Distribution quotas are enforced when usage is set, and health answers /health when set.
+func setupRouter(config *Config, validator *SignatureValidator, metrics *Metrics, bypass *BypassTokens, disk *responseCache, usage *usageTracker, health http.HandlerFunc, logSinks ...requestLogSink) (chi.Router, []*ProxyHandler) {
+	r := chi.NewRouter()
+
+	// Resolve the viewer IP first so logs and IP-based rules agree on it
+	r.Use(ClientIPMiddleware(&config.ClientIP))
+
+	// Request logging wraps everything so rejected requests are logged too
+	if len(logSinks) > 0 {
+		r.Use(RequestLogMiddleware(config, logSinks...))
+	}
+
+	// Mark CloudFront's responses with the edge location and error result, after every other middleware
+	// has had its say, so logs see the headers viewers get
+	r.Use(EdgeHeadersMiddleware(config))
+
+	// Recover from panics inside the logging middleware so the resulting 503 is logged
+	r.Use(RecoveryMiddleware(metrics))
+
+	// Turn away viewers sending requests faster than the rate limit allows
+	if config.RateLimit.Enabled {
+		r.Use(RateLimitMiddleware(config, metrics))
+	}
+
+	// Count requests toward their distribution's usage, turning them away once its quota is used up
+	if usage != nil {
+		r.Use(QuotaMiddleware(config, usage))
+	}
+
+	// Add CORS middleware if enabled
+	if config.CORS.Enabled {
+		corsMiddleware := NewCORSMiddleware(config.CORS)
+		r.Use(corsMiddleware.Handler)
+	}
+
+	// Main proxy handler, whose origin connection pools readiness probes share
+	proxyHandler := newProxyHandler(config, validator, metrics, bypass, disk)
+
+	// Health check endpoints: /health/live reports the process is up, /health also rolls up the state
+	// of the server's subsystems, and /health/ready probes every origin
+	if health == nil {
+		health = HealthHandler
+	}
+	r.Get("/health", health)
+	r.Get("/health/live", HealthHandler)
+	r.Get("/health/ready", ReadinessHandler(config, proxyHandler.transports))
+
+	// Prometheus metrics endpoint
+	if metrics != nil {
+		r.Method(http.MethodGet, config.Metrics.Path, metrics)
+	}
+
+	// Catch-all
+	var proxy http.Handler = proxyHandler
+	proxies := []*ProxyHandler{proxyHandler}
+	if config.ContinuousDeployment.Enabled {
+		// The continuous deployment policy sends part of the top-level traffic to the staging variant
+		deployment := newContinuousDeployment(config, proxy, metrics, bypass)
+		proxies = append(proxies, deployment.staging)
+		proxy = deployment
+	}
+	if len(config.Distributions) > 0 {
+		// Additional distributions are selected by Host header; others use the top-level origins
+		router := newDistributionRouter(config, proxy, metrics, bypass, disk)
+		for _, handler := range router.handlers {
+			proxies = append(proxies, handler)
+		}
+		proxy = router
+	}
+	if len(config.Aliases) > 0 {
+		// Once the top-level distribution has aliases, hosts no distribution serves are turned away
+		proxy = &aliasGuard{config: config, next: proxy}
+	}
+	r.NotFound(proxy.ServeHTTP)
+	// chi answers methods it doesn't know with its own 405; let the proxy decide instead
+	r.MethodNotAllowed(proxy.ServeHTTP)
+
+	return r, proxies
+}
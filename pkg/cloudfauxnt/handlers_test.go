@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// loadTestConfig loads a configuration written out from yaml
+func loadTestConfig(t *testing.T, yaml string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return config
+}
+
+// streamingProxy serves a proxy to origin with the given origin settings, on a server whose write
+// timeout is writeTimeout
+func streamingProxy(t *testing.T, origin *httptest.Server, settings string, writeTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	config := loadTestConfig(t, `
+config_version: 2
+server:
+  port: 8080
+signing:
+  enabled: false
+origins:
+  - name: app
+    url: `+origin.URL+`
+`+settings+`
+behaviors:
+  - target_origin: app
+    path_patterns: ["/*"]
+`)
+	proxy := httptest.NewUnstartedServer(NewProxyHandler(config, NewValidatorFromConfig(config), NewMetrics(), NewBypassTokens()))
+	proxy.Config.WriteTimeout = writeTimeout
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// getStream starts a GET request, failing if the response headers don't arrive in time
+func getStream(t *testing.T, url string) *http.Response {
+	t.Helper()
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		done <- result{resp, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		t.Cleanup(func() { r.resp.Body.Close() })
+		return r.resp
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the response headers")
+		return nil
+	}
+}
+
+// readLine reads a line from a streamed body, failing if it doesn't arrive in time
+func readLine(t *testing.T, body *bufio.Reader) string {
+	t.Helper()
+	line := make(chan string, 1)
+	go func() {
+		s, _ := body.ReadString('\n')
+		line <- s
+	}()
+	select {
+	case s := <-line:
+		return s
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for streamed data")
+		return ""
+	}
+}
+
+func TestEventStreamDeliversEventsAsTheyArrive(t *testing.T) {
+	next := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			io.WriteString(w, "data: "+strconv.Itoa(i)+"\n\n")
+			w.(http.Flusher).Flush()
+			if i < 3 {
+				<-next
+			}
+		}
+	}))
+	defer origin.Close()
+	defer close(next)
+
+	writeTimeout := 200 * time.Millisecond
+	proxy := streamingProxy(t, origin, "", writeTimeout)
+	body := bufio.NewReader(getStream(t, proxy.URL+"/events").Body)
+
+	for i := 1; i <= 3; i++ {
+		// The origin only sends the next event once this one has reached the viewer
+		if line := readLine(t, body); line != "data: "+strconv.Itoa(i)+"\n" {
+			t.Fatalf("event %d = %q", i, line)
+		}
+		readLine(t, body)
+		if i == 2 {
+			// Keep the stream open past the server's write timeout
+			time.Sleep(2 * writeTimeout)
+		}
+		if i < 3 {
+			next <- struct{}{}
+		}
+	}
+	if rest, err := io.ReadAll(body); err != nil || len(rest) != 0 {
+		t.Errorf("end of stream = %q, %v; want a clean end", rest, err)
+	}
+}
+
+func TestFlushIntervalStreamsResponsesWithContentLength(t *testing.T) {
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len("first\nsecond\n")))
+		io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "second\n")
+	}))
+	defer origin.Close()
+	defer close(release)
+
+	proxy := streamingProxy(t, origin, "    flush_interval_ms: 20", time.Minute)
+	body := bufio.NewReader(getStream(t, proxy.URL+"/download").Body)
+
+	// The first line arrives while the origin is still holding back the rest of the body
+	if line := readLine(t, body); line != "first\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	release <- struct{}{}
+	if line := readLine(t, body); line != "second\n" {
+		t.Fatalf("second line = %q", line)
+	}
+}
@@ -0,0 +1,418 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseHCL reads the subset of HCL native syntax that Terraform resource definitions use: blocks,
+// and attributes whose values are literals, lists, and objects. Labelled blocks nest as maps keyed
+// by their labels and unlabelled blocks collect into lists, the layout of Terraform's JSON syntax.
+// Other expressions, such as references and function calls, become unresolvedReference placeholders.
+func parseHCL(src []byte) (map[string]any, error) {
+	p := &hclParser{src: string(src), line: 1}
+	body, err := p.body(false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return body, nil
+}
+
+// hclParser is the position in a document being parsed
+type hclParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// body parses attributes and blocks up to the closing brace of a block, or the end of the document
+func (p *hclParser) body(nested bool) (map[string]any, error) {
+	body := make(map[string]any)
+	for {
+		p.skipSpace(true)
+		if p.pos >= len(p.src) {
+			if nested {
+				return nil, fmt.Errorf("unclosed block")
+			}
+			return body, nil
+		}
+		if p.src[p.pos] == '}' && nested {
+			p.pos++
+			return body, nil
+		}
+		if p.src[p.pos] == ',' {
+			// Not valid HCL, but a common slip in one-line blocks
+			p.pos++
+			continue
+		}
+
+		name := p.identifier()
+		if name == "" {
+			return nil, fmt.Errorf("unexpected %q", p.src[p.pos])
+		}
+		p.skipSpace(false)
+		if p.peek() == '=' {
+			p.pos++
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			body[name] = value
+			continue
+		}
+
+		var labels []string
+		for p.peek() != '{' {
+			label := p.identifier()
+			if p.peek() == '"' {
+				value, err := p.quoted()
+				if err != nil {
+					return nil, err
+				}
+				label, _ = value.(string)
+			}
+			if label == "" {
+				return nil, fmt.Errorf("expected = or { after %s", name)
+			}
+			labels = append(labels, label)
+			p.skipSpace(false)
+		}
+		p.pos++
+		block, err := p.body(true)
+		if err != nil {
+			return nil, err
+		}
+		if len(labels) == 0 {
+			blocks, _ := body[name].([]any)
+			body[name] = append(blocks, block)
+			continue
+		}
+		parent := body
+		for _, key := range append([]string{name}, labels[:len(labels)-1]...) {
+			child, ok := parent[key].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[labels[len(labels)-1]] = block
+	}
+}
+
+// expression parses a value: a literal, list, or object, or anything else as a placeholder
+func (p *hclParser) expression() (any, error) {
+	p.skipSpace(false)
+	if strings.HasPrefix(p.src[p.pos:], "<<") {
+		return p.heredoc()
+	}
+	start, line := p.pos, p.line
+	if value, err := p.literal(); err == nil && p.atExpressionEnd() {
+		return value, nil
+	}
+	p.pos, p.line = start, line
+
+	// Anything else is kept as written: the expression runs to the end of the line, or to the
+	// next comma or closing bracket outside nested brackets and strings
+	depth := 0
+	for p.pos < len(p.src) && (depth > 0 || !p.atExpressionEnd()) {
+		switch p.src[p.pos] {
+		case '"':
+			if _, err := p.quoted(); err != nil {
+				return nil, err
+			}
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case '\n':
+			p.line++
+		}
+		p.pos++
+	}
+	return unresolvedReference(strings.Join(strings.Fields(p.src[start:p.pos]), " ")), nil
+}
+
+// literal parses a string, number, boolean, null, list, or object
+func (p *hclParser) literal() (any, error) {
+	switch p.peek() {
+	case '"':
+		return p.quoted()
+	case '[':
+		p.pos++
+		list := []any{}
+		for {
+			p.skipSpace(true)
+			if p.peek() == ']' {
+				p.pos++
+				return list, nil
+			}
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+			p.skipSpace(true)
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return nil, fmt.Errorf("expected , or ]")
+			}
+		}
+	case '{':
+		p.pos++
+		object := make(map[string]any)
+		for {
+			p.skipSpace(true)
+			if p.peek() == '}' {
+				p.pos++
+				return object, nil
+			}
+			key := p.identifier()
+			if p.peek() == '"' {
+				value, err := p.quoted()
+				if err != nil {
+					return nil, err
+				}
+				key, _ = value.(string)
+			}
+			p.skipSpace(false)
+			if key == "" || (p.peek() != '=' && p.peek() != ':') {
+				return nil, fmt.Errorf("expected an object key")
+			}
+			p.pos++
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+			p.skipSpace(true)
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+	}
+	switch token := p.identifier(); token {
+	case "true", "false":
+		return token == "true", nil
+	case "null":
+		return nil, nil
+	default:
+		if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(token, 64); err == nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("not a literal")
+	}
+}
+
+// atExpressionEnd reports whether only spaces or a comment remain before the end of the current
+// expression
+func (p *hclParser) atExpressionEnd() bool {
+	rest := strings.TrimLeft(p.src[p.pos:], " \t\r")
+	return rest == "" || strings.ContainsRune("\n,]})#", rune(rest[0])) || strings.HasPrefix(rest, "//") || strings.HasPrefix(rest, "/*")
+}
+
+// quoted parses a quoted string. Templates with ${ or %{ sequences become placeholders.
+func (p *hclParser) quoted() (any, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	template := false
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			if template {
+				return templateReference(p.src[start+1 : p.pos-1]), nil
+			}
+			return b.String(), nil
+		case c == '\n':
+			return nil, fmt.Errorf("unterminated string")
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u', 'U':
+				size := 4
+				if e == 'U' {
+					size = 8
+				}
+				if p.pos+size >= len(p.src) {
+					return nil, fmt.Errorf("invalid escape sequence")
+				}
+				r, err := strconv.ParseUint(p.src[p.pos+1:p.pos+1+size], 16, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid escape sequence")
+				}
+				b.WriteRune(rune(r))
+				p.pos += size
+			default:
+				b.WriteByte(e)
+			}
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "$${") || strings.HasPrefix(p.src[p.pos:], "%%{"):
+			b.WriteString(p.src[p.pos+1 : p.pos+3])
+			p.pos += 3
+		case strings.HasPrefix(p.src[p.pos:], "${") || strings.HasPrefix(p.src[p.pos:], "%{"):
+			// Skip to the end of the interpolation, which may itself contain quoted strings
+			template = true
+			p.pos++
+			for depth := 0; p.pos < len(p.src); {
+				switch p.src[p.pos] {
+				case '"':
+					if _, err := p.quoted(); err != nil {
+						return nil, err
+					}
+					continue
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+				p.pos++
+				if depth == 0 {
+					break
+				}
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteRune(r)
+			p.pos += size
+		}
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// templateReference returns the placeholder for a string template: the expression itself for
+// "${expression}", or the whole template otherwise
+func templateReference(template string) string {
+	if inner, ok := strings.CutPrefix(template, "${"); ok && strings.Count(template, "${") == 1 && strings.HasSuffix(inner, "}") {
+		return unresolvedReference(strings.TrimSpace(strings.TrimSuffix(inner, "}")))
+	}
+	return unresolvedReference(template)
+}
+
+// heredoc parses a <<EOF or indented <<-EOF string
+func (p *hclParser) heredoc() (any, error) {
+	p.pos += 2
+	indented := p.peek() == '-'
+	if indented {
+		p.pos++
+	}
+	marker := p.identifier()
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if marker == "" || end < 0 {
+		return nil, fmt.Errorf("invalid heredoc")
+	}
+	p.pos += end + 1
+	p.line++
+	var lines []string
+	for p.pos < len(p.src) {
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		line := p.src[p.pos : p.pos+end]
+		p.pos += end
+		if strings.TrimSpace(line) == marker {
+			if indented {
+				lines = dedent(lines)
+			}
+			text := strings.Join(lines, "\n") + "\n"
+			if strings.Contains(text, "${") || strings.Contains(text, "%{") {
+				return unresolvedReference("heredoc " + marker), nil
+			}
+			return text, nil
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+		if p.pos < len(p.src) {
+			p.pos++
+			p.line++
+		}
+	}
+	return nil, fmt.Errorf("unterminated heredoc %s", marker)
+}
+
+// dedent removes the indentation all non-blank lines share
+func dedent(lines []string) []string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+	return lines
+}
+
+// identifier reads a run of identifier characters, which also covers numbers and dotted references
+func (p *hclParser) identifier() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c != '_' && c != '-' && c != '.' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// skipSpace skips spaces and comments, and newlines too if newlines is set
+func (p *hclParser) skipSpace(newlines bool) {
+	for p.pos < len(p.src) {
+		rest := p.src[p.pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r':
+			p.pos++
+		case rest[0] == '\n' && newlines:
+			p.pos++
+			p.line++
+		case rest[0] == '#' || strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			p.pos += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				end = len(rest) - 2
+			}
+			p.line += strings.Count(rest[:end], "\n")
+			p.pos += end + 2
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the current character, or 0 at the end of the document
+func (p *hclParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
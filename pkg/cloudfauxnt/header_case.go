@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// validateHeaderCasing rejects entries that cannot be sent as header names
+func validateHeaderCasing(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("header_casing: invalid header name %q", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if seen[canonical] {
+			return fmt.Errorf("header_casing: %q is listed more than once", name)
+		}
+		seen[canonical] = true
+	}
+	return nil
+}
+
+// applyHeaderCasing re-keys headers so they are written to the origin with the configured spelling.
+// net/http writes header map keys verbatim over HTTP/1.1; HTTP/2 lowercases every name regardless.
+func applyHeaderCasing(header http.Header, names []string) {
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == name {
+			continue
+		}
+		if values, ok := header[canonical]; ok {
+			delete(header, canonical)
+			header[name] = values
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isHealthPath reports whether a path is one of the health endpoints, which aren't logged or rate limited
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/health/live" || path == "/health/ready"
+}
+
+// originProbe is the result of probing one origin for readiness
+type originProbe struct {
+	Name       string  `json:"name"`
+	URL        string  `json:"url"`
+	Status     string  `json:"status"`                // "up", or "down" when the origin failed or answered 5xx
+	HTTPStatus int     `json:"http_status,omitempty"` // Status the origin answered with
+	LatencyMS  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+	// Certificate is the one a TLS origin presented on its latest handshake, through any request
+	Certificate *originCertificate `json:"certificate,omitempty"`
+}
+
+// ReadinessHandler answers /health/ready by sending a HEAD request to every configured origin,
+// including those of distributions. It answers 200 when every origin responded without a 5xx
+// status, and 503 otherwise. Behaviors targeting the same origin share one probe.
+func ReadinessHandler(config *Config, transports *originTransports) http.HandlerFunc {
+	timeout := time.Duration(config.Server.ReadinessTimeoutSeconds) * time.Second
+	return func(w http.ResponseWriter, r *http.Request) {
+		var origins []*Origin
+		seen := make(map[string]bool)
+		addOrigins := func(list []Origin) {
+			for i := range list {
+				key := list[i].URL + list[i].HealthCheckPath
+				if !seen[key] {
+					seen[key] = true
+					origins = append(origins, &list[i])
+				}
+			}
+		}
+		addOrigins(config.Origins)
+		for _, d := range config.Distributions {
+			addOrigins(d.Origins)
+		}
+		if config.ContinuousDeployment.Enabled {
+			addOrigins(config.ContinuousDeployment.Staging.Origins)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		probes := make([]originProbe, len(origins))
+		var wg sync.WaitGroup
+		for i, origin := range origins {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probes[i] = probeOrigin(ctx, transports, origin)
+			}()
+		}
+		wg.Wait()
+
+		status, code := "ready", http.StatusOK
+		for _, probe := range probes {
+			if probe.Status != "up" {
+				status, code = "not_ready", http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Status  string        `json:"status"`
+			Service string        `json:"service"`
+			Origins []originProbe `json:"origins"`
+		}{status, "cloudfauxnt", probes})
+	}
+}
+
+// probeOrigin sends a HEAD request to an origin's health check path through its connection pool
+func probeOrigin(ctx context.Context, transports *originTransports, origin *Origin) (probe originProbe) {
+	target := strings.TrimSuffix(origin.URL, "/") + origin.HealthCheckPath
+	probe = originProbe{Name: origin.Name, URL: target, Status: "down"}
+	started := time.Now()
+	defer func() { probe.LatencyMS = float64(time.Since(started).Microseconds()) / 1000 }()
+
+	transport, err := transports.get(origin)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer func() { probe.Certificate = transports.certificates.get(origin.URL) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	req.Header.Set("User-Agent", "Amazon CloudFront")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		transports.certificates.recordFailure(origin, req.URL.Hostname(), err)
+		probe.Error = err.Error()
+		return probe
+	}
+	resp.Body.Close()
+	probe.HTTPStatus = resp.StatusCode
+	if resp.StatusCode < 500 {
+		probe.Status = "up"
+	}
+	return probe
+}
+
+// Component states reported by the composite /health status
+const (
+	componentOK       = "ok"
+	componentDegraded = "degraded" // Working around a failure, e.g. serving the previous configuration
+	componentFailed   = "failed"   // Requests that depend on the component fail
+)
+
+// componentHealth is the state of one subsystem in the composite /health status
+type componentHealth struct {
+	Name   string     `json:"name"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	Since  *time.Time `json:"since,omitempty"` // When the component started failing, for background work
+}
+
+// subsystemState is the outcome of a background subsystem's latest attempt at its work, such as
+// shipping a log file or refreshing a public key
+type subsystemState struct {
+	mu    sync.Mutex
+	err   error
+	since time.Time // When the current run of failures began
+}
+
+// report records the outcome of an attempt
+func (s *subsystemState) report(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && s.err == nil {
+		s.since = time.Now().UTC()
+	}
+	s.err = err
+}
+
+// component describes the state as a component that is degraded while its latest attempt failed
+func (s *subsystemState) component(name string) componentHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		return componentHealth{Name: name, Status: componentOK}
+	}
+	since := s.since
+	return componentHealth{Name: name, Status: componentDegraded, Error: s.err.Error(), Since: &since}
+}
+
+// signingKeyHealth checks every enabled signing configuration has the public keys of its key pairs,
+// and that a private key configured for tooling belongs to its key pair. Failed public key refreshes
+// leave the previous keys in use, so they only degrade it.
+func signingKeyHealth(config *Config, refreshes *subsystemState) componentHealth {
+	for _, s := range config.signingConfigs() {
+		if !s.Enabled {
+			continue
+		}
+		if s.PublicKey == nil {
+			return componentHealth{Name: "signing_keys", Status: componentFailed, Error: fmt.Sprintf("key pair %s has no public key", s.KeyPairID)}
+		}
+		for _, trusted := range s.TrustedKeyPairs {
+			if trusted.PublicKey == nil {
+				return componentHealth{Name: "signing_keys", Status: componentFailed, Error: fmt.Sprintf("key pair %s has no public key", trusted.KeyPairID)}
+			}
+		}
+		if s.PrivateKeyPath == "" {
+			continue
+		}
+		key, err := LoadSigningKey(s.PrivateKeyPath)
+		if err == nil && !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(s.PublicKey) {
+			err = fmt.Errorf("private_key_path does not match the public key of key pair %s", s.KeyPairID)
+		}
+		if err != nil {
+			return componentHealth{Name: "signing_keys", Status: componentDegraded, Error: err.Error()}
+		}
+	}
+	return refreshes.component("signing_keys")
+}
+
+// healthStatus rolls component states up into one status: healthy, degraded (still answering 200),
+// or unhealthy when a component has failed
+func healthStatus(components []componentHealth) (string, int) {
+	status, code := "healthy", http.StatusOK
+	for _, c := range components {
+		switch c.Status {
+		case componentFailed:
+			return "unhealthy", http.StatusServiceUnavailable
+		case componentDegraded:
+			status = "degraded"
+		}
+	}
+	return status, code
+}
+
+// writeHealth writes a composite health status
+func writeHealth(w http.ResponseWriter, components []componentHealth) {
+	status, code := healthStatus(components)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status     string            `json:"status"`
+		Service    string            `json:"service"`
+		Components []componentHealth `json:"components"`
+	}{status, "cloudfauxnt", components})
+}
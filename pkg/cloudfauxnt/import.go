@@ -0,0 +1,480 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The distribution settings ImportDistributionConfig understands. Each struct decodes both the JSON
+// the AWS CLI prints and the XML the CloudFront API returns, whose lists wrap every item in an element.
+type (
+	cfDistributionConfig struct {
+		Comment string `json:"Comment" xml:"Comment"`
+		Enabled bool   `json:"Enabled" xml:"Enabled"`
+		Aliases struct {
+			Items []string `json:"Items" xml:"Items>CNAME"`
+		} `json:"Aliases" xml:"Aliases"`
+		DefaultRootObject    string               `json:"DefaultRootObject" xml:"DefaultRootObject"`
+		Origins              cfOrigins            `json:"Origins" xml:"Origins"`
+		OriginGroups         cfOriginGroups       `json:"OriginGroups" xml:"OriginGroups"`
+		DefaultCacheBehavior cfCacheBehavior      `json:"DefaultCacheBehavior" xml:"DefaultCacheBehavior"`
+		CacheBehaviors       cfCacheBehaviors     `json:"CacheBehaviors" xml:"CacheBehaviors"`
+		CustomErrorResponses cfCustomErrorResults `json:"CustomErrorResponses" xml:"CustomErrorResponses"`
+	}
+	cfOrigins struct {
+		Items []cfOrigin `json:"Items" xml:"Items>Origin"`
+	}
+	cfOrigin struct {
+		ID                 string `json:"Id" xml:"Id"`
+		DomainName         string `json:"DomainName" xml:"DomainName"`
+		OriginPath         string `json:"OriginPath" xml:"OriginPath"`
+		ConnectionAttempts int    `json:"ConnectionAttempts" xml:"ConnectionAttempts"`
+		ConnectionTimeout  int    `json:"ConnectionTimeout" xml:"ConnectionTimeout"`
+		CustomHeaders      struct {
+			Items []struct {
+				HeaderName  string `json:"HeaderName" xml:"HeaderName"`
+				HeaderValue string `json:"HeaderValue" xml:"HeaderValue"`
+			} `json:"Items" xml:"Items>OriginCustomHeader"`
+		} `json:"CustomHeaders" xml:"CustomHeaders"`
+		S3OriginConfig     *struct{} `json:"S3OriginConfig" xml:"S3OriginConfig"`
+		CustomOriginConfig *struct {
+			HTTPPort               int    `json:"HTTPPort" xml:"HTTPPort"`
+			HTTPSPort              int    `json:"HTTPSPort" xml:"HTTPSPort"`
+			OriginProtocolPolicy   string `json:"OriginProtocolPolicy" xml:"OriginProtocolPolicy"`
+			OriginReadTimeout      int    `json:"OriginReadTimeout" xml:"OriginReadTimeout"`
+			OriginKeepaliveTimeout int    `json:"OriginKeepaliveTimeout" xml:"OriginKeepaliveTimeout"`
+		} `json:"CustomOriginConfig" xml:"CustomOriginConfig"`
+	}
+	cfOriginGroups struct {
+		Items []struct {
+			ID      string `json:"Id" xml:"Id"`
+			Members struct {
+				Items []struct {
+					OriginID string `json:"OriginId" xml:"OriginId"`
+				} `json:"Items" xml:"Items>OriginGroupMember"`
+			} `json:"Members" xml:"Members"`
+		} `json:"Items" xml:"Items>OriginGroup"`
+	}
+	cfCacheBehaviors struct {
+		Items []cfCacheBehavior `json:"Items" xml:"Items>CacheBehavior"`
+	}
+	cfCacheBehavior struct {
+		PathPattern             string `json:"PathPattern" xml:"PathPattern"`
+		TargetOriginID          string `json:"TargetOriginId" xml:"TargetOriginId"`
+		ViewerProtocolPolicy    string `json:"ViewerProtocolPolicy" xml:"ViewerProtocolPolicy"`
+		CachePolicyID           string `json:"CachePolicyId" xml:"CachePolicyId"`
+		OriginRequestPolicyID   string `json:"OriginRequestPolicyId" xml:"OriginRequestPolicyId"`
+		ResponseHeadersPolicyID string `json:"ResponseHeadersPolicyId" xml:"ResponseHeadersPolicyId"`
+		FieldLevelEncryptionID  string `json:"FieldLevelEncryptionId" xml:"FieldLevelEncryptionId"`
+		AllowedMethods          struct {
+			Items []string `json:"Items" xml:"Items>Method"`
+		} `json:"AllowedMethods" xml:"AllowedMethods"`
+		TrustedKeyGroups struct {
+			Enabled bool     `json:"Enabled" xml:"Enabled"`
+			Items   []string `json:"Items" xml:"Items>KeyGroup"`
+		} `json:"TrustedKeyGroups" xml:"TrustedKeyGroups"`
+		TrustedSigners struct {
+			Enabled bool `json:"Enabled" xml:"Enabled"`
+		} `json:"TrustedSigners" xml:"TrustedSigners"`
+		// Legacy cache settings, used when the behavior has no cache policy
+		MinTTL          *int `json:"MinTTL" xml:"MinTTL"`
+		DefaultTTL      *int `json:"DefaultTTL" xml:"DefaultTTL"`
+		MaxTTL          *int `json:"MaxTTL" xml:"MaxTTL"`
+		ForwardedValues *struct {
+			QueryString bool `json:"QueryString" xml:"QueryString"`
+			Cookies     struct {
+				Forward          string `json:"Forward" xml:"Forward"`
+				WhitelistedNames struct {
+					Items []string `json:"Items" xml:"Items>Name"`
+				} `json:"WhitelistedNames" xml:"WhitelistedNames"`
+			} `json:"Cookies" xml:"Cookies"`
+			Headers struct {
+				Items []string `json:"Items" xml:"Items>Name"`
+			} `json:"Headers" xml:"Headers"`
+		} `json:"ForwardedValues" xml:"ForwardedValues"`
+		LambdaFunctionAssociations struct {
+			Quantity int `json:"Quantity" xml:"Quantity"`
+		} `json:"LambdaFunctionAssociations" xml:"LambdaFunctionAssociations"`
+		FunctionAssociations struct {
+			Quantity int `json:"Quantity" xml:"Quantity"`
+		} `json:"FunctionAssociations" xml:"FunctionAssociations"`
+	}
+	cfCustomErrorResults struct {
+		Items []struct {
+			ErrorCode          int    `json:"ErrorCode" xml:"ErrorCode"`
+			ResponsePagePath   string `json:"ResponsePagePath" xml:"ResponsePagePath"`
+			ResponseCode       string `json:"ResponseCode" xml:"ResponseCode"`
+			ErrorCachingMinTTL int    `json:"ErrorCachingMinTTL" xml:"ErrorCachingMinTTL"`
+		} `json:"Items" xml:"Items>CustomErrorResponse"`
+	}
+)
+
+// managedCachePolicyTTLs are the TTLs (min, default, max) of CloudFront's managed cache policies
+// that cache; CachingDisabled turns caching off
+var managedCachePolicyTTLs = map[string][3]int{
+	"658327ea-f89d-4fab-a63d-7e88639e58f6": {1, 86400, 31536000}, // CachingOptimized
+	"b2884449-e4de-46a7-ac36-70bc7f1ddd6d": {1, 86400, 31536000}, // CachingOptimizedForUncompressedObjects
+}
+
+// cachingDisabledPolicyID is the managed CachingDisabled cache policy
+const cachingDisabledPolicyID = "4135ea2d-6df8-44a3-9df3-4b5a84be39ad"
+
+// parseDistributionConfig decodes a DistributionConfig from the CloudFront API's XML, or from the
+// JSON of `aws cloudfront get-distribution-config` or `get-distribution`
+func parseDistributionConfig(data []byte) (*cfDistributionConfig, error) {
+	data = bytes.TrimSpace(data)
+	var config cfDistributionConfig
+	if bytes.HasPrefix(data, []byte("<")) {
+		if err := xml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse DistributionConfig XML: %w", err)
+		}
+		return &config, nil
+	}
+
+	var wrapper struct {
+		DistributionConfig *cfDistributionConfig `json:"DistributionConfig"`
+		Distribution       *struct {
+			DistributionConfig *cfDistributionConfig `json:"DistributionConfig"`
+		} `json:"Distribution"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, fmt.Errorf("failed to parse DistributionConfig JSON: %w", err)
+	}
+	switch {
+	case wrapper.DistributionConfig != nil:
+		return wrapper.DistributionConfig, nil
+	case wrapper.Distribution != nil && wrapper.Distribution.DistributionConfig != nil:
+		return wrapper.Distribution.DistributionConfig, nil
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse DistributionConfig JSON: %w", err)
+	}
+	if len(config.Origins.Items) == 0 {
+		return nil, fmt.Errorf("no DistributionConfig with origins found")
+	}
+	return &config, nil
+}
+
+// ImportDistributionConfig converts a CloudFront DistributionConfig, as returned by the CloudFront
+// API or saved from `aws cloudfront get-distribution-config`, into a configuration file to mirror
+// the distribution locally. keyGroupKeys, if not nil, returns the public key IDs of a trusted key
+// group, so the signing key pair ID can be filled in (see CloudFrontAPI.KeyGroupPublicKeys).
+// Settings CloudFauxnt doesn't emulate are kept as comments.
+func ImportDistributionConfig(data []byte, keyGroupKeys func(keyGroupID string) ([]string, error)) ([]byte, error) {
+	dc, err := parseDistributionConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return importDistribution(dc, "a CloudFront DistributionConfig", nil, keyGroupKeys)
+}
+
+// importDistribution renders a decoded DistributionConfig read from source, starting with comment
+// lines for notes about the conversion
+func importDistribution(dc *cfDistributionConfig, source string, notes []string, keyGroupKeys func(string) ([]string, error)) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("# Imported from " + source)
+	if dc.Comment != "" {
+		fmt.Fprintf(&b, ": %s", strings.Join(strings.Fields(dc.Comment), " "))
+	}
+	b.WriteString("\n# Origin URLs point at the production origins; replace them with local stand-ins as needed.\n")
+	for _, note := range notes {
+		fmt.Fprintf(&b, "# %s\n", note)
+	}
+	if len(dc.Aliases.Items) > 0 {
+		fmt.Fprintf(&b, "# Uncomment to answer requests for other hosts with a 403, as CloudFront does:\n# aliases: [%s]\n", quoteAll(dc.Aliases.Items))
+	}
+	if !dc.Enabled {
+		b.WriteString("# The distribution is disabled in CloudFront.\n")
+	}
+	for _, e := range dc.CustomErrorResponses.Items {
+		fmt.Fprintf(&b, "# Custom error response (not emulated): %d", e.ErrorCode)
+		if e.ResponsePagePath != "" {
+			fmt.Fprintf(&b, " -> %s (%s)", e.ResponsePagePath, e.ResponseCode)
+		}
+		fmt.Fprintf(&b, ", error caching min TTL %ds\n", e.ErrorCachingMinTTL)
+	}
+	b.WriteString("config_version: 2\nserver:\n  port: 8080\n")
+	if dc.DefaultRootObject != "" {
+		fmt.Fprintf(&b, "  default_root_object: %s\n", importedString(dc.DefaultRootObject))
+	}
+
+	// Behaviors targeting an origin group are sent to its primary origin
+	groups := make(map[string][]string)
+	for _, group := range dc.OriginGroups.Items {
+		for _, member := range group.Members.Items {
+			groups[group.ID] = append(groups[group.ID], member.OriginID)
+		}
+	}
+
+	// CloudFront checks behaviors in order and falls back to the default, as the ordered match
+	// strategy does with /* listed last
+	behaviors := append(slices.Clone(dc.CacheBehaviors.Items), dc.DefaultCacheBehavior)
+	behaviors[len(behaviors)-1].PathPattern = "/*"
+	signed := false
+	for _, behavior := range behaviors {
+		signed = signed || behavior.TrustedKeyGroups.Enabled || behavior.TrustedSigners.Enabled
+	}
+	var groupKeys map[string][]string
+	if signed {
+		var err error
+		if groupKeys, err = writeImportedSigning(&b, behaviors, keyGroupKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	b.WriteString("origins:\n")
+	originNames := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, origin := range dc.Origins.Items {
+		originNames[origin.ID] = uniqueName(origin.ID, taken)
+		writeImportedOrigin(&b, originNames[origin.ID], &origin)
+	}
+
+	b.WriteString("behaviors:\n")
+	behaviorNames := make(map[string]bool)
+	for i, behavior := range behaviors {
+		name := "default"
+		if i < len(behaviors)-1 {
+			name = strings.Trim(behavior.PathPattern, "/*")
+		}
+		target := behavior.TargetOriginID
+		if members, ok := groups[target]; ok && len(members) > 0 {
+			target = members[0]
+			fmt.Fprintf(&b, "  # Targets origin group %s; failover to %s isn't emulated\n", behavior.TargetOriginID, strings.Join(members[1:], ", "))
+		}
+		if originNames[target] == "" {
+			return nil, fmt.Errorf("cache behavior %s targets unknown origin %s", behavior.PathPattern, behavior.TargetOriginID)
+		}
+		writeImportedBehavior(&b, uniqueName(name, behaviorNames), originNames[target], &behavior, signed, behaviorKeyPairs(&behavior, groupKeys))
+	}
+	return []byte(b.String()), nil
+}
+
+// writeImportedSigning renders the signing settings for behaviors with trusted key groups or signers,
+// returning the public key IDs of each key group when keyGroupKeys could look them up
+func writeImportedSigning(b *strings.Builder, behaviors []cfCacheBehavior, keyGroupKeys func(string) ([]string, error)) (map[string][]string, error) {
+	var groupIDs, keyIDs []string
+	groupKeys := make(map[string][]string)
+	for _, behavior := range behaviors {
+		for _, id := range behavior.TrustedKeyGroups.Items {
+			if slices.Contains(groupIDs, id) {
+				continue
+			}
+			groupIDs = append(groupIDs, id)
+			if keyGroupKeys != nil {
+				keys, err := keyGroupKeys(id)
+				if err != nil {
+					return nil, fmt.Errorf("key group %s: %w", id, err)
+				}
+				groupKeys[id] = keys
+				for _, key := range keys {
+					if !slices.Contains(keyIDs, key) {
+						keyIDs = append(keyIDs, key)
+					}
+				}
+			}
+		}
+	}
+
+	b.WriteString("signing:\n  enabled: true\n")
+	if len(groupIDs) > 0 {
+		fmt.Fprintf(b, "  # Trusted key groups: %s\n", strings.Join(groupIDs, ", "))
+	}
+	if len(keyIDs) > 0 {
+		fmt.Fprintf(b, "  key_pair_id: %s\n", importedString(keyIDs[0]))
+		fmt.Fprintf(b, "  public_key_path: %s\n", importedString("./keys/"+keyIDs[0]+".pem"))
+		if len(keyIDs) > 1 {
+			b.WriteString("  trusted_key_pairs:\n")
+			for _, id := range keyIDs[1:] {
+				fmt.Fprintf(b, "    - key_pair_id: %s\n", importedString(id))
+				fmt.Fprintf(b, "      public_key_path: %s\n", importedString("./keys/"+id+".pem"))
+			}
+		}
+		return groupKeys, nil
+	}
+	b.WriteString("  key_pair_id: \"\"  # ID of a public key in the trusted key group\n")
+	b.WriteString("  public_key_path: ./keys/public.pem\n")
+	return groupKeys, nil
+}
+
+// behaviorKeyPairs returns the public key IDs of a behavior's trusted key groups, or nil when the
+// behavior trusts every imported key pair anyway
+func behaviorKeyPairs(behavior *cfCacheBehavior, groupKeys map[string][]string) []string {
+	var ids []string
+	for _, group := range behavior.TrustedKeyGroups.Items {
+		for _, id := range groupKeys[group] {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	all := make(map[string]bool)
+	for _, keys := range groupKeys {
+		for _, id := range keys {
+			all[id] = true
+		}
+	}
+	if len(ids) == len(all) {
+		return nil
+	}
+	return ids
+}
+
+// writeImportedOrigin renders one origin
+func writeImportedOrigin(b *strings.Builder, name string, origin *cfOrigin) {
+	u := url.URL{Scheme: "https", Host: origin.DomainName}
+	if isUnresolvedReference(origin.DomainName) {
+		fmt.Fprintf(b, "  # The domain name of %s is %s in the template; point url at the origin it resolves to\n", name, origin.DomainName)
+		u.Host = "origin.invalid"
+	}
+	if custom := origin.CustomOriginConfig; custom != nil {
+		switch {
+		case custom.OriginProtocolPolicy == "http-only":
+			u.Scheme = "http"
+			if custom.HTTPPort != 0 && custom.HTTPPort != 80 {
+				u.Host += ":" + strconv.Itoa(custom.HTTPPort)
+			}
+		case custom.HTTPSPort != 0 && custom.HTTPSPort != 443:
+			u.Host += ":" + strconv.Itoa(custom.HTTPSPort)
+		}
+	}
+
+	fmt.Fprintf(b, "  - name: %s\n    url: %s\n", importedString(name), importedString(u.String()))
+	if origin.OriginPath != "" {
+		fmt.Fprintf(b, "    target_prefix: %s\n", importedString(origin.OriginPath))
+	}
+	if origin.ConnectionAttempts != 0 {
+		fmt.Fprintf(b, "    connection_attempts: %d\n", origin.ConnectionAttempts)
+	}
+	if origin.ConnectionTimeout != 0 {
+		fmt.Fprintf(b, "    connection_timeout_seconds: %d\n", origin.ConnectionTimeout)
+	}
+	if custom := origin.CustomOriginConfig; custom != nil {
+		if custom.OriginReadTimeout != 0 {
+			fmt.Fprintf(b, "    read_timeout_seconds: %d\n", custom.OriginReadTimeout)
+		}
+		if custom.OriginKeepaliveTimeout != 0 {
+			fmt.Fprintf(b, "    keepalive_timeout_seconds: %d\n", custom.OriginKeepaliveTimeout)
+		}
+	}
+	if len(origin.CustomHeaders.Items) > 0 {
+		b.WriteString("    custom_headers:\n")
+		for _, header := range origin.CustomHeaders.Items {
+			fmt.Fprintf(b, "      %s: %s\n", strconv.Quote(header.HeaderName), importedString(header.HeaderValue))
+		}
+	}
+}
+
+// writeImportedBehavior renders one cache behavior, limited to keyPairIDs when its key groups
+// don't hold every imported key pair
+func writeImportedBehavior(b *strings.Builder, name, target string, behavior *cfCacheBehavior, signed bool, keyPairIDs []string) {
+	pattern := behavior.PathPattern
+	if !strings.HasPrefix(pattern, "/") {
+		// CloudFront treats images/* and /images/* alike; request paths here always start with /
+		pattern = "/" + pattern
+	}
+	fmt.Fprintf(b, "  - name: %s\n    target_origin: %s\n    path_patterns: [%s]\n",
+		importedString(name), importedString(target), importedString(pattern))
+
+	if methods := importedMethods(behavior.AllowedMethods.Items); methods != "" {
+		fmt.Fprintf(b, "    allowed_methods: [%s]\n", methods)
+	}
+	switch {
+	case behavior.TrustedKeyGroups.Enabled || behavior.TrustedSigners.Enabled:
+		b.WriteString("    require_signature: true\n")
+		if len(keyPairIDs) > 0 {
+			quoted := make([]string, len(keyPairIDs))
+			for i, id := range keyPairIDs {
+				quoted[i] = importedString(id)
+			}
+			fmt.Fprintf(b, "    trusted_key_pair_ids: [%s]  # From key groups %s\n", strings.Join(quoted, ", "),
+				strings.Join(behavior.TrustedKeyGroups.Items, ", "))
+		}
+	case signed:
+		b.WriteString("    public: true\n")
+	}
+
+	// Cache TTLs come from the cache policy, or the legacy settings without one
+	switch ttls, managed := managedCachePolicyTTLs[behavior.CachePolicyID]; {
+	case behavior.CachePolicyID == cachingDisabledPolicyID:
+	case managed:
+		writeImportedCache(b, ttls[0], ttls[1], ttls[2])
+	case behavior.CachePolicyID != "":
+		fmt.Fprintf(b, "    # Cache policy %s: set cache TTLs to match it\n", behavior.CachePolicyID)
+	case behavior.DefaultTTL != nil && *behavior.DefaultTTL > 0 && behavior.MaxTTL != nil:
+		minTTL := 0
+		if behavior.MinTTL != nil {
+			minTTL = *behavior.MinTTL
+		}
+		writeImportedCache(b, minTTL, *behavior.DefaultTTL, *behavior.MaxTTL)
+	}
+
+	if forwarded := behavior.ForwardedValues; forwarded != nil {
+		if !forwarded.QueryString {
+			b.WriteString("    query_strings: {behavior: none}\n")
+		}
+		switch forwarded.Cookies.Forward {
+		case "none":
+			b.WriteString("    cookies: {behavior: none}\n")
+		case "whitelist":
+			fmt.Fprintf(b, "    cookies: {behavior: whitelist, items: [%s]}\n", quoteAll(forwarded.Cookies.WhitelistedNames.Items))
+		}
+		if len(forwarded.Headers.Items) > 0 {
+			fmt.Fprintf(b, "    # Forwarded headers: %s\n", strings.Join(forwarded.Headers.Items, ", "))
+		}
+	}
+	for _, policy := range []struct{ kind, id string }{
+		{"Origin request policy", behavior.OriginRequestPolicyID},
+		{"Response headers policy", behavior.ResponseHeadersPolicyID},
+		{"Field-level encryption configuration", behavior.FieldLevelEncryptionID},
+	} {
+		if policy.id != "" {
+			fmt.Fprintf(b, "    # %s %s: define it and reference it by name\n", policy.kind, policy.id)
+		}
+	}
+	if behavior.ViewerProtocolPolicy != "" && behavior.ViewerProtocolPolicy != "allow-all" {
+		fmt.Fprintf(b, "    # Viewer protocol policy %s isn't enforced\n", behavior.ViewerProtocolPolicy)
+	}
+	if n := behavior.FunctionAssociations.Quantity + behavior.LambdaFunctionAssociations.Quantity; n > 0 {
+		fmt.Fprintf(b, "    # Edge function associations (%d) aren't emulated\n", n)
+	}
+}
+
+// writeImportedCache renders a behavior's cache TTLs
+func writeImportedCache(b *strings.Builder, minTTL, defaultTTL, maxTTL int) {
+	fmt.Fprintf(b, "    cache:\n      min_ttl_seconds: %d\n      default_ttl_seconds: %d\n      max_ttl_seconds: %d\n", minTTL, defaultTTL, maxTTL)
+}
+
+// importedMethods returns the method set matching CloudFront's allowed methods, or "" for all seven,
+// which is the default
+func importedMethods(methods []string) string {
+	for _, set := range allowedMethodSets[:len(allowedMethodSets)-1] {
+		if len(set) == len(methods) && !slices.ContainsFunc(methods, func(m string) bool { return !slices.Contains(set, m) }) {
+			return strings.Join(set, ", ")
+		}
+	}
+	return ""
+}
+
+// importedString quotes a value for the generated configuration, escaping ${ so it isn't taken for
+// an environment variable reference
+func importedString(s string) string {
+	return strconv.Quote(strings.ReplaceAll(s, "${", "$${"))
+}
+
+// quoteAll quotes each string for a YAML flow sequence
+func quoteAll(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = importedString(item)
+	}
+	return strings.Join(quoted, ", ")
+}
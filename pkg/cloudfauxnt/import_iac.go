@@ -0,0 +1,582 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unresolvedReference is the placeholder for a value a template computes, such as a Terraform
+// reference or a CloudFormation !GetAtt, which only exists once the infrastructure is deployed
+func unresolvedReference(expression string) string {
+	return "<" + expression + ">"
+}
+
+// isUnresolvedReference reports whether a value is an unresolvedReference placeholder
+func isUnresolvedReference(value string) bool {
+	return strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">")
+}
+
+// ImportCloudFormationTemplate converts the AWS::CloudFront::Distribution resource of a
+// CloudFormation template, in YAML or JSON, into a configuration file like ImportDistributionConfig.
+// logicalID selects the resource when the template defines several. Refs to parameters use their
+// defaults; other intrinsic functions are kept as <...> placeholders to fill in.
+func ImportCloudFormationTemplate(data []byte, logicalID string) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse CloudFormation template: %w", err)
+	}
+	if len(root.Content) == 0 {
+		return nil, fmt.Errorf("empty CloudFormation template")
+	}
+	template, _ := cfnValue(root.Content[0], nil).(map[string]any)
+
+	// Parameter defaults resolve Refs, which also lets !Sub and !Join build strings from them
+	params := make(map[string]any)
+	parameters, _ := template["Parameters"].(map[string]any)
+	for name, parameter := range parameters {
+		if parameter, ok := parameter.(map[string]any); ok && parameter["Default"] != nil {
+			params[name] = parameter["Default"]
+		}
+	}
+	template, _ = cfnValue(root.Content[0], params).(map[string]any)
+
+	resources, _ := template["Resources"].(map[string]any)
+	var ids []string
+	for id, resource := range resources {
+		if resource, ok := resource.(map[string]any); ok && resource["Type"] == "AWS::CloudFront::Distribution" {
+			ids = append(ids, id)
+		}
+	}
+	id, err := selectResource("AWS::CloudFront::Distribution", ids, logicalID)
+	if err != nil {
+		return nil, err
+	}
+	resource := resources[id].(map[string]any)
+	properties, _ := resource["Properties"].(map[string]any)
+	config, _ := properties["DistributionConfig"].(map[string]any)
+	if config == nil {
+		return nil, fmt.Errorf("resource %s has no DistributionConfig", id)
+	}
+
+	dc, notes, err := decodeDistributionConfig(cfnDistributionConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	return importDistribution(dc, "CloudFormation resource "+id, notes, nil)
+}
+
+// cfnValue converts a template node to plain values, evaluating Refs to parameters and the string
+// functions !Sub and !Join; other intrinsic functions become placeholders
+func cfnValue(node *yaml.Node, params map[string]any) any {
+	if node.Kind == yaml.AliasNode {
+		return cfnValue(node.Alias, params)
+	}
+	if strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+		untagged := *node
+		untagged.Tag = ""
+		return cfnIntrinsic(strings.TrimPrefix(node.Tag, "!"), cfnValue(&untagged, params), params)
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 2 {
+			if key := node.Content[0].Value; key == "Ref" || strings.HasPrefix(key, "Fn::") {
+				return cfnIntrinsic(strings.TrimPrefix(key, "Fn::"), cfnValue(node.Content[1], params), params)
+			}
+		}
+		object := make(map[string]any)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			object[node.Content[i].Value] = cfnValue(node.Content[i+1], params)
+		}
+		return object
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(node.Content))
+		for _, item := range node.Content {
+			if value := cfnValue(item, params); value != nil {
+				list = append(list, value)
+			}
+		}
+		return list
+	case yaml.ScalarNode:
+		var value any
+		if node.Decode(&value) == nil {
+			return value
+		}
+		return node.Value
+	}
+	return nil
+}
+
+// cfnSubstitution matches the ${Name} and ${!Literal} sequences of !Sub
+var cfnSubstitution = regexp.MustCompile(`\$\{(!?)([^}]*)\}`)
+
+// cfnIntrinsic evaluates an intrinsic function as far as the template alone allows
+func cfnIntrinsic(name string, arg any, params map[string]any) any {
+	switch name {
+	case "Ref":
+		ref := fmt.Sprint(arg)
+		if ref == "AWS::NoValue" {
+			return nil
+		}
+		if value, ok := params[ref]; ok {
+			return value
+		}
+		return unresolvedReference("Ref " + ref)
+	case "Sub":
+		text, variables := arg, map[string]any(nil)
+		if list, ok := arg.([]any); ok && len(list) == 2 {
+			text = list[0]
+			variables, _ = list[1].(map[string]any)
+		}
+		s, ok := text.(string)
+		if !ok {
+			break
+		}
+		resolved := true
+		s = cfnSubstitution.ReplaceAllStringFunc(s, func(ref string) string {
+			match := cfnSubstitution.FindStringSubmatch(ref)
+			if match[1] == "!" {
+				return "${" + match[2] + "}"
+			}
+			for _, values := range []map[string]any{variables, params} {
+				if value, ok := values[match[2]]; ok && !isUnresolvedReference(fmt.Sprint(value)) {
+					return fmt.Sprint(value)
+				}
+			}
+			resolved = false
+			return ref
+		})
+		if resolved {
+			return s
+		}
+		return unresolvedReference("Sub " + s)
+	case "Join":
+		list, ok := arg.([]any)
+		if !ok || len(list) != 2 {
+			break
+		}
+		parts, _ := list[1].([]any)
+		strs := make([]string, len(parts))
+		for i, part := range parts {
+			strs[i] = fmt.Sprint(part)
+			if isUnresolvedReference(strs[i]) {
+				return unresolvedReference("Join " + strings.Join(strs, fmt.Sprint(list[0])))
+			}
+		}
+		return strings.Join(strs, fmt.Sprint(list[0]))
+	case "If":
+		// Conditions aren't evaluated; the value for a true condition is used
+		if list, ok := arg.([]any); ok && len(list) == 3 {
+			return list[1]
+		}
+	case "GetAtt":
+		if list, ok := arg.([]any); ok {
+			strs := make([]string, len(list))
+			for i, part := range list {
+				strs[i] = fmt.Sprint(part)
+			}
+			arg = strings.Join(strs, ".")
+		}
+		return unresolvedReference("GetAtt " + fmt.Sprint(arg))
+	}
+	return unresolvedReference(name)
+}
+
+// cfnDistributionConfig reshapes a template's DistributionConfig into the API's shape, where lists
+// are wrapped in an object with Items
+func cfnDistributionConfig(config map[string]any) map[string]any {
+	config = maps.Clone(config)
+	config["Aliases"] = apiItems(config["Aliases"])
+	var origins []any
+	for _, origin := range objects(config["Origins"]) {
+		origin = maps.Clone(origin)
+		origin["CustomHeaders"] = apiItems(origin["OriginCustomHeaders"])
+		origins = append(origins, origin)
+	}
+	config["Origins"] = apiItems(origins)
+	if behavior, ok := config["DefaultCacheBehavior"].(map[string]any); ok {
+		config["DefaultCacheBehavior"] = cfnCacheBehavior(behavior)
+	}
+	var behaviors []any
+	for _, behavior := range objects(config["CacheBehaviors"]) {
+		behaviors = append(behaviors, cfnCacheBehavior(behavior))
+	}
+	config["CacheBehaviors"] = apiItems(behaviors)
+	var errorResponses []any
+	for _, response := range objects(config["CustomErrorResponses"]) {
+		response = maps.Clone(response)
+		if code, ok := response["ResponseCode"]; ok {
+			response["ResponseCode"] = fmt.Sprint(code)
+		}
+		errorResponses = append(errorResponses, response)
+	}
+	config["CustomErrorResponses"] = apiItems(errorResponses)
+	return config
+}
+
+// cfnCacheBehavior reshapes a template's cache behavior into the API's shape
+func cfnCacheBehavior(behavior map[string]any) map[string]any {
+	behavior = maps.Clone(behavior)
+	behavior["AllowedMethods"] = apiItems(behavior["AllowedMethods"])
+	behavior["TrustedKeyGroups"] = apiTrusted(behavior["TrustedKeyGroups"])
+	behavior["TrustedSigners"] = apiTrusted(behavior["TrustedSigners"])
+	behavior["LambdaFunctionAssociations"] = apiItems(behavior["LambdaFunctionAssociations"])
+	behavior["FunctionAssociations"] = apiItems(behavior["FunctionAssociations"])
+	if forwarded, ok := behavior["ForwardedValues"].(map[string]any); ok {
+		forwarded = maps.Clone(forwarded)
+		forwarded["Headers"] = apiItems(forwarded["Headers"])
+		if cookies, ok := forwarded["Cookies"].(map[string]any); ok {
+			cookies = maps.Clone(cookies)
+			cookies["WhitelistedNames"] = apiItems(cookies["WhitelistedNames"])
+			forwarded["Cookies"] = cookies
+		}
+		behavior["ForwardedValues"] = forwarded
+	}
+	return behavior
+}
+
+// ImportTerraform converts an aws_cloudfront_distribution resource into a configuration file like
+// ImportDistributionConfig. It reads Terraform's native syntax (.tf), its JSON syntax (.tf.json),
+// or the output of `terraform show -json` for a plan or state, whose references are resolved.
+// resource selects the resource by name or address when there are several. Expressions other
+// than literals are kept as <...> placeholders to fill in.
+func ImportTerraform(data []byte, resource string) ([]byte, error) {
+	var root map[string]any
+	var err error
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("{")) {
+		err = json.Unmarshal(trimmed, &root)
+		if _, ok := root["format_version"]; !ok && err == nil {
+			// Strings are templates in the JSON syntax, as in the native syntax
+			root = terraformJSONTemplates(root).(map[string]any)
+		}
+	} else {
+		root, err = parseHCL(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Terraform configuration: %w", err)
+	}
+
+	candidates := make(map[string]map[string]any)
+	if _, ok := root["format_version"]; ok {
+		// terraform show -json: resources of the root and child modules, with values resolved
+		for _, key := range []string{"values", "planned_values"} {
+			if values, ok := root[key].(map[string]any); ok {
+				terraformShowResources(values["root_module"], candidates)
+			}
+		}
+	} else {
+		resource = strings.TrimPrefix(resource, "aws_cloudfront_distribution.")
+		resources, _ := root["resource"].(map[string]any)
+		distributions, _ := resources["aws_cloudfront_distribution"].(map[string]any)
+		for name, body := range distributions {
+			// The JSON syntax allows a list holding one body
+			if blocks := objects(body); len(blocks) > 0 {
+				candidates[name] = blocks[0]
+			}
+		}
+	}
+	name, err := selectResource("aws_cloudfront_distribution", slices.Collect(maps.Keys(candidates)), resource)
+	if err != nil {
+		return nil, err
+	}
+	// Variable defaults resolve plain var.NAME references
+	variables, _ := root["variable"].(map[string]any)
+	body := resolveTerraformVariables(candidates[name], variables).(map[string]any)
+
+	var notes []string
+	if dynamic, ok := body["dynamic"].(map[string]any); ok {
+		notes = append(notes, fmt.Sprintf("Dynamic blocks weren't expanded: %s", strings.Join(slices.Sorted(maps.Keys(dynamic)), ", ")))
+	}
+	dc, unresolved, err := decodeDistributionConfig(terraformDistributionConfig(body))
+	if err != nil {
+		return nil, err
+	}
+	notes = append(notes, unresolved...)
+	if !strings.Contains(name, ".") {
+		name = "aws_cloudfront_distribution." + name
+	}
+	return importDistribution(dc, "Terraform resource "+name, notes, nil)
+}
+
+// resolveTerraformVariables replaces var.NAME placeholders with the defaults of the variables
+func resolveTerraformVariables(value any, variables map[string]any) any {
+	switch value := value.(type) {
+	case string:
+		name, ok := strings.CutPrefix(value, "<var.")
+		if !ok {
+			return value
+		}
+		for _, variable := range objects(variables[strings.TrimSuffix(name, ">")]) {
+			if def, ok := variable["default"]; ok && def != nil {
+				return def
+			}
+		}
+	case map[string]any:
+		resolved := make(map[string]any, len(value))
+		for key, item := range value {
+			resolved[key] = resolveTerraformVariables(item, variables)
+		}
+		return resolved
+	case []any:
+		resolved := make([]any, len(value))
+		for i, item := range value {
+			resolved[i] = resolveTerraformVariables(item, variables)
+		}
+		return resolved
+	}
+	return value
+}
+
+// terraformJSONTemplates replaces the strings of Terraform's JSON syntax that interpolate with placeholders
+func terraformJSONTemplates(value any) any {
+	switch value := value.(type) {
+	case string:
+		if strings.Contains(strings.ReplaceAll(value, "$${", ""), "${") {
+			return templateReference(value)
+		}
+		return strings.ReplaceAll(value, "$${", "${")
+	case map[string]any:
+		for key, item := range value {
+			value[key] = terraformJSONTemplates(item)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = terraformJSONTemplates(item)
+		}
+	}
+	return value
+}
+
+// terraformShowResources collects the distributions of a module in `terraform show -json` output
+// by address, including those of its child modules
+func terraformShowResources(module any, candidates map[string]map[string]any) {
+	m, ok := module.(map[string]any)
+	if !ok {
+		return
+	}
+	for _, resource := range objects(m["resources"]) {
+		if resource["type"] == "aws_cloudfront_distribution" && resource["mode"] != "data" {
+			if values, ok := resource["values"].(map[string]any); ok {
+				candidates[fmt.Sprint(resource["address"])] = values
+			}
+		}
+	}
+	for _, child := range objects(m["child_modules"]) {
+		terraformShowResources(child, candidates)
+	}
+}
+
+// terraformDistributionConfig converts the arguments of an aws_cloudfront_distribution into a
+// DistributionConfig in the API's shape
+func terraformDistributionConfig(body map[string]any) map[string]any {
+	var origins []any
+	for _, origin := range objects(body["origin"]) {
+		var headers []any
+		for _, header := range objects(origin["custom_header"]) {
+			headers = append(headers, map[string]any{"HeaderName": header["name"], "HeaderValue": header["value"]})
+		}
+		converted := map[string]any{
+			"Id":                 origin["origin_id"],
+			"DomainName":         origin["domain_name"],
+			"OriginPath":         origin["origin_path"],
+			"ConnectionAttempts": origin["connection_attempts"],
+			"ConnectionTimeout":  origin["connection_timeout"],
+			"CustomHeaders":      apiItems(headers),
+		}
+		if custom := objects(origin["custom_origin_config"]); len(custom) > 0 {
+			converted["CustomOriginConfig"] = map[string]any{
+				"HTTPPort":               custom[0]["http_port"],
+				"HTTPSPort":              custom[0]["https_port"],
+				"OriginProtocolPolicy":   custom[0]["origin_protocol_policy"],
+				"OriginReadTimeout":      custom[0]["origin_read_timeout"],
+				"OriginKeepaliveTimeout": custom[0]["origin_keepalive_timeout"],
+			}
+		}
+		origins = append(origins, converted)
+	}
+
+	var groups []any
+	for _, group := range objects(body["origin_group"]) {
+		var members []any
+		for _, member := range objects(group["member"]) {
+			members = append(members, map[string]any{"OriginId": member["origin_id"]})
+		}
+		groups = append(groups, map[string]any{"Id": group["origin_id"], "Members": apiItems(members)})
+	}
+
+	config := map[string]any{
+		"Comment":           body["comment"],
+		"Enabled":           body["enabled"],
+		"DefaultRootObject": body["default_root_object"],
+		"Aliases":           apiItems(body["aliases"]),
+		"Origins":           apiItems(origins),
+		"OriginGroups":      apiItems(groups),
+	}
+	if behaviors := objects(body["default_cache_behavior"]); len(behaviors) > 0 {
+		config["DefaultCacheBehavior"] = terraformCacheBehavior(behaviors[0])
+	}
+	var behaviors []any
+	for _, behavior := range objects(body["ordered_cache_behavior"]) {
+		behaviors = append(behaviors, terraformCacheBehavior(behavior))
+	}
+	config["CacheBehaviors"] = apiItems(behaviors)
+	var errorResponses []any
+	for _, response := range objects(body["custom_error_response"]) {
+		converted := map[string]any{
+			"ErrorCode":          response["error_code"],
+			"ResponsePagePath":   response["response_page_path"],
+			"ErrorCachingMinTTL": response["error_caching_min_ttl"],
+		}
+		if code := response["response_code"]; code != nil {
+			converted["ResponseCode"] = fmt.Sprint(code)
+		}
+		errorResponses = append(errorResponses, converted)
+	}
+	config["CustomErrorResponses"] = apiItems(errorResponses)
+	return config
+}
+
+// terraformCacheBehavior converts a default_cache_behavior or ordered_cache_behavior block
+func terraformCacheBehavior(behavior map[string]any) map[string]any {
+	converted := map[string]any{
+		"PathPattern":                behavior["path_pattern"],
+		"TargetOriginId":             behavior["target_origin_id"],
+		"ViewerProtocolPolicy":       behavior["viewer_protocol_policy"],
+		"CachePolicyId":              behavior["cache_policy_id"],
+		"OriginRequestPolicyId":      behavior["origin_request_policy_id"],
+		"ResponseHeadersPolicyId":    behavior["response_headers_policy_id"],
+		"FieldLevelEncryptionId":     behavior["field_level_encryption_id"],
+		"AllowedMethods":             apiItems(behavior["allowed_methods"]),
+		"TrustedKeyGroups":           apiTrusted(behavior["trusted_key_groups"]),
+		"TrustedSigners":             apiTrusted(behavior["trusted_signers"]),
+		"MinTTL":                     behavior["min_ttl"],
+		"DefaultTTL":                 behavior["default_ttl"],
+		"MaxTTL":                     behavior["max_ttl"],
+		"LambdaFunctionAssociations": apiItems(behavior["lambda_function_association"]),
+		"FunctionAssociations":       apiItems(behavior["function_association"]),
+	}
+	if forwarded := objects(behavior["forwarded_values"]); len(forwarded) > 0 {
+		values := map[string]any{
+			"QueryString": forwarded[0]["query_string"],
+			"Headers":     apiItems(forwarded[0]["headers"]),
+		}
+		if cookies := objects(forwarded[0]["cookies"]); len(cookies) > 0 {
+			values["Cookies"] = map[string]any{
+				"Forward":          cookies[0]["forward"],
+				"WhitelistedNames": apiItems(cookies[0]["whitelisted_names"]),
+			}
+		}
+		converted["ForwardedValues"] = values
+	}
+	return converted
+}
+
+// selectResource picks the resource named name, or the only one when name is empty
+func selectResource(kind string, names []string, name string) (string, error) {
+	slices.Sort(names)
+	switch {
+	case len(names) == 0:
+		return "", fmt.Errorf("no %s resource found", kind)
+	case name != "":
+		for _, candidate := range names {
+			// Names match the resource's own name as well as a full address
+			if candidate == name || strings.HasSuffix(candidate, "."+name) {
+				return candidate, nil
+			}
+		}
+		return "", fmt.Errorf("no %s resource named %s (found %s)", kind, name, strings.Join(names, ", "))
+	case len(names) > 1:
+		return "", fmt.Errorf("several %s resources found (%s); choose one by name", kind, strings.Join(names, ", "))
+	}
+	return names[0], nil
+}
+
+// nonStringSettings are the DistributionConfig settings holding numbers or booleans
+var nonStringSettings = []string{
+	"Enabled", "ConnectionAttempts", "ConnectionTimeout", "HTTPPort", "HTTPSPort", "OriginReadTimeout",
+	"OriginKeepaliveTimeout", "MinTTL", "DefaultTTL", "MaxTTL", "QueryString", "ErrorCode", "ErrorCachingMinTTL",
+}
+
+// decodeDistributionConfig decodes a DistributionConfig built in the API's JSON shape. Numbers and
+// booleans the template computes are left unset, with a note for each.
+func decodeDistributionConfig(config map[string]any) (*cfDistributionConfig, []string, error) {
+	var notes []string
+	var unsetUnresolved func(value any)
+	unsetUnresolved = func(value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			for _, key := range nonStringSettings {
+				s, ok := value[key].(string)
+				switch {
+				case !ok || !isUnresolvedReference(s):
+				case key == "Enabled":
+					notes = append(notes, fmt.Sprintf("Enabled is %s in the template and was taken as true", s))
+					value[key] = true
+				default:
+					notes = append(notes, fmt.Sprintf("%s is %s in the template and was left unset", key, s))
+					delete(value, key)
+				}
+			}
+			for _, key := range slices.Sorted(maps.Keys(value)) {
+				unsetUnresolved(value[key])
+			}
+		case []any:
+			for _, item := range value {
+				unsetUnresolved(item)
+			}
+		}
+	}
+	unsetUnresolved(config)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dc cfDistributionConfig
+	if err := json.Unmarshal(data, &dc); err != nil {
+		return nil, nil, fmt.Errorf("unsupported distribution settings: %w", err)
+	}
+	if len(dc.Origins.Items) == 0 {
+		return nil, nil, fmt.Errorf("the distribution has no origins")
+	}
+	return &dc, notes, nil
+}
+
+// apiItems wraps a list the way the API does, with its Quantity
+func apiItems(list any) map[string]any {
+	items, _ := list.([]any)
+	if items == nil {
+		items = []any{}
+	}
+	return map[string]any{"Quantity": len(items), "Items": items}
+}
+
+// apiTrusted converts a list of trusted key groups or signers, enabled when it isn't empty
+func apiTrusted(list any) map[string]any {
+	trusted := apiItems(list)
+	trusted["Enabled"] = trusted["Quantity"].(int) > 0
+	return trusted
+}
+
+// objects returns the maps in a list, or a single map as a list of one
+func objects(value any) []map[string]any {
+	if object, ok := value.(map[string]any); ok {
+		return []map[string]any{object}
+	}
+	var list []map[string]any
+	items, _ := value.([]any)
+	for _, item := range items {
+		if object, ok := item.(map[string]any); ok {
+			list = append(list, object)
+		}
+	}
+	return list
+}
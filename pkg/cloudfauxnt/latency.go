@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// defaultLatencyProfileHeader is the request header that selects a latency profile by default
+const defaultLatencyProfileHeader = "X-CloudFauxnt-Latency-Profile"
+
+// LatencyProfilesConfig holds named viewer latency profiles, simulating how far viewers are from
+// their edge location. Behaviors assign a profile with latency_profile, and a request header can
+// pick another one (or "none") for a single request.
+type LatencyProfilesConfig struct {
+	Header   string           `yaml:"header"` // Request header naming a profile (default: X-CloudFauxnt-Latency-Profile)
+	Profiles []LatencyProfile `yaml:"profiles"`
+}
+
+// LatencyProfile describes a viewer population's network, such as "eu-viewer" or "mobile-3g"
+type LatencyProfile struct {
+	Name                    string `yaml:"name"`
+	BaseLatencyMS           int    `yaml:"base_latency_ms"`            // Fixed delay before the request is handled
+	JitterMS                int    `yaml:"jitter_ms"`                  // Additional random delay of up to this many milliseconds
+	BandwidthBytesPerSecond int    `yaml:"bandwidth_bytes_per_second"` // Optional: throttle response bodies to this rate
+}
+
+// validate checks the latency profiles, filling in defaults
+func (c *LatencyProfilesConfig) validate() error {
+	if c.Header == "" {
+		c.Header = defaultLatencyProfileHeader
+	}
+	names := make(map[string]bool)
+	for i := range c.Profiles {
+		profile := &c.Profiles[i]
+		if profile.Name == "" {
+			return fmt.Errorf("profiles %d: name is required", i)
+		}
+		if profile.Name == "none" {
+			return fmt.Errorf("profile name none is reserved for turning profiles off")
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("profile %s: duplicate name", profile.Name)
+		}
+		names[profile.Name] = true
+		if profile.BaseLatencyMS < 0 || profile.JitterMS < 0 || profile.BandwidthBytesPerSecond < 0 {
+			return fmt.Errorf("profile %s: base_latency_ms, jitter_ms, and bandwidth_bytes_per_second cannot be negative", profile.Name)
+		}
+	}
+	return nil
+}
+
+// find returns the named latency profile, or nil if none is configured
+func (c *LatencyProfilesConfig) find(name string) *LatencyProfile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// latencyProfileFor returns the profile that applies to a request: the one named in the profile
+// header, or else the behavior's. Unknown header values are ignored.
+func (c *Config) latencyProfileFor(r *http.Request, origin *Origin) *LatencyProfile {
+	if name := r.Header.Get(c.LatencyProfiles.Header); name != "" {
+		if name == "none" {
+			return nil
+		}
+		if profile := c.LatencyProfiles.find(name); profile != nil {
+			return profile
+		}
+		slog.Debug("Ignoring unknown latency profile", "profile", name, "path", r.URL.Path)
+	}
+	if origin.LatencyProfile == "" {
+		return nil
+	}
+	return c.LatencyProfiles.find(origin.LatencyProfile)
+}
+
+// applyLatencyProfile delays a request by the profile's latency and jitter. It returns the writer
+// to send the response through, throttled to the profile's bandwidth, or false when the viewer
+// went away while waiting.
+func applyLatencyProfile(w http.ResponseWriter, r *http.Request, profile *LatencyProfile) (http.ResponseWriter, bool) {
+	started := time.Now()
+	delay := time.Duration(profile.BaseLatencyMS) * time.Millisecond
+	if profile.JitterMS > 0 {
+		delay += time.Duration(latencyRandom.IntN(profile.JitterMS+1)) * time.Millisecond
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return nil, false
+		}
+	}
+	ruleTraceFrom(r.Context()).record("latency_profile", started)
+
+	if profile.BandwidthBytesPerSecond > 0 {
+		return &slowBodyWriter{ResponseWriter: w, bytesPerSecond: profile.BandwidthBytesPerSecond, done: r.Context().Done()}, true
+	}
+	return w, true
+}
@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLearnedRoutes bounds how many distinct routes learning mode tracks
+const maxLearnedRoutes = 10000
+
+// LearningConfig records which origins and path prefixes traffic actually uses, so configuration
+// can be suggested from a legacy setup's real requests
+type LearningConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	PathDepth  int    `yaml:"path_depth"`  // Directory levels kept per path prefix (default: 1)
+	OutputFile string `yaml:"output_file"` // Optional: suggested configuration written here on shutdown
+	OutputKey  string `yaml:"output_key"`  // Optional: storage key the suggested configuration is written to on shutdown
+}
+
+// validate checks the learning mode settings, filling in defaults
+func (c *LearningConfig) validate() error {
+	if c.PathDepth == 0 {
+		c.PathDepth = 1
+	}
+	if c.PathDepth < 1 {
+		return fmt.Errorf("path_depth must be at least 1")
+	}
+	return nil
+}
+
+// learnedRoute is a distinct way viewer requests reached an origin
+type learnedRoute struct {
+	ViewerHost   string `json:"viewer_host"`
+	ViewerPrefix string `json:"viewer_prefix"` // Leading directories of the viewer path, "" for the root
+	Origin       string `json:"origin"`        // Scheme and host the request was sent to
+	// UpstreamPrefix is what the viewer prefix was rewritten to; Irregular is set instead when the
+	// upstream path isn't the viewer path with its prefix replaced (path templates, index documents)
+	UpstreamPrefix string `json:"upstream_prefix"`
+	Irregular      bool   `json:"irregular"`
+	Behavior       string `json:"behavior"`
+}
+
+// learnedStats describes the traffic seen on a learned route
+type learnedStats struct {
+	learnedRoute
+	Requests        int            `json:"requests"`
+	Methods         map[string]int `json:"methods"`
+	Statuses        map[int]int    `json:"statuses"`
+	FirstSeen       time.Time      `json:"first_seen"`
+	LastSeen        time.Time      `json:"last_seen"`
+	ExampleViewer   string         `json:"example_viewer_path"`
+	ExampleUpstream string         `json:"example_upstream_path"`
+}
+
+// trafficLearner is a request log sink that records the routes requests took to origins
+type trafficLearner struct {
+	depth      int
+	outputFile string
+	outputKey  string
+	storage    Storage
+
+	mu      sync.Mutex
+	routes  map[learnedRoute]*learnedStats
+	dropped int // Requests on new routes once maxLearnedRoutes was reached
+}
+
+// newTrafficLearner creates a learner for the configured path depth, writing its suggestions to
+// storage on shutdown if an output key is configured
+func newTrafficLearner(config LearningConfig, storage Storage) *trafficLearner {
+	return &trafficLearner{
+		depth: config.PathDepth, outputFile: config.OutputFile, outputKey: config.OutputKey, storage: storage,
+		routes: make(map[learnedRoute]*learnedStats),
+	}
+}
+
+// pathPrefix returns the first depth directories of a path, without a trailing slash
+func pathPrefix(path string, depth int) string {
+	dirs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	dirs = dirs[:len(dirs)-1] // The last element is a file name, or "" for a directory
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	if len(dirs) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(dirs, "/")
+}
+
+// logRequest records the route of a request that was sent to an origin
+func (l *trafficLearner) logRequest(entry *requestLogEntry) {
+	origin, upstreamPath := entry.rules.upstream()
+	if origin == "" {
+		return
+	}
+	viewerPath := entry.request.URL.Path
+	route := learnedRoute{
+		ViewerHost:   entry.request.Host,
+		ViewerPrefix: pathPrefix(viewerPath, l.depth),
+		Origin:       origin,
+		Behavior:     entry.rules.behaviorName(),
+	}
+	if rest := strings.TrimPrefix(viewerPath, route.ViewerPrefix); strings.HasSuffix(upstreamPath, rest) {
+		route.UpstreamPrefix = strings.TrimSuffix(upstreamPath, rest)
+	} else {
+		route.Irregular = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := l.routes[route]
+	if stats == nil {
+		if len(l.routes) >= maxLearnedRoutes {
+			l.dropped++
+			return
+		}
+		stats = &learnedStats{
+			learnedRoute:    route,
+			Methods:         make(map[string]int),
+			Statuses:        make(map[int]int),
+			FirstSeen:       entry.start,
+			ExampleViewer:   viewerPath,
+			ExampleUpstream: upstreamPath,
+		}
+		l.routes[route] = stats
+	}
+	stats.Requests++
+	stats.Methods[entry.request.Method]++
+	stats.Statuses[entry.status]++
+	stats.LastSeen = entry.end
+}
+
+// snapshot returns a copy of the learned routes, busiest first
+func (l *trafficLearner) snapshot() ([]learnedStats, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	routes := make([]learnedStats, 0, len(l.routes))
+	for _, stats := range l.routes {
+		copied := *stats
+		copied.Methods = make(map[string]int, len(stats.Methods))
+		for method, n := range stats.Methods {
+			copied.Methods[method] = n
+		}
+		copied.Statuses = make(map[int]int, len(stats.Statuses))
+		for status, n := range stats.Statuses {
+			copied.Statuses[status] = n
+		}
+		routes = append(routes, copied)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Requests != routes[j].Requests {
+			return routes[i].Requests > routes[j].Requests
+		}
+		return routes[i].ViewerPrefix < routes[j].ViewerPrefix
+	})
+	return routes, l.dropped
+}
+
+// reset forgets everything learned so far
+func (l *trafficLearner) reset() {
+	l.mu.Lock()
+	l.routes = make(map[learnedRoute]*learnedStats)
+	l.dropped = 0
+	l.mu.Unlock()
+}
+
+// nameUnsafe matches the characters replaced when deriving names from hosts and paths
+var nameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// uniqueName derives a name from a host or path that isn't in taken yet, and marks it taken
+func uniqueName(from string, taken map[string]bool) string {
+	base := strings.Trim(nameUnsafe.ReplaceAllString(from, "-"), "-")
+	if base == "" {
+		base = "default"
+	}
+	name := base
+	for n := 2; taken[name]; n++ {
+		name = base + "-" + strconv.Itoa(n)
+	}
+	taken[name] = true
+	return name
+}
+
+// suggestedOrigin is an origin of the suggested configuration
+type suggestedOrigin struct {
+	name         string
+	url          string
+	targetPrefix string
+}
+
+// suggestConfig renders the learned routes as a version 2 configuration to start from. Each viewer
+// prefix becomes a behavior routed the way most of its requests were; other routes seen for the
+// same prefix are listed in comments.
+func (l *trafficLearner) suggestConfig() string {
+	routes, dropped := l.snapshot()
+
+	var origins []*suggestedOrigin
+	originNames := make(map[string]bool)
+	originFor := func(url, targetPrefix string) *suggestedOrigin {
+		for _, o := range origins {
+			if o.url == url && o.targetPrefix == targetPrefix {
+				return o
+			}
+		}
+		host := strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+		o := &suggestedOrigin{name: uniqueName(host, originNames), url: url, targetPrefix: targetPrefix}
+		origins = append(origins, o)
+		return o
+	}
+	behaviorNames := make(map[string]bool)
+
+	var behaviors strings.Builder
+	total := 0
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		total += route.Requests
+		if seen[route.ViewerPrefix] {
+			continue
+		}
+		seen[route.ViewerPrefix] = true
+
+		// Turn the observed rewrite into strip_prefix on the behavior and target_prefix on the origin
+		stripPrefix, targetPrefix := "", ""
+		if !route.Irregular && route.UpstreamPrefix != route.ViewerPrefix {
+			if kept, ok := strings.CutSuffix(route.UpstreamPrefix, route.ViewerPrefix); ok && route.ViewerPrefix != "" {
+				targetPrefix = kept
+			} else {
+				stripPrefix, targetPrefix = route.ViewerPrefix, route.UpstreamPrefix
+			}
+		}
+		origin := originFor(route.Origin, targetPrefix)
+
+		name := uniqueName(route.ViewerPrefix, behaviorNames)
+		fmt.Fprintf(&behaviors, "  - name: %s\n", strconv.Quote(name))
+		fmt.Fprintf(&behaviors, "    target_origin: %s\n", strconv.Quote(origin.name))
+		fmt.Fprintf(&behaviors, "    path_patterns: [%s]\n", strconv.Quote(route.ViewerPrefix+"/*"))
+		if stripPrefix != "" {
+			fmt.Fprintf(&behaviors, "    strip_prefix: %s\n", strconv.Quote(stripPrefix))
+		}
+		fmt.Fprintf(&behaviors, "    # %s\n", describeLearnedRoute(&route))
+		if route.Irregular {
+			fmt.Fprintf(&behaviors, "    # Upstream paths were rewritten beyond a prefix, e.g. %s -> %s\n", route.ExampleViewer, route.ExampleUpstream)
+		}
+		for _, other := range routes {
+			if other.ViewerPrefix == route.ViewerPrefix && other.learnedRoute != route.learnedRoute {
+				fmt.Fprintf(&behaviors, "    # Also seen: host %s to %s%s, %s\n", other.ViewerHost, other.Origin, other.UpstreamPrefix, describeLearnedRoute(&other))
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Suggested by CloudFauxnt learning mode from %d requests on %d routes\n", total, len(routes))
+	if dropped > 0 {
+		fmt.Fprintf(&b, "# %d requests on further routes weren't recorded (limit: %d routes)\n", dropped, maxLearnedRoutes)
+	}
+	b.WriteString("config_version: 2\nserver:\n  port: 8080\norigins:")
+	if len(origins) == 0 {
+		b.WriteString(" []")
+	}
+	b.WriteString("\n")
+	for _, o := range origins {
+		fmt.Fprintf(&b, "  - name: %s\n    url: %s\n", strconv.Quote(o.name), strconv.Quote(o.url))
+		if o.targetPrefix != "" {
+			fmt.Fprintf(&b, "    target_prefix: %s\n", strconv.Quote(o.targetPrefix))
+		}
+	}
+	b.WriteString("behaviors:")
+	if behaviors.Len() == 0 {
+		b.WriteString(" []")
+	}
+	b.WriteString("\n")
+	b.WriteString(behaviors.String())
+	return b.String()
+}
+
+// describeLearnedRoute summarizes a route's traffic for a comment
+func describeLearnedRoute(route *learnedStats) string {
+	methods := make([]string, 0, len(route.Methods))
+	for method, n := range route.Methods {
+		methods = append(methods, fmt.Sprintf("%s %d", method, n))
+	}
+	sort.Strings(methods)
+	statuses := make([]int, 0, len(route.Statuses))
+	for status := range route.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	counts := make([]string, len(statuses))
+	for i, status := range statuses {
+		counts[i] = fmt.Sprintf("%d: %d", status, route.Statuses[status])
+	}
+	requests := "requests"
+	if route.Requests == 1 {
+		requests = "request"
+	}
+	return fmt.Sprintf("%d %s (%s; status %s), last seen %s",
+		route.Requests, requests, strings.Join(methods, ", "), strings.Join(counts, ", "), route.LastSeen.UTC().Format(time.RFC3339))
+}
+
+// Close writes the suggested configuration to the output file and storage key, if configured and
+// storage started
+func (l *trafficLearner) Close() error {
+	if l.outputFile == "" && l.outputKey == "" {
+		return nil
+	}
+	suggested := []byte(l.suggestConfig())
+	if l.outputFile != "" {
+		if err := os.WriteFile(l.outputFile, suggested, 0o644); err != nil {
+			return fmt.Errorf("failed to write learned configuration: %w", err)
+		}
+	}
+	if l.outputKey != "" && l.storage != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := l.storage.Put(ctx, l.outputKey, suggested); err != nil {
+			return fmt.Errorf("failed to store learned configuration: %w", err)
+		}
+	}
+	return nil
+}
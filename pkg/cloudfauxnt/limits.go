@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+)
+
+// CloudFront quotas that can't be raised
+const (
+	maxOriginCustomHeaderName    = 256
+	maxOriginCustomHeaderValue   = 1783
+	maxOriginCustomHeadersLength = 10240
+	maxForwardedNamesPerBehavior = 10 // Whitelisted cookies, query strings, or cache key headers of a cache behavior
+	maxForwardedNamesPerPolicy   = 10 // Headers, cookies, or query strings of an origin request policy
+	maxResponsePolicyHeaders     = 10 // Custom or removed headers of a response headers policy
+	maxOriginRequestPolicies     = 20
+	maxResponseHeadersPolicies   = 20
+	maxStagingWeight             = 0.15 // Share of requests a weight-based continuous deployment policy sends to staging
+)
+
+// Default CloudFront quotas, which AWS raises on request
+const (
+	defaultMaxCacheBehaviors = 75
+	defaultMaxOrigins        = 100
+	defaultMaxAliases        = 100
+)
+
+// AWSLimitsConfig rejects configurations exceeding CloudFront quotas, so local settings stay deployable.
+// Quotas AWS raises on request can be overridden to match an account.
+type AWSLimitsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Optional: path patterns per distribution, not counting the default /* (default: 75)
+	CacheBehaviorsPerDistribution int `yaml:"cache_behaviors_per_distribution"`
+	// Optional: distinct origin URLs and target prefixes per distribution (default: 100)
+	OriginsPerDistribution int `yaml:"origins_per_distribution"`
+	// Optional: aliases per distribution (default: 100)
+	AlternateDomainNamesPerDistribution int `yaml:"alternate_domain_names_per_distribution"`
+}
+
+// validate checks the quota overrides, filling in CloudFront's defaults
+func (l *AWSLimitsConfig) validate() error {
+	if l.CacheBehaviorsPerDistribution < 0 || l.OriginsPerDistribution < 0 || l.AlternateDomainNamesPerDistribution < 0 {
+		return fmt.Errorf("quotas cannot be negative")
+	}
+	if l.CacheBehaviorsPerDistribution == 0 {
+		l.CacheBehaviorsPerDistribution = defaultMaxCacheBehaviors
+	}
+	if l.OriginsPerDistribution == 0 {
+		l.OriginsPerDistribution = defaultMaxOrigins
+	}
+	if l.AlternateDomainNamesPerDistribution == 0 {
+		l.AlternateDomainNamesPerDistribution = defaultMaxAliases
+	}
+	return nil
+}
+
+// checkAWSLimits records every setting that exceeds a CloudFront quota
+func (c *Config) checkAWSLimits(problems *ConfigErrors) {
+	limits := &c.AWSLimits
+	if len(c.OriginRequestPolicies) > maxOriginRequestPolicies {
+		problems.addf("origin_request_policies", "%d policies exceed the CloudFront quota of %d per account", len(c.OriginRequestPolicies), maxOriginRequestPolicies)
+	}
+	for i, policy := range c.OriginRequestPolicies {
+		path := fmt.Sprintf("origin_request_policies[%d]", i)
+		for _, rule := range []struct {
+			field string
+			items []string
+		}{
+			{"headers", policy.Headers.Items},
+			{"cookies", policy.Cookies.Items},
+			{"query_strings", policy.QueryStrings.Items},
+		} {
+			if len(rule.items) > maxForwardedNamesPerPolicy {
+				problems.addf(path+"."+rule.field, "%d items exceed the CloudFront quota of %d", len(rule.items), maxForwardedNamesPerPolicy)
+			}
+		}
+	}
+	if len(c.ResponseHeadersPolicies) > maxResponseHeadersPolicies {
+		problems.addf("response_headers_policies", "%d policies exceed the CloudFront quota of %d per account", len(c.ResponseHeadersPolicies), maxResponseHeadersPolicies)
+	}
+	for i, policy := range c.ResponseHeadersPolicies {
+		path := fmt.Sprintf("response_headers_policies[%d]", i)
+		if len(policy.CustomHeaders) > maxResponsePolicyHeaders {
+			problems.addf(path+".custom_headers", "%d headers exceed the CloudFront quota of %d", len(policy.CustomHeaders), maxResponsePolicyHeaders)
+		}
+		if len(policy.RemoveHeaders) > maxResponsePolicyHeaders {
+			problems.addf(path+".remove_headers", "%d headers exceed the CloudFront quota of %d", len(policy.RemoveHeaders), maxResponsePolicyHeaders)
+		}
+	}
+
+	if len(c.Aliases) > limits.AlternateDomainNamesPerDistribution {
+		problems.addf("aliases", "%d aliases exceed the CloudFront quota of %d per distribution", len(c.Aliases), limits.AlternateDomainNamesPerDistribution)
+	}
+	checkDistributionLimits(problems, limits, "", c.Origins)
+	for i, d := range c.Distributions {
+		scope := fmt.Sprintf("distributions[%d].", i)
+		if len(d.Aliases) > limits.AlternateDomainNamesPerDistribution {
+			problems.addf(scope+"aliases", "%d aliases exceed the CloudFront quota of %d per distribution", len(d.Aliases), limits.AlternateDomainNamesPerDistribution)
+		}
+		checkDistributionLimits(problems, limits, scope, d.Origins)
+	}
+	if deployment := &c.ContinuousDeployment; deployment.Enabled {
+		if deployment.Weight > maxStagingWeight {
+			problems.addf("continuous_deployment.weight", "%g exceeds the CloudFront maximum of %g", deployment.Weight, maxStagingWeight)
+		}
+		checkDistributionLimits(problems, limits, "continuous_deployment.staging.", deployment.Staging.Origins)
+	}
+}
+
+// checkDistributionLimits checks the behaviors and origins of the top level or one distribution
+// against CloudFront's per-distribution quotas
+func checkDistributionLimits(problems *ConfigErrors, limits *AWSLimitsConfig, scope string, origins []Origin) {
+	// Each path pattern is its own cache behavior in CloudFront, apart from the default
+	cacheBehaviors := 0
+	// CloudFront origins are a domain and origin path
+	distinctOrigins := make(map[string]bool)
+	for i := range origins {
+		origin := &origins[i]
+		for _, pattern := range origin.PathPatterns {
+			if pattern != "*" && pattern != "/*" {
+				cacheBehaviors++
+			}
+		}
+		path := fmt.Sprintf("%sbehaviors[%d] (%s)", scope, i, origin.Name)
+		if origin.Cookies != nil && len(origin.Cookies.Items) > maxForwardedNamesPerBehavior {
+			problems.addf(path+".cookies", "%d items exceed the CloudFront quota of %d", len(origin.Cookies.Items), maxForwardedNamesPerBehavior)
+		}
+		if origin.QueryStrings != nil && len(origin.QueryStrings.Items) > maxForwardedNamesPerBehavior {
+			problems.addf(path+".query_strings", "%d items exceed the CloudFront quota of %d", len(origin.QueryStrings.Items), maxForwardedNamesPerBehavior)
+		}
+		if origin.Cache != nil && len(origin.Cache.Headers) > maxForwardedNamesPerBehavior {
+			problems.addf(path+".cache.headers", "%d headers exceed the CloudFront quota of %d", len(origin.Cache.Headers), maxForwardedNamesPerBehavior)
+		}
+		problems.add(path+".custom_headers", checkCustomHeaderLimits(origin.CustomHeaders))
+		distinctOrigins[origin.URL+"\x00"+origin.TargetPrefix] = true
+	}
+
+	if cacheBehaviors > limits.CacheBehaviorsPerDistribution {
+		problems.addf(scope+"behaviors", "%d path patterns exceed the CloudFront quota of %d cache behaviors per distribution", cacheBehaviors, limits.CacheBehaviorsPerDistribution)
+	}
+	if len(distinctOrigins) > limits.OriginsPerDistribution {
+		problems.addf(scope+"origins", "%d origins exceed the CloudFront quota of %d per distribution", len(distinctOrigins), limits.OriginsPerDistribution)
+	}
+}
+
+// checkCustomHeaderLimits checks an origin's custom headers against CloudFront's length quotas;
+// their number is always checked by validateOriginCustomHeaders
+func checkCustomHeaderLimits(headers map[string]string) error {
+	total := 0
+	for name, value := range headers {
+		if len(name) > maxOriginCustomHeaderName {
+			return fmt.Errorf("a header name exceeds the CloudFront quota of %d characters", maxOriginCustomHeaderName)
+		}
+		if len(value) > maxOriginCustomHeaderValue {
+			return fmt.Errorf("value of %s exceeds the CloudFront quota of %d characters", name, maxOriginCustomHeaderValue)
+		}
+		total += len(name) + len(value)
+	}
+	if total > maxOriginCustomHeadersLength {
+		return fmt.Errorf("names and values total %d characters, exceeding the CloudFront quota of %d", total, maxOriginCustomHeadersLength)
+	}
+	return nil
+}
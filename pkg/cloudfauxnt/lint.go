@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lint reports settings that are valid but almost certainly not what was intended, such as path
+// patterns no request can reach. It expects a configuration that passed Validate.
+func (c *Config) Lint() ConfigErrors {
+	var warnings ConfigErrors
+	lintPathPatterns(&warnings, "", c.Origins, c.MatchStrategy)
+	for i, d := range c.Distributions {
+		lintPathPatterns(&warnings, fmt.Sprintf("distributions[%d].", i), d.Origins, d.MatchStrategy)
+	}
+	if staging := c.ContinuousDeployment.Staging; c.ContinuousDeployment.Enabled {
+		lintPathPatterns(&warnings, "continuous_deployment.staging.", staging.Origins, staging.MatchStrategy)
+	}
+	return warnings
+}
+
+// behaviorPattern is one path pattern of a behavior, in the order FindOrigin considers them
+type behaviorPattern struct {
+	behavior int
+	pattern  string
+}
+
+// lintPathPatterns warns about path patterns that never match a request, either because request
+// paths can't look like them or because a pattern of another behavior takes precedence for every
+// path they match under the match strategy
+func lintPathPatterns(warnings *ConfigErrors, scope string, origins []Origin, strategy string) {
+	var patterns []behaviorPattern
+	for i := range origins {
+		for _, pattern := range origins[i].PathPatterns {
+			patterns = append(patterns, behaviorPattern{behavior: i, pattern: pattern})
+		}
+	}
+
+	for j, p := range patterns {
+		path := fmt.Sprintf("%sbehaviors[%d] (%s)", scope, p.behavior, origins[p.behavior].Name)
+		if p.pattern != "*" && !strings.HasPrefix(p.pattern, "/") {
+			warnings.addf(path, "path pattern %q never matches: request paths start with /", p.pattern)
+			continue
+		}
+		for k, q := range patterns {
+			if q.behavior == p.behavior || !patternPrecedes(q.pattern, k, p.pattern, j, strategy) || !patternCovers(q.pattern, p.pattern) {
+				continue
+			}
+			warnings.addf(path, "path pattern %q is unreachable: %q of %sbehaviors[%d] (%s) takes precedence for every path it matches",
+				p.pattern, q.pattern, scope, q.behavior, origins[q.behavior].Name)
+			break
+		}
+	}
+}
+
+// patternPrecedes reports whether pattern q, listed at position k, is tried before pattern p at
+// position j when both match a path
+func patternPrecedes(q string, k int, p string, j int, strategy string) bool {
+	if strategy == "longest" {
+		// The longest matching pattern wins, and the first one listed among equally long ones
+		return len(q) > len(p) || len(q) == len(p) && k < j
+	}
+	// The first one listed wins, with the default patterns after all others
+	if isDefaultPattern(q) != isDefaultPattern(p) {
+		return isDefaultPattern(p)
+	}
+	return k < j
+}
+
+// patternCovers reports whether pattern q matches every path pattern p matches. Patterns with
+// wildcards inside them are only known to cover identical patterns.
+func patternCovers(q, p string) bool {
+	if !strings.ContainsAny(p, "*?") {
+		return matchPath(q, p)
+	}
+	if hasInnerWildcards(q) || !strings.HasSuffix(q, "*") {
+		return q == p
+	}
+	return strings.HasPrefix(patternPrefix(p), patternPrefix(q))
+}
+
+// patternPrefix returns the text every path a wildcard pattern matches starts with
+func patternPrefix(pattern string) string {
+	if !hasInnerWildcards(pattern) && strings.HasSuffix(pattern, "/*") {
+		return strings.TrimSuffix(pattern, "/*")
+	}
+	return pattern[:strings.IndexAny(pattern, "*?")]
+}
@@ -0,0 +1,427 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestLogSink receives one entry per completed viewer request
+type requestLogSink interface {
+	logRequest(entry *requestLogEntry)
+}
+
+// requestLogEntry captures a completed request so log sinks can render the fields they need
+type requestLogEntry struct {
+	request      *http.Request
+	header       http.Header // Response headers
+	status       int         // 0 when the viewer left before the response started
+	bytes        int64
+	aborted      bool // The viewer disconnected before the response was complete
+	start        time.Time
+	firstByte    time.Time
+	lastByte     time.Time
+	end          time.Time
+	bodySHA256   string // Hex digest of the response body, or "" when body hashing is off
+	edgeLocation string
+	rules        *ruleTrace
+}
+
+// hashesResponseBodies reports whether a log wants response body digests, which cost a hash per byte sent
+func (c *Config) hashesResponseBodies() bool {
+	if c.AccessLog.Enabled && c.AccessLog.IncludeBodySHA256 {
+		return true
+	}
+	return c.RealtimeLog.Enabled && slices.Contains(c.RealtimeLog.Fields, "x-cloudfauxnt-body-sha256")
+}
+
+// RequestLogMiddleware records every viewer request (except health and metrics scrapes) and
+// hands it to the log sinks
+func RequestLogMiddleware(config *Config, sinks ...requestLogSink) func(http.Handler) http.Handler {
+	edgeLocation := config.Server.EdgeLocation
+	hashBodies := config.hashesResponseBodies()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHealthPath(r.URL.Path) || (config.Metrics.Enabled && r.URL.Path == config.Metrics.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, rules := withRuleTrace(r.Context())
+			r = r.WithContext(ctx)
+
+			rec := &accessLogRecorder{ResponseWriter: w, status: http.StatusOK}
+			if hashBodies {
+				rec.hash = sha256.New()
+			}
+			start := time.Now().UTC()
+			// Responses aborted mid-stream are logged before the abort carries on to net/http
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					if recovered == http.ErrAbortHandler {
+						logRequestEntry(sinks, rec, r, start, edgeLocation, rules)
+					}
+					panic(recovered)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			logRequestEntry(sinks, rec, r, start, edgeLocation, rules)
+		})
+	}
+}
+
+// logRequestEntry hands a finished (or aborted) request to the log sinks
+func logRequestEntry(sinks []requestLogSink, rec *accessLogRecorder, r *http.Request, start time.Time, edgeLocation string, rules *ruleTrace) {
+	end := time.Now().UTC()
+
+	firstByte := rec.firstByte
+	if firstByte.IsZero() {
+		firstByte = end
+	}
+	lastByte := rec.lastByte
+	if lastByte.IsZero() {
+		lastByte = firstByte
+	}
+	entry := &requestLogEntry{
+		request:      r,
+		header:       rec.Header(),
+		status:       rec.status,
+		bytes:        rec.bytes,
+		start:        start,
+		firstByte:    firstByte,
+		lastByte:     lastByte,
+		end:          end,
+		edgeLocation: edgeLocation,
+		rules:        rules,
+	}
+	// A canceled request context means the viewer's connection closed, which a failed write may
+	// reveal first
+	if rec.writeFailed || r.Context().Err() != nil {
+		entry.aborted = true
+		if !rec.wroteHeader {
+			entry.status = 0
+		}
+	}
+	if rec.hash != nil {
+		entry.bodySHA256 = hex.EncodeToString(rec.hash.Sum(nil))
+	}
+	for _, sink := range sinks {
+		sink.logRequest(entry)
+	}
+}
+
+// accessLogRecorder captures the status, size, first- and last-byte times, and optionally the
+// SHA-256 digest of a response as it streams
+type accessLogRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	firstByte   time.Time
+	lastByte    time.Time
+	hash        hash.Hash // nil when body hashing is off
+	wroteHeader bool
+	writeFailed bool // A write to the viewer failed, so it has gone away
+}
+
+// WriteHeader records the response status
+func (rec *accessLogRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+		rec.firstByte = time.Now().UTC()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts response body bytes
+func (rec *accessLogRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	if err != nil {
+		rec.writeFailed = true
+	}
+	if n > 0 {
+		rec.lastByte = time.Now().UTC()
+		if rec.hash != nil {
+			rec.hash.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (rec *accessLogRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// knownLogFields lists every field name field() can render, covering both the standard
+// log names (e.g. cs(Host)) and the real-time log names (e.g. cs-host)
+var knownLogFields = map[string]bool{
+	"date": true, "time": true, "timestamp": true, "x-edge-location": true, "sc-bytes": true,
+	"c-ip": true, "c-ip-version": true, "c-port": true, "cs-method": true, "cs(Host)": true,
+	"cs-host": true, "cs-uri-stem": true, "sc-status": true, "cs(Referer)": true, "cs-referer": true,
+	"cs(User-Agent)": true, "cs-user-agent": true, "cs-uri-query": true, "cs(Cookie)": true,
+	"cs-cookie": true, "x-edge-result-type": true, "x-edge-response-result-type": true,
+	"x-edge-detailed-result-type": true, "x-edge-request-id": true, "x-host-header": true,
+	"cs-protocol": true, "cs-protocol-version": true, "cs-bytes": true, "time-taken": true,
+	"time-to-first-byte": true, "x-forwarded-for": true, "ssl-protocol": true, "ssl-cipher": true,
+	"fle-status": true, "fle-encrypted-fields": true, "sc-content-type": true, "sc-content-len": true,
+	"sc-range-start": true, "sc-range-end": true, "cs-accept": true, "cs-accept-encoding": true,
+	"cs-header-names": true, "cs-headers-count": true, "x-cloudfauxnt-rules": true,
+	"x-cloudfauxnt-trace-id": true, "x-cloudfauxnt-body-bytes": true, "x-cloudfauxnt-time-to-last-byte": true,
+	"x-cloudfauxnt-body-sha256": true, "x-cloudfauxnt-key-pair-id": true, "x-cloudfauxnt-signature-expires": true,
+	"x-cloudfauxnt-signature-status": true,
+}
+
+// field renders a single log field; unknown or empty values are returned as ""
+func (e *requestLogEntry) field(name string) string {
+	r := e.request
+	switch name {
+	case "date":
+		return e.start.Format("2006-01-02")
+	case "time":
+		return e.start.Format("15:04:05")
+	case "timestamp":
+		return fmt.Sprintf("%d.%03d", e.start.Unix(), e.start.Nanosecond()/int(time.Millisecond))
+	case "x-edge-location":
+		return e.edgeLocation
+	case "sc-bytes":
+		return strconv.FormatInt(e.bytes, 10)
+	case "c-ip":
+		ip, _ := e.clientAddr()
+		return ip
+	case "c-ip-version":
+		ip, _ := e.clientAddr()
+		if strings.Contains(ip, ":") {
+			return "IPv6"
+		}
+		return "IPv4"
+	case "c-port":
+		_, port := e.clientAddr()
+		return port
+	case "cs-method":
+		return r.Method
+	case "cs(Host)", "cs-host", "x-host-header":
+		return r.Host
+	case "cs-uri-stem":
+		return r.URL.EscapedPath()
+	case "sc-status":
+		return e.statusCode()
+	case "cs(Referer)", "cs-referer":
+		return r.Header.Get("Referer")
+	case "cs(User-Agent)", "cs-user-agent":
+		return r.Header.Get("User-Agent")
+	case "cs-uri-query":
+		return r.URL.RawQuery
+	case "cs(Cookie)", "cs-cookie":
+		return r.Header.Get("Cookie")
+	case "x-edge-result-type", "x-edge-response-result-type":
+		return e.resultType()
+	case "x-edge-detailed-result-type":
+		if e.aborted {
+			return "ClientCommError"
+		}
+		return e.resultType()
+	case "x-edge-request-id":
+		return e.header.Get("X-Amz-Cf-Id")
+	case "cs-protocol":
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	case "cs-protocol-version":
+		return r.Proto
+	case "cs-bytes":
+		return strconv.FormatInt(requestSize(r), 10)
+	case "time-taken":
+		return formatSeconds(e.end.Sub(e.start))
+	case "time-to-first-byte":
+		return formatSeconds(e.firstByte.Sub(e.start))
+	case "x-forwarded-for":
+		return r.Header.Get("X-Forwarded-For")
+	case "ssl-protocol":
+		if r.TLS != nil {
+			return tlsVersionName(r.TLS.Version)
+		}
+	case "ssl-cipher":
+		if r.TLS != nil {
+			return tls.CipherSuiteName(r.TLS.CipherSuite)
+		}
+	case "sc-content-type":
+		return e.header.Get("Content-Type")
+	case "sc-content-len":
+		return e.header.Get("Content-Length")
+	case "sc-range-start":
+		start, _ := contentRangeBounds(e.header.Get("Content-Range"))
+		return start
+	case "sc-range-end":
+		_, end := contentRangeBounds(e.header.Get("Content-Range"))
+		return end
+	case "cs-accept":
+		return r.Header.Get("Accept")
+	case "cs-accept-encoding":
+		return r.Header.Get("Accept-Encoding")
+	case "cs-header-names":
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		return strings.Join(names, "\n")
+	case "cs-headers-count":
+		return strconv.Itoa(len(r.Header))
+	case "fle-status":
+		status, _ := e.rules.fieldLevelEncryption()
+		return status
+	case "fle-encrypted-fields":
+		if status, fields := e.rules.fieldLevelEncryption(); status != "" {
+			return strconv.Itoa(fields)
+		}
+	case "x-cloudfauxnt-rules":
+		return e.rules.String()
+	case "x-cloudfauxnt-trace-id":
+		return traceID(r)
+	case "x-cloudfauxnt-body-bytes":
+		return strconv.FormatInt(e.bytes, 10)
+	case "x-cloudfauxnt-time-to-last-byte":
+		return formatSeconds(e.lastByte.Sub(e.start))
+	case "x-cloudfauxnt-body-sha256":
+		return e.bodySHA256
+	case "x-cloudfauxnt-key-pair-id":
+		return e.rules.signatureOutcome().keyPairID
+	case "x-cloudfauxnt-signature-expires":
+		if expires := e.rules.signatureOutcome().expires; expires != 0 {
+			return strconv.FormatInt(expires, 10)
+		}
+	case "x-cloudfauxnt-signature-status":
+		return e.rules.signatureOutcome().status
+	}
+	return ""
+}
+
+// clientAddr returns the viewer IP chosen by the client IP policy, and the port when the viewer
+// connected directly
+func (e *requestLogEntry) clientAddr() (string, string) {
+	ip := clientIP(e.request)
+	remote, port, err := net.SplitHostPort(e.request.RemoteAddr)
+	if err != nil || remote != ip {
+		return ip, ""
+	}
+	return ip, port
+}
+
+// statusCode renders the response status, which CloudFront logs as 000 when the viewer closed
+// the connection before it was sent
+func (e *requestLogEntry) statusCode() string {
+	if e.status == 0 {
+		return "000"
+	}
+	return strconv.Itoa(e.status)
+}
+
+// resultType is the request's x-edge-result-type, which is Error for viewers that disconnected
+func (e *requestLogEntry) resultType() string {
+	if e.aborted {
+		return "Error"
+	}
+	return edgeResultType(e.status, e.header.Get("X-Cache"))
+}
+
+// shieldResult splits the origin shield result of an edge miss, e.g. Hit and us-east-1, returning
+// empty strings when no shield was consulted
+func (e *requestLogEntry) shieldResult() (result, region string) {
+	result, region, _ = strings.Cut(e.header.Get(originShieldHeader), " from ")
+	return result, region
+}
+
+// edgeResultType maps a response to CloudFront's x-edge-result-type values
+func edgeResultType(status int, xCache string) string {
+	switch {
+	case status >= 400:
+		return "Error"
+	case strings.HasPrefix(xCache, "RefreshHit"):
+		return "RefreshHit"
+	case strings.HasPrefix(xCache, "Hit"):
+		return "Hit"
+	default:
+		return "Miss"
+	}
+}
+
+// requestSize approximates cs-bytes: request line, headers, and body
+func requestSize(r *http.Request) int64 {
+	size := int64(len(r.Method) + len(r.URL.RequestURI()) + len(r.Proto) + 4)
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += int64(len(name) + len(value) + 4)
+		}
+	}
+	if r.ContentLength > 0 {
+		size += r.ContentLength
+	}
+	return size
+}
+
+// contentRangeBounds extracts the start and end from a "bytes start-end/total" Content-Range
+func contentRangeBounds(contentRange string) (string, string) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return "", ""
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	start, end, ok := strings.Cut(spec, "-")
+	if !ok {
+		return "", ""
+	}
+	return start, end
+}
+
+// tlsVersionName returns the TLS version in CloudFront's log notation (e.g. TLSv1.2)
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return ""
+}
+
+// formatSeconds formats a duration as seconds with millisecond precision
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// logEscape URL-encodes characters that would break the tab-separated format and
+// replaces empty values with "-", as CloudFront does
+func logEscape(value string) string {
+	if value == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// NewLogger builds the application logger from the logging configuration
+func NewLogger(config LoggingConfig) *slog.Logger {
+	options := &slog.HandlerOptions{Level: parseLogLevel(config.Level)}
+	if config.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, options))
+}
+
+// parseLogLevel maps a configured level name to a slog level, defaulting to info
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// audit records a security-relevant event (admin changes, bypass token use) in the audit log,
+// which is the application log tagged with log=audit
+func audit(msg string, args ...any) {
+	slog.Default().With("log", "audit").Info(msg, args...)
+}
+
+// requestLogger writes one structured application log line per viewer request
+type requestLogger struct {
+	logger *slog.Logger
+}
+
+// logRequest logs the request ID, client IP, matched origin, status, latency, and cache result,
+// plus the trace ID when the viewer sent a traceparent header and "client aborted" when the
+// viewer disconnected before the response was complete
+func (rl *requestLogger) logRequest(entry *requestLogEntry) {
+	logger := rl.logger
+	if id := traceID(entry.request); id != "" {
+		logger = logger.With("trace_id", id)
+	}
+	if entry.aborted {
+		logger = logger.With("error", "client aborted")
+	}
+	if variant := entry.header.Get(deploymentHeader); variant != "" {
+		logger = logger.With("deployment", variant)
+	}
+	if result, region := entry.shieldResult(); result != "" {
+		logger = logger.With("origin_shield", result, "shield_region", region)
+	}
+	logger.Info("request",
+		"request_id", entry.header.Get("X-Amz-Cf-Id"),
+		"client", clientIP(entry.request),
+		"method", entry.request.Method,
+		"path", entry.request.URL.Path,
+		"origin", entry.rules.behaviorName(),
+		"status", entry.status,
+		"latency_ms", float64(entry.end.Sub(entry.start).Microseconds())/1000,
+		"cache", entry.resultType(),
+		"bytes", entry.bytes,
+	)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// viaPseudonym identifies CloudFauxnt in Via headers so that loops can be detected
+const viaPseudonym = "cloudfauxnt"
+
+// ErrRedirectLoop is returned when an origin redirects a request back to itself
+var ErrRedirectLoop = errors.New("origin redirected the request back to the same URL")
+
+// viaHopCount returns how many CloudFauxnt hops a request has already passed through
+func viaHopCount(h http.Header) int {
+	count := 0
+	for _, value := range h.Values("Via") {
+		for _, hop := range strings.Split(value, ",") {
+			fields := strings.Fields(hop)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], viaPseudonym) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// appendVia adds CloudFauxnt to the viewer's Via chain instead of replacing previous hops.
+// The viewer headers are used so hops survive origin request policies that drop Via.
+func appendVia(h, viewer http.Header) {
+	hop := "1.1 " + viaPseudonym
+	if prior := strings.Join(viewer.Values("Via"), ", "); prior != "" {
+		hop = prior + ", " + hop
+	}
+	h.Set("Via", hop)
+}
+
+// isRedirectLoop reports whether a redirect response points back at the URL that produced it,
+// either as seen by the viewer or as requested from the origin
+func isRedirectLoop(resp *http.Response, viewer *http.Request) bool {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return false
+	}
+
+	// Compare against the URL the origin was asked for
+	if resp.Request != nil && resp.Request.URL != nil {
+		if target, err := resp.Request.URL.Parse(location); err == nil && sameURL(target, resp.Request.URL) {
+			return true
+		}
+	}
+
+	// Compare against the URL the viewer asked for
+	scheme := "http"
+	if viewer.TLS != nil {
+		scheme = "https"
+	}
+	viewerURL := &url.URL{Scheme: scheme, Host: viewer.Host, Path: viewer.URL.Path, RawQuery: viewer.URL.RawQuery}
+	if target, err := viewerURL.Parse(location); err == nil && sameURL(target, viewerURL) {
+		return true
+	}
+
+	return false
+}
+
+// sameURL compares two absolute URLs ignoring fragments and host case
+func sameURL(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Host, b.Host) &&
+		a.EscapedPath() == b.EscapedPath() &&
+		a.RawQuery == b.RawQuery
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cloudFrontMethods are the only methods CloudFront accepts from viewers, on any behavior
+var cloudFrontMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true, http.MethodPut: true,
+	http.MethodPost: true, http.MethodPatch: true, http.MethodDelete: true,
+}
+
+// allowedMethodSets are the method combinations a CloudFront cache behavior can allow
+var allowedMethodSets = [][]string{
+	{http.MethodGet, http.MethodHead},
+	{http.MethodGet, http.MethodHead, http.MethodOptions},
+	{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete},
+}
+
+// validateAllowedMethods uppercases an allowed_methods list and checks it is one of the sets CloudFront offers
+func validateAllowedMethods(methods []string) error {
+	if len(methods) == 0 {
+		return nil
+	}
+	listed := make(map[string]bool)
+	for i, method := range methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(method))
+		listed[methods[i]] = true
+	}
+	for _, set := range allowedMethodSets {
+		if len(set) != len(listed) {
+			continue
+		}
+		matches := true
+		for _, method := range set {
+			matches = matches && listed[method]
+		}
+		if matches {
+			return nil
+		}
+	}
+	return fmt.Errorf("allowed_methods must be [GET, HEAD], [GET, HEAD, OPTIONS], or [GET, HEAD, OPTIONS, PUT, POST, PATCH, DELETE]")
+}
+
+// allowsMethod reports whether an origin's allowed_methods include method; an empty list allows all
+func (o *Origin) allowsMethod(method string) bool {
+	if len(o.AllowedMethods) == 0 {
+		return true
+	}
+	for _, allowed := range o.AllowedMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// methodNotAllowedReason is CloudFront's explanation for requests using an unsupported method
+	methodNotAllowedReason = "This distribution is not configured to allow the HTTP request method that was used for this request. The distribution supports only cachable requests."
+	// bodyTooLargeReason is CloudFront's explanation for request bodies over the allowed size
+	bodyTooLargeReason = "Your request body is too large for this distribution."
+	// badRequestReason is CloudFront's explanation for malformed requests
+	badRequestReason = "Bad request."
+)
+
+// cloudFrontHTMLErrorPage mirrors the HTML page CloudFront's edge returns for requests it rejects
+// itself, before any origin is contacted
+const cloudFrontHTMLErrorPage = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 4.01 Transitional//EN" "http://www.w3.org/TR/html4/loose.dtd">
+<HTML><HEAD><META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=iso-8859-1">
+<TITLE>ERROR: The request could not be satisfied</TITLE>
+</HEAD><BODY>
+<H1>%d ERROR</H1>
+<H2>The request could not be satisfied.</H2>
+<HR noshade size="1px">
+%s
+We can't connect to the server for this app or website at this time. There might be too much traffic or a configuration error. Try again later, or contact the app or website owner.
+<BR clear="all">
+If you provide content to customers through CloudFront, you can find steps to troubleshoot and help prevent this error by reviewing the CloudFront documentation.
+<BR clear="all">
+<HR noshade size="1px">
+<PRE>
+Generated by cloudfront (CloudFront)
+Request ID: %s
+</PRE>
+<ADDRESS>
+</ADDRESS>
+</BODY></HTML>`
+
+// writeEdgeError writes CloudFront's HTML error page for a request rejected at the edge and
+// returns the request ID it reported
+func writeEdgeError(w http.ResponseWriter, status int, reason string) string {
+	requestID := generateCloudFrontID()
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("X-Amz-Cf-Id", requestID)
+	w.Header().Set("Server", "CloudFauxnt")
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	w.WriteHeader(status)
+	io.WriteString(w, fmt.Sprintf(cloudFrontHTMLErrorPage, status, reason, requestID))
+	return requestID
+}
@@ -0,0 +1,362 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBuckets are the histogram buckets (in seconds) used for request latency
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics collects CloudFauxnt metrics and exposes them in the Prometheus text format
+type Metrics struct {
+	requests          *counterVec
+	requestDuration   *histogramVec
+	cacheResults      *counterVec
+	originErrors      *counterVec
+	signatureFailures *counterVec
+	panics            *counterVec
+	requestIDEchoes   *counterVec
+	wafMatches        *counterVec
+	rateLimits        *counterVec
+	clientAborts      *counterVec
+	clientAbortBytes  *counterVec
+	shieldResults     *counterVec
+	deployments       *counterVec
+}
+
+// NewMetrics creates the metric set
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("cloudfauxnt_requests_total",
+			"Viewer requests by matched origin, method, and status code.", "origin", "method", "status"),
+		requestDuration: newHistogramVec("cloudfauxnt_request_duration_seconds",
+			"Viewer request latency by matched origin.", defaultLatencyBuckets, "origin"),
+		cacheResults: newCounterVec("cloudfauxnt_cache_results_total",
+			"Viewer requests by edge result type (Hit, RefreshHit, Miss, Error).", "result"),
+		originErrors: newCounterVec("cloudfauxnt_origin_errors_total",
+			"Origin failures by origin and reason (connection, 5xx).", "origin", "reason"),
+		signatureFailures: newCounterVec("cloudfauxnt_signature_failures_total",
+			"Requests rejected by CloudFront signature validation, by origin and reason.", "origin", "reason"),
+		panics: newCounterVec("cloudfauxnt_panics_total",
+			"Handler panics recovered and answered with a 503, by origin.", "origin"),
+		requestIDEchoes: newCounterVec("cloudfauxnt_origin_request_id_echo_failures_total",
+			"Origin responses that didn't echo the request ID, by origin and reason (missing, mismatch).", "origin", "reason"),
+		wafMatches: newCounterVec("cloudfauxnt_waf_matches_total",
+			"Requests matched by WAF rules, by rule and action (block, allow, count).", "rule", "action"),
+		rateLimits: newCounterVec("cloudfauxnt_rate_limited_total",
+			"Requests answered with 429 by the rate limiter, by the key the viewer was identified by (client_ip, signed_cookie).", "key"),
+		clientAborts: newCounterVec("cloudfauxnt_client_aborted_total",
+			"Requests whose viewer disconnected before the response was complete, by origin.", "origin"),
+		clientAbortBytes: newCounterVec("cloudfauxnt_client_aborted_bytes_total",
+			"Response body bytes sent to viewers before they disconnected, by origin.", "origin"),
+		shieldResults: newCounterVec("cloudfauxnt_origin_shield_results_total",
+			"Edge cache misses by origin, origin shield region, and shield result (Hit, Miss); shield misses are the requests that reach the origin.", "origin", "region", "result"),
+		deployments: newCounterVec("cloudfauxnt_continuous_deployment_requests_total",
+			"Requests of the top-level distribution by the continuous deployment variant serving them (primary, staging) and status code.", "variant", "status"),
+	}
+}
+
+// logRequest records request, latency, and cache metrics for a completed request
+func (m *Metrics) logRequest(entry *requestLogEntry) {
+	origin := entry.rules.behaviorName()
+	m.requests.inc(origin, entry.request.Method, entry.statusCode())
+	m.requestDuration.observeWithExemplar(entry.end.Sub(entry.start).Seconds(), exemplar{
+		requestID: entry.header.Get("X-Amz-Cf-Id"),
+		traceID:   traceID(entry.request),
+		timestamp: entry.end,
+	}, origin)
+	m.cacheResults.inc(entry.resultType())
+	if entry.aborted {
+		m.clientAborts.inc(origin)
+		m.clientAbortBytes.add(float64(entry.bytes), origin)
+	}
+	if result, region := entry.shieldResult(); result != "" {
+		m.shieldResults.inc(origin, region, result)
+	}
+	if variant := entry.header.Get(deploymentHeader); variant != "" {
+		m.deployments.inc(variant, entry.statusCode())
+	}
+	if origin != "" && entry.rules.originResponseStatus() >= 500 {
+		m.originErrors.inc(origin, "5xx")
+	}
+}
+
+// originConnectionFailed records a request that couldn't reach its origin
+func (m *Metrics) originConnectionFailed(origin string) {
+	if m == nil {
+		return
+	}
+	m.originErrors.inc(origin, "connection")
+}
+
+// signatureFailed records a request rejected by signature validation
+func (m *Metrics) signatureFailed(origin, reason string) {
+	if m == nil {
+		return
+	}
+	m.signatureFailures.inc(origin, reason)
+}
+
+// panicked records a recovered handler panic
+func (m *Metrics) panicked(origin string) {
+	if m == nil {
+		return
+	}
+	m.panics.inc(origin)
+}
+
+// requestIDEchoFailed records an origin response that didn't echo the request ID it was sent
+func (m *Metrics) requestIDEchoFailed(origin, reason string) {
+	if m == nil {
+		return
+	}
+	m.requestIDEchoes.inc(origin, reason)
+}
+
+// wafMatched records a request matched by a WAF rule
+func (m *Metrics) wafMatched(rule, action string) {
+	if m == nil {
+		return
+	}
+	m.wafMatches.inc(rule, action)
+}
+
+// rateLimited records a request turned away by the rate limiter
+func (m *Metrics) rateLimited(key string) {
+	if m == nil {
+		return
+	}
+	m.rateLimits.inc(key)
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format, or in OpenMetrics
+// (which carries latency exemplars) when the scraper asks for it
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	m.requests.write(w, openMetrics)
+	m.requestDuration.write(w, openMetrics)
+	m.cacheResults.write(w, openMetrics)
+	m.originErrors.write(w, openMetrics)
+	m.signatureFailures.write(w, openMetrics)
+	m.panics.write(w, openMetrics)
+	m.requestIDEchoes.write(w, openMetrics)
+	m.wafMatches.write(w, openMetrics)
+	m.rateLimits.write(w, openMetrics)
+	m.clientAborts.write(w, openMetrics)
+	m.clientAbortBytes.write(w, openMetrics)
+	m.shieldResults.write(w, openMetrics)
+	m.deployments.write(w, openMetrics)
+	if openMetrics {
+		io.WriteString(w, "# EOF\n")
+	}
+}
+
+// counterVec is a counter partitioned by label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newCounterVec creates a labeled counter
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// inc adds one to the counter for the given label values
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// add adds delta to the counter for the given label values
+func (c *counterVec) add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// write renders the counter; OpenMetrics names the metric family without the _total suffix
+func (c *counterVec) write(w io.Writer, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	family := c.name
+	if openMetrics {
+		family = strings.TrimSuffix(family, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", family, c.help, family)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, "", ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+// histogramValue holds the observations for one label combination
+type histogramValue struct {
+	counts    []uint64   // Per bucket, non-cumulative
+	exemplars []exemplar // Latest exemplar per bucket, with +Inf last
+	count     uint64
+	sum       float64
+}
+
+// exemplar links an observation to the request (and trace, if any) that produced it
+type exemplar struct {
+	requestID string
+	traceID   string
+	value     float64
+	timestamp time.Time
+}
+
+// String renders the exemplar in OpenMetrics form: # {labels} value timestamp
+func (e exemplar) String() string {
+	labels := []string{`request_id="` + escapeLabelValue(e.requestID) + `"`}
+	if e.traceID != "" {
+		labels = append(labels, `trace_id="`+escapeLabelValue(e.traceID)+`"`)
+	}
+	return fmt.Sprintf(" # {%s} %s %.3f", strings.Join(labels, ","), formatFloat(e.value),
+		float64(e.timestamp.UnixMilli())/1000)
+}
+
+// newHistogramVec creates a labeled histogram with the given upper bounds
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+}
+
+// observe records a value for the given label values
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.observeWithExemplar(value, exemplar{}, labelValues...)
+}
+
+// observeWithExemplar records a value and, when it names a request, keeps it as the exemplar
+// of the bucket the value falls in
+func (h *histogramVec) observeWithExemplar(value float64, ex exemplar, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			counts:    make([]uint64, len(h.buckets)),
+			exemplars: make([]exemplar, len(h.buckets)+1),
+		}
+		h.values[key] = hv
+	}
+	bucket := len(h.buckets)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hv.counts[i]++
+			bucket = i
+			break
+		}
+	}
+	if ex.requestID != "" {
+		ex.value = value
+		hv.exemplars[bucket] = ex
+	}
+	hv.count++
+	hv.sum += value
+}
+
+// write renders the histogram with cumulative buckets, attaching exemplars in OpenMetrics
+func (h *histogramVec) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hv := h.values[key]
+		exemplarFor := func(bucket int) string {
+			if !openMetrics || hv.exemplars[bucket].requestID == "" {
+				return ""
+			}
+			return hv.exemplars[bucket].String()
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d%s\n", h.name, formatLabels(h.labels, key, "le", formatFloat(bound)), cumulative, exemplarFor(i))
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d%s\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), hv.count, exemplarFor(len(h.buckets)))
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, "", ""), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, "", ""), hv.count)
+	}
+}
+
+// formatLabels renders {name="value",...} for a joined label key, plus an optional extra label
+func formatLabels(names []string, key, extraName, extraValue string) string {
+	var pairs []string
+	if len(names) > 0 {
+		values := strings.Split(key, "\xff")
+		for i, name := range names {
+			pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelValueEscaper escapes label values per the Prometheus text format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value for the Prometheus text format
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// formatFloat renders a sample value the way Prometheus clients do
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns map keys in a stable order
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
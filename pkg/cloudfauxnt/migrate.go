@@ -0,0 +1,298 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion is the config_version written by `cloudfauxnt config migrate`.
+// Files without a config_version are version 1.
+const CurrentConfigVersion = 2
+
+// configMigrations upgrade a configuration document one version at a time; entry i turns
+// version i+1 into version i+2. They work on the YAML node tree so comments survive a rewrite.
+var configMigrations = []func(root *yaml.Node) error{
+	migrateFlatOriginsToBehaviors,
+}
+
+// behaviorKeys are the settings that describe how requests are matched and handled (cache behaviors)
+// rather than where they are sent (origins). Version 1 files mix both on each origin.
+var behaviorKeys = []string{
+	"path_patterns", "strip_prefix", "require_signature", "default_root_object", "index_document",
+	"response_headers_policy", "origin_request_policy", "forward_non_standard_methods",
+	"allowed_methods", "max_body_bytes", "max_response_bytes", "public", "faults", "post_dedupe_window_seconds", "cache",
+	"response_cookies", "query_strings", "cookies", "field_level_encryption", "path_template",
+	"latency_profile", "signing_scopes", "trusted_key_pair_ids", "tee",
+}
+
+// MigrateConfig upgrades a configuration file to the current config_version, preserving comments.
+// It returns the rewritten YAML and the version the file started at.
+func MigrateConfig(data []byte) ([]byte, int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse config YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, 0, fmt.Errorf("configuration file is empty")
+	}
+	version, err := upgradeConfigDocument(doc.Content[0])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, 0, fmt.Errorf("failed to write migrated configuration: %w", err)
+	}
+	return out.Bytes(), version, nil
+}
+
+// decodeConfig decodes a configuration file of any supported version into a Config, returning
+// the version the file was written in and any keys no setting reads. Unknown keys are an error
+// unless the file sets strict_config: false.
+func decodeConfig(data []byte, config *Config) (int, []unknownField, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse config YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		// An empty file (or none) is a current-version document, which environment variables may fill in
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "config_version"},
+			{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentConfigVersion)},
+		}}}
+	}
+	root := doc.Content[0]
+	if err := expandEnv(root); err != nil {
+		return 0, nil, err
+	}
+	version, err := upgradeConfigDocument(root)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := applyEnvOverrides(root); err != nil {
+		return 0, nil, err
+	}
+	unknown := unknownFields(root)
+	if err := resolveBehaviors(root); err != nil {
+		return 0, nil, err
+	}
+	if err := root.Decode(config); err != nil {
+		return 0, nil, fmt.Errorf("failed to parse config YAML: %w", err)
+	}
+
+	if len(unknown) > 0 && config.strict() {
+		descriptions := make([]string, len(unknown))
+		for i, field := range unknown {
+			descriptions[i] = field.String()
+		}
+		return 0, nil, fmt.Errorf("%s (set strict_config: false to ignore unknown keys)", strings.Join(descriptions, "; "))
+	}
+	return version, unknown, nil
+}
+
+// upgradeConfigDocument applies every migration newer than the document's config_version and
+// returns the version it started at
+func upgradeConfigDocument(root *yaml.Node) (int, error) {
+	if root.Kind != yaml.MappingNode {
+		return 0, fmt.Errorf("configuration must be a YAML mapping")
+	}
+	version := 1
+	if node := mappingValue(root, "config_version"); node != nil {
+		parsed, err := strconv.Atoi(node.Value)
+		if err != nil || parsed < 1 {
+			return 0, fmt.Errorf("invalid config_version %q", node.Value)
+		}
+		version = parsed
+	}
+	if version > CurrentConfigVersion {
+		return 0, fmt.Errorf("config_version %d is newer than this CloudFauxnt supports (%d)", version, CurrentConfigVersion)
+	}
+
+	for v := version; v < CurrentConfigVersion; v++ {
+		if err := configMigrations[v-1](root); err != nil {
+			return 0, fmt.Errorf("failed to migrate configuration from version %d: %w", v, err)
+		}
+	}
+	versionNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentConfigVersion)}
+	if mappingValue(root, "config_version") != nil {
+		setMappingValue(root, "config_version", versionNode)
+	} else {
+		root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: "config_version"}, versionNode}, root.Content...)
+	}
+	return version, nil
+}
+
+// migrateFlatOriginsToBehaviors splits each version 1 origin into an origin (where requests go) and
+// a behavior targeting it (which requests match and how they are handled), for the top level, every
+// distribution, and the continuous deployment staging variant
+func migrateFlatOriginsToBehaviors(root *yaml.Node) error {
+	scopes := []*yaml.Node{root}
+	if distributions := mappingValue(root, "distributions"); distributions != nil {
+		scopes = append(scopes, distributions.Content...)
+	}
+	if staging := stagingScope(root); staging != nil {
+		scopes = append(scopes, staging)
+	}
+
+	for _, scope := range scopes {
+		origins := mappingValue(scope, "origins")
+		if origins == nil || origins.Kind != yaml.SequenceNode {
+			continue
+		}
+		if mappingValue(scope, "behaviors") != nil {
+			return fmt.Errorf("behaviors are not supported before config_version 2")
+		}
+		behaviors := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, origin := range origins.Content {
+			name := mappingValue(origin, "name")
+			if name == nil {
+				return fmt.Errorf("every origin needs a name to be migrated")
+			}
+			behavior := &yaml.Node{Kind: yaml.MappingNode}
+			setMappingValue(behavior, "target_origin", &yaml.Node{Kind: yaml.ScalarNode, Value: name.Value})
+			for _, key := range behaviorKeys {
+				if keyNode, value := removeMappingKey(origin, key); value != nil {
+					behavior.Content = append(behavior.Content, keyNode, value)
+				}
+			}
+			behaviors.Content = append(behaviors.Content, behavior)
+		}
+		insertMappingValueAfter(scope, "origins", "behaviors", behaviors)
+	}
+	return nil
+}
+
+// resolveBehaviors turns a current-version document into the shape Config decodes: one routed
+// entry per behavior, combining the behavior's settings with those of the origin it targets.
+// A behavior is named after its target origin unless it sets its own name.
+func resolveBehaviors(root *yaml.Node) error {
+	removeMappingKey(root, "config_version")
+	if err := resolveScopeBehaviors(root, "top level"); err != nil {
+		return err
+	}
+	if distributions := mappingValue(root, "distributions"); distributions != nil {
+		for i, distribution := range distributions.Content {
+			scope := fmt.Sprintf("distribution %d", i)
+			if name := mappingValue(distribution, "name"); name != nil {
+				scope = "distribution " + name.Value
+			}
+			if err := resolveScopeBehaviors(distribution, scope); err != nil {
+				return err
+			}
+		}
+	}
+	if staging := stagingScope(root); staging != nil {
+		return resolveScopeBehaviors(staging, "continuous deployment staging")
+	}
+	return nil
+}
+
+// stagingScope returns the continuous deployment staging variant of a document, or nil
+func stagingScope(root *yaml.Node) *yaml.Node {
+	if deployment := mappingValue(root, "continuous_deployment"); deployment != nil {
+		return mappingValue(deployment, "staging")
+	}
+	return nil
+}
+
+// resolveScopeBehaviors resolves the behaviors of the top level or a single distribution
+func resolveScopeBehaviors(scope *yaml.Node, label string) error {
+	originDefs := make(map[string]*yaml.Node)
+	if origins := mappingValue(scope, "origins"); origins != nil {
+		for _, origin := range origins.Content {
+			name := mappingValue(origin, "name")
+			if name == nil {
+				return fmt.Errorf("%s: origin without a name", label)
+			}
+			for _, key := range behaviorKeys {
+				if mappingValue(origin, key) != nil {
+					return fmt.Errorf("%s: origin %s: %s belongs on a behavior in config_version %d", label, name.Value, key, CurrentConfigVersion)
+				}
+			}
+			originDefs[name.Value] = origin
+		}
+	}
+
+	_, behaviors := removeMappingKey(scope, "behaviors")
+	routed := &yaml.Node{Kind: yaml.SequenceNode}
+	if behaviors != nil {
+		for i, behavior := range behaviors.Content {
+			target := mappingValue(behavior, "target_origin")
+			if target == nil {
+				return fmt.Errorf("%s: behavior %d: target_origin is required", label, i)
+			}
+			origin, ok := originDefs[target.Value]
+			if !ok {
+				return fmt.Errorf("%s: behavior %d: unknown target_origin %q", label, i, target.Value)
+			}
+
+			entry := &yaml.Node{Kind: yaml.MappingNode}
+			entry.Content = append(entry.Content, origin.Content...)
+			for j := 0; j+1 < len(behavior.Content); j += 2 {
+				if key := behavior.Content[j].Value; key != "target_origin" {
+					setMappingValue(entry, key, behavior.Content[j+1])
+				}
+			}
+			routed.Content = append(routed.Content, entry)
+		}
+	}
+	setMappingValue(scope, "origins", routed)
+	return nil
+}
+
+// mappingValue returns the value stored under key in a mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value under key in a mapping node, appending the key if it is missing
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// insertMappingValueAfter adds key to a mapping node directly after an existing key
+func insertMappingValueAfter(mapping *yaml.Node, after, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == after {
+			pair := []*yaml.Node{{Kind: yaml.ScalarNode, Value: key}, value}
+			mapping.Content = append(mapping.Content[:i+2], append(pair, mapping.Content[i+2:]...)...)
+			return
+		}
+	}
+	setMappingValue(mapping, key, value)
+}
+
+// removeMappingKey deletes key from a mapping node, returning the removed key and value nodes
+func removeMappingKey(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			keyNode, value := mapping.Content[i], mapping.Content[i+1]
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return keyNode, value
+		}
+	}
+	return nil, nil
+}
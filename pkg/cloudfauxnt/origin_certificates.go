@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// originCertificate is what CloudFauxnt found checking the certificate a TLS origin presented
+type originCertificate struct {
+	Host          string               `json:"host"` // Name the certificate was checked against (SNI)
+	Valid         bool                 `json:"valid"`
+	Error         string               `json:"error,omitempty"`
+	SANMatch      bool                 `json:"san_match"`
+	MatchedSAN    string               `json:"matched_san,omitempty"` // e.g. *.staging.example.com
+	NotAfter      time.Time            `json:"not_after"`             // Of the leaf certificate
+	ExpiresInDays int                  `json:"expires_in_days"`       // Negative once expired
+	Chain         []certificateSummary `json:"chain"`                 // Leaf first, as the origin sent it
+	CheckedAt     time.Time            `json:"checked_at"`
+}
+
+// certificateSummary describes one certificate of a chain
+type certificateSummary struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	SHA256      string    `json:"sha256"`
+}
+
+// describeCertificate describes the chain an origin presented for host, and why verification
+// rejected it, if it did
+func describeCertificate(chain []*x509.Certificate, host string, verifyErr error) *originCertificate {
+	now := time.Now()
+	cert := &originCertificate{Host: host, Valid: verifyErr == nil, CheckedAt: now.UTC()}
+	if verifyErr != nil {
+		cert.Error = verifyErr.Error()
+	}
+	if len(chain) == 0 {
+		return cert
+	}
+	for _, c := range chain {
+		summary := certificateSummary{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			DNSNames:  c.DNSNames,
+			NotBefore: c.NotBefore.UTC(),
+			NotAfter:  c.NotAfter.UTC(),
+		}
+		for _, ip := range c.IPAddresses {
+			summary.IPAddresses = append(summary.IPAddresses, ip.String())
+		}
+		fingerprint := sha256.Sum256(c.Raw)
+		summary.SHA256 = hex.EncodeToString(fingerprint[:])
+		cert.Chain = append(cert.Chain, summary)
+	}
+
+	leaf := chain[0]
+	cert.NotAfter = leaf.NotAfter.UTC()
+	cert.ExpiresInDays = int(leaf.NotAfter.Sub(now).Hours() / 24)
+	cert.MatchedSAN, cert.SANMatch = matchedSAN(leaf, host)
+	return cert
+}
+
+// matchedSAN returns the subject alternative name of a certificate that covers host, which for a
+// wildcard name like *.example.com covers exactly one more label
+func matchedSAN(cert *x509.Certificate, host string) (string, bool) {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, candidate := range cert.IPAddresses {
+			if candidate.Equal(ip) {
+				return candidate.String(), true
+			}
+		}
+		return "", false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, name := range cert.DNSNames {
+		pattern := strings.ToLower(strings.TrimSuffix(name, "."))
+		if pattern == host {
+			return name, true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			label, rest, found := strings.Cut(host, ".")
+			if found && label != "" && rest == suffix {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// originCertificates keeps the certificate each TLS origin presented on its latest handshake
+type originCertificates struct {
+	mu    sync.Mutex
+	byURL map[string]*originCertificate
+}
+
+// newOriginCertificates creates an empty certificate record
+func newOriginCertificates() *originCertificates {
+	return &originCertificates{byURL: make(map[string]*originCertificate)}
+}
+
+// tlsConfig returns the TLS settings for an origin's connections, which record the certificates
+// that pass Go's usual verification. Rejected ones are recorded by recordFailure.
+func (oc *originCertificates) tlsConfig(origin *Origin) *tls.Config {
+	name, originURL := origin.Name, origin.URL
+	originHost := ""
+	if u, err := url.Parse(origin.URL); err == nil {
+		originHost = u.Hostname()
+	}
+	return &tls.Config{
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			// No SNI, and so no server name, is sent to IP address origins
+			host := cmp.Or(cs.ServerName, originHost)
+			oc.record(name, originURL, describeCertificate(cs.PeerCertificates, host, nil))
+			return nil
+		},
+	}
+}
+
+// recordFailure records the certificate an origin request to host failed on, if it failed
+// certificate verification
+func (oc *originCertificates) recordFailure(origin *Origin, host string, err error) {
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		oc.record(origin.Name, origin.URL, describeCertificate(verifyErr.UnverifiedCertificates, host, verifyErr.Err))
+	}
+}
+
+// record keeps an origin's certificate, logging it when it differs from the last one seen
+func (oc *originCertificates) record(name, originURL string, cert *originCertificate) {
+	oc.mu.Lock()
+	previous := oc.byURL[originURL]
+	oc.byURL[originURL] = cert
+	oc.mu.Unlock()
+	if previous != nil && previous.Valid == cert.Valid && previous.Host == cert.Host &&
+		len(previous.Chain) > 0 && len(cert.Chain) > 0 && previous.Chain[0].SHA256 == cert.Chain[0].SHA256 {
+		return
+	}
+
+	var subjects []string
+	for _, c := range cert.Chain {
+		subjects = append(subjects, c.Subject)
+	}
+	if !cert.Valid {
+		slog.Warn("Origin certificate rejected", "origin", name, "url", originURL, "host", cert.Host,
+			"san_match", cert.SANMatch, "not_after", cert.NotAfter, "chain", subjects, "error", cert.Error)
+		return
+	}
+	slog.Debug("Origin certificate verified", "origin", name, "url", originURL, "host", cert.Host,
+		"matched_san", cert.MatchedSAN, "not_after", cert.NotAfter, "expires_in_days", cert.ExpiresInDays, "chain", subjects)
+}
+
+// get returns the certificate an origin presented on its latest handshake, or nil
+func (oc *originCertificates) get(originURL string) *originCertificate {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	return oc.byURL[originURL]
+}
+
+// originCertificateCheck is the outcome of a fresh TLS handshake with an origin
+type originCertificateCheck struct {
+	Name        string             `json:"name"`
+	URL         string             `json:"url"`
+	Certificate *originCertificate `json:"certificate,omitempty"`
+	Error       string             `json:"error,omitempty"` // Why no certificate was received
+}
+
+// checkCertificate opens a TLS connection to an https origin through its connection pool's dialer
+// and returns the certificate it presented, without sending a request
+func (t *originTransports) checkCertificate(ctx context.Context, origin *Origin) (*originCertificate, error) {
+	roundTripper, err := t.get(origin)
+	if err != nil {
+		return nil, err
+	}
+	var transport *http.Transport
+	if retrying, ok := roundTripper.(*retryingTransport); ok {
+		transport, _ = retrying.base.(*http.Transport)
+	}
+	if transport == nil {
+		return nil, fmt.Errorf("origin transport does not support TLS checks")
+	}
+	u, err := url.Parse(origin.URL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	conn, err := transport.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	config := transport.TLSClientConfig.Clone()
+	config.ServerName = u.Hostname()
+	tlsConn := tls.Client(conn, config)
+	defer tlsConn.Close()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		if verifyErr := new(tls.CertificateVerificationError); errors.As(err, &verifyErr) {
+			t.certificates.recordFailure(origin, config.ServerName, err)
+			return t.certificates.get(origin.URL), nil
+		}
+		return nil, err
+	}
+	return t.certificates.get(origin.URL), nil
+}
+
+// checkOriginCertificates handshakes with every https origin of the proxies, once per connection pool
+func checkOriginCertificates(ctx context.Context, proxies []*ProxyHandler) []originCertificateCheck {
+	var checks []originCertificateCheck
+	for _, proxy := range proxies {
+		for _, origin := range proxy.warmOrigins() {
+			if !strings.HasPrefix(strings.ToLower(origin.URL), "https://") {
+				continue
+			}
+			check := originCertificateCheck{Name: origin.Name, URL: origin.URL}
+			cert, err := proxy.transports.checkCertificate(ctx, origin)
+			if err != nil {
+				check.Error = err.Error()
+			}
+			check.Certificate = cert
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// warnExpiringCertificates checks the certificates of the proxies' https origins, warning about those
+// expiring within server.origin_cert_warning_days
+func warnExpiringCertificates(config *Config, proxies []*ProxyHandler) {
+	days := config.Server.OriginCertWarningDays
+	if days < 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Server.ReadinessTimeoutSeconds)*time.Second)
+	defer cancel()
+	for _, check := range checkOriginCertificates(ctx, proxies) {
+		if cert := check.Certificate; cert != nil && cert.ExpiresInDays <= days {
+			slog.Warn("Origin certificate expires soon", "origin", check.Name, "url", check.URL,
+				"not_after", cert.NotAfter, "expires_in_days", cert.ExpiresInDays, "warning_days", days)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxOriginCustomHeaders is CloudFront's quota on custom headers per origin
+const maxOriginCustomHeaders = 10
+
+// disallowedOriginCustomHeaders are the headers CloudFront won't let an origin's custom headers set
+var disallowedOriginCustomHeaders = map[string]bool{
+	"Cache-Control": true, "Connection": true, "Content-Length": true, "Cookie": true, "Host": true,
+	"If-Match": true, "If-Modified-Since": true, "If-None-Match": true, "If-Range": true,
+	"If-Unmodified-Since": true, "Max-Forwards": true, "Pragma": true, "Proxy-Authorization": true,
+	"Proxy-Connection": true, "Range": true, "Request-Range": true, "Te": true, "Trailer": true,
+	"Transfer-Encoding": true, "Upgrade": true, "Via": true, "X-Real-Ip": true,
+}
+
+// validateOriginCustomHeaders checks an origin's custom headers against CloudFront's restrictions
+func validateOriginCustomHeaders(headers map[string]string) error {
+	if len(headers) > maxOriginCustomHeaders {
+		return fmt.Errorf("custom_headers: at most %d headers are allowed, got %d", maxOriginCustomHeaders, len(headers))
+	}
+	seen := make(map[string]bool)
+	for name := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("custom_headers: invalid header name %q", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if seen[canonical] {
+			return fmt.Errorf("custom_headers: %q is set more than once", name)
+		}
+		seen[canonical] = true
+		if disallowedOriginCustomHeaders[canonical] || strings.HasPrefix(canonical, "X-Amz-") || strings.HasPrefix(canonical, "X-Edge-") {
+			return fmt.Errorf("custom_headers: CloudFront doesn't allow origin custom header %s", name)
+		}
+	}
+	return nil
+}
+
+// applyOriginCustomHeaders adds an origin's custom headers to a request, replacing any viewer
+// headers with the same name as CloudFront does
+func applyOriginCustomHeaders(header http.Header, headers map[string]string) {
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// alwaysForwardedHeaders are viewer headers CloudFront sends to the origin regardless of policy,
+// because the request body can't be interpreted, a WebSocket opened, or a cached object revalidated without them
+var alwaysForwardedHeaders = map[string]bool{
+	"Content-Length":           true,
+	"Content-Type":             true,
+	"Content-Encoding":         true,
+	"Transfer-Encoding":        true,
+	"Expect":                   true,
+	"Connection":               true,
+	"Upgrade":                  true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Extensions": true,
+	"If-None-Match":            true,
+	"If-Modified-Since":        true,
+}
+
+// Apply strips the viewer headers, cookies, and query strings the policy doesn't forward
+func (p *OriginRequestPolicy) Apply(req *http.Request) {
+	// Cookies are filtered first since the Cookie header itself may be dropped below
+	p.applyCookies(req)
+	p.applyHeaders(req)
+	p.applyQueryStrings(req.URL)
+}
+
+// applyHeaders removes viewer headers that aren't forwarded
+func (p *OriginRequestPolicy) applyHeaders(req *http.Request) {
+	if p.Headers.Behavior == "allViewer" || p.Headers.Behavior == "allViewerAndCloudFrontHeaders" {
+		return
+	}
+
+	allowed := make(map[string]bool, len(p.Headers.Items))
+	for _, name := range p.Headers.Items {
+		allowed[http.CanonicalHeaderKey(name)] = true
+	}
+	for name := range req.Header {
+		// Cookies are governed by the cookie rule, not the header rule
+		if name == "Cookie" || alwaysForwardedHeaders[name] || allowed[name] {
+			continue
+		}
+		req.Header.Del(name)
+	}
+
+	// CloudFront identifies itself when the viewer's User-Agent isn't forwarded
+	if !allowed["User-Agent"] {
+		req.Header.Set("User-Agent", "Amazon CloudFront")
+	}
+}
+
+// applyCookies rewrites the Cookie header to contain only forwarded cookies
+func (p *OriginRequestPolicy) applyCookies(req *http.Request) {
+	switch p.Cookies.Behavior {
+	case "all":
+		return
+	case "none":
+		req.Header.Del("Cookie")
+		return
+	}
+
+	allowed := make(map[string]bool, len(p.Cookies.Items))
+	for _, name := range p.Cookies.Items {
+		allowed[name] = true
+	}
+	var kept []string
+	for _, cookie := range req.Cookies() {
+		if allowed[cookie.Name] {
+			kept = append(kept, cookie.Name+"="+cookie.Value)
+		}
+	}
+	if len(kept) == 0 {
+		req.Header.Del("Cookie")
+		return
+	}
+	req.Header.Set("Cookie", strings.Join(kept, "; "))
+}
+
+// applyQueryStrings removes query parameters that aren't forwarded
+func (p *OriginRequestPolicy) applyQueryStrings(u *url.URL) {
+	switch p.QueryStrings.Behavior {
+	case "all":
+		return
+	case "none":
+		u.RawQuery = ""
+		return
+	}
+
+	query := u.Query()
+	allowed := make(map[string]bool, len(p.QueryStrings.Items))
+	for _, name := range p.QueryStrings.Items {
+		allowed[name] = true
+	}
+	for name := range query {
+		if !allowed[name] {
+			query.Del(name)
+		}
+	}
+	u.RawQuery = query.Encode()
+}
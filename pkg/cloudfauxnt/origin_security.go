@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// metadataPrefixes are link-local and cloud metadata ranges blocked by block_metadata_ranges
+var metadataPrefixes = []string{
+	"169.254.0.0/16",     // IPv4 link-local, including 169.254.169.254 (AWS/GCP/Azure metadata)
+	"fe80::/10",          // IPv6 link-local
+	"fd00:ec2::254/128",  // AWS IMDS over IPv6
+	"100.100.100.200/32", // Alibaba Cloud metadata
+}
+
+// prepare parses the deny ranges and fills in defaults
+func (s *OriginSecurityConfig) prepare() error {
+	if len(s.AllowedSchemes) == 0 {
+		s.AllowedSchemes = []string{"http", "https"}
+	}
+	for i, scheme := range s.AllowedSchemes {
+		s.AllowedSchemes[i] = strings.ToLower(scheme)
+	}
+
+	cidrs := s.DeniedCIDRs
+	if s.BlockMetadataRanges {
+		cidrs = append(append([]string{}, metadataPrefixes...), cidrs...)
+	}
+	s.deniedPrefixes = nil
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid denied CIDR %q: %w", cidr, err)
+		}
+		s.deniedPrefixes = append(s.deniedPrefixes, prefix.Masked())
+	}
+	return nil
+}
+
+// CheckOriginURL validates an origin URL against the scheme allowlist and, for IP literal hosts,
+// the deny ranges. Hostnames are checked again at connection time.
+func (s *OriginSecurityConfig) CheckOriginURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	allowed := false
+	for _, candidate := range s.AllowedSchemes {
+		if candidate == scheme {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("scheme %q is not allowed (allowed: %s)", u.Scheme, strings.Join(s.AllowedSchemes, ", "))
+	}
+
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return s.checkAddr(addr)
+	}
+	return nil
+}
+
+// checkAddr rejects addresses inside a denied range
+func (s *OriginSecurityConfig) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range s.deniedPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("origin address %s is in denied range %s", addr, prefix)
+		}
+	}
+	return nil
+}
+
+// dialControl checks the address actually being connected to, so a hostname that is re-resolved
+// to a denied address after startup (DNS rebinding) is still refused
+func (s *OriginSecurityConfig) dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("refusing to dial unresolved address %q", address)
+	}
+	return s.checkAddr(addr)
+}
+
+// newOriginTransport builds the HTTP transport used for origin requests, guarding every
+// connection against the configured deny ranges. Connections are made from localAddr, if set.
+func newOriginTransport(security *OriginSecurityConfig, connectTimeout time.Duration, localAddr net.Addr) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+		LocalAddr: localAddr,
+	}
+	if len(security.deniedPrefixes) > 0 {
+		dialer.Control = security.dialControl
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// OriginShieldConfig routes a caching behavior's edge misses through a simulated Origin Shield: a
+// regional cache between the edge cache and the origin, which only fetches from the origin on its
+// own misses
+type OriginShieldConfig struct {
+	Region string `yaml:"region"` // AWS Region of the shield, reported in X-Cloudfauxnt-Origin-Shield (default: us-east-1)
+}
+
+// originShieldHeader reports whether an edge miss was answered by the origin shield, e.g.
+// "Hit from us-east-1", or had to go to the origin ("Miss from us-east-1")
+const originShieldHeader = "X-Cloudfauxnt-Origin-Shield"
+
+// awsRegionPattern matches AWS Region names like us-east-1 or ap-southeast-2
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// validate checks the shield settings, filling in the default region
+func (s *OriginShieldConfig) validate() error {
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if !awsRegionPattern.MatchString(s.Region) {
+		return fmt.Errorf("origin_shield.region must be an AWS Region like us-east-1, got %q", s.Region)
+	}
+	return nil
+}
+
+// shieldRegion returns the region of the behavior's origin shield, or "" when it has none
+func (o *Origin) shieldRegion() string {
+	if o.OriginShield == nil {
+		return ""
+	}
+	return o.OriginShield.Region
+}
+
+// originShields are the shield caches by region. They are kept when handlers are rebuilt on reload,
+// so a reload empties the edge caches but not the shields behind them.
+var originShields = struct {
+	sync.Mutex
+	byRegion map[string]*responseCache
+}{byRegion: make(map[string]*responseCache)}
+
+// openOriginShields returns the shield caches of the regions the behaviors of config use
+func openOriginShields(config *Config) map[string]*responseCache {
+	if config.Server.CacheSize < 0 {
+		return nil
+	}
+	originShields.Lock()
+	defer originShields.Unlock()
+	var shields map[string]*responseCache
+	for _, origin := range config.Origins {
+		if origin.OriginShield == nil || shields[origin.OriginShield.Region] != nil {
+			continue
+		}
+		region := origin.OriginShield.Region
+		shield, ok := originShields.byRegion[region]
+		if !ok {
+			shield = newMemoryCache(config.Server.ShieldCacheSize, config.Server.CacheMaxObjectBytes)
+			shield.shield = region
+			originShields.byRegion[region] = shield
+		} else {
+			shield.mu.Lock()
+			shield.capacity = config.Server.ShieldCacheSize
+			shield.maxObjectBytes = config.Server.CacheMaxObjectBytes
+			shield.evict()
+			shield.mu.Unlock()
+		}
+		if shields == nil {
+			shields = make(map[string]*responseCache)
+		}
+		shields[region] = shield
+	}
+	return shields
+}
+
+// flushOriginShields discards every object of the shield caches
+func flushOriginShields() {
+	originShields.Lock()
+	defer originShields.Unlock()
+	for _, shield := range originShields.byRegion {
+		shield.flush()
+	}
+}
+
+// serveShielded answers an edge cache miss from the behavior's origin shield when it holds a fresh
+// copy, which the edge then caches as well; otherwise the origin is fetched through the shield, and
+// the response stored in both layers
+func (ph *ProxyHandler) serveShielded(w http.ResponseWriter, r *http.Request, origin *Origin, key string, shield *responseCache) {
+	region := origin.OriginShield.Region
+	started := time.Now()
+	if entry := shield.get(key); entry != nil {
+		if age := started.Sub(entry.stored); age < entry.ttl {
+			ruleTraceFrom(r.Context()).record("origin_shield_hit", started)
+			if r.Method == http.MethodGet {
+				// The edge copy expires with the shield's, as CloudFront counts the Age it was served with
+				ph.cache.put(key, entry.status, entry.header, entry.body, origin.Cache, entry.stored)
+			}
+			w.Header().Set(originShieldHeader, "Hit from "+region)
+			entry.write(w, r, age, "Miss from cloudfauxnt")
+			return
+		}
+	}
+
+	w.Header().Set(originShieldHeader, "Miss from "+region)
+	rec := &responseRecorder{ResponseWriter: w, limit: int(ph.cache.maxObjectBytes)}
+	ph.fetch(rec, r, origin)
+	if r.Method == http.MethodGet && r.Context().Err() == nil {
+		// Stored objects carry no shield result; each viewer response reports its own
+		rec.header.Del(originShieldHeader)
+		shield.store(key, rec, origin.Cache)
+		ph.cache.store(key, rec, origin.Cache)
+	}
+}
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// validateConnectionSettings checks an origin's connection settings against CloudFront's limits,
+// fills in CloudFront's defaults, and checks the source address connections are made from
+func (o *Origin) validateConnectionSettings() error {
+	settings := []struct {
+		name     string
+		value    *int
+		min, max int
+		def      int
+	}{
+		{"connection_attempts", &o.ConnectionAttempts, 1, 3, 3},
+		{"connection_timeout_seconds", &o.ConnectionTimeoutSeconds, 1, 10, 10},
+		{"read_timeout_seconds", &o.ReadTimeoutSeconds, 1, 180, 30},
+		{"keepalive_timeout_seconds", &o.KeepaliveTimeoutSeconds, 1, 180, 5},
+	}
+	for _, setting := range settings {
+		if *setting.value == 0 {
+			*setting.value = setting.def
+		}
+		if *setting.value < setting.min || *setting.value > setting.max {
+			return fmt.Errorf("%s must be %d-%d, got %d", setting.name, setting.min, setting.max, *setting.value)
+		}
+	}
+	if o.SourceIP != "" && o.SourceInterface != "" {
+		return fmt.Errorf("source_ip and source_interface cannot both be set")
+	}
+	if _, err := o.sourceAddr(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sourceAddr returns the local address origin connections are made from, or nil to let the
+// operating system choose. An interface's first IPv4 address is preferred, then its first IPv6 address.
+func (o *Origin) sourceAddr() (net.Addr, error) {
+	if o.SourceIP != "" {
+		ip := net.ParseIP(o.SourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("source_ip %q is not an IP address", o.SourceIP)
+		}
+		if !isLocalIP(ip) {
+			return nil, fmt.Errorf("source_ip %s is not assigned to any local interface", o.SourceIP)
+		}
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	if o.SourceInterface == "" {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(o.SourceInterface)
+	if err != nil {
+		return nil, fmt.Errorf("source_interface %q: %w", o.SourceInterface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("source_interface %q: %w", o.SourceInterface, err)
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return &net.TCPAddr{IP: ipNet.IP}, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("source_interface %q has no usable address", o.SourceInterface)
+	}
+	return &net.TCPAddr{IP: ipv6}, nil
+}
+
+// isLocalIP reports whether an address is assigned to one of this host's interfaces, so connections
+// can be made from it
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// originTransportKey identifies the connection pool shared by behaviors that target the same origin
+type originTransportKey struct {
+	url                string
+	grpc               bool
+	connectionAttempts int
+	connectionTimeout  int
+	readTimeout        int
+	keepaliveTimeout   int
+	sourceIP           string
+	sourceInterface    string
+}
+
+// originTransports holds one pooled transport per origin, created on first use
+type originTransports struct {
+	mu         sync.Mutex
+	security   *OriginSecurityConfig
+	transports map[originTransportKey]http.RoundTripper
+	idleConns  int // Idle connections kept per origin, when more than Go's default of 2
+	// certificates are the certificates TLS origins presented on their latest handshakes
+	certificates *originCertificates
+}
+
+// newOriginTransports creates an empty set of origin transports guarded by the origin security
+// rules, keeping at least idleConns idle connections per origin
+func newOriginTransports(security *OriginSecurityConfig, idleConns int) *originTransports {
+	return &originTransports{
+		security:     security,
+		transports:   make(map[originTransportKey]http.RoundTripper),
+		idleConns:    idleConns,
+		certificates: newOriginCertificates(),
+	}
+}
+
+// transportKey returns the key of the connection pool an origin's requests use
+func transportKey(origin *Origin) originTransportKey {
+	return originTransportKey{
+		url:                origin.URL,
+		grpc:               origin.GRPC,
+		connectionAttempts: origin.ConnectionAttempts,
+		connectionTimeout:  origin.ConnectionTimeoutSeconds,
+		readTimeout:        origin.ReadTimeoutSeconds,
+		keepaliveTimeout:   origin.KeepaliveTimeoutSeconds,
+		sourceIP:           origin.SourceIP,
+		sourceInterface:    origin.SourceInterface,
+	}
+}
+
+// get returns the transport for an origin, creating its connection pool on first use. It fails
+// when the origin's source interface no longer has an address to connect from.
+func (t *originTransports) get(origin *Origin) (http.RoundTripper, error) {
+	key := transportKey(origin)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.transports[key]; ok {
+		return transport, nil
+	}
+
+	localAddr, err := origin.sourceAddr()
+	if err != nil {
+		return nil, err
+	}
+	connectTimeout := time.Duration(origin.ConnectionTimeoutSeconds) * time.Second
+	base := newOriginTransport(t.security, connectTimeout, localAddr)
+	if origin.GRPC {
+		base = newGRPCTransport(t.security, connectTimeout, localAddr)
+	}
+	base.ResponseHeaderTimeout = time.Duration(origin.ReadTimeoutSeconds) * time.Second
+	base.IdleConnTimeout = time.Duration(origin.KeepaliveTimeoutSeconds) * time.Second
+	base.TLSClientConfig = t.certificates.tlsConfig(origin)
+	if t.idleConns > http.DefaultMaxIdleConnsPerHost {
+		base.MaxIdleConnsPerHost = t.idleConns
+	}
+
+	transport := &retryingTransport{base: base, attempts: origin.ConnectionAttempts}
+	t.transports[key] = transport
+	return transport, nil
+}
+
+// retryingTransport retries origin requests like CloudFront's ConnectionAttempts: connections that
+// can't be established are retried for every method, and responses that time out for GET and HEAD.
+// Requests with a body are sent once, since it can't be replayed.
+type retryingTransport struct {
+	base     http.RoundTripper
+	attempts int
+}
+
+// RoundTrip sends the request, retrying failed attempts
+func (t *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		if err == nil || attempt >= t.attempts || !retryableOriginError(req, err) {
+			return resp, err
+		}
+		ruleTraceFrom(req.Context()).record("origin_retry", started)
+	}
+}
+
+// retryableOriginError reports whether a failed origin request can be sent again
+func retryableOriginError(req *http.Request, err error) bool {
+	if (req.Body != nil && req.Body != http.NoBody) || req.Context().Err() != nil {
+		return false
+	}
+	if opErr := new(net.OpError); errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return isOriginTimeout(err) && (req.Method == http.MethodGet || req.Method == http.MethodHead)
+}
+
+// isOriginTimeout reports whether an origin request failed because the origin was too slow
+func isOriginTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// globCaptures matches a path against a pattern where * matches any run of characters and ? any
+// single character, returning the text each wildcard matched. Earlier wildcards match as little as
+// possible, so /*/* splits /a/b/c into "a" and "b/c".
+func globCaptures(pattern, path string) ([]string, bool) {
+	if pattern == "" {
+		return nil, path == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(path); i++ {
+			if rest, ok := globCaptures(pattern[1:], path[i:]); ok {
+				return append([]string{path[:i]}, rest...), true
+			}
+		}
+		return nil, false
+	case '?':
+		if path == "" {
+			return nil, false
+		}
+		_, size := utf8.DecodeRuneInString(path)
+		if rest, ok := globCaptures(pattern[1:], path[size:]); ok {
+			return append([]string{path[:size]}, rest...), true
+		}
+		return nil, false
+	default:
+		if path == "" || path[0] != pattern[0] {
+			return nil, false
+		}
+		return globCaptures(pattern[1:], path[1:])
+	}
+}
+
+// hasInnerWildcards reports whether a path pattern has wildcards other than a single trailing *
+func hasInnerWildcards(pattern string) bool {
+	return strings.ContainsAny(strings.TrimSuffix(pattern, "*"), "*?")
+}
+
+// pathCaptures returns the text a path pattern's wildcards matched. A pattern ending in its only *
+// is a prefix match, capturing the rest of the path.
+func pathCaptures(pattern, path string) []string {
+	if captures, ok := globCaptures(pattern, path); ok {
+		return captures
+	}
+	if !hasInnerWildcards(pattern) && strings.HasSuffix(pattern, "*") {
+		prefix := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
+		return []string{strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")}
+	}
+	return nil
+}
+
+// expandPathTemplate builds the upstream path for a request from the behavior's path_template,
+// replacing ${1}, ${2}, ... with what the wildcards of the path pattern matched under strategy captured
+func (o *Origin) expandPathTemplate(path, strategy string) string {
+	pattern, _ := o.matchedPattern(path, strategy)
+	captures := pathCaptures(pattern, path)
+
+	var b strings.Builder
+	template := o.PathTemplate
+	for {
+		start := strings.Index(template, "${")
+		if start < 0 {
+			b.WriteString(template)
+			return b.String()
+		}
+		end := strings.IndexByte(template[start:], '}') + start
+		b.WriteString(template[:start])
+		if n, err := strconv.Atoi(template[start+2 : end]); err == nil && n <= len(captures) {
+			b.WriteString(captures[n-1])
+		}
+		template = template[end+1:]
+	}
+}
+
+// validatePathTemplate checks a path template and that every variable it uses is captured by each path pattern
+func validatePathTemplate(template string, patterns []string) error {
+	if !strings.HasPrefix(template, "/") {
+		return fmt.Errorf("path_template must start with /")
+	}
+	if strings.ContainsAny(template, "?#") {
+		return fmt.Errorf("path_template cannot contain a query string or fragment")
+	}
+	for rest := template; ; {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return fmt.Errorf("path_template: unterminated variable in %q", template)
+		}
+		name := rest[start+2 : start+end]
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 {
+			return fmt.Errorf("path_template: unknown variable ${%s}; use ${1}, ${2}, ... for the path pattern's wildcards", name)
+		}
+		for _, pattern := range patterns {
+			if wildcards := strings.Count(pattern, "*") + strings.Count(pattern, "?"); n > wildcards {
+				return fmt.Errorf("path_template uses ${%d}, but path pattern %q has %d wildcards", n, pattern, wildcards)
+			}
+		}
+		rest = rest[start+end+1:]
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// connectionWarmer opens idle origin connections ahead of the first requests, and with keep_warm
+// keeps refreshing them for the proxy handlers currently serving
+type connectionWarmer struct {
+	mu   sync.Mutex
+	stop context.CancelFunc // Stops refreshing the previous handlers' connections; nil when nothing is refreshed
+}
+
+// warm opens server.prewarm_connections idle connections to every origin of the proxies, waiting up
+// to server.readiness_timeout_seconds so the handlers are warm before they serve. Refreshing for
+// previously warmed handlers stops.
+func (cw *connectionWarmer) warm(config *Config, proxies []*ProxyHandler) {
+	cw.close()
+	if config.Server.PrewarmConnections == 0 {
+		return
+	}
+
+	timeout := time.Duration(config.Server.ReadinessTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, proxy := range proxies {
+		for _, origin := range proxy.warmOrigins() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				proxy.warmOrigin(ctx, origin, slog.LevelInfo)
+			}()
+		}
+	}
+	wg.Wait()
+
+	if !config.Server.KeepWarm {
+		return
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	cw.mu.Lock()
+	cw.stop = stop
+	cw.mu.Unlock()
+	for _, proxy := range proxies {
+		for _, origin := range proxy.warmOrigins() {
+			go proxy.keepWarm(ctx, origin, timeout)
+		}
+	}
+}
+
+// close stops refreshing warmed connections
+func (cw *connectionWarmer) close() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.stop != nil {
+		cw.stop()
+		cw.stop = nil
+	}
+}
+
+// warmOrigins returns one origin per connection pool of the handler's behaviors
+func (ph *ProxyHandler) warmOrigins() []*Origin {
+	var origins []*Origin
+	seen := make(map[originTransportKey]bool)
+	for i := range ph.config.Origins {
+		key := transportKey(&ph.config.Origins[i])
+		if !seen[key] {
+			seen[key] = true
+			origins = append(origins, &ph.config.Origins[i])
+		}
+	}
+	return origins
+}
+
+// warmOrigin sends concurrent HEAD requests to an origin's health check path, one per connection to
+// open, leaving the connections idle in its pool. Requests reuse connections that are still open.
+func (ph *ProxyHandler) warmOrigin(ctx context.Context, origin *Origin, level slog.Level) {
+	started := time.Now()
+	probes := make([]originProbe, ph.config.Server.PrewarmConnections)
+	var wg sync.WaitGroup
+	for i := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i] = probeOrigin(ctx, ph.transports, origin)
+		}()
+	}
+	wg.Wait()
+
+	for _, probe := range probes {
+		if probe.Error != "" {
+			slog.Warn("Failed to warm origin connections", "origin", origin.Name, "url", origin.URL, "error", probe.Error)
+			return
+		}
+	}
+	slog.Log(ctx, level, "Warmed origin connections", "origin", origin.Name, "url", origin.URL,
+		"connections", len(probes), "latency_ms", time.Since(started).Milliseconds())
+}
+
+// keepWarm warms an origin's connections again before its keepalive timeout would close them,
+// until ctx is done
+func (ph *ProxyHandler) keepWarm(ctx context.Context, origin *Origin, timeout time.Duration) {
+	interval := time.Duration(origin.KeepaliveTimeoutSeconds) * time.Second * 3 / 4
+	ticker := time.NewTicker(max(interval, 500*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, timeout)
+			ph.warmOrigin(roundCtx, origin, slog.LevelDebug)
+			cancel()
+		}
+	}
+}
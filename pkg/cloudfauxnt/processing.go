@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ProcessingLatencyConfig adds artificial processing time to stages of request handling, modelling
+// an edge that does heavier work than CloudFauxnt so client timeout budgets can be tested against it
+type ProcessingLatencyConfig struct {
+	SignatureValidation StageLatency `yaml:"signature_validation"` // Before signed URLs and cookies are checked
+	CacheLookup         StageLatency `yaml:"cache_lookup"`         // Before the cache is consulted, for behaviors with caching
+}
+
+// StageLatency is the delay added to one stage
+type StageLatency struct {
+	LatencyMS int `yaml:"latency_ms"` // Fixed delay
+	JitterMS  int `yaml:"jitter_ms"`  // Additional random delay of up to this many milliseconds
+}
+
+// validate checks the stage latencies
+func (c *ProcessingLatencyConfig) validate() error {
+	for _, stage := range []struct {
+		name    string
+		latency StageLatency
+	}{
+		{"signature_validation", c.SignatureValidation},
+		{"cache_lookup", c.CacheLookup},
+	} {
+		if stage.latency.LatencyMS < 0 || stage.latency.JitterMS < 0 {
+			return fmt.Errorf("%s: latency_ms and jitter_ms cannot be negative", stage.name)
+		}
+	}
+	return nil
+}
+
+// wait delays a request by the stage's latency, recording it in the rule trace under name. It
+// returns false when the viewer went away while waiting.
+func (s StageLatency) wait(r *http.Request, name string) bool {
+	if s.LatencyMS == 0 && s.JitterMS == 0 {
+		return true
+	}
+	started := time.Now()
+	delay := time.Duration(s.LatencyMS) * time.Millisecond
+	if s.JitterMS > 0 {
+		delay += time.Duration(processingRandom.IntN(s.JitterMS+1)) * time.Millisecond
+	}
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return false
+	}
+	ruleTraceFrom(r.Context()).record(name, started)
+	return true
+}
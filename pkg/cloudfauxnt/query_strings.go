@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/url"
+)
+
+// filterQuery removes the query parameters a behavior's query_strings rule doesn't forward,
+// reporting whether any were removed
+func (o *Origin) filterQuery(u *url.URL) bool {
+	rule := o.QueryStrings
+	if rule == nil || rule.Behavior == "all" || u.RawQuery == "" {
+		return false
+	}
+
+	query := u.Query()
+	removed := false
+	for name := range query {
+		if !rule.allows(name) {
+			query.Del(name)
+			removed = true
+		}
+	}
+	if removed {
+		u.RawQuery = query.Encode()
+	}
+	return removed
+}
+
+// cacheQuery returns the query string a request is cached under: the parameters the behavior
+// forwards, sorted by name and consistently escaped, so ?b=2&a=1 and ?a=%31&b=2 share an object
+func (o *Origin) cacheQuery(u *url.URL) string {
+	query := u.Query()
+	if rule := o.QueryStrings; rule != nil {
+		for name := range query {
+			if !rule.allows(name) {
+				query.Del(name)
+			}
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"sync"
+)
+
+// randomStream is the source of randomness for one randomized feature. Each feature has its own
+// stream so that, for a given seed, one feature's draws don't shift another's.
+type randomStream struct {
+	id  uint64 // Distinguishes the stream's sequence from the others for the same seed
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+var (
+	requestIDRandom  = &randomStream{id: 1} // X-Amz-Cf-Id request IDs
+	canaryRandom     = &randomStream{id: 2} // Canary assignment of new viewers
+	faultRandom      = &randomStream{id: 3} // Fault injection jitter, errors, and resets
+	samplingRandom   = &randomStream{id: 4} // Real-time log sampling
+	latencyRandom    = &randomStream{id: 5} // Latency profile jitter
+	processingRandom = &randomStream{id: 6} // Processing latency jitter
+	teeRandom        = &randomStream{id: 7} // Response tee sampling
+	stagingRandom    = &randomStream{id: 8} // Continuous deployment assignment to staging
+
+	randomStreams = []*randomStream{requestIDRandom, canaryRandom, faultRandom, samplingRandom, latencyRandom, processingRandom, teeRandom, stagingRandom}
+
+	seedMu      sync.Mutex
+	currentSeed uint64
+)
+
+func init() {
+	SetSeed(rand.Uint64())
+}
+
+// SetSeed makes every randomized feature replay the same sequence for the same seed, reseeding
+// each of randomStreams. Requests must arrive in the same order for a run to be reproduced exactly.
+// Secrets such as bypass tokens stay random.
+func SetSeed(seed uint64) {
+	seedMu.Lock()
+	defer seedMu.Unlock()
+	currentSeed = seed
+	for _, stream := range randomStreams {
+		stream.mu.Lock()
+		stream.rng = rand.New(rand.NewPCG(seed, stream.id))
+		stream.mu.Unlock()
+	}
+}
+
+// Seed returns the seed randomized features are currently using
+func Seed() uint64 {
+	seedMu.Lock()
+	defer seedMu.Unlock()
+	return currentSeed
+}
+
+// IntN returns a random int in [0, n)
+func (s *randomStream) IntN(n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.IntN(n)
+}
+
+// Float64 returns a random float64 in [0.0, 1.0)
+func (s *randomStream) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64()
+}
+
+// Read fills p with random bytes, so the stream can back UUID generation
+func (s *randomStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var word [8]byte
+	for i := 0; i < len(p); i += len(word) {
+		binary.LittleEndian.PutUint64(word[:], s.rng.Uint64())
+		copy(p[i:], word[:])
+	}
+	return len(p), nil
+}
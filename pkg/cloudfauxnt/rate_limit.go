@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitedReason explains a request turned away by the rate limiter
+const rateLimitedReason = "Too many requests. Slow down and retry after the time given in the Retry-After header."
+
+// RateLimitConfig limits how fast each viewer may send requests, with a token bucket per viewer
+type RateLimitConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // Sustained rate each viewer is allowed
+	Burst             int     `yaml:"burst"`               // Requests a viewer may send at once (default: requests_per_second, at least 1)
+	// Key identifies viewers: client_ip (default), or signed_cookie to give each set of CloudFront
+	// signed cookies its own bucket, falling back to the client IP for requests without them
+	Key string `yaml:"key"`
+}
+
+// validate checks the rate limit settings, filling in defaults
+func (c *RateLimitConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.RequestsPerSecond <= 0 {
+		return fmt.Errorf("requests_per_second must be positive")
+	}
+	if c.Burst == 0 {
+		c.Burst = max(1, int(math.Ceil(c.RequestsPerSecond)))
+	}
+	if c.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1")
+	}
+	if c.Key == "" {
+		c.Key = "client_ip"
+	}
+	if c.Key != "client_ip" && c.Key != "signed_cookie" {
+		return fmt.Errorf("key must be client_ip or signed_cookie, got %q", c.Key)
+	}
+	return nil
+}
+
+// tokenBucket holds a viewer's remaining requests as of the last update
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter hands out requests from a token bucket per viewer
+type rateLimiter struct {
+	config *RateLimitConfig
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a rate limiter with every viewer's bucket full
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	return &rateLimiter{config: config, buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+// viewerKey identifies the viewer a request is counted against, and the kind of key used
+func (l *rateLimiter) viewerKey(r *http.Request) (string, string) {
+	if l.config.Key == "signed_cookie" {
+		if signature, err := r.Cookie("CloudFront-Signature"); err == nil && signature.Value != "" {
+			sum := sha256.Sum256([]byte(signature.Value))
+			return "cookie:" + hex.EncodeToString(sum[:]), "signed_cookie"
+		}
+	}
+	return "ip:" + clientIP(r), "client_ip"
+}
+
+// take spends a token from the viewer's bucket. When it is empty, it returns false and how long
+// until a token is available.
+func (l *rateLimiter) take(key string) (bool, time.Duration) {
+	rate, burst := l.config.RequestsPerSecond, float64(l.config.Burst)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget buckets that have refilled completely, at most once a minute
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, bucket := range l.buckets {
+			if bucket.tokens+now.Sub(bucket.updated).Seconds()*rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// RateLimitMiddleware answers viewers that exceed their request rate with 429 Too Many Requests
+// and a Retry-After header. Health checks and metrics scrapes aren't limited.
+func RateLimitMiddleware(config *Config, metrics *Metrics) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(&config.RateLimit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHealthPath(r.URL.Path) || (config.Metrics.Enabled && r.URL.Path == config.Metrics.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, kind := limiter.viewerKey(r)
+			if ok, wait := limiter.take(key); !ok {
+				metrics.rateLimited(kind)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeEdgeError(w, http.StatusTooManyRequests, rateLimitedReason)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// kinesisRecord is a single entry in a Kinesis PutRecords request
+type kinesisRecord struct {
+	Data         []byte `json:"Data"` // Encoded as base64 by encoding/json
+	PartitionKey string `json:"PartitionKey"`
+}
+
+// kinesisPutRecords is the Kinesis PutRecords request body
+type kinesisPutRecords struct {
+	StreamName string          `json:"StreamName"`
+	Records    []kinesisRecord `json:"Records"`
+}
+
+// RealtimeLogger streams CloudFront real-time log records to a Kinesis-compatible endpoint
+type RealtimeLogger struct {
+	config  RealtimeLogConfig
+	client  *http.Client
+	records chan kinesisRecord
+	done    chan struct{} // Closed to stop the delivery loop
+	stopped chan struct{} // Closed once the final batch has been shipped
+
+	deliveries subsystemState // Outcome of the latest PutRecords call
+}
+
+// NewRealtimeLogger creates a real-time logger and starts its delivery loop
+func NewRealtimeLogger(config RealtimeLogConfig) *RealtimeLogger {
+	rl := &RealtimeLogger{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		records: make(chan kinesisRecord, 10000),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go rl.run()
+	return rl
+}
+
+// logRequest queues a real-time log record, honoring the sampling rate
+func (rl *RealtimeLogger) logRequest(entry *requestLogEntry) {
+	if rl.config.SamplingRate < 100 && samplingRandom.IntN(100) >= rl.config.SamplingRate {
+		return
+	}
+
+	// Real-time log records are the selected fields, tab-separated, in configured order
+	fields := make([]string, len(rl.config.Fields))
+	for i, name := range rl.config.Fields {
+		fields[i] = logEscape(entry.field(name))
+	}
+	record := kinesisRecord{
+		Data:         []byte(strings.Join(fields, "\t") + "\n"),
+		PartitionKey: entry.header.Get("X-Amz-Cf-Id"),
+	}
+	if record.PartitionKey == "" {
+		record.PartitionKey = generateCloudFrontID()
+	}
+
+	select {
+	case rl.records <- record:
+	default:
+		// Drop rather than slow down viewer requests when the stream can't keep up
+	}
+}
+
+// run batches queued records and ships them on size or interval
+func (rl *RealtimeLogger) run() {
+	defer close(rl.stopped)
+	ticker := time.NewTicker(time.Duration(rl.config.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]kinesisRecord, 0, rl.config.BatchSize)
+	for {
+		select {
+		case record := <-rl.records:
+			batch = append(batch, record)
+			if len(batch) < rl.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-rl.done:
+			// Ship whatever is already queued, then stop
+			for len(rl.records) > 0 {
+				batch = append(batch, <-rl.records)
+			}
+			for len(batch) > 0 {
+				n := min(len(batch), rl.config.BatchSize)
+				if err := rl.deliver(batch[:n]); err != nil {
+					slog.Warn("Real-time log delivery failed", "dropped_records", n, "error", err)
+				}
+				batch = batch[n:]
+			}
+			return
+		}
+		if err := rl.deliver(batch); err != nil {
+			slog.Warn("Real-time log delivery failed", "dropped_records", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// Close ships any queued records and stops the delivery loop
+func (rl *RealtimeLogger) Close() error {
+	close(rl.done)
+	<-rl.stopped
+	return nil
+}
+
+// deliver sends a batch, recording whether delivery works
+func (rl *RealtimeLogger) deliver(records []kinesisRecord) error {
+	err := rl.putRecords(records)
+	rl.deliveries.report(err)
+	return err
+}
+
+// putRecords sends a batch with the Kinesis PutRecords API
+func (rl *RealtimeLogger) putRecords(records []kinesisRecord) error {
+	body, err := json.Marshal(kinesisPutRecords{StreamName: rl.config.StreamName, Records: records})
+	if err != nil {
+		return fmt.Errorf("failed to encode records: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, rl.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Kinesis_20131202.PutRecords")
+	signAWSRequest(req, body, "kinesis", rl.config.Region, rl.config.AccessKeyID, rl.config.SecretAccessKey, time.Now())
+
+	resp, err := rl.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kinesis returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseRecorder passes a response through to the viewer while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	limit     int // Largest body kept; 0 keeps everything
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool // The body outgrew limit and wasn't kept
+}
+
+// newBufferedRecorder returns a recorder that keeps the response without sending it anywhere
+func newBufferedRecorder() *responseRecorder {
+	return &responseRecorder{ResponseWriter: &discardResponseWriter{header: make(http.Header)}}
+}
+
+// WriteHeader records the status and a snapshot of the response headers
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body until it grows past the limit
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.truncated {
+		if w.limit > 0 && w.body.Len()+len(b) > w.limit {
+			w.truncated = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeTo sends the recorded response to w
+func (w *responseRecorder) writeTo(dst http.ResponseWriter) {
+	for name, values := range w.header {
+		dst.Header()[name] = values
+	}
+	dst.WriteHeader(w.status)
+	dst.Write(w.body.Bytes())
+}
+
+// discardResponseWriter is a ResponseWriter with nobody on the other end
+type discardResponseWriter struct {
+	header http.Header
+}
+
+// Header returns the response headers
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// Write discards b
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// WriteHeader does nothing
+func (w *discardResponseWriter) WriteHeader(int) {}
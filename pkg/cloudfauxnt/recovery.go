@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// internalErrorReason explains a 503 caused by a CloudFauxnt bug
+const internalErrorReason = "CloudFauxnt encountered an internal error while processing this request."
+
+// RecoveryMiddleware turns handler panics into a CloudFront-style 503 with a request ID, logging the
+// stack trace and counting the panic, instead of dropping the connection
+func RecoveryMiddleware(metrics *Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			guard := &panicGuardWriter{ResponseWriter: w}
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				// net/http uses this panic to abort a response deliberately (e.g. a failed body copy)
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				origin := ruleTraceFrom(r.Context()).behaviorName()
+				metrics.panicked(origin)
+				requestID := ""
+				if !guard.wroteHeader {
+					requestID = writeEdgeError(w, http.StatusServiceUnavailable, internalErrorReason)
+				}
+				slog.Error("Panic while handling request",
+					"request_id", requestID, "method", r.Method, "path", r.URL.Path, "origin", origin,
+					"panic", recovered, "stack", string(debug.Stack()))
+			}()
+			next.ServeHTTP(guard, r)
+		})
+	}
+}
+
+// panicGuardWriter tracks whether a response has started, since a 503 can only be sent before that
+type panicGuardWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// WriteHeader records that the response has started
+func (w *panicGuardWriter) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records that the response has started
+func (w *panicGuardWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer so flushing and hijacking keep working
+func (w *panicGuardWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
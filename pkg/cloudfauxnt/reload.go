@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// handlerBuilder builds the request handler for a loaded configuration
+type handlerBuilder func(config *Config) http.Handler
+
+// ConfigReloader serves requests with the handler built from the current configuration and
+// atomically swaps in a new one when the configuration is reloaded. In-flight requests finish
+// on the handler they started with.
+type ConfigReloader struct {
+	path  string
+	build handlerBuilder
+
+	mu      sync.Mutex // Serializes reloads
+	config  atomic.Pointer[Config]
+	handler atomic.Pointer[http.Handler]
+	modTime time.Time
+	reloads subsystemState // Outcome of the latest reload from the file
+
+	done      chan struct{} // Closed to stop the signal and file watchers
+	closeOnce sync.Once
+}
+
+// NewConfigReloader creates a reloader serving the handler built from an already loaded configuration
+func NewConfigReloader(path string, config *Config, build handlerBuilder) *ConfigReloader {
+	cr := &ConfigReloader{path: path, build: build, done: make(chan struct{})}
+	cr.swap(config)
+	if info, err := os.Stat(path); err == nil {
+		cr.modTime = info.ModTime()
+	}
+	return cr
+}
+
+// ServeHTTP dispatches to the current handler
+func (cr *ConfigReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*cr.handler.Load()).ServeHTTP(w, r)
+}
+
+// Config returns the configuration currently being served
+func (cr *ConfigReloader) Config() *Config {
+	return cr.config.Load()
+}
+
+// Reload loads the configuration file again and swaps it in; on error the current configuration stays active
+func (cr *ConfigReloader) Reload() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if cr.path == "" {
+		return fmt.Errorf("reload failed: configuration was not loaded from a file")
+	}
+
+	config, err := LoadConfig(cr.path)
+	if err != nil {
+		err = fmt.Errorf("reload failed, keeping current configuration: %w", err)
+		cr.reloads.report(err)
+		return err
+	}
+	cr.reloads.report(nil)
+
+	previous := cr.config.Load()
+	if previous.Server.Host != config.Server.Host || previous.Server.Port != config.Server.Port {
+		slog.Warn("Listen address changes require a restart", "current", fmt.Sprintf("%s:%d", previous.Server.Host, previous.Server.Port))
+	}
+
+	cr.swap(config)
+	slog.Info("Configuration reloaded", "path", cr.path, "origins", len(config.Origins))
+	return nil
+}
+
+// Update applies a change to a copy of the current configuration, validates it, and swaps it in.
+// Changes made this way last until the configuration file is next reloaded.
+func (cr *ConfigReloader) Update(change func(config *Config) error) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	config, err := cr.config.Load().clone()
+	if err != nil {
+		return fmt.Errorf("failed to copy configuration: %w", err)
+	}
+	if err := change(config); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	cr.swap(config)
+	return nil
+}
+
+// Rebuild rebuilds the handler from the current configuration, discarding its in-memory caches
+func (cr *ConfigReloader) Rebuild() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.swap(cr.config.Load())
+}
+
+// swap builds the handler for a configuration and makes both current
+func (cr *ConfigReloader) swap(config *Config) {
+	handler := cr.build(config)
+	cr.config.Store(config)
+	cr.handler.Store(&handler)
+}
+
+// WatchSignals reloads the configuration whenever the process receives SIGHUP
+func (cr *ConfigReloader) WatchSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+			case <-cr.done:
+				return
+			}
+			slog.Info("SIGHUP received, reloading configuration")
+			if err := cr.Reload(); err != nil {
+				slog.Error("Configuration reload failed", "error", err)
+			}
+		}
+	}()
+}
+
+// WatchFile polls the configuration file and reloads it when its modification time changes
+func (cr *ConfigReloader) WatchFile(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-cr.done:
+				return
+			}
+			info, err := os.Stat(cr.path)
+			if err != nil || info.ModTime().Equal(cr.modTime) {
+				continue
+			}
+			cr.modTime = info.ModTime()
+			slog.Info("Configuration file changed, reloading", "path", cr.path)
+			if err := cr.Reload(); err != nil {
+				slog.Error("Configuration reload failed", "error", err)
+			}
+		}
+	}()
+}
+
+// Close stops the signal and file watchers
+func (cr *ConfigReloader) Close() {
+	cr.closeOnce.Do(func() { close(cr.done) })
+}
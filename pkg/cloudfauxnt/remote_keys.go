@@ -0,0 +1,335 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultKeyRefreshSeconds is how often keys from public_key_url are fetched again by default
+	defaultKeyRefreshSeconds = 300
+	// keyFetchTimeout bounds fetching one public key
+	keyFetchTimeout = 10 * time.Second
+	// maxPublicKeyBytes bounds the size of a fetched key or JWKS document
+	maxPublicKeyBytes = 1 << 20
+)
+
+// validatePublicKeySource checks a key pair sets at most one of public_key_path and public_key_url,
+// and that the URL is one keys can be fetched from
+func validatePublicKeySource(path, keyURL string) error {
+	if keyURL == "" {
+		return nil
+	}
+	if path != "" {
+		return fmt.Errorf("public_key_path and public_key_url cannot both be set")
+	}
+	parsed, err := url.Parse(keyURL)
+	if err != nil {
+		return fmt.Errorf("invalid public_key_url: %w", err)
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("invalid public_key_url %q: missing host", keyURL)
+		}
+	case "cloudfront":
+	default:
+		return fmt.Errorf("public_key_url must be an http, https, or cloudfront: URL, got %q", keyURL)
+	}
+	return nil
+}
+
+// fetchPublicKey fetches a key pair's public key from its public_key_url: a PEM key or JWKS document
+// served over HTTP, or cloudfront:<public key ID> (default: the key pair ID) read from the CloudFront
+// API at endpoint with the credentials in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+func fetchPublicKey(ctx context.Context, keyURL, keyPairID, endpoint string) (crypto.PublicKey, error) {
+	parsed, err := url.Parse(keyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public_key_url: %w", err)
+	}
+	if parsed.Scheme == "cloudfront" {
+		api := &CloudFrontAPI{
+			Endpoint:        endpoint,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if api.AccessKeyID == "" || api.SecretAccessKey == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to read public keys from the CloudFront API")
+		}
+		publicKeyID := parsed.Opaque + parsed.Host
+		if publicKeyID == "" {
+			publicKeyID = keyPairID
+		}
+		keyData, err := api.PublicKey(ctx, publicKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return parseSigningPublicKey(keyData)
+	}
+
+	keyData, err := fetchKeyDocument(ctx, keyURL)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(keyData); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJWKS(trimmed, keyPairID)
+	}
+	return parseSigningPublicKey(keyData)
+}
+
+// fetchKeyDocument downloads a PEM key or JWKS document
+func fetchKeyDocument(ctx context.Context, keyURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch public key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch public key: %s returned %d", keyURL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPublicKeyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch public key: %w", err)
+	}
+	return body, nil
+}
+
+// jsonWebKey is an RSA or EC public key in a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"` // RSA modulus
+	E   string `json:"e"` // RSA exponent
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the key of a JWKS document whose kid is the key pair ID, or its only key
+func parseJWKS(data []byte, keyPairID string) (crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	for _, key := range jwks.Keys {
+		if key.Kid == keyPairID {
+			return key.publicKey()
+		}
+	}
+	if len(jwks.Keys) == 1 && jwks.Keys[0].Kid == "" {
+		return jwks.Keys[0].publicKey()
+	}
+	return nil, fmt.Errorf("JWKS has no key with kid %s", keyPairID)
+}
+
+// publicKey decodes the RSA or ECDSA key a JWK describes
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(field, value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("JWK %s: invalid %s", k.Kid, field)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("JWK %s: invalid e", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("JWK %s: unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("JWK %s: %w", k.Kid, err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("JWK %s: unsupported key type %q", k.Kid, k.Kty)
+}
+
+// remoteKeySource identifies a public key fetched from a public_key_url
+type remoteKeySource struct {
+	url       string
+	keyPairID string
+	endpoint  string
+}
+
+// remoteKeys calls visit with every key pair of an enabled signing configuration whose public key
+// is fetched from a URL
+func (s *SigningConfig) remoteKeys(visit func(source remoteKeySource, key *crypto.PublicKey)) {
+	if !s.Enabled {
+		return
+	}
+	if s.PublicKeyURL != "" {
+		visit(remoteKeySource{s.PublicKeyURL, s.KeyPairID, s.CloudFrontEndpoint}, &s.PublicKey)
+	}
+	for i := range s.TrustedKeyPairs {
+		trusted := &s.TrustedKeyPairs[i]
+		if trusted.PublicKeyURL != "" {
+			visit(remoteKeySource{trusted.PublicKeyURL, trusted.KeyPairID, s.CloudFrontEndpoint}, &trusted.PublicKey)
+		}
+	}
+}
+
+// signingConfigs returns the top-level signing settings and those of every distribution with its own
+func (c *Config) signingConfigs() []*SigningConfig {
+	configs := []*SigningConfig{&c.Signing}
+	for _, d := range c.Distributions {
+		if d.Signing != nil {
+			configs = append(configs, d.Signing)
+		}
+	}
+	return configs
+}
+
+// keyRefreshInterval returns the shortest key_refresh_seconds of the signing settings that fetch
+// keys from URLs, or 0 when none do
+func (c *Config) keyRefreshInterval() time.Duration {
+	var interval time.Duration
+	for _, s := range c.signingConfigs() {
+		s.remoteKeys(func(remoteKeySource, *crypto.PublicKey) {
+			refresh := time.Duration(s.KeyRefreshSeconds) * time.Second
+			if interval == 0 || refresh < interval {
+				interval = refresh
+			}
+		})
+	}
+	return interval
+}
+
+// keyRefresher fetches the keys of public_key_url key pairs again every key_refresh_seconds,
+// swapping in the configuration with the keys that changed
+type keyRefresher struct {
+	mu        sync.Mutex
+	stop      context.CancelFunc // nil until started
+	refreshes subsystemState     // Outcome of the latest refresh
+}
+
+// start refreshes the keys of the reloader's current configuration until close is called
+func (kr *keyRefresher) start(reloader *ConfigReloader) {
+	ctx, stop := context.WithCancel(context.Background())
+	kr.mu.Lock()
+	kr.stop = stop
+	kr.mu.Unlock()
+	go func() {
+		for {
+			// Configurations without remote keys are checked again in case a reload adds some
+			interval := reloader.Config().keyRefreshInterval()
+			if interval == 0 {
+				interval = defaultKeyRefreshSeconds * time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			if err := refreshPublicKeys(ctx, reloader); ctx.Err() == nil {
+				kr.refreshes.report(err)
+			}
+		}
+	}()
+}
+
+// close stops refreshing keys
+func (kr *keyRefresher) close() {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kr.stop != nil {
+		kr.stop()
+		kr.stop = nil
+	}
+}
+
+// refreshPublicKeys fetches every remote key again, updating the configuration when any changed.
+// Keys that fail to fetch stay as they were; the failures are returned.
+func refreshPublicKeys(ctx context.Context, reloader *ConfigReloader) error {
+	fetched := make(map[remoteKeySource]crypto.PublicKey)
+	changed := false
+	var errs []error
+	for _, s := range reloader.Config().signingConfigs() {
+		s.remoteKeys(func(source remoteKeySource, current *crypto.PublicKey) {
+			if _, ok := fetched[source]; ok {
+				return
+			}
+			fetchCtx, cancel := context.WithTimeout(ctx, keyFetchTimeout)
+			defer cancel()
+			key, err := fetchPublicKey(fetchCtx, source.url, source.keyPairID, source.endpoint)
+			if err != nil {
+				slog.Warn("Failed to refresh public key, keeping the current one", "key_pair_id", source.keyPairID,
+					"url", source.url, "error", err)
+				errs = append(errs, fmt.Errorf("key pair %s: %w", source.keyPairID, err))
+				key = *current
+			} else if !key.(interface{ Equal(crypto.PublicKey) bool }).Equal(*current) {
+				slog.Info("Public key changed", "key_pair_id", source.keyPairID, "url", source.url)
+				changed = true
+			}
+			fetched[source] = key
+		})
+	}
+	if !changed || ctx.Err() != nil {
+		return errors.Join(errs...)
+	}
+
+	err := reloader.Update(func(config *Config) error {
+		for _, s := range config.signingConfigs() {
+			s.remoteKeys(func(source remoteKeySource, key *crypto.PublicKey) {
+				if refreshed, ok := fetched[source]; ok {
+					*key = refreshed
+				}
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to apply refreshed public keys", "error", err)
+		errs = append(errs, fmt.Errorf("failed to apply refreshed public keys: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// verifyRequestIDEcho checks that an origin echoed the X-Amz-Cf-Id it was sent back in the
+// response header named by request_id_echo_header, reporting a missing or wrong value in the rule
+// trace, metrics, and application log
+func (ph *ProxyHandler) verifyRequestIDEcho(resp *http.Response, origin *Origin, requestID string) {
+	started := time.Now()
+	echoed := resp.Header.Get(origin.RequestIDEchoHeader)
+	if echoed == requestID {
+		return
+	}
+
+	reason := "mismatch"
+	if echoed == "" {
+		reason = "missing"
+	}
+	ruleTraceFrom(resp.Request.Context()).record("request_id_echo_"+reason, started)
+	ph.metrics.requestIDEchoFailed(origin.Name, reason)
+	slog.Warn("Origin did not echo the request ID", "origin", origin.Name, "header", origin.RequestIDEchoHeader,
+		"request_id", requestID, "echoed", echoed)
+}
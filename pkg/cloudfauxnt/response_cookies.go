@@ -0,0 +1,34 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+	"strings"
+)
+
+// filterSetCookies removes the Set-Cookie headers a behavior's response_cookies rule keeps from
+// the viewer, reporting whether any were removed
+func (o *Origin) filterSetCookies(header http.Header) bool {
+	rule := o.ResponseCookies
+	values := header.Values("Set-Cookie")
+	if rule == nil || rule.Behavior == "all" || len(values) == 0 {
+		return false
+	}
+
+	var kept []string
+	for _, value := range values {
+		name, _, _ := strings.Cut(value, "=")
+		if rule.allows(strings.TrimSpace(name)) {
+			kept = append(kept, value)
+		}
+	}
+	if len(kept) == len(values) {
+		return false
+	}
+	header.Del("Set-Cookie")
+	for _, value := range kept {
+		header.Add("Set-Cookie", value)
+	}
+	return true
+}
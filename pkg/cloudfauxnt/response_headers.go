@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Apply adds the policy's headers to a proxied response for the given viewer request
+func (p *ResponseHeadersPolicy) Apply(h http.Header, r *http.Request) {
+	for _, name := range p.RemoveHeaders {
+		h.Del(name)
+	}
+
+	sec := p.SecurityHeaders
+	if hsts := sec.StrictTransportSecurity; hsts != nil {
+		value := fmt.Sprintf("max-age=%d", hsts.MaxAgeSeconds)
+		if hsts.IncludeSubdomains {
+			value += "; includeSubDomains"
+		}
+		if hsts.Preload {
+			value += "; preload"
+		}
+		setPolicyHeader(h, "Strict-Transport-Security", value, hsts.Override)
+	}
+	if sec.FrameOptions != nil {
+		setPolicyHeader(h, "X-Frame-Options", sec.FrameOptions.Value, sec.FrameOptions.Override)
+	}
+	if sec.ContentSecurityPolicy != nil {
+		setPolicyHeader(h, "Content-Security-Policy", sec.ContentSecurityPolicy.Value, sec.ContentSecurityPolicy.Override)
+	}
+	if csp := sec.ContentSecurityPolicyReportOnly; csp != nil {
+		value := csp.header(r)
+		setPolicyHeader(h, "Content-Security-Policy-Report-Only", value, csp.Override)
+		if csp.CollectReports && h.Get("Content-Security-Policy-Report-Only") == value {
+			h.Set("Reporting-Endpoints", fmt.Sprintf("%s=%q", cspReportGroup, csp.reportURL(r)))
+		}
+	}
+	if sec.ReferrerPolicy != nil {
+		setPolicyHeader(h, "Referrer-Policy", sec.ReferrerPolicy.Value, sec.ReferrerPolicy.Override)
+	}
+	if sec.ContentTypeOptions != nil {
+		setPolicyHeader(h, "X-Content-Type-Options", "nosniff", sec.ContentTypeOptions.Override)
+	}
+	if sec.XSSProtection != nil {
+		setPolicyHeader(h, "X-XSS-Protection", sec.XSSProtection.Value, sec.XSSProtection.Override)
+	}
+
+	if p.CORS != nil {
+		p.CORS.apply(h, r)
+	}
+
+	for _, custom := range p.CustomHeaders {
+		setPolicyHeader(h, custom.Header, custom.Value, custom.Override)
+	}
+}
+
+// apply adds CORS headers when the viewer sent an allowed Origin
+func (c *ResponseCORSConfig) apply(h http.Header, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !originAllowed(c.AllowOrigins, origin) {
+		return
+	}
+	// Leave the origin's own CORS answer alone unless the policy overrides it
+	if !c.OriginOverride && h.Get("Access-Control-Allow-Origin") != "" {
+		return
+	}
+
+	allowOrigin := origin
+	if !c.AllowCredentials && len(c.AllowOrigins) == 1 && c.AllowOrigins[0] == "*" {
+		allowOrigin = "*"
+	} else {
+		h.Add("Vary", "Origin")
+	}
+	h.Set("Access-Control-Allow-Origin", allowOrigin)
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposeHeaders, ", "))
+	}
+
+	// Preflight responses also describe what the actual request may do
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		if len(c.AllowMethods) > 0 {
+			h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowMethods, ", "))
+		}
+		if len(c.AllowHeaders) > 0 {
+			h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+		}
+		if c.MaxAgeSeconds > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAgeSeconds))
+		}
+	}
+}
+
+// setPolicyHeader sets a header when override is enabled or the origin didn't send one
+func setPolicyHeader(h http.Header, name, value string, override bool) {
+	if override || h.Get(name) == "" {
+		h.Set(name, value)
+	}
+}
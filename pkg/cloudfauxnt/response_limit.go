@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// ErrResponseTooLarge is returned when an origin response is larger than its behavior's max_response_bytes
+var ErrResponseTooLarge = errors.New("origin response exceeds max_response_bytes")
+
+// limitResponse enforces the behavior's max_response_bytes on an origin response. Responses
+// declaring a larger Content-Length are refused before anything reaches the viewer; bodies of
+// unknown length are cut off once they pass the limit, aborting the viewer's connection.
+func limitResponse(resp *http.Response, r *http.Request, origin *Origin) error {
+	limit := origin.MaxResponseBytes
+	if r.Method == http.MethodHead || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		slog.Warn("Origin response exceeds max_response_bytes", "origin", origin.Name, "path", r.URL.Path,
+			"content_length", resp.ContentLength, "max_response_bytes", limit)
+		return fmt.Errorf("%w: the origin's %d-byte response to %s is over the %d-byte limit of behavior %s",
+			ErrResponseTooLarge, resp.ContentLength, r.URL.Path, limit, origin.Name)
+	}
+	if resp.ContentLength < 0 {
+		resp.Body = &limitedResponseBody{ReadCloser: resp.Body, remaining: limit, limit: limit, origin: origin.Name, path: r.URL.Path}
+	}
+	return nil
+}
+
+// limitedResponseBody fails reads once a response body of unknown length passes its limit
+type limitedResponseBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+	origin    string
+	path      string
+}
+
+// Read passes the body through until the limit, then fails with ErrResponseTooLarge
+func (b *limitedResponseBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	// Read one byte past the limit to tell a body ending exactly at it from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		slog.Warn("Origin response exceeded max_response_bytes while streaming, aborting it", "origin", b.origin,
+			"path", b.path, "max_response_bytes", b.limit)
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseTeeConfig copies the bodies of origin responses to a directory as they are proxied, so
+// artifacts served during a test run can be inspected afterwards
+type ResponseTeeConfig struct {
+	Dir string `yaml:"dir"` // Bodies are written under it at their request path; later fetches overwrite earlier ones
+	// Optional: paths whose responses are kept, with the wildcards of behavior path patterns (default: every path)
+	PathPatterns   []string `yaml:"path_patterns"`
+	MaxObjectBytes int64    `yaml:"max_object_bytes"` // Larger bodies aren't kept (default: 104857600, 100MiB)
+	MaxTotalBytes  int64    `yaml:"max_total_bytes"`  // Nothing more is kept once this much was written (default: 1073741824, 1GiB)
+	SamplingRate   int      `yaml:"sampling_rate"`    // Percentage of matching responses kept, 1-100 (default: 100)
+}
+
+// validate checks the tee settings, filling in defaults
+func (t *ResponseTeeConfig) validate() error {
+	if t.Dir == "" {
+		return fmt.Errorf("tee.dir is required")
+	}
+	if t.MaxObjectBytes < 0 || t.MaxTotalBytes < 0 {
+		return fmt.Errorf("tee size limits cannot be negative")
+	}
+	if t.MaxObjectBytes == 0 {
+		t.MaxObjectBytes = 100 << 20
+	}
+	if t.MaxTotalBytes == 0 {
+		t.MaxTotalBytes = 1 << 30
+	}
+	if t.SamplingRate == 0 {
+		t.SamplingRate = 100
+	}
+	if t.SamplingRate < 1 || t.SamplingRate > 100 {
+		return fmt.Errorf("tee.sampling_rate must be 1-100, got %d", t.SamplingRate)
+	}
+	return nil
+}
+
+// matches reports whether responses for a viewer path are teed
+func (t *ResponseTeeConfig) matches(urlPath string) bool {
+	if len(t.PathPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range t.PathPatterns {
+		if matchPath(pattern, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// file returns where the body for a viewer path is kept: the path under dir, with "index" for
+// paths ending in "/"
+func (t *ResponseTeeConfig) file(urlPath string) string {
+	cleaned := path.Clean("/" + urlPath)
+	if strings.HasSuffix(urlPath, "/") || cleaned == "/" {
+		cleaned = path.Join(cleaned, "index")
+	}
+	return filepath.Join(t.Dir, filepath.FromSlash(cleaned))
+}
+
+// responseTees counts the bytes each behavior's tee has written, against its max_total_bytes
+type responseTees struct {
+	mu      sync.Mutex
+	written map[string]int64 // By behavior name
+	full    map[string]bool  // Behaviors whose tee reached max_total_bytes, so it is logged once
+}
+
+// newResponseTees creates tee accounting with nothing written
+func newResponseTees() *responseTees {
+	return &responseTees{written: make(map[string]int64), full: make(map[string]bool)}
+}
+
+// tee starts copying the body of a 200 response to a GET request for the behavior's tee directory,
+// when its path matches, it is sampled, and the tee has room left
+func (ph *ProxyHandler) tee(resp *http.Response, r *http.Request, origin *Origin) {
+	tee := origin.Tee
+	if resp.StatusCode != http.StatusOK || r.Method != http.MethodGet || !tee.matches(r.URL.Path) {
+		return
+	}
+	if tee.SamplingRate < 100 && teeRandom.IntN(100) >= tee.SamplingRate {
+		return
+	}
+	if resp.ContentLength > tee.MaxObjectBytes || !ph.tees.hasRoom(origin.Name, tee) {
+		return
+	}
+
+	started := time.Now()
+	name := tee.file(r.URL.Path)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		slog.Warn("Failed to tee response body", "origin", origin.Name, "path", r.URL.Path, "error", err)
+		return
+	}
+	file, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		slog.Warn("Failed to tee response body", "origin", origin.Name, "path", r.URL.Path, "error", err)
+		return
+	}
+	ruleTraceFrom(r.Context()).record("response_tee", started)
+	resp.Body = &teeBody{ReadCloser: resp.Body, file: file, name: name, limit: tee.MaxObjectBytes,
+		done: func(written int64) { ph.tees.add(origin.Name, written) }}
+}
+
+// hasRoom reports whether a behavior's tee may keep another body, logging when it first can't
+func (t *responseTees) hasRoom(behavior string, tee *ResponseTeeConfig) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.written[behavior] < tee.MaxTotalBytes {
+		return true
+	}
+	if !t.full[behavior] {
+		t.full[behavior] = true
+		slog.Warn("Response tee reached max_total_bytes, no more bodies are kept", "origin", behavior,
+			"dir", tee.Dir, "max_total_bytes", tee.MaxTotalBytes)
+	}
+	return false
+}
+
+// add counts the bytes of a kept body
+func (t *responseTees) add(behavior string, written int64) {
+	t.mu.Lock()
+	t.written[behavior] += written
+	t.mu.Unlock()
+}
+
+// teeBody writes a response body to a temporary file as the viewer reads it, moving the file into
+// place once the whole body was read. Bodies over the limit, and those cut short, aren't kept.
+type teeBody struct {
+	io.ReadCloser
+	file     *os.File // nil once the copy was abandoned
+	name     string
+	limit    int64
+	written  int64
+	complete bool
+	done     func(written int64)
+}
+
+// Read passes the body through, copying what was read
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.file != nil {
+		if b.written+int64(n) > b.limit {
+			b.abandon()
+		} else if _, werr := b.file.Write(p[:n]); werr != nil {
+			slog.Warn("Failed to tee response body", "file", b.name, "error", werr)
+			b.abandon()
+		} else {
+			b.written += int64(n)
+		}
+	}
+	if err == io.EOF {
+		b.complete = true
+	}
+	return n, err
+}
+
+// Close closes the body, keeping the copy if the body was read to the end
+func (b *teeBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.file == nil {
+		return err
+	}
+	if !b.complete {
+		b.abandon()
+		return err
+	}
+	if cerr := b.file.Close(); cerr != nil {
+		slog.Warn("Failed to tee response body", "file", b.name, "error", cerr)
+		os.Remove(b.file.Name())
+	} else if rerr := os.Rename(b.file.Name(), b.name); rerr != nil {
+		slog.Warn("Failed to tee response body", "file", b.name, "error", rerr)
+		os.Remove(b.file.Name())
+	} else {
+		slog.Debug("Teed response body", "file", b.name, "bytes", b.written)
+		b.done(b.written)
+	}
+	b.file = nil
+	return err
+}
+
+// abandon discards the partial copy
+func (b *teeBody) abandon() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+	b.file = nil
+}
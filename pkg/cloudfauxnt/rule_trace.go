@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ruleTraceKey is the context key for the per-request rule trace
+type ruleTraceKey struct{}
+
+// firedRule is a rewrite rule or policy that was applied to a request, or a stage of handling it
+type firedRule struct {
+	name     string
+	started  time.Time
+	duration time.Duration
+}
+
+// ruleTrace records which behavior matched a request and which rules fired for it, along with
+// the outcome of field-level encryption and signature validation for the log fields about them
+type ruleTrace struct {
+	mu       sync.Mutex
+	behavior string
+	rules    []firedRule
+	// phases are the stages of handling the request that ran, for the admin dashboard's timeline
+	phases    []firedRule
+	fleStatus string
+	fleFields int
+	// signature is the signed URL or cookies presented, for the x-cloudfauxnt-signature-* log fields
+	signature signatureTrace
+	// origin and upstreamPath are where the request was sent, for learning mode
+	origin       string
+	upstreamPath string
+	// distribution is the one whose usage an admitted request counts toward
+	distribution string
+	// originStatus is the status the origin answered with, or 0 if it didn't answer
+	originStatus int
+}
+
+// withRuleTrace attaches a new rule trace to a context
+func withRuleTrace(ctx context.Context) (context.Context, *ruleTrace) {
+	trace := &ruleTrace{}
+	return context.WithValue(ctx, ruleTraceKey{}, trace), trace
+}
+
+// ruleTraceFrom returns the request's rule trace, or nil when rule tracing is off
+func ruleTraceFrom(ctx context.Context) *ruleTrace {
+	trace, _ := ctx.Value(ruleTraceKey{}).(*ruleTrace)
+	return trace
+}
+
+// setBehavior records the behavior (origin) that matched the request
+func (t *ruleTrace) setBehavior(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.behavior = name
+	t.mu.Unlock()
+}
+
+// behaviorName returns the behavior (origin) that matched, or "" if none did
+func (t *ruleTrace) behaviorName() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.behavior
+}
+
+// setDistribution records the distribution whose usage the request counts toward
+func (t *ruleTrace) setDistribution(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.distribution = name
+	t.mu.Unlock()
+}
+
+// distributionName returns the distribution the request counts toward, or "" if it isn't counted
+func (t *ruleTrace) distributionName() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.distribution
+}
+
+// setOriginStatus records the status of the origin's response
+func (t *ruleTrace) setOriginStatus(status int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.originStatus = status
+	t.mu.Unlock()
+}
+
+// originResponseStatus returns the status the origin answered with, or 0 if it didn't answer
+func (t *ruleTrace) originResponseStatus() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.originStatus
+}
+
+// setFieldLevelEncryption records the fle-status of a request and how many fields were encrypted
+func (t *ruleTrace) setFieldLevelEncryption(status string, fields int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.fleStatus, t.fleFields = status, fields
+	t.mu.Unlock()
+}
+
+// fieldLevelEncryption returns the fle-status and encrypted field count, or "" when no profile applied
+func (t *ruleTrace) fieldLevelEncryption() (string, int) {
+	if t == nil {
+		return "", 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.fleStatus, t.fleFields
+}
+
+// signatureTrace describes the signature a request presented and how its validation ended
+type signatureTrace struct {
+	keyPairID string
+	expires   int64  // Unix time of the canned Expires or the policy's DateLessThan, 0 if not read
+	status    string // "valid", "bypassed", or the failure reason; "" when no signature was required
+	// signedString is the exact string the signature was checked against, for the debug endpoint
+	signedString string
+}
+
+// setSignatureKeyPair records the key pair ID a signature claims
+func (t *ruleTrace) setSignatureKeyPair(keyPairID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.signature.keyPairID = keyPairID
+	t.mu.Unlock()
+}
+
+// setSignatureExpires records when a signature's policy expires
+func (t *ruleTrace) setSignatureExpires(expires int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.signature.expires = expires
+	t.mu.Unlock()
+}
+
+// setSignedString records the string a signature was checked against
+func (t *ruleTrace) setSignedString(signed string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.signature.signedString = signed
+	t.mu.Unlock()
+}
+
+// setSignatureStatus records the outcome of signature validation
+func (t *ruleTrace) setSignatureStatus(status string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.signature.status = status
+	t.mu.Unlock()
+}
+
+// signatureOutcome returns what was recorded about the request's signature
+func (t *ruleTrace) signatureOutcome() signatureTrace {
+	if t == nil {
+		return signatureTrace{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.signature
+}
+
+// setUpstream records the origin (scheme and host) and path a request was sent to
+func (t *ruleTrace) setUpstream(origin, path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.origin, t.upstreamPath = origin, path
+	t.mu.Unlock()
+}
+
+// upstream returns the origin and path the request was sent to, or "" when it never reached one
+func (t *ruleTrace) upstream() (string, string) {
+	if t == nil {
+		return "", ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.origin, t.upstreamPath
+}
+
+// record notes that a rule fired, timing it from started
+func (t *ruleTrace) record(name string, started time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.rules = append(t.rules, firedRule{name: name, started: started, duration: time.Since(started)})
+	t.mu.Unlock()
+}
+
+// phase notes that a stage of handling the request (routing, auth, cache, origin) ran from started
+// until now
+func (t *ruleTrace) phase(name string, started time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.phases = append(t.phases, firedRule{name: name, started: started, duration: time.Since(started)})
+	t.mu.Unlock()
+}
+
+// timeline returns copies of the recorded phases and fired rules
+func (t *ruleTrace) timeline() (phases, rules []firedRule) {
+	if t == nil {
+		return nil, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.phases), slices.Clone(t.rules)
+}
+
+// String renders the trace as "behavior:rule(latency),rule(latency)"
+func (t *ruleTrace) String() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.behavior == "" {
+		return ""
+	}
+	rules := make([]string, len(t.rules))
+	for i, rule := range t.rules {
+		rules[i] = fmt.Sprintf("%s(%.3fms)", rule.name, float64(rule.duration)/float64(time.Millisecond))
+	}
+	return t.behavior + ":" + strings.Join(rules, ",")
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// ConfigSchema returns a JSON Schema (draft 2020-12) for configuration files, for editor completion
+// and CI checks. It accepts the keys strict_config does, and ${VAR} references in place of numbers
+// and booleans; ranges, required settings, and cross-references are left to Validate.
+func ConfigSchema() ([]byte, error) {
+	defs := make(map[string]any)
+	root := structSchema(reflect.TypeFor[Config](), defs)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "CloudFauxnt configuration"
+	// Extension keys hold anchors for reuse, as in Docker Compose files
+	root["patternProperties"] = map[string]any{"^x-": map[string]any{}}
+	root["$defs"] = defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// typeSchema returns the schema for a value of type t, adding the structs it uses to defs
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	if t == nil {
+		// config_version, which only exists in configuration files
+		return envReferenceOr(map[string]any{"type": "integer"})
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return envReferenceOr(map[string]any{"type": "boolean"})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return envReferenceOr(map[string]any{"type": "integer"})
+	case reflect.Float32, reflect.Float64:
+		return envReferenceOr(map[string]any{"type": "number"})
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil // Placeholder, in case the struct refers to itself
+			defs[name] = structSchema(t, defs)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the schema for the keys of a struct type
+func structSchema(t reflect.Type, defs map[string]any) map[string]any {
+	properties := make(map[string]any)
+	for key, fieldType := range yamlFields(t) {
+		properties[key] = typeSchema(fieldType, defs)
+	}
+	return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+}
+
+// envReferenceOr allows a ${VAR} reference in place of a non-string value
+func envReferenceOr(schema map[string]any) map[string]any {
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "string", "pattern": `\$\{`}}}
+}
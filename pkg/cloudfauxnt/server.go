@@ -0,0 +1,391 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cloudfauxnt is a local CloudFront emulator. It can be run as the cloudfauxnt binary or
+// embedded in Go test suites, either as an http.Handler or as a listening Server.
+package cloudfauxnt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Server is a CloudFauxnt instance: the proxy, the optional admin API, and the request logs
+type Server struct {
+	config   *Config
+	logger   *slog.Logger
+	reloader *ConfigReloader
+	bypass   *BypassTokens
+	learner  *trafficLearner // nil unless learning mode is enabled
+	captures *requestCapture // nil unless the admin API is enabled
+	usage    *usageTracker
+	reports  *cspReports // CSP violation reports, kept across reloads
+	logSinks []requestLogSink
+	degraded []string       // Optional subsystems running disabled because they failed to start
+	storage  Storage        // nil without a storage backend
+	disk     *responseCache // Disk cache of cache_dir, kept across reloads; nil without one
+	// The log sinks whose delivery /health reports, nil when disabled
+	accessLog   *AccessLogger
+	realtimeLog *RealtimeLogger
+	// proxies are the proxy handlers currently serving, whose caches the admin API inspects
+	proxies atomic.Pointer[[]*ProxyHandler]
+	warmer  connectionWarmer // Prewarms the proxies' origin connections
+	keys    keyRefresher     // Refetches public keys served from public_key_url
+
+	httpServer  *http.Server
+	adminServer *http.Server
+	addr        string
+	adminAddr   string
+	errs        chan error
+}
+
+// NewServer creates a server for a configuration. The configuration may come from LoadConfig or be
+// built in code; it is validated and its public key is loaded from public_key_path or public_key_url if not
+// already set.
+func NewServer(config *Config) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.loadPublicKeys(); err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config:  config,
+		logger:  NewLogger(config.Logging),
+		reports: newCSPReports(),
+		errs:    make(chan error, 2),
+	}
+
+	// Request logs, metrics, learned routes, bypass tokens, and storage live for the whole server and survive reloads
+	storage, err := NewStorage(config.Storage)
+	if err != nil {
+		if err := s.optional("storage", err); err != nil {
+			return nil, err
+		}
+	}
+	if storage != nil {
+		s.logger.Info("Storage backend enabled", "backend", config.Storage.Backend)
+		s.storage = storage
+	}
+	s.usage = newUsageTracker()
+	s.logSinks = append(s.logSinks, s.usage)
+	if config.Logging.Requests == nil || *config.Logging.Requests {
+		s.logSinks = append(s.logSinks, &requestLogger{logger: s.logger})
+	}
+	if config.AccessLog.Enabled {
+		accessLog, err := NewAccessLogger(config.AccessLog)
+		if err != nil {
+			if err := s.optional("access_log", fmt.Errorf("failed to open access log: %w", err)); err != nil {
+				return nil, err
+			}
+		} else {
+			if config.AccessLog.ShipToStorage {
+				accessLog.storage = storage
+			}
+			s.logSinks = append(s.logSinks, accessLog)
+			s.accessLog = accessLog
+			s.logger.Info("Access logging enabled", "edge_location", config.Server.EdgeLocation)
+		}
+	}
+	if config.Server.CacheDir != "" && config.Server.CacheSize >= 0 {
+		// Opened here so a bad directory is reported at startup; proxy handlers share the opened cache
+		disk, err := openDiskCache(config.Server.CacheDir, config.Server.CacheMaxBytes, config.Server.CacheMaxObjectBytes)
+		if err != nil {
+			if err := s.optional("disk_cache", fmt.Errorf("failed to open disk cache: %w", err)); err != nil {
+				return nil, err
+			}
+		}
+		s.disk = disk
+	}
+	if config.RealtimeLog.Enabled {
+		s.realtimeLog = NewRealtimeLogger(config.RealtimeLog)
+		s.logSinks = append(s.logSinks, s.realtimeLog)
+		s.logger.Info("Real-time logging enabled",
+			"stream", config.RealtimeLog.StreamName, "sampling_rate", config.RealtimeLog.SamplingRate)
+	}
+	var metrics *Metrics
+	if config.Metrics.Enabled {
+		metrics = NewMetrics()
+		s.logSinks = append(s.logSinks, metrics)
+	}
+	if config.Learning.Enabled {
+		s.learner = newTrafficLearner(config.Learning, storage)
+		s.logSinks = append(s.logSinks, s.learner)
+		s.logger.Info("Learning mode enabled", "path_depth", config.Learning.PathDepth, "output_file", config.Learning.OutputFile)
+	}
+	if config.Admin.Enabled {
+		s.bypass = NewBypassTokens()
+		s.captures = newRequestCapture(config.Admin.CapturedRequests)
+		s.logSinks = append(s.logSinks, s.captures)
+	}
+
+	if len(s.degraded) > 0 {
+		s.logger.Warn("Starting in degraded mode", "disabled", s.degraded)
+	}
+
+	// The router is rebuilt from the new configuration on every reload
+	s.reloader = NewConfigReloader(config.path, config, func(config *Config) http.Handler {
+		router, proxies := setupRouter(config, NewValidatorFromConfig(config), metrics, s.bypass, s.diskCache(config), s.usage, s.health, s.logSinks...)
+		s.proxies.Store(&proxies)
+		s.warmer.warm(config, proxies)
+		go warnExpiringCertificates(config, proxies)
+		return router
+	})
+	return s, nil
+}
+
+// optional handles an optional subsystem failing to start. With server.on_optional_failure set to
+// degrade, it warns and records the subsystem as disabled, returning nil; otherwise it returns err.
+func (s *Server) optional(subsystem string, err error) error {
+	if s.config.Server.OnOptionalFailure != "degrade" {
+		return err
+	}
+	s.logger.Warn("Optional subsystem failed to start, continuing without it", "subsystem", subsystem, "error", err)
+	s.degraded = append(s.degraded, subsystem)
+	return nil
+}
+
+// Degraded returns the optional subsystems that failed to start and are disabled
+func (s *Server) Degraded() []string {
+	return s.degraded
+}
+
+// Handler returns the proxy handler, suitable for httptest.NewServer
+func (s *Server) Handler() http.Handler {
+	return s.reloader
+}
+
+// AdminHandler returns the admin API handler
+func (s *Server) AdminHandler() http.Handler {
+	return NewAdminRouter(s.reloader, s.bypass, s.learner, s.captures, s.caches, s.usage, s.reports, s.originCertificates)
+}
+
+// diskCache returns the disk cache the proxy handlers of config share. It is kept across reloads,
+// so cached objects survive them, and only reopened when cache_dir changes.
+func (s *Server) diskCache(config *Config) *responseCache {
+	server := &config.Server
+	if server.CacheDir == "" || server.CacheSize < 0 {
+		return nil
+	}
+	if s.disk != nil && s.disk.disk.dir == server.CacheDir {
+		s.disk.resize(server.CacheMaxBytes, server.CacheMaxObjectBytes)
+		return s.disk
+	}
+	s.disk = configuredDiskCache(server)
+	return s.disk
+}
+
+// caches returns the response caches of the proxy handlers currently serving; distributions
+// sharing a disk cache appear once
+func (s *Server) caches() []*responseCache {
+	var caches []*responseCache
+	for _, proxy := range *s.proxies.Load() {
+		if proxy.cache != nil && !slices.Contains(caches, proxy.cache) {
+			caches = append(caches, proxy.cache)
+		}
+		for _, shield := range proxy.shields {
+			if !slices.Contains(caches, shield) {
+				caches = append(caches, shield)
+			}
+		}
+	}
+	return caches
+}
+
+// originCertificates checks the certificates of the https origins of the proxy handlers currently serving
+func (s *Server) originCertificates(ctx context.Context) []originCertificateCheck {
+	return checkOriginCertificates(ctx, *s.proxies.Load())
+}
+
+// health answers /health with the state of every enabled subsystem, rolled up into one status
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	config := s.reloader.Config()
+	var components []componentHealth
+	add := func(c componentHealth) {
+		if !slices.ContainsFunc(components, func(existing componentHealth) bool { return existing.Name == c.Name }) {
+			components = append(components, c)
+		}
+	}
+	for _, name := range s.degraded {
+		add(componentHealth{Name: name, Status: componentDegraded, Error: "failed to start, running without it"})
+	}
+
+	add(s.reloader.reloads.component("config"))
+	if slices.ContainsFunc(config.signingConfigs(), func(signing *SigningConfig) bool { return signing.Enabled }) {
+		add(signingKeyHealth(config, &s.keys.refreshes))
+	}
+	if s.storage != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(config.Server.ReadinessTimeoutSeconds)*time.Second)
+		defer cancel()
+		storage := componentHealth{Name: "storage", Status: componentOK}
+		if _, err := s.storage.List(ctx, "health/"); err != nil {
+			storage.Status, storage.Error = componentDegraded, err.Error()
+		}
+		add(storage)
+	}
+	if config.Server.CacheDir != "" && config.Server.CacheSize >= 0 {
+		cache := componentHealth{Name: "disk_cache", Status: componentOK}
+		if info, err := os.Stat(config.Server.CacheDir); err != nil {
+			cache.Status, cache.Error = componentDegraded, err.Error()
+		} else if !info.IsDir() {
+			cache.Status, cache.Error = componentDegraded, config.Server.CacheDir+" is not a directory"
+		}
+		add(cache)
+	}
+	if s.accessLog != nil {
+		add(s.accessLog.shipments.component("access_log"))
+	}
+	if s.realtimeLog != nil {
+		add(s.realtimeLog.deliveries.component("realtime_log"))
+	}
+	writeHealth(w, components)
+}
+
+// Reloader returns the reloader holding the configuration currently being served
+func (s *Server) Reloader() *ConfigReloader {
+	return s.reloader
+}
+
+// Start binds the proxy listener, and the admin listener if enabled, and serves them in the
+// background. Errors after startup are reported on Errors.
+func (s *Server) Start() error {
+	config := s.config
+	timeout := time.Duration(config.Server.TimeoutSeconds) * time.Second
+	errorLog := slog.NewLogLogger(s.logger.Handler(), slog.LevelError)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	s.addr = listener.Addr().String()
+	s.httpServer = &http.Server{
+		Handler:      s.reloader,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		IdleTimeout:  120 * time.Second,
+		ErrorLog:     errorLog,
+		// Let the proxy answer OPTIONS * the way CloudFront does
+		DisableGeneralOptionsHandler: true,
+		Protocols:                    viewerProtocols(config.Server.HTTP2),
+	}
+	if h2 := config.Server.HTTP2; h2.Enabled {
+		s.httpServer.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams:          h2.MaxConcurrentStreams,
+			MaxReceiveBufferPerStream:     h2.InitialStreamWindowSize,
+			MaxReceiveBufferPerConnection: h2.InitialConnectionWindowSize,
+			MaxReadFrameSize:              h2.MaxFrameSize,
+		}
+	}
+	useTLS := config.Server.TLSCertFile != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(config.Server.TLSCertFile, config.Server.TLSKeyFile)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		s.httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		// Fingerprint ClientHellos for the CloudFront-Viewer-JA3-Fingerprint header
+		new(viewerConns).track(s.httpServer)
+	}
+
+	// The admin API gets its own listener so it is never reachable through the proxy port
+	if config.Admin.Enabled {
+		adminListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", config.Admin.Host, config.Admin.Port))
+		if err != nil {
+			if err := s.optional("admin", fmt.Errorf("failed to listen for admin API: %w", err)); err != nil {
+				listener.Close()
+				return err
+			}
+		} else {
+			s.adminAddr = adminListener.Addr().String()
+			s.adminServer = &http.Server{
+				Handler:      s.AdminHandler(),
+				ReadTimeout:  timeout,
+				WriteTimeout: timeout,
+				ErrorLog:     errorLog,
+			}
+			go s.serve(s.adminServer, adminListener, false)
+			s.logger.Info("Admin API listening", "addr", s.adminAddr)
+		}
+	}
+
+	if config.Server.WatchConfig && config.path != "" {
+		s.reloader.WatchFile(time.Duration(config.Server.WatchIntervalSeconds) * time.Second)
+		s.logger.Info("Watching configuration file for changes", "path", config.path)
+	}
+	s.keys.start(s.reloader)
+
+	go s.serve(s.httpServer, listener, useTLS)
+	s.logger.Info("CloudFauxnt listening", "addr", s.addr, "tls", useTLS, "http2", config.Server.HTTP2.Enabled)
+	return nil
+}
+
+// serve runs an HTTP server until it is shut down, reporting any other failure
+func (s *Server) serve(server *http.Server, listener net.Listener, useTLS bool) {
+	var err error
+	if useTLS {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.errs <- err
+	}
+}
+
+// viewerProtocols returns the protocols viewers may use: HTTP/1.1 only, or also HTTP/2 over TLS
+// and cleartext HTTP/2 with prior knowledge
+func viewerProtocols(h2 HTTP2Settings) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if h2.Enabled {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	return protocols
+}
+
+// Addr returns the address the proxy is listening on once started
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// AdminAddr returns the address the admin API is listening on once started, or "" if disabled
+func (s *Server) AdminAddr() string {
+	return s.adminAddr
+}
+
+// Errors reports listener failures that happen after Start returns
+func (s *Server) Errors() <-chan error {
+	return s.errs
+}
+
+// Shutdown gracefully stops the listeners, waiting for in-flight requests until ctx is done,
+// then stops the configuration watchers and flushes and closes the request logs
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, server := range []*http.Server{s.httpServer, s.adminServer} {
+		if server != nil {
+			errs = append(errs, server.Shutdown(ctx))
+		}
+	}
+	s.reloader.Close()
+	s.warmer.close()
+	s.keys.close()
+	for _, sink := range s.logSinks {
+		if closer, ok := sink.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// signatureDebugPath is where signing debug mode explains signed URLs and cookies on the admin listener
+const signatureDebugPath = "/debug/verify"
+
+// signatureDebugRequest is what the debug endpoint checks: a signed URL, with the cookies sent
+// alongside it and the viewer address policies' AWS:SourceIp is compared with
+type signatureDebugRequest struct {
+	URL      string `json:"url"`
+	Cookies  string `json:"cookies"`   // Cookie header value, e.g. "CloudFront-Policy=...; CloudFront-Signature=..."
+	ViewerIP string `json:"viewer_ip"` // Default: the caller's address
+}
+
+// signatureDebugResult breaks down how a signature was validated and why it failed
+type signatureDebugResult struct {
+	Valid        bool     `json:"valid"`
+	Method       string   `json:"method"` // signed_url, signed_cookies, or none
+	KeyPairID    string   `json:"key_pair_id,omitempty"`
+	Key          string   `json:"key,omitempty"`           // Why the key pair was accepted, once it was
+	SignedString string   `json:"signed_string,omitempty"` // Exact string the signature was verified against
+	Expires      int64    `json:"expires,omitempty"`
+	ExpiresIn    *int64   `json:"expires_in_seconds,omitempty"` // Negative once expired
+	Reason       string   `json:"reason,omitempty"`             // As in the signature failure metrics, e.g. signature_mismatch
+	Error        string   `json:"error,omitempty"`
+	Steps        []string `json:"steps"`
+}
+
+// signingDebug reports whether signing debug mode is on at the top level or for any distribution
+func (c *Config) signingDebug() bool {
+	if c.Signing.Debug {
+		return true
+	}
+	for _, d := range c.Distributions {
+		if d.Signing != nil && d.Signing.Debug {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureDebugHandler answers the admin API's /debug/verify, validating a signed URL or cookie set
+// exactly as the proxy would and returning a JSON breakdown of the result. GET takes the URL in the
+// url parameter and checks the caller's own cookies; POST takes a signatureDebugRequest.
+func SignatureDebugHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := signatureDebugRequest{URL: r.URL.Query().Get("url"), Cookies: r.Header.Get("Cookie")}
+		if r.Method == http.MethodPost {
+			req = signatureDebugRequest{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodyBytes)).Decode(&req); err != nil {
+				writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+				return
+			}
+		}
+		if req.URL == "" {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("url is required"))
+			return
+		}
+		if req.ViewerIP == "" {
+			req.ViewerIP = clientIP(r)
+		}
+
+		result, err := debugSignature(config, req)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		// Signed strings are shown as they are, without & escaped, so they can be diffed with a signer's
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(result)
+	}
+}
+
+// debugSignature validates the request the proxy would receive for a signed URL, with the signing
+// settings of the distribution and behavior serving it
+func debugSignature(config *Config, debug signatureDebugRequest) (*signatureDebugResult, error) {
+	req, err := http.NewRequest(http.MethodGet, debug.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL: %s is not an http or https URL", debug.URL)
+	}
+	config = config.ForHost(req.Host)
+	if !config.Signing.Enabled {
+		return nil, fmt.Errorf("signing is not enabled for host %s", req.Host)
+	}
+	if req.URL.Scheme == "https" {
+		req.TLS = &tls.ConnectionState{}
+	}
+	if debug.Cookies != "" {
+		req.Header.Set("Cookie", debug.Cookies)
+	}
+	req.RemoteAddr = net.JoinHostPort(debug.ViewerIP, "0")
+	ctx, trace := withRuleTrace(req.Context())
+	req = req.WithContext(ctx)
+
+	result := &signatureDebugResult{Method: "none", Steps: []string{}}
+	if req.URL.Query().Has("Signature") {
+		result.Method = "signed_url"
+	} else if _, err := req.Cookie("CloudFront-Signature"); err == nil {
+		result.Method = "signed_cookies"
+	}
+
+	validator := NewValidatorFromConfig(config).ForPath(config, req.URL.Path)
+	err = validator.ExplainRequest(req, func(format string, args ...any) {
+		result.Steps = append(result.Steps, fmt.Sprintf(format, args...))
+	})
+	outcome := trace.signatureOutcome()
+	result.KeyPairID = outcome.keyPairID
+	result.SignedString = outcome.signedString
+	if outcome.expires != 0 {
+		result.Expires = outcome.expires
+		expiresIn := outcome.expires - time.Now().Unix()
+		result.ExpiresIn = &expiresIn
+	}
+	if err == nil {
+		result.Valid = true
+	} else {
+		result.Reason = signatureFailureReason(err)
+		result.Error = err.Error()
+	}
+	if outcome.keyPairID != "" && !errors.Is(err, ErrKeyPairMismatch) && !errors.Is(err, ErrMissingSignatureParts) {
+		result.Key = validator.describeKey()
+	}
+	return result, nil
+}
@@ -0,0 +1,478 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureValidator handles CloudFront signature validation
+type SignatureValidator struct {
+	keys             map[string]signingKey // Trusted public keys by key pair ID
+	clockSkewSeconds int64                 // Allow for clock skew when validating expiration
+	scope            *SigningScope         // Limits the key pairs accepted, when set
+	trusted          []string              // The behavior's trusted_key_pair_ids; nil accepts every key pair
+	behavior         string                // Name of the behavior trusted comes from
+	debug            bool                  // Log the exact string each signature is verified against
+}
+
+// NewSignatureValidator creates a signature validator trusting one RSA or ECDSA public key, whose
+// signatures use SHA1 as CloudFront's do
+func NewSignatureValidator(publicKey crypto.PublicKey, keyPairID string, clockSkewSeconds int) *SignatureValidator {
+	return &SignatureValidator{
+		keys:             map[string]signingKey{keyPairID: {public: publicKey, hash: crypto.SHA1}},
+		clockSkewSeconds: int64(clockSkewSeconds),
+	}
+}
+
+// NewValidatorFromConfig creates the signature validator for a configuration, trusting its key pair
+// and any trusted_key_pairs, or nil if signing is disabled
+func NewValidatorFromConfig(config *Config) *SignatureValidator {
+	if !config.Signing.Enabled {
+		return nil
+	}
+	sv := NewSignatureValidator(config.Signing.PublicKey, config.Signing.KeyPairID, config.Signing.TokenOptions.ClockSkewSeconds)
+	sv.keys[config.Signing.KeyPairID] = signingKey{config.Signing.PublicKey, signatureHash(config.Signing.SignatureHash)}
+	for _, trusted := range config.Signing.TrustedKeyPairs {
+		sv.keys[trusted.KeyPairID] = signingKey{trusted.PublicKey, signatureHash(trusted.SignatureHash)}
+	}
+	sv.debug = config.Signing.Debug
+	return sv
+}
+
+// ForPath narrows the validator to the key pairs accepted for a path, when the trusted key pairs
+// or signing scope of the behavior serving it limit them
+func (sv *SignatureValidator) ForPath(config *Config, path string) *SignatureValidator {
+	origin, err := config.FindOrigin(path)
+	if err != nil {
+		return sv
+	}
+	return sv.forBehavior(origin, path)
+}
+
+// forBehavior returns a validator accepting only the key pairs a behavior trusts, narrowed further
+// by the signing scope covering path
+func (sv *SignatureValidator) forBehavior(origin *Origin, path string) *SignatureValidator {
+	if len(origin.TrustedKeyPairIDs) == 0 {
+		return sv.scoped(origin.signingScope(path))
+	}
+	narrowed := *sv
+	narrowed.trusted = origin.TrustedKeyPairIDs
+	narrowed.behavior = origin.Name
+	return narrowed.scoped(origin.signingScope(path))
+}
+
+// scoped returns a validator accepting only the key pairs of scope, or sv itself when scope is nil
+func (sv *SignatureValidator) scoped(scope *SigningScope) *SignatureValidator {
+	if scope == nil {
+		return sv
+	}
+	narrowed := *sv
+	narrowed.scope = scope
+	return &narrowed
+}
+
+// key returns the public key of a trusted key pair the validator accepts; source says where the ID
+// came from in errors, e.g. " in cookie"
+func (sv *SignatureValidator) key(keyPairID, source string) (signingKey, error) {
+	key, ok := sv.keys[keyPairID]
+	if !ok {
+		return signingKey{}, errorOf(ErrKeyPairMismatch, "invalid key pair ID%s: %s", source, keyPairID)
+	}
+	if sv.trusted != nil && !slices.Contains(sv.trusted, keyPairID) {
+		return signingKey{}, errorOf(ErrKeyPairMismatch, "key pair ID%s %s is not trusted by behavior %s", source, keyPairID, sv.behavior)
+	}
+	if sv.scope != nil && !slices.Contains(sv.scope.KeyPairIDs, keyPairID) {
+		return signingKey{}, errorOf(ErrKeyPairMismatch, "key pair ID%s %s is not accepted for %s", source, keyPairID, sv.scope.PathPattern)
+	}
+	return key, nil
+}
+
+// stepReporter receives a description of each validation step as it happens
+type stepReporter func(format string, args ...any)
+
+// report describes a validation step; a nil reporter ignores it
+func (report stepReporter) report(format string, args ...any) {
+	if report != nil {
+		report(format, args...)
+	}
+}
+
+// ValidateRequest checks if a request has a valid CloudFront signature
+func (sv *SignatureValidator) ValidateRequest(r *http.Request) error {
+	return sv.ExplainRequest(r, nil)
+}
+
+// ExplainRequest validates a request exactly like ValidateRequest, reporting each step along the way
+func (sv *SignatureValidator) ExplainRequest(r *http.Request, steps stepReporter) error {
+	// Check for signed URL parameters
+	if r.URL.Query().Has("Signature") {
+		steps.report("Found Signature query parameter, validating as a signed URL")
+		return sv.validateSignedURL(r, steps)
+	}
+
+	// Check for signed cookies
+	if _, err := r.Cookie("CloudFront-Signature"); err == nil {
+		steps.report("Found CloudFront-Signature cookie, validating as signed cookies")
+		return sv.validateSignedCookies(r, steps)
+	}
+
+	// No signature found
+	steps.report("No Signature query parameter or CloudFront-Signature cookie present")
+	return ErrNoSignature
+}
+
+// validateSignedURL validates a canned or custom policy signed URL
+func (sv *SignatureValidator) validateSignedURL(r *http.Request, steps stepReporter) error {
+	query := r.URL.Query()
+
+	// Extract required parameters
+	signature := query.Get("Signature")
+	expires := query.Get("Expires")
+	customPolicy := query.Get("Policy")
+	keyPairID := query.Get("Key-Pair-Id")
+
+	trace := ruleTraceFrom(r.Context())
+	trace.setSignatureKeyPair(keyPairID)
+	if signature == "" || (expires == "" && customPolicy == "") || keyPairID == "" {
+		return ErrMissingSignatureParts
+	}
+
+	// Verify the key pair is trusted, and accepted for the path
+	key, err := sv.key(keyPairID, "")
+	if err != nil {
+		return err
+	}
+	steps.report("Key-Pair-Id %s is %s", keyPairID, sv.describeKey())
+
+	// Decode signature (standard or CloudFront URL-safe base64)
+	sigBytes, err := decodeCloudFrontBase64(signature)
+	if err != nil {
+		return errorOf(ErrMalformedSignature, "failed to decode signature: %w", err)
+	}
+
+	// A Policy parameter carries a custom policy, which is signed and checked like a signed cookie's
+	if customPolicy != "" {
+		policyBytes, err := decodeCloudFrontBase64(customPolicy)
+		if err != nil {
+			return errorOf(ErrMalformedSignature, "failed to decode policy: %w", err)
+		}
+		steps.report("Decoded custom policy: %s", policyBytes)
+		if err := sv.verify(r, keyPairID, key, string(policyBytes), sigBytes); err != nil {
+			return errorOf(ErrSignatureMismatch, "signature verification failed: %w", err)
+		}
+		steps.report("%s signature verified against the public key of %s", key.algorithm(), keyPairID)
+		if err := sv.validatePolicy(string(policyBytes), r, steps); err != nil {
+			return fmt.Errorf("policy validation failed: %w", err)
+		}
+		return nil
+	}
+
+	// Parse expiration time
+	expiresInt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errorOf(ErrMalformedSignature, "invalid Expires parameter: %w", err)
+	}
+	trace.setSignatureExpires(expiresInt)
+
+	// Check if expired (with clock skew tolerance)
+	currentTime := time.Now().Unix()
+	steps.report("Expires %d is %d seconds from now (clock skew tolerance %d seconds)",
+		expiresInt, expiresInt-currentTime, sv.clockSkewSeconds)
+	if currentTime > expiresInt+sv.clockSkewSeconds {
+		return errorOf(ErrSignatureExpired, "signed URL has expired")
+	}
+
+	// The canned policy string is the URL as the viewer sent it, up to and including Expires
+	resource := sv.buildResourceURL(r)
+	steps.report("Canonical resource: %s", resource)
+	separator := "?"
+	if strings.Contains(resource, "?") {
+		separator = "&"
+	}
+	policyStr := resource + separator + "Expires=" + expires
+	steps.report("Canned policy string: %s", policyStr)
+
+	// Verify signature
+	if err := sv.verify(r, keyPairID, key, policyStr, sigBytes); err != nil {
+		return errorOf(ErrSignatureMismatch, "signature verification failed: %w", err)
+	}
+	steps.report("%s signature verified against the public key of %s", key.algorithm(), keyPairID)
+
+	return nil
+}
+
+// validateSignedCookies validates CloudFront signed cookies
+func (sv *SignatureValidator) validateSignedCookies(r *http.Request, steps stepReporter) error {
+	// Extract cookies
+	policyCookie, err := r.Cookie("CloudFront-Policy")
+	if err != nil {
+		return errorOf(ErrMissingSignatureParts, "missing CloudFront-Policy cookie")
+	}
+
+	signatureCookie, err := r.Cookie("CloudFront-Signature")
+	if err != nil {
+		return errorOf(ErrMissingSignatureParts, "missing CloudFront-Signature cookie")
+	}
+
+	keyPairIDCookie, err := r.Cookie("CloudFront-Key-Pair-Id")
+	if err != nil {
+		return errorOf(ErrMissingSignatureParts, "missing CloudFront-Key-Pair-Id cookie")
+	}
+
+	// Verify the key pair is trusted, and accepted for the path
+	ruleTraceFrom(r.Context()).setSignatureKeyPair(keyPairIDCookie.Value)
+	key, err := sv.key(keyPairIDCookie.Value, " in cookie")
+	if err != nil {
+		return err
+	}
+	steps.report("CloudFront-Key-Pair-Id %s is %s", keyPairIDCookie.Value, sv.describeKey())
+
+	// Decode policy (URL-safe base64)
+	policyBytes, err := decodeCloudFrontBase64(policyCookie.Value)
+	if err != nil {
+		return errorOf(ErrMalformedSignature, "failed to decode policy: %w", err)
+	}
+	steps.report("Decoded policy: %s", policyBytes)
+
+	// Decode signature (URL-safe base64)
+	sigBytes, err := decodeCloudFrontBase64(signatureCookie.Value)
+	if err != nil {
+		return errorOf(ErrMalformedSignature, "failed to decode signature: %w", err)
+	}
+
+	// Verify signature against policy
+	if err := sv.verify(r, keyPairIDCookie.Value, key, string(policyBytes), sigBytes); err != nil {
+		return errorOf(ErrSignatureMismatch, "cookie signature verification failed: %w", err)
+	}
+	steps.report("%s signature verified against the public key of %s", key.algorithm(), keyPairIDCookie.Value)
+
+	// Parse and validate the policy's resource and expiration
+	if err := sv.validatePolicy(string(policyBytes), r, steps); err != nil {
+		return fmt.Errorf("policy validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// cloudFrontPolicy is a CloudFront custom policy document
+type cloudFrontPolicy struct {
+	Statement []policyStatement `json:"Statement"`
+}
+
+// policyStatement is a single statement of a custom policy
+type policyStatement struct {
+	Resource  string `json:"Resource"`
+	Condition struct {
+		DateLessThan struct {
+			EpochTime int64 `json:"AWS:EpochTime"`
+		} `json:"DateLessThan"`
+		DateGreaterThan struct {
+			EpochTime int64 `json:"AWS:EpochTime"`
+		} `json:"DateGreaterThan"`
+		IPAddress struct {
+			SourceIP string `json:"AWS:SourceIp"`
+		} `json:"IpAddress"`
+	} `json:"Condition"`
+}
+
+// validatePolicy parses a custom policy and checks that it covers the requested URL and has not expired
+func (sv *SignatureValidator) validatePolicy(policyStr string, r *http.Request, steps stepReporter) error {
+	var policy cloudFrontPolicy
+	if err := json.Unmarshal([]byte(policyStr), &policy); err != nil {
+		return errorOf(ErrMalformedSignature, "failed to parse policy JSON: %w", err)
+	}
+
+	if len(policy.Statement) == 0 {
+		return errorOf(ErrMalformedSignature, "policy contains no statements")
+	}
+	statement := policy.Statement[0]
+
+	// A policy without a Resource covers every URL, as it does on CloudFront
+	if statement.Resource == "" {
+		steps.report("Policy has no Resource, so it covers every URL")
+	} else {
+		requested := sv.buildResourceURL(r)
+		if !matchPolicyResource(statement.Resource, requested) {
+			return errorOf(ErrResourceMismatch, "policy resource %s does not cover %s", statement.Resource, requested)
+		}
+		steps.report("Policy Resource %s covers %s", statement.Resource, requested)
+	}
+
+	// Restrict the viewer's address when the policy has an IpAddress condition
+	if sourceIP := statement.Condition.IPAddress.SourceIP; sourceIP != "" {
+		if err := checkPolicySourceIP(sourceIP, clientIP(r), steps); err != nil {
+			return err
+		}
+	}
+
+	// Check if the statement has expired
+	expirationTime := statement.Condition.DateLessThan.EpochTime
+	if expirationTime == 0 {
+		return errorOf(ErrMalformedSignature, "policy missing expiration time")
+	}
+	ruleTraceFrom(r.Context()).setSignatureExpires(expirationTime)
+
+	// Check if expired (with clock skew tolerance)
+	currentTime := time.Now().Unix()
+	steps.report("Policy DateLessThan %d is %d seconds from now (clock skew tolerance %d seconds)",
+		expirationTime, expirationTime-currentTime, sv.clockSkewSeconds)
+	if currentTime > expirationTime+sv.clockSkewSeconds {
+		return errorOf(ErrSignatureExpired, "policy has expired")
+	}
+
+	// Check the optional not-before time, with the same clock skew tolerance in the other direction
+	if notBefore := statement.Condition.DateGreaterThan.EpochTime; notBefore != 0 {
+		steps.report("Policy DateGreaterThan %d is %d seconds from now (clock skew tolerance %d seconds)",
+			notBefore, notBefore-currentTime, sv.clockSkewSeconds)
+		if currentTime < notBefore-sv.clockSkewSeconds {
+			return errorOf(ErrSignatureNotYetValid, "policy is not valid yet")
+		}
+	}
+
+	return nil
+}
+
+// checkPolicySourceIP checks the viewer's IP address against a policy's AWS:SourceIp CIDR
+func checkPolicySourceIP(sourceIP, viewer string, steps stepReporter) error {
+	prefix, err := netip.ParsePrefix(sourceIP)
+	if err != nil {
+		// A bare address allows only that address
+		addr, addrErr := netip.ParseAddr(sourceIP)
+		if addrErr != nil {
+			return errorOf(ErrMalformedSignature, "invalid AWS:SourceIp %q: %w", sourceIP, err)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	addr, err := netip.ParseAddr(viewer)
+	if err != nil {
+		return errorOf(ErrSourceIPMismatch, "policy requires AWS:SourceIp %s but the viewer IP %q is unknown", sourceIP, viewer)
+	}
+	if !prefix.Masked().Contains(addr.Unmap()) {
+		return errorOf(ErrSourceIPMismatch, "viewer IP %s is outside the policy's AWS:SourceIp %s", addr, sourceIP)
+	}
+	steps.report("Viewer IP %s is within the policy's AWS:SourceIp %s", addr, sourceIP)
+	return nil
+}
+
+// matchPolicyResource matches a URL against a policy Resource, where * matches any run of
+// characters (including none) and ? matches exactly one, as CloudFront does
+func matchPolicyResource(pattern, requested string) bool {
+	// Iterative wildcard matching, remembering the last * to backtrack to
+	p, u := 0, 0
+	star, mark := -1, 0
+	for u < len(requested) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == requested[u]):
+			p++
+			u++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, u
+			p++
+		case star >= 0:
+			mark++
+			p, u = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// buildResourceURL constructs the URL that was signed: the canonical URL plus the query string as
+// the viewer sent it, in its order and encoding, without the CloudFront signing parameters
+func (sv *SignatureValidator) buildResourceURL(r *http.Request) string {
+	resource := sv.buildCanonicalURL(r)
+	if query := removeSignatureParams(r.URL.RawQuery); query != "" {
+		resource += "?" + query
+	}
+	return resource
+}
+
+// decodeCloudFrontBase64 decodes CloudFront's URL-safe base64 (with -, _, and ~ in place of
+// +, /, and =), which also accepts standard base64
+func decodeCloudFrontBase64(value string) ([]byte, error) {
+	value = strings.ReplaceAll(value, "-", "+")
+	value = strings.ReplaceAll(value, "_", "/")
+	value = strings.ReplaceAll(value, "~", "=")
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// buildCanonicalURL constructs the canonical resource URL: the scheme, host, and path, with the
+// path escaped as the viewer sent it
+func (sv *SignatureValidator) buildCanonicalURL(r *http.Request) string {
+	// Get base URL without query parameters
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	host := r.Host
+	path := r.URL.EscapedPath()
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+// describeKey explains why a key pair was accepted, for the validation steps
+func (sv *SignatureValidator) describeKey() string {
+	if sv.scope != nil {
+		return "trusted and accepted for " + sv.scope.PathPattern
+	}
+	if sv.trusted != nil {
+		return "trusted by behavior " + sv.behavior
+	}
+	return "a trusted key pair"
+}
+
+// verify checks a signature against the string that was signed, logging the string and the result
+// in signing debug mode
+func (sv *SignatureValidator) verify(r *http.Request, keyPairID string, key signingKey, message string, signature []byte) error {
+	err := verifySignature(key, message, signature)
+	ruleTraceFrom(r.Context()).setSignedString(message)
+	if sv.debug {
+		slog.Info("Verified signature", "path", r.URL.Path, "key_pair_id", keyPairID,
+			"signed_string", message, "valid", err == nil)
+	}
+	return err
+}
+
+// RemoveSignatureParams removes CloudFront signature parameters from URL, leaving the other
+// parameters in their original order and encoding
+func RemoveSignatureParams(u *url.URL) *url.URL {
+	cleaned := *u
+	cleaned.RawQuery = removeSignatureParams(u.RawQuery)
+	return &cleaned
+}
+
+// removeSignatureParams drops the Signature, Expires, Key-Pair-Id, and Policy parameters from a raw
+// query string without decoding and re-encoding the rest
+func removeSignatureParams(rawQuery string) string {
+	var kept []string
+	for param := range strings.SplitSeq(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		switch name {
+		case "Signature", "Expires", "Key-Pair-Id", "Policy", "":
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
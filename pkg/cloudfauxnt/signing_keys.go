@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1" // Registers SHA1 for the legacy signature hash
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// signingKey is a trusted public key with the hash its signatures are made with
+type signingKey struct {
+	public crypto.PublicKey // *rsa.PublicKey or *ecdsa.PublicKey
+	hash   crypto.Hash
+}
+
+// algorithm names the signature algorithm, e.g. RSA-SHA1 or ECDSA-SHA256
+func (k signingKey) algorithm() string {
+	keyType := "RSA"
+	if _, ok := k.public.(*ecdsa.PublicKey); ok {
+		keyType = "ECDSA"
+	}
+	return keyType + "-" + strings.ReplaceAll(k.hash.String(), "-", "")
+}
+
+// verifySignature verifies an RSA PKCS#1 v1.5 or ASN.1 ECDSA signature over message
+func verifySignature(key signingKey, message string, signature []byte) error {
+	digest := key.hash.New()
+	digest.Write([]byte(message))
+	hashed := digest.Sum(nil)
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(public, key.hash, hashed, signature); err != nil {
+			return fmt.Errorf("%s verification failed: %w", key.algorithm(), err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(public, hashed, signature) {
+			return fmt.Errorf("%s verification failed", key.algorithm())
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key.public)
+	}
+	return nil
+}
+
+// parseSignatureHash returns the hash a signature_hash setting names: sha1 (the default, as
+// CloudFront signs) or sha256
+func parseSignatureHash(name string) (crypto.Hash, error) {
+	switch strings.ToLower(name) {
+	case "", "sha1":
+		return crypto.SHA1, nil
+	case "sha256":
+		return crypto.SHA256, nil
+	}
+	return 0, fmt.Errorf("signature_hash must be sha1 or sha256, got %q", name)
+}
+
+// Hash returns the hash the key pair's signatures use
+func (s *SigningConfig) Hash() crypto.Hash {
+	return signatureHash(s.SignatureHash)
+}
+
+// signatureHash returns the configured hash, which validation has already checked
+func signatureHash(name string) crypto.Hash {
+	hash, _ := parseSignatureHash(name)
+	return hash
+}
+
+// parseSigningPublicKey parses a PEM-encoded PKIX RSA or ECDSA public key
+func parseSigningPublicKey(keyData []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block from public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return pub, nil
+	}
+	return nil, fmt.Errorf("public key is not RSA or ECDSA")
+}
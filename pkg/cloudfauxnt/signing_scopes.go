@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"crypto"
+	"fmt"
+	"slices"
+)
+
+// TrustedKeyPair is another key pair a distribution accepts signatures from, like the other public
+// keys of a CloudFront key group
+type TrustedKeyPair struct {
+	KeyPairID     string           `yaml:"key_pair_id"`
+	PublicKeyPath string           `yaml:"public_key_path"`
+	PublicKeyURL  string           `yaml:"public_key_url"` // Instead of public_key_path, as for the main key pair
+	PublicKey     crypto.PublicKey `yaml:"-"`              // *rsa.PublicKey or *ecdsa.PublicKey
+	SignatureHash string           `yaml:"signature_hash"` // sha1 (default) or sha256
+}
+
+// SigningScope limits the key pairs a behavior accepts for the paths under a narrower pattern, for
+// partners handed keys that are only good for part of a behavior
+type SigningScope struct {
+	PathPattern string   `yaml:"path_pattern"` // e.g. /downloads/premium/*
+	KeyPairIDs  []string `yaml:"key_pair_ids"` // Trusted key pairs accepted under the pattern
+}
+
+// validateTrustedKeyPairs checks the trusted key pairs have IDs and keys, and don't repeat the main key pair
+func (s *SigningConfig) validateTrustedKeyPairs() error {
+	ids := map[string]bool{s.KeyPairID: true}
+	for i, trusted := range s.TrustedKeyPairs {
+		if trusted.KeyPairID == "" {
+			return fmt.Errorf("trusted_key_pairs[%d]: key_pair_id is required", i)
+		}
+		if ids[trusted.KeyPairID] {
+			return fmt.Errorf("trusted_key_pairs[%d]: key pair %s is already trusted", i, trusted.KeyPairID)
+		}
+		ids[trusted.KeyPairID] = true
+		if trusted.PublicKeyPath == "" && trusted.PublicKeyURL == "" && trusted.PublicKey == nil {
+			return fmt.Errorf("trusted_key_pairs[%d]: public_key_path or public_key_url is required", i)
+		}
+		if err := validatePublicKeySource(trusted.PublicKeyPath, trusted.PublicKeyURL); err != nil {
+			return fmt.Errorf("trusted_key_pairs[%d]: %w", i, err)
+		}
+		if _, err := parseSignatureHash(trusted.SignatureHash); err != nil {
+			return fmt.Errorf("trusted_key_pairs[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// trusts reports whether a signing configuration accepts signatures from a key pair
+func (s *SigningConfig) trusts(keyPairID string) bool {
+	return keyPairID == s.KeyPairID || slices.ContainsFunc(s.TrustedKeyPairs, func(t TrustedKeyPair) bool {
+		return t.KeyPairID == keyPairID
+	})
+}
+
+// validateSigningScopes checks a behavior's signing scopes are complete, and only name key pairs
+// the behavior trusts
+func (o *Origin) validateSigningScopes() error {
+	if len(o.TrustedKeyPairIDs) > 0 && o.RequireSignature != nil && !*o.RequireSignature {
+		return fmt.Errorf("trusted_key_pair_ids cannot be combined with require_signature: false")
+	}
+	for i, scope := range o.SigningScopes {
+		if scope.PathPattern == "" {
+			return fmt.Errorf("signing_scopes[%d]: path_pattern is required", i)
+		}
+		if len(scope.KeyPairIDs) == 0 {
+			return fmt.Errorf("signing_scopes[%d]: at least one key pair ID is required", i)
+		}
+		if len(o.TrustedKeyPairIDs) == 0 {
+			continue
+		}
+		for _, id := range scope.KeyPairIDs {
+			if !slices.Contains(o.TrustedKeyPairIDs, id) {
+				return fmt.Errorf("signing_scopes[%d]: key pair %s is not in the behavior's trusted_key_pair_ids", i, id)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSigningScopes checks the key pairs the trusted_key_pair_ids and signing scopes of origins
+// name are trusted by signing
+func checkSigningScopes(problems *ConfigErrors, scope string, origins []Origin, signing *SigningConfig) {
+	for i, origin := range origins {
+		if len(origin.TrustedKeyPairIDs) > 0 {
+			path := fmt.Sprintf("%sbehaviors[%d] (%s).trusted_key_pair_ids", scope, i, origin.Name)
+			if !signing.Enabled {
+				problems.addf(path, "signing must be enabled to trust key pairs")
+			}
+			for _, id := range origin.TrustedKeyPairIDs {
+				if signing.Enabled && !signing.trusts(id) {
+					problems.addf(path, "key pair %s is not trusted by the signing settings", id)
+				}
+			}
+		}
+		for j, signingScope := range origin.SigningScopes {
+			path := fmt.Sprintf("%sbehaviors[%d] (%s).signing_scopes[%d]", scope, i, origin.Name, j)
+			if !signing.Enabled {
+				problems.addf(path, "signing must be enabled to scope key pairs")
+				continue
+			}
+			for _, id := range signingScope.KeyPairIDs {
+				if !signing.trusts(id) {
+					problems.addf(path, "key pair %s is not trusted by the signing settings", id)
+				}
+			}
+		}
+	}
+}
+
+// signingScope returns the first of the behavior's signing scopes covering path, or nil when every
+// trusted key pair is accepted there
+func (o *Origin) signingScope(path string) *SigningScope {
+	for i := range o.SigningScopes {
+		if matchPath(o.SigningScopes[i].PathPattern, path) {
+			return &o.SigningScopes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,514 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned by Storage.Get for keys that hold no object
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage persists objects for features that outlive the process, such as shipped access logs and
+// learned configuration. Keys are slash-separated paths.
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error) // Keys starting with prefix, sorted
+}
+
+// objectOpener is implemented by backends that can stream an object instead of loading it whole
+type objectOpener interface {
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error) // The object and its size
+}
+
+// StorageConfig selects the storage backend shared by every feature that persists objects
+type StorageConfig struct {
+	Backend   string             `yaml:"backend"`   // filesystem, s3, or redis (default: none)
+	Prefix    string             `yaml:"prefix"`    // Optional: prepended to every key, e.g. "staging/"
+	Directory string             `yaml:"directory"` // filesystem: directory objects are written under
+	S3        S3StorageConfig    `yaml:"s3"`
+	Redis     RedisStorageConfig `yaml:"redis"`
+}
+
+// S3StorageConfig holds the settings of an S3-compatible storage backend, such as ess-three or MinIO
+type S3StorageConfig struct {
+	Endpoint        string `yaml:"endpoint"`          // e.g. http://ess-three:9000 (default: https://s3.<region>.amazonaws.com)
+	Bucket          string `yaml:"bucket"`            // Addressed path-style, as S3-compatible servers expect
+	Region          string `yaml:"region"`            // Signing region (default: us-east-1)
+	AccessKeyID     string `yaml:"access_key_id"`     // Signing credentials (default: "test", as LocalStack expects)
+	SecretAccessKey string `yaml:"secret_access_key"` // Default: "test"; redacted from the effective configuration
+}
+
+// RedisStorageConfig holds the settings of a Redis storage backend
+type RedisStorageConfig struct {
+	Address  string `yaml:"address"`  // host:port (default: localhost:6379)
+	Password string `yaml:"password"` // Optional: sent with AUTH
+	DB       int    `yaml:"db"`       // Optional: database selected with SELECT
+}
+
+// validate checks the storage settings, filling in defaults
+func (c *StorageConfig) validate() error {
+	switch c.Backend {
+	case "":
+	case "filesystem":
+		if c.Directory == "" {
+			return fmt.Errorf("directory is required for the filesystem backend")
+		}
+	case "s3":
+		if c.S3.Bucket == "" {
+			return fmt.Errorf("s3.bucket is required for the s3 backend")
+		}
+		if c.S3.Region == "" {
+			c.S3.Region = "us-east-1"
+		}
+		if c.S3.Endpoint == "" {
+			c.S3.Endpoint = "https://s3." + c.S3.Region + ".amazonaws.com"
+		}
+		if c.S3.AccessKeyID == "" {
+			c.S3.AccessKeyID = "test"
+		}
+		if c.S3.SecretAccessKey == "" {
+			c.S3.SecretAccessKey = "test"
+		}
+	case "redis":
+		if c.Redis.Address == "" {
+			c.Redis.Address = "localhost:6379"
+		}
+		if c.Redis.DB < 0 {
+			return fmt.Errorf("redis.db cannot be negative")
+		}
+	default:
+		return fmt.Errorf("backend must be filesystem, s3, or redis, got %q", c.Backend)
+	}
+	return nil
+}
+
+// NewStorage opens the configured storage backend, or returns nil if none is configured
+func NewStorage(config StorageConfig) (Storage, error) {
+	var storage Storage
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case "filesystem":
+		if err := os.MkdirAll(config.Directory, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+		storage = &fileStorage{dir: config.Directory}
+	case "s3":
+		storage = &s3Storage{config: config.S3, client: &http.Client{Timeout: 30 * time.Second}}
+	case "redis":
+		storage = &redisStorage{config: config.Redis}
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", config.Backend)
+	}
+	if config.Prefix != "" {
+		storage = &prefixedStorage{Storage: storage, prefix: config.Prefix}
+	}
+	return storage, nil
+}
+
+// prefixedStorage keeps every key of a backend under a prefix
+type prefixedStorage struct {
+	Storage
+	prefix string
+}
+
+func (s *prefixedStorage) Put(ctx context.Context, key string, data []byte) error {
+	return s.Storage.Put(ctx, s.prefix+key, data)
+}
+
+func (s *prefixedStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.Storage.Get(ctx, s.prefix+key)
+}
+
+func (s *prefixedStorage) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, s.prefix+key)
+}
+
+func (s *prefixedStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.Storage.List(ctx, s.prefix+prefix)
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], s.prefix)
+	}
+	return keys, err
+}
+
+// fileStorage keeps objects as files under a directory
+type fileStorage struct {
+	dir string
+}
+
+// path returns the file holding key, refusing keys that would escape the directory
+func (s *fileStorage) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s *fileStorage) Put(_ context.Context, key string, data []byte) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomically(name, data)
+}
+
+// writeFileAtomically writes a file then renames it into place, so readers never see a partial file
+func writeFileAtomically(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *fileStorage) Get(_ context.Context, key string) ([]byte, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return data, err
+}
+
+func (s *fileStorage) Open(_ context.Context, key string) (io.ReadCloser, int64, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (s *fileStorage) Delete(_ context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStorage) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	slices.Sort(keys)
+	return keys, err
+}
+
+// s3Storage keeps objects in an S3-compatible bucket, signing requests with AWS Signature Version 4
+type s3Storage struct {
+	config S3StorageConfig
+	client *http.Client
+}
+
+// do sends a signed request for an object (or the bucket, for an empty key) and returns the response body
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, body []byte) ([]byte, int, error) {
+	target := strings.TrimSuffix(s.config.Endpoint, "/") + "/" + url.PathEscape(s.config.Bucket)
+	if key != "" {
+		target += "/" + (&url.URL{Path: key}).EscapedPath()
+	}
+	if len(query) > 0 {
+		// SigV4 encodes spaces as %20, not +
+		target += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	signAWSRequest(req, body, "s3", s.config.Region, s.config.AccessKeyID, s.config.SecretAccessKey, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("S3 request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read S3 response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var s3Error struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		if xml.Unmarshal(data, &s3Error) == nil && s3Error.Code != "" {
+			return nil, resp.StatusCode, fmt.Errorf("S3 returned %d: %s: %s", resp.StatusCode, s3Error.Code, s3Error.Message)
+		}
+		return nil, resp.StatusCode, fmt.Errorf("S3 returned %d", resp.StatusCode)
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, data []byte) error {
+	_, _, err := s.do(ctx, http.MethodPut, key, nil, data)
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	data, status, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if status == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	return data, err
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, _, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	return err
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		data, _, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Keys                  []string `xml:"Contents>Key"`
+			IsTruncated           bool     `xml:"IsTruncated"`
+			NextContinuationToken string   `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse S3 listing: %w", err)
+		}
+		keys = append(keys, page.Keys...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// redisStorage keeps objects as Redis string values, opening a connection per operation
+type redisStorage struct {
+	config RedisStorageConfig
+}
+
+// redisConn is one connection speaking the Redis protocol (RESP)
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dial connects to Redis, authenticating and selecting the configured database
+func (s *redisStorage) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if s.config.Password != "" {
+		if _, err := rc.do("AUTH", s.config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.config.DB != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(s.config.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply: nil, []byte, int64, or []any
+func (rc *redisConn) do(args ...string) (any, error) {
+	var command bytes.Buffer
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := rc.conn.Write(command.Bytes()); err != nil {
+		return nil, fmt.Errorf("Redis %s failed: %w", args[0], err)
+	}
+	reply, err := rc.readReply()
+	if err != nil {
+		return nil, fmt.Errorf("Redis %s failed: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+// readReply reads one RESP reply
+func (rc *redisConn) readReply() (any, error) {
+	line, err := rc.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// command runs a single command on a new connection
+func (s *redisStorage) command(ctx context.Context, args ...string) (any, error) {
+	rc, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.conn.Close()
+	return rc.do(args...)
+}
+
+func (s *redisStorage) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.command(ctx, "SET", key, string(data))
+	return err
+}
+
+func (s *redisStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := s.command(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrObjectNotFound
+	}
+	return reply.([]byte), nil
+}
+
+func (s *redisStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.command(ctx, "DEL", key)
+	return err
+}
+
+func (s *redisStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	rc, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.conn.Close()
+
+	// Escape glob characters so the prefix is matched literally
+	pattern := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(prefix) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := rc.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		page, ok := reply.([]any)
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected Redis SCAN reply")
+		}
+		cursorBytes, _ := page[0].([]byte)
+		items, _ := page[1].([]any)
+		for _, item := range items {
+			if key, ok := item.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		cursor = string(cursorBytes)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	// SCAN may return a key more than once
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+// storageKey joins slash-separated key segments
+func storageKey(segments ...string) string {
+	return path.Join(segments...)
+}
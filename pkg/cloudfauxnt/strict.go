@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// behaviorDocument is a cache behavior as written in a configuration file: the behavior-level
+// settings of an Origin plus the origin it targets
+type behaviorDocument struct {
+	Origin       `yaml:",inline"`
+	TargetOrigin string `yaml:"target_origin"`
+}
+
+// documentOnlyFields are configuration file keys that don't map onto a field of the decoded type
+var documentOnlyFields = map[reflect.Type]map[string]reflect.Type{
+	reflect.TypeFor[Config](): {
+		"config_version": nil,
+		"behaviors":      reflect.TypeFor[[]behaviorDocument](),
+	},
+	reflect.TypeFor[Distribution](): {
+		"behaviors": reflect.TypeFor[[]behaviorDocument](),
+	},
+	reflect.TypeFor[StagingDistribution](): {
+		"behaviors": reflect.TypeFor[[]behaviorDocument](),
+	},
+}
+
+// unknownField is a configuration key no setting reads
+type unknownField struct {
+	path       string // Dotted location, e.g. behaviors[0].path_pattern
+	line       int
+	anchorLine int    // Line of the anchor the key was expanded from, or 0
+	suggestion string // Closest known key, if any is close
+}
+
+// String describes the unknown key with its location and a suggestion
+func (f unknownField) String() string {
+	s := fmt.Sprintf("unknown key %s at line %d", f.path, f.line)
+	if f.anchorLine > 0 {
+		s += fmt.Sprintf(" (from the anchor at line %d)", f.anchorLine)
+	}
+	if f.suggestion != "" {
+		s += fmt.Sprintf(", did you mean %s?", f.suggestion)
+	}
+	return s
+}
+
+// unknownFields lists the keys of a configuration document that no Config field reads. Aliases and
+// merge keys (<<) are expanded, and keys reached through an anchor report where the anchor is defined.
+func unknownFields(root *yaml.Node) []unknownField {
+	var found []unknownField
+	collectUnknownFields(root, reflect.TypeFor[Config](), "", 0, &found)
+	return found
+}
+
+// collectUnknownFields checks a node against the type it decodes into
+func collectUnknownFields(node *yaml.Node, t reflect.Type, path string, anchorLine int, found *[]unknownField) {
+	if node.Kind == yaml.AliasNode {
+		collectUnknownFields(node.Alias, t, path, node.Alias.Line, found)
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			collectUnknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), anchorLine, found)
+		}
+
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" && key.Tag == "!!merge" {
+				collectMergedFields(value, t, path, anchorLine, found)
+				continue
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok && path == "" && strings.HasPrefix(key.Value, "x-") {
+				// Extension keys hold anchors for reuse, as in Docker Compose files
+				continue
+			}
+			if !ok {
+				*found = append(*found, unknownField{
+					path:       joinFieldPath(path, key.Value),
+					line:       key.Line,
+					anchorLine: anchorLine,
+					suggestion: closestField(key.Value, fields),
+				})
+				continue
+			}
+			if fieldType != nil {
+				collectUnknownFields(value, fieldType, joinFieldPath(path, key.Value), anchorLine, found)
+			}
+		}
+	}
+}
+
+// collectMergedFields checks the mappings merged into a struct with <<, which may be a single
+// mapping (usually an alias) or a sequence of them
+func collectMergedFields(value *yaml.Node, t reflect.Type, path string, anchorLine int, found *[]unknownField) {
+	if value.Kind == yaml.SequenceNode {
+		for _, item := range value.Content {
+			collectUnknownFields(item, t, path, anchorLine, found)
+		}
+		return
+	}
+	collectUnknownFields(value, t, path, anchorLine, found)
+}
+
+// yamlFields maps the YAML keys a struct type reads to their types, including inlined structs and
+// keys that only exist in configuration files
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if options == "inline" {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	for key, fieldType := range documentOnlyFields[t] {
+		fields[key] = fieldType
+	}
+	return fields
+}
+
+// joinFieldPath appends a key to a dotted configuration path
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestField suggests the known key most similar to an unknown one, or "" if none is close.
+// Keys missing a suffix (default_ttl for default_ttl_seconds) are suggested too.
+func closestField(key string, fields map[string]reflect.Type) string {
+	key = strings.ToLower(key)
+	best, bestDistance := "", 3
+	for name := range fields {
+		distance := editDistance(key, name)
+		if distance < bestDistance || (distance == bestDistance && best != "" && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for name := range fields {
+		if strings.HasPrefix(name, key+"_") && (best == "" || name < best) {
+			best = name
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
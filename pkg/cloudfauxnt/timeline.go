@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTimeline is where the time of one captured request went, for the admin dashboard
+type requestTimeline struct {
+	RequestID string    `json:"request_id"`
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Behavior  string    `json:"behavior"`
+	Cache     string    `json:"cache"` // X-Cache result, e.g. Hit or Miss
+	Bytes     int64     `json:"bytes"`
+	// DurationMS is the whole request, and TimeToFirstByteMS the time until response headers were sent
+	DurationMS        float64 `json:"duration_ms"`
+	TimeToFirstByteMS float64 `json:"time_to_first_byte_ms"`
+	// Phases are the stages of handling the request: routing, auth, cache, origin, and response_write
+	Phases []timelineSpan `json:"phases"`
+	// Rules are the rewrite rules, policies, and simulated delays that fired within those stages
+	Rules []timelineSpan `json:"rules"`
+}
+
+// timelineSpan is one bar of a request's waterfall, offset from the start of the request
+type timelineSpan struct {
+	Name       string  `json:"name"`
+	StartMS    float64 `json:"start_ms"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// requestCapture keeps the timelines of the most recent requests
+type requestCapture struct {
+	mu        sync.Mutex
+	timelines []*requestTimeline // Ring buffer, oldest at next once full
+	next      int
+	full      bool
+}
+
+// newRequestCapture creates a capture holding the last size requests
+func newRequestCapture(size int) *requestCapture {
+	return &requestCapture{timelines: make([]*requestTimeline, size)}
+}
+
+// logRequest captures the timeline of a completed request, replacing the oldest one
+func (c *requestCapture) logRequest(entry *requestLogEntry) {
+	r := entry.request
+	timeline := &requestTimeline{
+		RequestID:         entry.header.Get("X-Amz-Cf-Id"),
+		Time:              entry.start,
+		Method:            r.Method,
+		Host:              r.Host,
+		Path:              r.URL.Path,
+		Status:            entry.status,
+		Behavior:          entry.rules.behaviorName(),
+		Cache:             strings.TrimSuffix(entry.header.Get("X-Cache"), " from cloudfauxnt"),
+		Bytes:             entry.bytes,
+		DurationMS:        milliseconds(entry.end.Sub(entry.start)),
+		TimeToFirstByteMS: milliseconds(entry.firstByte.Sub(entry.start)),
+		Phases:            []timelineSpan{},
+		Rules:             []timelineSpan{},
+	}
+	phases, rules := entry.rules.timeline()
+	for _, phase := range phases {
+		timeline.Phases = append(timeline.Phases, entry.span(phase))
+	}
+	timeline.Phases = append(timeline.Phases, timelineSpan{
+		Name:       "response_write",
+		StartMS:    timeline.TimeToFirstByteMS,
+		DurationMS: milliseconds(entry.lastByte.Sub(entry.firstByte)),
+	})
+	for _, rule := range rules {
+		timeline.Rules = append(timeline.Rules, entry.span(rule))
+	}
+
+	c.mu.Lock()
+	c.timelines[c.next] = timeline
+	c.next = (c.next + 1) % len(c.timelines)
+	c.full = c.full || c.next == 0
+	c.mu.Unlock()
+}
+
+// span places a phase or rule on the request's timeline
+func (e *requestLogEntry) span(rule firedRule) timelineSpan {
+	return timelineSpan{Name: rule.name, StartMS: milliseconds(rule.started.Sub(e.start)), DurationMS: milliseconds(rule.duration)}
+}
+
+// recent returns the captured timelines, newest first
+func (c *requestCapture) recent() []*requestTimeline {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := c.next
+	if c.full {
+		count = len(c.timelines)
+	}
+	timelines := make([]*requestTimeline, 0, count)
+	for i := range count {
+		timelines = append(timelines, c.timelines[(c.next-1-i+len(c.timelines))%len(c.timelines)])
+	}
+	return timelines
+}
+
+// find returns the captured timeline of a request ID, or nil if it is no longer held
+func (c *requestCapture) find(requestID string) *requestTimeline {
+	for _, timeline := range c.recent() {
+		if timeline.RequestID == requestID {
+			return timeline
+		}
+	}
+	return nil
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
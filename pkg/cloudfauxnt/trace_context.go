@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+	"strings"
+)
+
+// traceID returns the trace ID from a viewer's W3C traceparent header (as sent by OpenTelemetry
+// instrumented clients), or "" when the header is missing or malformed
+func traceID(r *http.Request) string {
+	// traceparent: version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	parts := strings.Split(strings.TrimSpace(r.Header.Get("Traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+	id := strings.ToLower(parts[1])
+	if !isLowerHex(id) || id == strings.Repeat("0", 32) {
+		return ""
+	}
+	return id
+}
+
+// isLowerHex reports whether s consists only of lowercase hex digits
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
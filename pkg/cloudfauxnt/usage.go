@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultDistributionName is what usage reports call the top-level distribution
+const defaultDistributionName = "default"
+
+// QuotaConfig caps the traffic a distribution serves per UTC day, like an internal chargeback limit
+type QuotaConfig struct {
+	DailyRequests int64 `yaml:"daily_requests"` // Optional: requests admitted per day (default: 0, unlimited)
+	DailyBytes    int64 `yaml:"daily_bytes"`    // Optional: response bytes sent to viewers per day (default: 0, unlimited)
+	Status        int   `yaml:"status"`         // 429 or 503 once a quota is used up (default: 429)
+}
+
+// validate checks the quota, filling in the default status
+func (q *QuotaConfig) validate() error {
+	if q.DailyRequests < 0 || q.DailyBytes < 0 {
+		return fmt.Errorf("quotas cannot be negative")
+	}
+	switch q.Status {
+	case 0:
+		q.Status = http.StatusTooManyRequests
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return fmt.Errorf("status must be 429 or 503, got %d", q.Status)
+	}
+	return nil
+}
+
+// quotaExceededReason is the error page text for requests over a distribution's daily quota
+const quotaExceededReason = "This distribution has used up its daily %s quota. Try again after midnight UTC."
+
+// distributionUsage is the traffic one distribution has served
+type distributionUsage struct {
+	Requests int64 `json:"requests"` // Admitted today
+	Bytes    int64 `json:"bytes"`    // Sent to viewers today
+	Rejected int64 `json:"rejected"` // Turned away today for exceeding a quota
+	// Totals since CloudFauxnt started
+	TotalRequests int64 `json:"total_requests"`
+	TotalBytes    int64 `json:"total_bytes"`
+	TotalRejected int64 `json:"total_rejected"`
+}
+
+// usageTracker counts requests and bytes per distribution, for quotas and the usage report. It lives
+// for the whole server, so usage survives reloads.
+type usageTracker struct {
+	mu            sync.Mutex
+	day           string // UTC date the daily counts cover
+	distributions map[string]*distributionUsage
+}
+
+// newUsageTracker creates a tracker with no usage recorded
+func newUsageTracker() *usageTracker {
+	return &usageTracker{distributions: make(map[string]*distributionUsage)}
+}
+
+// usage returns a distribution's counts, starting new daily counts at midnight UTC; u.mu must be held
+func (u *usageTracker) usage(name string) *distributionUsage {
+	if today := time.Now().UTC().Format(time.DateOnly); today != u.day {
+		u.day = today
+		for _, usage := range u.distributions {
+			usage.Requests, usage.Bytes, usage.Rejected = 0, 0, 0
+		}
+	}
+	usage, ok := u.distributions[name]
+	if !ok {
+		usage = &distributionUsage{}
+		u.distributions[name] = usage
+	}
+	return usage
+}
+
+// admit counts a request toward a distribution unless its quota is used up, in which case it
+// returns which quota: "request" or "byte"
+func (u *usageTracker) admit(name string, quota *QuotaConfig) (bool, string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage := u.usage(name)
+	exceeded := ""
+	switch {
+	case quota == nil:
+	case quota.DailyRequests > 0 && usage.Requests >= quota.DailyRequests:
+		exceeded = "request"
+	case quota.DailyBytes > 0 && usage.Bytes >= quota.DailyBytes:
+		exceeded = "byte"
+	}
+	if exceeded != "" {
+		usage.Rejected++
+		usage.TotalRejected++
+		return false, exceeded
+	}
+	usage.Requests++
+	usage.TotalRequests++
+	return true, ""
+}
+
+// logRequest adds the bytes sent for an admitted request to its distribution
+func (u *usageTracker) logRequest(entry *requestLogEntry) {
+	name := entry.rules.distributionName()
+	if name == "" {
+		return
+	}
+	u.mu.Lock()
+	usage := u.usage(name)
+	usage.Bytes += entry.bytes
+	usage.TotalBytes += entry.bytes
+	u.mu.Unlock()
+}
+
+// distributionReport is one distribution's line of the usage report
+type distributionReport struct {
+	Name string `json:"name"`
+	distributionUsage
+	Quota *quotaReport `json:"quota"` // null when the distribution has no quota
+}
+
+// quotaReport is a distribution's quota as the usage report shows it
+type quotaReport struct {
+	DailyRequests int64 `json:"daily_requests"`
+	DailyBytes    int64 `json:"daily_bytes"`
+	Status        int   `json:"status"`
+}
+
+// report returns every distribution's usage today, in configuration order
+func (u *usageTracker) report(config *Config) map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	line := func(name string, quota *QuotaConfig) distributionReport {
+		report := distributionReport{Name: name, distributionUsage: *u.usage(name)}
+		if quota != nil {
+			report.Quota = &quotaReport{quota.DailyRequests, quota.DailyBytes, quota.Status}
+		}
+		return report
+	}
+	distributions := []distributionReport{line(defaultDistributionName, config.Quota)}
+	for i := range config.Distributions {
+		d := &config.Distributions[i]
+		distributions = append(distributions, line(d.Name, config.quotaFor(d)))
+	}
+	resets := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	return map[string]any{"date": u.day, "resets_at": resets, "distributions": distributions}
+}
+
+// quotaFor returns the quota of a distribution, which defaults to the top-level quota
+func (c *Config) quotaFor(d *Distribution) *QuotaConfig {
+	if d.Quota != nil {
+		return d.Quota
+	}
+	return c.Quota
+}
+
+// QuotaMiddleware counts every request toward its distribution's usage, turning requests away
+// once the distribution's daily quota is used up
+func QuotaMiddleware(config *Config, usage *usageTracker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHealthPath(r.URL.Path) || (config.Metrics.Enabled && r.URL.Path == config.Metrics.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, quota := defaultDistributionName, config.Quota
+			if d := config.findDistribution(requestHost(r)); d != nil {
+				name, quota = d.Name, config.quotaFor(d)
+			}
+			if ok, exceeded := usage.admit(name, quota); !ok {
+				resets := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+				w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(resets).Seconds())+1))
+				writeEdgeError(w, quota.Status, fmt.Sprintf(quotaExceededReason, exceeded))
+				return
+			}
+			ruleTraceFrom(r.Context()).setDistribution(name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
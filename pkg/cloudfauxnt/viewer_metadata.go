@@ -0,0 +1,214 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// viewerMetadataHeaders are the CloudFront headers describing the viewer's connection and device, plus
+// the negotiated ALPN protocol, which CloudFront has no header for. An origin request policy or cache key
+// adds them when it lists them, and drops values viewers send under these names so they can't be forged.
+var viewerMetadataHeaders = map[string]func(r *http.Request) string{
+	"Cloudfront-Viewer-Address": func(r *http.Request) string {
+		_, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return ""
+		}
+		return net.JoinHostPort(clientIP(r), port)
+	},
+	"Cloudfront-Viewer-Http-Version": func(r *http.Request) string {
+		return fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)
+	},
+	"Cloudfront-Forwarded-Proto": func(r *http.Request) string {
+		if r.TLS != nil {
+			return "https"
+		}
+		return "http"
+	},
+	"Cloudfront-Viewer-Tls": func(r *http.Request) string {
+		if r.TLS == nil {
+			return ""
+		}
+		handshake := "fullHandshake"
+		if r.TLS.DidResume {
+			handshake = "sessionResumed"
+		}
+		return tlsVersionName(r.TLS.Version) + ":" + tls.CipherSuiteName(r.TLS.CipherSuite) + ":" + handshake
+	},
+	"X-Cloudfauxnt-Viewer-Alpn": func(r *http.Request) string {
+		if r.TLS == nil {
+			return ""
+		}
+		return r.TLS.NegotiatedProtocol
+	},
+	"Cloudfront-Viewer-Ja3-Fingerprint": func(r *http.Request) string {
+		if conn, ok := r.Context().Value(viewerConnKey{}).(*viewerConn); ok {
+			return conn.fingerprint()
+		}
+		return ""
+	},
+	"Cloudfront-Is-Mobile-Viewer": func(r *http.Request) string {
+		device := viewerDevice(r.Header.Get("User-Agent"))
+		return strconv.FormatBool(device == "mobile" || device == "tablet")
+	},
+	"Cloudfront-Is-Tablet-Viewer": func(r *http.Request) string {
+		return strconv.FormatBool(viewerDevice(r.Header.Get("User-Agent")) == "tablet")
+	},
+	"Cloudfront-Is-Desktop-Viewer": func(r *http.Request) string {
+		return strconv.FormatBool(viewerDevice(r.Header.Get("User-Agent")) == "desktop")
+	},
+	"Cloudfront-Is-Smarttv-Viewer": func(r *http.Request) string {
+		return strconv.FormatBool(viewerDevice(r.Header.Get("User-Agent")) == "smarttv")
+	},
+}
+
+// User-Agent substrings identifying each kind of device, checked in this order
+var (
+	smartTVAgents = []string{"smart-tv", "smarttv", "hbbtv", "appletv", "googletv", "crkey", "roku", "aftb", "aftm", "bravia", "netcast", "webos.tv", "tizen tv"}
+	tabletAgents  = []string{"ipad", "tablet", "kindle", "silk/", "playbook"}
+	mobileAgents  = []string{"mobile", "iphone", "ipod", "android", "windows phone", "blackberry", "opera mini"}
+)
+
+// viewerDevice classifies a viewer as mobile, tablet, smarttv, or desktop from its User-Agent,
+// the way CloudFront's device detection headers do. Android devices without "Mobile" are tablets,
+// and anything unrecognized, including an empty User-Agent, is a desktop.
+func viewerDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	has := func(agents []string) bool {
+		return slices.ContainsFunc(agents, func(agent string) bool { return strings.Contains(ua, agent) })
+	}
+	switch {
+	case has(smartTVAgents):
+		return "smarttv"
+	case has(tabletAgents), strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return "tablet"
+	case has(mobileAgents):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
+
+// addViewerMetadata sets the viewer connection headers the policy lists on an origin request
+func (p *OriginRequestPolicy) addViewerMetadata(req, viewer *http.Request) {
+	for name := range viewerMetadataHeaders {
+		req.Header.Del(name)
+	}
+	if p.Headers.Behavior == "none" {
+		return
+	}
+	for _, name := range p.Headers.Items {
+		if value, ok := viewerMetadataHeaders[http.CanonicalHeaderKey(name)]; ok {
+			if v := value(viewer); v != "" {
+				req.Header.Set(name, v)
+			}
+		}
+	}
+}
+
+// viewerConnKey is the connection context key for the viewer's TLS ClientHello fingerprint
+type viewerConnKey struct{}
+
+// viewerConn holds what is known about a viewer connection beyond what net/http records
+type viewerConn struct {
+	mu  sync.Mutex
+	ja3 string
+}
+
+// fingerprint returns the JA3 fingerprint of the connection's ClientHello, if it used TLS
+func (c *viewerConn) fingerprint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ja3
+}
+
+// viewerConns tracks the TLS connections whose handshake hasn't been seen yet, keyed by the
+// underlying connection, which is all the ClientHello callback gets to identify them by
+type viewerConns struct {
+	pending sync.Map
+}
+
+// track installs the hooks that fingerprint TLS viewers on a server
+func (v *viewerConns) track(server *http.Server) {
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		tlsConn, ok := c.(*tls.Conn)
+		if !ok {
+			return ctx
+		}
+		conn := &viewerConn{}
+		v.pending.Store(tlsConn.NetConn(), conn)
+		return context.WithValue(ctx, viewerConnKey{}, conn)
+	}
+	server.ConnState = func(c net.Conn, state http.ConnState) {
+		if tlsConn, ok := c.(*tls.Conn); ok && state == http.StateClosed {
+			v.pending.Delete(tlsConn.NetConn())
+		}
+	}
+	server.TLSConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if pending, ok := v.pending.LoadAndDelete(hello.Conn); ok {
+			conn := pending.(*viewerConn)
+			conn.mu.Lock()
+			conn.ja3 = ja3Fingerprint(hello)
+			conn.mu.Unlock()
+		}
+		return nil, nil
+	}
+}
+
+// ja3Fingerprint returns the MD5 of a ClientHello's JA3 string: version, cipher suites,
+// extensions, curves, and point formats, with GREASE values left out. The ClientHello's legacy
+// version isn't exposed by crypto/tls, so it is taken as the highest offered version up to TLS 1.2,
+// which is what TLS 1.3 clients send.
+func ja3Fingerprint(hello *tls.ClientHelloInfo) string {
+	var version uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = min(v, tls.VersionTLS12)
+		}
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, point := range hello.SupportedPoints {
+		points[i] = uint16(point)
+	}
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, curve := range hello.SupportedCurves {
+		curves[i] = uint16(curve)
+	}
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinJA3Values(hello.CipherSuites),
+		joinJA3Values(hello.Extensions),
+		joinJA3Values(curves),
+		joinJA3Values(points),
+	}, ",")
+	sum := md5.Sum([]byte(ja3))
+	return hex.EncodeToString(sum[:])
+}
+
+// joinJA3Values formats values as JA3 does, dash-separated in decimal without GREASE values
+func joinJA3Values(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// isGREASE reports whether a value is one of the reserved GREASE values (RFC 8701) clients add
+// at random, which fingerprints ignore
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ViewerResponseHeadersConfig limits the origin response headers viewers see, to catch internal
+// headers leaking out of an origin before they reach production
+type ViewerResponseHeadersConfig struct {
+	// Strict passes only standard response headers, CloudFront's own, and those listed in allow
+	Strict bool `yaml:"strict"`
+	// Optional: more header names to pass, with * and ? wildcards, e.g. X-Request-Id or X-Amz-Meta-*
+	Allow []string `yaml:"allow"`
+}
+
+// defaultViewerResponseHeaders are the headers strict mode passes without being listed: standard
+// HTTP response, CORS, and security headers, those WebSocket and gRPC responses need, and CloudFront's own
+var defaultViewerResponseHeaders = []string{
+	"Accept-Ranges", "Age", "Allow", "Alt-Svc", "Cache-Control", "Connection", "Content-Disposition",
+	"Content-Encoding", "Content-Language", "Content-Length", "Content-Location", "Content-Range",
+	"Content-Type", "Date", "ETag", "Expires", "Last-Modified", "Link", "Location", "Proxy-Authenticate",
+	"Retry-After", "Server", "Server-Timing", "Set-Cookie", "Timing-Allow-Origin", "Trailer",
+	"Transfer-Encoding", "Upgrade", "Vary", "Via", "WWW-Authenticate",
+	"Access-Control-*", "Content-Security-Policy", "Content-Security-Policy-Report-Only",
+	"Cross-Origin-Embedder-Policy", "Cross-Origin-Opener-Policy", "Cross-Origin-Resource-Policy",
+	"Permissions-Policy", "Referrer-Policy", "Strict-Transport-Security", "X-Content-Type-Options",
+	"X-Frame-Options", "X-XSS-Protection",
+	"Sec-WebSocket-Accept", "Sec-WebSocket-Extensions", "Sec-WebSocket-Protocol", "Grpc-*",
+	"X-Amz-Cf-Id", "X-Amz-Cf-Pop", "X-Cache",
+}
+
+// validate checks the allowlist entries are header names
+func (c *ViewerResponseHeadersConfig) validate() error {
+	for _, name := range c.Allow {
+		if name == "" || strings.ContainsAny(name, " :\t") {
+			return fmt.Errorf("allow entries must be header names, got %q", name)
+		}
+	}
+	return nil
+}
+
+// allows reports whether strict mode passes a header to viewers
+func (c *ViewerResponseHeadersConfig) allows(name string) bool {
+	name = strings.ToLower(name)
+	matches := func(pattern string) bool { return matchPolicyResource(strings.ToLower(pattern), name) }
+	return slices.ContainsFunc(defaultViewerResponseHeaders, matches) || slices.ContainsFunc(c.Allow, matches)
+}
+
+// apply strips the headers of an origin response that viewers shouldn't see, warning about them
+func (c *ViewerResponseHeadersConfig) apply(resp *http.Response, origin *Origin) {
+	started := time.Now()
+	stripped := c.strip(resp.Header)
+	if len(stripped) == 0 {
+		return
+	}
+	ruleTraceFrom(resp.Request.Context()).record("viewer_response_headers", started)
+	slog.Warn("Stripped origin response headers not on the viewer allowlist", "origin", origin.Name,
+		"path", resp.Request.URL.Path, "headers", stripped)
+}
+
+// strip removes the origin response headers strict mode doesn't pass, returning their names sorted
+func (c *ViewerResponseHeadersConfig) strip(h http.Header) []string {
+	var stripped []string
+	for name := range h {
+		if !c.allows(name) {
+			h.Del(name)
+			stripped = append(stripped, name)
+		}
+	}
+	slices.Sort(stripped)
+	return stripped
+}
@@ -0,0 +1,254 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// wafBlockReason is the explanation on the page CloudFront returns for requests AWS WAF blocks
+const wafBlockReason = "Request blocked."
+
+// wafRateWindows are the evaluation windows AWS WAF offers for rate-based rules, in seconds
+var wafRateWindows = []int{60, 120, 300, 600}
+
+// WAFConfig is a small stand-in for an AWS WAF web ACL in front of the distribution. Rules are
+// evaluated in order; the first matching block or allow rule decides, and count rules only record
+// their matches.
+type WAFConfig struct {
+	Enabled bool       `yaml:"enabled"`
+	IPSets  []WAFIPSet `yaml:"ip_sets"`
+	Rules   []WAFRule  `yaml:"rules"`
+}
+
+// WAFIPSet is a named list of viewer addresses rules can refer to
+type WAFIPSet struct {
+	Name      string   `yaml:"name"`
+	Addresses []string `yaml:"addresses"` // IPs or CIDRs
+
+	prefixes []netip.Prefix
+}
+
+// WAFRule matches requests on every condition it sets and applies its action to them
+type WAFRule struct {
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"` // block, allow, or count (default: block)
+	// Optional conditions; at least one is required
+	IPSet     string          `yaml:"ip_set"`     // Viewer IP is in the named IP set
+	URIRegex  string          `yaml:"uri_regex"`  // Request path matches the regular expression
+	Header    *WAFHeaderMatch `yaml:"header"`     // A request header matches
+	RateLimit *WAFRateLimit   `yaml:"rate_limit"` // Viewer sent more requests matching the other conditions than allowed
+
+	ipSet    *WAFIPSet
+	uriRegex *regexp.Regexp
+}
+
+// WAFHeaderMatch matches a request header's value against a regular expression
+type WAFHeaderMatch struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+
+	regex *regexp.Regexp
+}
+
+// WAFRateLimit makes a rule rate-based: it matches once a viewer IP exceeds limit requests in a window
+type WAFRateLimit struct {
+	Limit         int `yaml:"limit"`
+	WindowSeconds int `yaml:"window_seconds"` // 60, 120, 300, or 600 (default: 300)
+}
+
+// prepare validates the WAF rules and compiles their IP sets and regular expressions
+func (c *WAFConfig) prepare() error {
+	ipSets := make(map[string]*WAFIPSet)
+	for i := range c.IPSets {
+		set := &c.IPSets[i]
+		if set.Name == "" {
+			return fmt.Errorf("ip_sets %d: name is required", i)
+		}
+		if ipSets[set.Name] != nil {
+			return fmt.Errorf("ip set %s: duplicate name", set.Name)
+		}
+		ipSets[set.Name] = set
+		set.prefixes = nil
+		for _, address := range set.Addresses {
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(address)
+				if addrErr != nil {
+					return fmt.Errorf("ip set %s: invalid address %q", set.Name, address)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			set.prefixes = append(set.prefixes, prefix.Masked())
+		}
+	}
+
+	ruleNames := make(map[string]bool)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rules %d: name is required", i)
+		}
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("rule %s: duplicate name", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+		if err := rule.prepare(ipSets); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// prepare validates a rule, resolving its IP set and compiling its regular expressions
+func (r *WAFRule) prepare(ipSets map[string]*WAFIPSet) error {
+	if r.Action == "" {
+		r.Action = "block"
+	}
+	if r.Action != "block" && r.Action != "allow" && r.Action != "count" {
+		return fmt.Errorf("action must be block, allow, or count, got %q", r.Action)
+	}
+	if r.IPSet == "" && r.URIRegex == "" && r.Header == nil && r.RateLimit == nil {
+		return fmt.Errorf("at least one of ip_set, uri_regex, header, or rate_limit is required")
+	}
+
+	r.ipSet, r.uriRegex = nil, nil
+	if r.IPSet != "" {
+		if r.ipSet = ipSets[r.IPSet]; r.ipSet == nil {
+			return fmt.Errorf("unknown ip_set %q", r.IPSet)
+		}
+	}
+	if r.URIRegex != "" {
+		re, err := regexp.Compile(r.URIRegex)
+		if err != nil {
+			return fmt.Errorf("invalid uri_regex: %w", err)
+		}
+		r.uriRegex = re
+	}
+	if r.Header != nil {
+		if r.Header.Name == "" || r.Header.Regex == "" {
+			return fmt.Errorf("header: name and regex are required")
+		}
+		re, err := regexp.Compile(r.Header.Regex)
+		if err != nil {
+			return fmt.Errorf("header: invalid regex: %w", err)
+		}
+		r.Header.regex = re
+	}
+	if r.RateLimit != nil {
+		if r.RateLimit.WindowSeconds == 0 {
+			r.RateLimit.WindowSeconds = 300
+		}
+		if !slices.Contains(wafRateWindows, r.RateLimit.WindowSeconds) {
+			return fmt.Errorf("rate_limit: window_seconds must be 60, 120, 300, or 600, got %d", r.RateLimit.WindowSeconds)
+		}
+		if r.RateLimit.Limit < 1 {
+			return fmt.Errorf("rate_limit: limit must be at least 1")
+		}
+	}
+	return nil
+}
+
+// matches reports whether a request meets the rule's conditions other than its rate limit
+func (r *WAFRule) matches(req *http.Request, ip netip.Addr) bool {
+	if r.ipSet != nil && !slices.ContainsFunc(r.ipSet.prefixes, func(p netip.Prefix) bool { return p.Contains(ip) }) {
+		return false
+	}
+	if r.uriRegex != nil && !r.uriRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	if r.Header != nil && !slices.ContainsFunc(req.Header.Values(r.Header.Name), r.Header.regex.MatchString) {
+		return false
+	}
+	return true
+}
+
+// wafRateCounter counts a viewer's requests in the current window of a rate-based rule
+type wafRateCounter struct {
+	windowStart time.Time
+	count       int
+}
+
+// wafState holds the request counts of rate-based rules
+type wafState struct {
+	mu        sync.Mutex
+	counters  map[string]*wafRateCounter // Keyed by rule name and viewer IP
+	lastSweep time.Time
+}
+
+// newWAFState creates empty rate-based rule counters
+func newWAFState() *wafState {
+	return &wafState{counters: make(map[string]*wafRateCounter), lastSweep: time.Now()}
+}
+
+// overLimit counts a request against a rate-based rule and reports whether the viewer is over its
+// limit. Windows are fixed, starting at the viewer's first request.
+func (s *wafState) overLimit(rule *WAFRule, ip string) bool {
+	window := time.Duration(rule.RateLimit.WindowSeconds) * time.Second
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Forget counters older than the longest window, at most once a minute
+	if now.Sub(s.lastSweep) > time.Minute {
+		for key, counter := range s.counters {
+			if now.Sub(counter.windowStart) > 10*time.Minute {
+				delete(s.counters, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	key := rule.Name + "\n" + ip
+	counter := s.counters[key]
+	if counter == nil || now.Sub(counter.windowStart) >= window {
+		counter = &wafRateCounter{windowStart: now}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count > rule.RateLimit.Limit
+}
+
+// checkWAF evaluates the WAF rules against a request. It answers blocked requests with CloudFront's
+// 403 page and returns false.
+func (ph *ProxyHandler) checkWAF(w http.ResponseWriter, r *http.Request) bool {
+	waf := &ph.config.WAF
+	if !waf.Enabled {
+		return true
+	}
+	ip := clientIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err == nil {
+		addr = addr.Unmap()
+	}
+
+	for i := range waf.Rules {
+		rule := &waf.Rules[i]
+		started := time.Now()
+		if !rule.matches(r, addr) {
+			continue
+		}
+		if rule.RateLimit != nil && !ph.waf.overLimit(rule, ip) {
+			continue
+		}
+		ph.metrics.wafMatched(rule.Name, rule.Action)
+		ruleTraceFrom(r.Context()).record("waf_"+rule.Action, started)
+		switch rule.Action {
+		case "allow":
+			return true
+		case "block":
+			slog.Info("WAF rule blocked request", "rule", rule.Name, "client", ip,
+				"method", r.Method, "path", r.URL.Path)
+			writeEdgeError(w, http.StatusForbidden, wafBlockReason)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBypassTokenSkipsWAF(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from origin"))
+	}))
+	defer origin.Close()
+
+	config := loadTestConfig(t, `
+config_version: 2
+server:
+  port: 8080
+signing:
+  enabled: false
+waf:
+  enabled: true
+  rules:
+    - name: block-admin
+      uri_regex: "^/admin"
+origins:
+  - name: app
+    url: `+origin.URL+`
+behaviors:
+  - target_origin: app
+    path_patterns: ["/*"]
+`)
+	tokens := NewBypassTokens()
+	token, _ := tokens.Mint(time.Minute)
+	handler := NewProxyHandler(config, NewValidatorFromConfig(config), NewMetrics(), tokens)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusForbidden},
+		{"invalid token", "not-a-token", http.StatusForbidden},
+		{"valid token", token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/panel", nil)
+			if tt.token != "" {
+				req.Header.Set(bypassTokenHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+}
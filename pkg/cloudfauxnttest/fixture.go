@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+
+// Package cloudfauxnttest starts CloudFauxnt in-process for Go integration tests, with a signing key
+// generated for the test:
+//
+//	cdn := cloudfauxnttest.New(t, cloudfauxnttest.WithOrigin("s3", s3.URL, "/*"))
+//	resp, err := http.Get(cdn.SignURL("/bucket/key"))
+//
+// The fixture is shut down when the test ends.
+package cloudfauxnttest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
+)
+
+// KeyPairID is the key pair ID fixtures sign with
+const KeyPairID = "APKACLOUDFAUXNTTEST"
+
+// DefaultTTL is how long URLs and cookies signed by SignURL and SignCookies stay valid
+const DefaultTTL = 5 * time.Minute
+
+// Option customizes the configuration a fixture starts with
+type Option func(*cloudfauxnt.Config)
+
+// WithOrigin adds an origin with a behavior routing the path patterns to it
+func WithOrigin(name, url string, pathPatterns ...string) Option {
+	return func(config *cloudfauxnt.Config) {
+		config.Origins = append(config.Origins, cloudfauxnt.Origin{Name: name, URL: url, PathPatterns: pathPatterns})
+	}
+}
+
+// WithoutSigning serves every behavior without signatures unless it sets require_signature
+func WithoutSigning() Option {
+	return func(config *cloudfauxnt.Config) {
+		config.Signing.Enabled = false
+	}
+}
+
+// WithRequestLogs logs one line per request, which fixtures don't by default
+func WithRequestLogs() Option {
+	return func(config *cloudfauxnt.Config) {
+		enabled := true
+		config.Logging.Requests = &enabled
+	}
+}
+
+// WithConfig changes any other setting before the fixture starts
+func WithConfig(change func(*cloudfauxnt.Config)) Option {
+	return change
+}
+
+// Fixture is a CloudFauxnt serving on a local port for the duration of a test
+type Fixture struct {
+	// URL is the base URL of the emulated distribution, e.g. http://127.0.0.1:54321
+	URL string
+	// Server is the running CloudFauxnt, whose Reloader and AdminHandler tests can use
+	Server *cloudfauxnt.Server
+	// Signer signs URLs and cookies with the fixture's key, for custom expiries
+	Signer *Signer
+
+	t          testing.TB
+	httpServer *httptest.Server
+}
+
+// New starts a fixture with signing enabled and a freshly generated key, applying the options
+// in order. It fails the test if the configuration is invalid, and shuts down when the test ends.
+func New(t testing.TB, options ...Option) *Fixture {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cloudfauxnttest: generating signing key: %v", err)
+	}
+
+	requestLogs := false
+	config := &cloudfauxnt.Config{
+		Server: cloudfauxnt.ServerConfig{Port: 8080}, // Validated but unused: the fixture listens on a free port
+		Signing: cloudfauxnt.SigningConfig{
+			Enabled:   true,
+			KeyPairID: KeyPairID,
+			PublicKey: &key.PublicKey,
+		},
+		Logging: cloudfauxnt.LoggingConfig{Level: "warn", Requests: &requestLogs},
+	}
+	for _, option := range options {
+		option(config)
+	}
+
+	server, err := cloudfauxnt.NewServer(config)
+	if err != nil {
+		t.Fatalf("cloudfauxnttest: %v", err)
+	}
+	httpServer := httptest.NewServer(server.Handler())
+
+	f := &Fixture{
+		URL:        httpServer.URL,
+		Server:     server,
+		Signer:     &Signer{KeyPairID: KeyPairID, Key: key},
+		t:          t,
+		httpServer: httpServer,
+	}
+	t.Cleanup(f.Close)
+	return f
+}
+
+// Close stops the fixture, flushing its request logs. It is called automatically when the test ends.
+func (f *Fixture) Close() {
+	f.httpServer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := f.Server.Shutdown(ctx); err != nil {
+		f.t.Errorf("cloudfauxnttest: shutdown: %v", err)
+	}
+}
+
+// Client returns an HTTP client for the fixture
+func (f *Fixture) Client() *http.Client {
+	return f.httpServer.Client()
+}
+
+// SignURL returns the fixture URL for a path (which may include a query string), signed for DefaultTTL
+func (f *Fixture) SignURL(path string) string {
+	f.t.Helper()
+	signed, err := f.Signer.SignURL(f.URL+path, DefaultTTL)
+	if err != nil {
+		f.t.Fatalf("cloudfauxnttest: %v", err)
+	}
+	return signed
+}
+
+// SignCookies returns signed cookies allowing a path pattern, such as /private/*, for DefaultTTL
+func (f *Fixture) SignCookies(pathPattern string) []*http.Cookie {
+	f.t.Helper()
+	cookies, err := f.Signer.SignCookies(f.URL+pathPattern, DefaultTTL)
+	if err != nil {
+		f.t.Fatalf("cloudfauxnttest: %v", err)
+	}
+	return cookies
+}
+
+// Signer signs URLs and cookies the fixture accepts
+type Signer struct {
+	KeyPairID string
+	Key       *rsa.PrivateKey
+}
+
+// SignURL signs an absolute URL with a canned policy valid for ttl (negative for an expired URL)
+func (s *Signer) SignURL(rawURL string, ttl time.Duration) (string, error) {
+	return cloudfauxnt.SignURL(rawURL, s.KeyPairID, s.Key, time.Now().Add(ttl))
+}
+
+// SignCookies creates signed cookies with a custom policy for a resource URL, which may contain
+// wildcards, valid for ttl
+func (s *Signer) SignCookies(resource string, ttl time.Duration) ([]*http.Cookie, error) {
+	return cloudfauxnt.SignCookies(resource, s.KeyPairID, s.Key, time.Now().Add(ttl))
+}
+
+// AddCookies adds signed cookies to a request
+func AddCookies(r *http.Request, cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+}
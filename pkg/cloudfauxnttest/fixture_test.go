@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package cloudfauxnttest_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnttest"
+)
+
+// newOrigin starts an origin answering every request with its path
+func newOrigin(t *testing.T) *httptest.Server {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+// get sends a request to the fixture, returning the status and body
+func get(t *testing.T, cdn *cloudfauxnttest.Fixture, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := cdn.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestFixtureAcceptsSignedRequests(t *testing.T) {
+	cdn := cloudfauxnttest.New(t, cloudfauxnttest.WithOrigin("app", newOrigin(t).URL, "/*"))
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"signed URL", func() *http.Request {
+			req, _ := http.NewRequest("GET", cdn.SignURL("/private/a.txt"), nil)
+			return req
+		}, http.StatusOK},
+		{"signed cookies", func() *http.Request {
+			req, _ := http.NewRequest("GET", cdn.URL+"/private/a.txt", nil)
+			cloudfauxnttest.AddCookies(req, cdn.SignCookies("/private/*"))
+			return req
+		}, http.StatusOK},
+		{"cookies for another path", func() *http.Request {
+			req, _ := http.NewRequest("GET", cdn.URL+"/public/a.txt", nil)
+			cloudfauxnttest.AddCookies(req, cdn.SignCookies("/private/*"))
+			return req
+		}, http.StatusForbidden},
+		{"unsigned", func() *http.Request {
+			req, _ := http.NewRequest("GET", cdn.URL+"/private/a.txt", nil)
+			return req
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := get(t, cdn, tt.req())
+			if status != tt.status {
+				t.Errorf("status = %d, want %d (body %q)", status, tt.status, body)
+			}
+			if status == http.StatusOK && body != "/private/a.txt" {
+				t.Errorf("body = %q, want the origin's answer", body)
+			}
+		})
+	}
+}
+
+func TestFixtureClose(t *testing.T) {
+	cdn := cloudfauxnttest.New(t, cloudfauxnttest.WithOrigin("app", newOrigin(t).URL, "/*"))
+	req, _ := http.NewRequest("GET", cdn.SignURL("/a.txt"), nil)
+	if status, body := get(t, cdn, req); status != http.StatusOK {
+		t.Fatalf("status = %d before Close (body %q)", status, body)
+	}
+
+	cdn.Close()
+	if resp, err := http.Get(cdn.SignURL("/a.txt")); err == nil {
+		resp.Body.Close()
+		t.Errorf("request after Close got %s, want a connection error", resp.Status)
+	}
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
+)
+
+// smokePathSegment replaces the wildcard of a path pattern to build a request path for its behavior
+const smokePathSegment = "cloudfauxnt-smoke"
+
+// smokeRunner sends smoke test requests to a running instance and tallies the results
+type smokeRunner struct {
+	target *url.URL
+	client *http.Client
+	out    io.Writer
+	failed int
+}
+
+// runSmokeCommand implements the "smoke" subcommand and returns the process exit code
+func runSmokeCommand(args []string) int {
+	flags := flag.NewFlagSet("smoke", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "Path to the configuration the instance runs with")
+	target := flags.String("target", "http://localhost:8080", "Base URL of the running instance")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for each request")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	targetURL, err := url.Parse(*target)
+	if err != nil || targetURL.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid target %q\n", *target)
+		return 2
+	}
+	config, err := cloudfauxnt.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	runner := &smokeRunner{
+		target: targetURL,
+		client: &http.Client{
+			Timeout: *timeout,
+			// Redirects are part of the response under test, not something to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		out: os.Stdout,
+	}
+	runner.run(config)
+
+	if runner.failed > 0 {
+		fmt.Fprintf(runner.out, "FAILED: %d check(s) failed\n", runner.failed)
+		return 1
+	}
+	fmt.Fprintln(runner.out, "OK")
+	return 0
+}
+
+// run exercises health, every behavior of every distribution, and a repeated request
+func (s *smokeRunner) run(config *cloudfauxnt.Config) {
+	resp, err := s.get("/health", "")
+	if err != nil {
+		s.fail("health", err.Error())
+	} else {
+		s.expect("health", resp.StatusCode == http.StatusOK, "status %d", resp.StatusCode)
+	}
+
+	// The top-level behaviors are reached on the target's own host, distributions through an alias
+	s.behaviors(config, "", "")
+	for _, d := range config.Distributions {
+		host := strings.Replace(d.Aliases[0], "*", "smoke", 1)
+		s.behaviors(config.ForHost(host), host, d.Name)
+	}
+
+	s.repeat(config)
+}
+
+// behaviors sends one request per behavior, and a signed round trip for behaviors that require signatures
+func (s *smokeRunner) behaviors(config *cloudfauxnt.Config, host, distribution string) {
+	var key crypto.Signer
+	if config.Signing.PrivateKeyPath != "" {
+		loaded, err := cloudfauxnt.LoadSigningKey(config.Signing.PrivateKeyPath)
+		if err != nil {
+			s.fail("signing key", err.Error())
+		}
+		key = loaded
+	}
+
+	for i := range config.Origins {
+		origin := &config.Origins[i]
+		name := "behavior " + origin.Name
+		if distribution != "" {
+			name = fmt.Sprintf("distribution %s behavior %s", distribution, origin.Name)
+		}
+		if origin.GRPC {
+			fmt.Fprintf(s.out, "SKIP %s: gRPC behaviors need a gRPC client\n", name)
+			continue
+		}
+		path := behaviorPath(config, origin)
+		if path == "" {
+			fmt.Fprintf(s.out, "SKIP %s: no request path routes to it\n", name)
+			continue
+		}
+
+		resp, err := s.get(path, host)
+		if err != nil {
+			s.fail(name, err.Error())
+			continue
+		}
+		if !origin.RequiresSignature(&config.Signing) {
+			s.expect(name, resp.StatusCode < 500, "GET %s returned %d", path, resp.StatusCode)
+			continue
+		}
+
+		s.expect(name+" unsigned", resp.StatusCode == http.StatusForbidden, "GET %s returned %d", path, resp.StatusCode)
+		if key == nil {
+			fmt.Fprintf(s.out, "SKIP %s signed: set signing.private_key_path to test a signed URL round trip\n", name)
+			continue
+		}
+		if len(origin.TrustedKeyPairIDs) > 0 && !slices.Contains(origin.TrustedKeyPairIDs, config.Signing.KeyPairID) {
+			fmt.Fprintf(s.out, "SKIP %s signed: the behavior doesn't trust key pair %s\n", name, config.Signing.KeyPairID)
+			continue
+		}
+		signed, err := cloudfauxnt.SignURLWithKey(s.url(path, host), config.Signing.KeyPairID, key, config.Signing.Hash(), time.Now().Add(5*time.Minute))
+		if err != nil {
+			s.fail(name+" signed", err.Error())
+			continue
+		}
+		signedURL, _ := url.Parse(signed)
+		resp, err = s.get(signedURL.RequestURI(), host)
+		if err != nil {
+			s.fail(name+" signed", err.Error())
+			continue
+		}
+		s.expect(name+" signed", resp.StatusCode != http.StatusForbidden && resp.StatusCode < 500,
+			"signed GET %s returned %d", path, resp.StatusCode)
+	}
+}
+
+// repeat requests the same object twice and reports the X-Cache result of each response. A behavior
+// with caching is preferred, and its second response must be a hit.
+func (s *smokeRunner) repeat(config *cloudfauxnt.Config) {
+	candidates := make([]*cloudfauxnt.Origin, 0, len(config.Origins))
+	for i := range config.Origins {
+		if config.Origins[i].Cache != nil {
+			candidates = append(candidates, &config.Origins[i])
+		}
+	}
+	for i := range config.Origins {
+		if config.Origins[i].Cache == nil {
+			candidates = append(candidates, &config.Origins[i])
+		}
+	}
+
+	for _, origin := range candidates {
+		path := behaviorPath(config, origin)
+		if origin.GRPC || origin.PlainProxy || path == "" || origin.RequiresSignature(&config.Signing) {
+			continue
+		}
+
+		var results []string
+		var status int
+		for range 2 {
+			resp, err := s.get(path, "")
+			if err != nil {
+				s.fail("cache sequence", err.Error())
+				return
+			}
+			results = append(results, resp.Header.Get("X-Cache"))
+			status = resp.StatusCode
+		}
+		ok := results[0] != "" && results[1] != ""
+		if origin.Cache != nil && status == http.StatusOK {
+			ok = ok && strings.HasPrefix(results[1], "Hit")
+		}
+		s.expect("cache sequence", ok, "GET %s twice: X-Cache %q then %q", path, results[0], results[1])
+		return
+	}
+	fmt.Fprintln(s.out, "SKIP cache sequence: no unsigned behavior to request")
+}
+
+// behaviorPath builds a request path that routes to origin, or "" if another behavior shadows every candidate
+func behaviorPath(config *cloudfauxnt.Config, origin *cloudfauxnt.Origin) string {
+	for _, pattern := range origin.PathPatterns {
+		path := strings.ReplaceAll(pattern, "*", smokePathSegment)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		if matched, err := config.FindOrigin(path); err == nil && matched.Name == origin.Name {
+			return path
+		}
+	}
+	return ""
+}
+
+// url returns the absolute URL of path on the target, as seen from host
+func (s *smokeRunner) url(path, host string) string {
+	u := *s.target
+	if host != "" {
+		u.Host = host
+	}
+	return u.Scheme + "://" + u.Host + path
+}
+
+// get requests path from the target, with a Host header override for distributions
+func (s *smokeRunner) get(path, host string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(s.target.String(), "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// expect records a check as passed or failed, describing what was observed
+func (s *smokeRunner) expect(name string, ok bool, format string, args ...any) {
+	if ok {
+		fmt.Fprintf(s.out, "PASS %s: %s\n", name, fmt.Sprintf(format, args...))
+		return
+	}
+	s.fail(name, fmt.Sprintf(format, args...))
+}
+
+// fail records a failed check
+func (s *smokeRunner) fail(name, detail string) {
+	s.failed++
+	fmt.Fprintf(s.out, "FAIL %s: %s\n", name, detail)
+}
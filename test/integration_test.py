@@ -154,6 +154,29 @@ class CloudFauxntTester:
         
         assert 'Server' in response.headers, "Missing Server header"
         print("✓ CloudFront headers present")
+    
+    def test_not_modified_passthrough(self, path="/s3/MyTestFile.txt"):
+        """Test that 304 responses keep the origin's headers without invented ones"""
+        print("\nTesting 304 passthrough...")
+        
+        # Fetch once to learn the validator
+        response = requests.get(f"{self.base_url}{path}")
+        etag = response.headers.get('ETag')
+        if response.status_code != 200 or not etag:
+            print(f"  ⚠ Skipping - origin returned {response.status_code} without an ETag")
+            return
+        
+        # Revalidate; the origin should answer 304
+        response = requests.get(f"{self.base_url}{path}", headers={'If-None-Match': etag})
+        print(f"  Status: {response.status_code}")
+        print(f"  Headers: {dict(response.headers)}")
+        
+        assert response.status_code == 304, f"Expected 304, got {response.status_code}"
+        assert response.headers.get('ETag') == etag, "ETag not preserved on 304"
+        assert 'Content-Type' not in response.headers, "Content-Type invented on 304"
+        assert response.headers.get('Server') != 'CloudFauxnt', "Server header rewritten on 304"
+        assert 'X-Amz-Cf-Id' in response.headers, "Missing X-Amz-Cf-Id on 304"
+        print("✓ 304 passthrough preserved origin headers")
 
 
 def main():
@@ -178,19 +201,25 @@ def main():
     except Exception as e:
         print(f"✗ CloudFront headers test failed: {e}")
     
-    # Test 3: CORS preflight
+    # Test 3: 304 passthrough
+    try:
+        tester.test_not_modified_passthrough()
+    except Exception as e:
+        print(f"✗ 304 passthrough test failed: {e}")
+    
+    # Test 4: CORS preflight
     try:
         tester.test_cors_preflight()
     except Exception as e:
         print(f"✗ CORS preflight failed: {e}")
     
-    # Test 4: CORS actual request
+    # Test 5: CORS actual request
     try:
         tester.test_cors_actual_request()
     except Exception as e:
         print(f"✗ CORS actual request failed: {e}")
     
-    # Tests 5-7: Signature validation (requires keys)
+    # Tests 6-8: Signature validation (requires keys)
     try:
         tester.load_private_key()
         
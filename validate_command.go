@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tonyellard/cloudfauxnt/pkg/cloudfauxnt"
+)
+
+// runValidateCommand implements the "validate" subcommand and returns the process exit code: 0 for
+// a valid configuration, 1 for problems (or warnings with -fail-on-warnings), and 2 for bad usage
+func runValidateCommand(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	failOnWarnings := flags.Bool("fail-on-warnings", false, "Exit non-zero when warnings are found, such as unreachable path patterns")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Loading validates the configuration and reads the public keys it refers to
+	config, err := cloudfauxnt.LoadConfig(*configPath)
+	var problems cloudfauxnt.ConfigErrors
+	if errors.As(err, &problems) {
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "error: %s\n", problem)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d problems\n", *configPath, len(problems))
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if config.Server.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(config.Server.TLSCertFile, config.Server.TLSKeyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error: server: failed to load TLS certificate: %v\n", err)
+			return 1
+		}
+	}
+
+	warnings := config.Lint()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	behaviors := len(config.Origins)
+	for _, d := range config.Distributions {
+		behaviors += len(d.Origins)
+	}
+	fmt.Printf("%s is valid: %d behaviors, %d distributions, %d warnings\n", *configPath, behaviors, len(config.Distributions), len(warnings))
+	if *failOnWarnings && len(warnings) > 0 {
+		return 1
+	}
+	return 0
+}